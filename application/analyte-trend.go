@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"strconv"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/analyte"
+	ds "github.com/afrozahmed441/Capstone-Project/application/dataStructs"
+)
+
+/// numeric series of the analyte (e.g. hb or creatinine) across the medical records of the patient
+/// submitted, the read is recorded in the access log of the patient
+/// the chaincode only counts the encrypted reports, when there are any the records are queried,
+/// decrypted with the data key of the user and the series is built again with all of them
+func getAnalyteTrend(chaincode *gateway.Contract, user, org, pid, name string) (*ds.AnalyteTrend, error) {
+
+	data, err := submitTransaction(chaincode, "GetAnalyteTrend", org, pid, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var trend ds.AnalyteTrend
+	err = json.Unmarshal(data, &trend)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the analyte trend: %v", err)
+	}
+
+	if trend.Encrypted == 0 {
+		return &trend, nil
+	}
+
+	data, err = queryMedicalRecords(chaincode, user, org, pid, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var records ds.MedicalRecords
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the medical records: %v", err)
+	}
+
+	/// the reports are decrypted, none of the samples is encrypted
+	samples := make([]analyte.Sample, 0, len(records.Data))
+	for _, record := range records.Data {
+		samples = append(samples, analyte.Sample{
+			RecordID: record.ID,
+			RecordType: record.Type,
+			Time: record.ClinicalTime(),
+			IssuedAt: record.IssueTime(),
+			Report: record.MReport,
+		})
+	}
+
+	decrypted := analyte.NewTrend(samples, strings.TrimSpace(name))
+	decrypted.PID = pid
+
+	return decrypted, nil
+}
+
+func formatDelta(delta *float64, suffix string) string {
+	if delta == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*delta, 'f', -1, 64) + suffix
+}
+
+/// table of the trend with the local time of each value
+func printAnalyteTrend(trend *ds.AnalyteTrend) {
+
+	fmt.Printf("Trend of %v for patient %v\n", trend.Analyte, trend.PID)
+	fmt.Printf("%-20v %-8v %10v %-14v %10v %8v\n", "Time", "Type", "Value", "Unit", "Delta", "Delta %")
+	for _, point := range trend.Points {
+		fmt.Printf("%-20v %-8v %10v %-14v %10v %8v\n",
+			point.Time.Local().Format("2006-01-02 15:04"),
+			point.RecordType,
+			strconv.FormatFloat(point.Value, 'f', -1, 64),
+			point.Unit,
+			formatDelta(point.Delta, ""),
+			formatDelta(point.DeltaPercent, "%"))
+	}
+
+	if trend.NotNumeric > 0 {
+		fmt.Printf("%v records with a value that is not a number are not included\n", trend.NotNumeric)
+	}
+	if trend.Encrypted > 0 {
+		fmt.Printf("%v encrypted records are not included\n", trend.Encrypted)
+	}
+}
+
+/// write the trend as CSV, one row per value with RFC 3339 times, for charting tools
+func writeAnalyteTrendCSV(trend *ds.AnalyteTrend, fileName string) error {
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	rows := [][]string{{"pid", "analyte", "recordId", "recordType", "time", "issuedAt", "value", "unit", "delta", "deltaPercent"}}
+	for _, point := range trend.Points {
+		rows = append(rows, []string{
+			trend.PID,
+			trend.Analyte,
+			point.RecordID,
+			point.RecordType,
+			point.Time.Format(time.RFC3339),
+			point.IssuedAt.Format(time.RFC3339),
+			strconv.FormatFloat(point.Value, 'f', -1, 64),
+			point.Unit,
+			formatDelta(point.Delta, ""),
+			formatDelta(point.DeltaPercent, ""),
+		})
+	}
+
+	err := writer.WriteAll(rows)
+	if err != nil {
+		return fmt.Errorf("Cannot write the analyte trend: %v", err)
+	}
+
+	return ioutil.WriteFile(filepath.Clean(fileName), buffer.Bytes(), 0600)
+}
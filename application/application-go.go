@@ -9,16 +9,38 @@ import (
 	"time"
 	"errors"
 	"strconv"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 	cjson "github.com/TylerBrock/colorjson"
 	ds "github.com/afrozahmed441/Capstone-Project/application/dataStructs"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	envelope "github.com/afrozahmed441/Capstone-Project/application/envelope"
 	sign "github.com/afrozahmed441/Capstone-Project/application/sign"
 )
 
 func main() {
 
+	/// a record export is verified by its recipient without a network connection
+	if len(os.Args) == 3 && os.Args[1] == "verify-export" {
+		res, err := verifyRecordExport(os.Args[2])
+		if err != nil {
+			fmt.Println("ERROR : ", err)
+			os.Exit(1)
+		}
+
+		result, err := formatJSON(res)
+		if err != nil {
+			fmt.Println("ERROR : ", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Export Verified: %v\n", string(result))
+		return
+	}
+
 	err := os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
 	if err != nil {
 		fmt.Printf("Error setting DISCOVERY_AS_LOCALHOST environemnt variable: %v", err)
@@ -76,6 +98,9 @@ func main() {
 
 	chaincode := channel.GetContract(chaincodeName)
 
+	/// signed request payloads are bound to the channel and chaincode
+	target := requestTarget{channel: channelName, chaincode: chaincodeName}
+
 	for {
 		/// enter smart contract to invoke 
 		var smartContract string 
@@ -95,11 +120,49 @@ func main() {
 					return 
 				}
 				fmt.Printf("Result: %v\n", string(res))
+
+			/// synthetic data, admin only
+			case "InitLedger":
+				fmt.Printf("Enable synthetic data (true/false): ")
+				fmt.Scanf("%s", &args[0])
+				_, err := submitTransaction(chaincode, smartContract, org, args[:1]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Chaincode Initialized Successfully!")
+
+			case "GenerateSyntheticData":
+				fmt.Printf("Enter the seed: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the number of patients: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the number of doctors: ")
+				fmt.Scanf("%s", &args[2])
+				fmt.Printf("Enter the number of records per patient: ")
+				fmt.Scanf("%s", &args[3])
+				res, err := submitTransaction(chaincode, smartContract, org, args[:4]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
 			
 			case "AppointDoctor":
 				fmt.Printf("Enter the doctor id to appoint: ")
 				fmt.Scanf("%s", &args[0])
-				_, err := submitTransaction(chaincode, smartContract, org, args...)
+				transientData, err := wrapOwnDataKeyFor(chaincode, user, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				_, err = subTransactionWithTransientData(chaincode, smartContract, org, transientData, args[0])
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
@@ -111,7 +174,9 @@ func main() {
 			case "CreateRequestAgreement":
 				fmt.Printf("Enter the patient id to request patient data: ")
 				fmt.Scanf("%s", &args[0])
-				_, err := createRequestAgreement(chaincode, user, org, args[0])
+				fmt.Printf("Enter the days until the request expires (0 for default): ")
+				fmt.Scanf("%s", &args[1])
+				_, err := createRequestAgreement(chaincode, user, org, args[0], args[1], target)
 				if err != nil {
 					fmt.Printf("ERROR: %v", err)
 				}
@@ -146,7 +211,9 @@ func main() {
 			case "CreateDataAccessRequest":
 				fmt.Printf("Enter the patient id to request access patient data: ")
 				fmt.Scanf("%s", &args[0])
-				_, err := createDataAccessRequest(chaincode, user, org, args[0])
+				fmt.Printf("Enter the days until the request expires (0 for default): ")
+				fmt.Scanf("%s", &args[1])
+				_, err := createDataAccessRequest(chaincode, user, org, args[0], args[1], target)
 				if err != nil {
 					fmt.Printf("ERROR: %v", err)
 				}
@@ -172,8 +239,15 @@ func main() {
 			/// grantDataAccess 
 			case "GrantDataAccess":
 
+				/// data key of the patient wrapped for the requesting client
+				transientData, err := wrapDataKeyForRequester(chaincode, user, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
 				/// invoke grant data access smart contract 
-		        _, err = subTransactionWithOutArgs(chaincode, smartContract, org)
+		        _, err = subTransactionWithTransientData(chaincode, smartContract, org, transientData)
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
@@ -192,53 +266,116 @@ func main() {
 				}
 				// fmt.Println(res)
 				fmt.Println("Data Access Revoked Successfully!")
+
+				/// the revoked client still holds the data key
+				rotated, err := rotateDataKey(chaincode, user, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				if rotated {
+					fmt.Println("Data Key Rotated Successfully!")
+				}
 			
-			/// read patient data
-			case "GetPatientInfo", "GetDoctorInfo", "GetMedicalReports":
-				res, err := evuTxn(chaincode, smartContract, org)
+			/// referral smart contracts
+			case "ReferPatient":
+				fmt.Printf("Enter the patient id to refer: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the specialist id: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the record types to share (comma separated): ")
+				fmt.Scanf("%s", &args[3])
+				fmt.Printf("Enter the clinical note: ")
+				args[2] = scanLine()
+				res, err := submitTransaction(chaincode, smartContract, org, args[:4]...)
 				if err != nil {
-					fmt.Printf("ERROR : %v\n", err)
+					fmt.Println("ERROR: ", err)
+					return 
 				}
+				fmt.Printf("Referral %v Created Successfully!\n", string(res))
 
-				result, err :=  formatJSON(res)
-					if err != nil {
-						fmt.Printf("ERROR: %v\n", err)
-					}
+			case "RespondToReferral":
+				fmt.Printf("Enter the referral id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Accept the referral (true/false): ")
+				fmt.Scanf("%s", &args[1])
+				transientData, err := wrapRecordKeysForSpecialist(chaincode, user, org, args[0], args[1])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				_, err = subTransactionWithTransientData(chaincode, smartContract, org, transientData, args[:2]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Referral Response Recorded Successfully!")
 
-				fmt.Printf("Result: %v\n", string(result))
-			
-			case "GetPatientDataOrg", "GetPatientData", "GetDoctorDataOrg":
-				res, err := evaluateTransaction(chaincode, smartContract, org)
+			case "CompleteReferral":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the referral id: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the outcome: ")
+				args[2] = scanLine()
+				_, err := submitTransaction(chaincode, smartContract, org, args[:3]...)
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
 				}
-				
+				fmt.Println("Referral Completed Successfully!")
+
+			case "GetReferrals":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
 				result, err :=  formatJSON(res)
-					if err != nil {
-						fmt.Printf("ERROR: %v\n", err)
-					}
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
 
 				fmt.Printf("Result: %v\n", string(result))
-			
-			//// read smart contracts 
-			case "ReadRequestAgreement", "ReadPatientData":
+
+			/// prescription smart contracts
+			case "IssuePrescription":
 				fmt.Printf("Enter the patient id: ")
 				fmt.Scanf("%s", &args[0])
-				res, err := evaluateTransaction(chaincode, smartContract, org, args...)
+				res, code, err := issuePrescription(chaincode, user, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("Prescription %v Issued Successfully!\n", string(res))
+				fmt.Printf("Prescription code for the patient: %v\n", code)
+
+			case "ReadPrescriptionByCode", "DispensePrescription":
+				fmt.Printf("Enter the prescription code: ")
+				fmt.Scanf("%s", &args[0])
+				transientData := map[string][]byte{
+					"prescription_code": []byte(args[0]),
+				}
+				res, err := subTransactionWithTransientData(chaincode, smartContract, org, transientData)
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
 				}
 
+				if smartContract == "DispensePrescription" {
+					fmt.Println("Prescription Dispensed Successfully!")
+					break
+				}
+
 				result, err :=  formatJSON(res)
 				if err != nil {
 					fmt.Printf("ERROR: %v\n", err)
 				}
 
 				fmt.Printf("Result: %v\n", string(result))
-			
-			case "NotifyRequestAgreement", "NotifyDataAccessRequest", "ReadPatientsData":
+
+			case "GetPrescriptions":
 				res, err := evuTxn(chaincode, smartContract, org)
 				if err != nil {
 					fmt.Printf("ERROR: %v\n", err)
@@ -251,15 +388,75 @@ func main() {
 				}
 
 				fmt.Printf("Result: %v\n", string(result))
-			
-			case "ReadDoctorPrivateData":
-				fmt.Printf("Enter the doctor id: ")
+
+			/// lab order smart contracts
+			case "CreateLabOrder":
+				fmt.Printf("Enter the patient id: ")
 				fmt.Scanf("%s", &args[0])
-				res, err := evaluateTransaction(chaincode, smartContract, org, args...)
+				fmt.Printf("Enter the lab id: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter type of medical record: ")
+				fmt.Scanf("%s", &args[2])
+				/// the lab gets the record key of the result of an encrypted patient, not the data key
+				transientData, err := wrapLabRecordKey(chaincode, user, org, args[0], args[1], args[2])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				res, err := subTransactionWithTransientData(chaincode, smartContract, org, transientData, args[:3]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("Lab Order %v Created Successfully!\n", string(res))
+
+			case "RenewLabOrderKey":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the lab id: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the lab order id: ")
+				fmt.Scanf("%s", &args[2])
+				/// new record key for the current data key of the patient
+				order, err := readLabOrder(chaincode, org, args[2])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				transientData, err := wrapLabRecordKey(chaincode, user, org, args[0], args[1], order.RecordType)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				_, err = subTransactionWithTransientData(chaincode, smartContract, org, transientData, args[1], args[2])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Lab Order Key Renewed Successfully!")
+
+			case "PostLabResult":
+				fmt.Printf("Enter the lab order id: ")
+				fmt.Scanf("%s", &args[0])
+				transientData, err := createLabResultData(chaincode, user, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				_, err = subTransactionWithTransientData(chaincode, smartContract, org, transientData, args[0])
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
 				}
+				fmt.Println("Lab Result Posted Successfully!")
+
+			case "GetLabOrders":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
 				result, err :=  formatJSON(res)
 				if err != nil {
 					fmt.Printf("ERROR: %v\n", err)
@@ -267,110 +464,705 @@ func main() {
 
 				fmt.Printf("Result: %v\n", string(result))
 
-			case "RegisterPatient", "RegisterDoctor":
-				_, err := submitTransactionWithTransient(chaincode, smartContract, org)
+			/// research export smart contracts
+			case "SetResearchConsent":
+				fmt.Printf("Share de-identified data for research (true/false): ")
+				fmt.Scanf("%s", &args[0])
+				_, err := submitTransaction(chaincode, smartContract, org, args[:1]...)
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
 				}
+				fmt.Println("Research Consent Updated Successfully!")
 
-				// fmt.Println(res)
+			case "ExportResearchData":
+				fmt.Printf("Enter the minimum group size k: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the file to write the export to: ")
+				fmt.Scanf("%s", &args[1])
+				res, err := evaluateTransaction(chaincode, smartContract, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				err = ioutil.WriteFile(filepath.Clean(args[1]), res, 0600)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("Research Data Exported to %v\n", args[1])
 
-				if smartContract == "RegisterPatient" {
-					fmt.Println("Patient Registered Successfully!")
+				/// the chaincode cannot read encrypted reports, they are not part of the export
+				var export struct {
+					Encrypted int `json:"encrypted"`
+				}
+				if err := json.Unmarshal(res, &export); err == nil && export.Encrypted != 0 {
+					fmt.Printf("%v encrypted records of the released patients are not part of the export\n", export.Encrypted)
 				}
 
-				if smartContract == "RegisterDoctor" {
-					fmt.Println("Doctor Registered Successfully!")
+			/// retention and archival
+			case "SetRetentionPolicy":
+				fmt.Printf("Enter the object type: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the retention period in days (0 keeps forever): ")
+				fmt.Scanf("%s", &args[1])
+				_, err := submitTransaction(chaincode, smartContract, org, args[:2]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
 				}
-			
-			case "AddMedicalRecord":
-				_, err := submitTransactionWithTransient(chaincode, smartContract, org)
+				fmt.Println("Retention Policy Set Successfully!")
+
+			case "SetCollectedAtWindow":
+				fmt.Printf("Enter how many hours before the issue time records can be collected at: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter how many minutes after the issue time records can be collected at: ")
+				fmt.Scanf("%s", &args[1])
+				_, err := submitTransaction(chaincode, smartContract, org, args[:2]...)
 				if err != nil {
 					fmt.Println("ERROR: ", err)
 					return 
 				}
+				fmt.Println("Collected At Window Set Successfully!")
 
-				// fmt.Println(res)
+			case "MigrateCollection":
+				fmt.Printf("Enter the collection name: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the objects scanned per transaction (0 for the default): ")
+				fmt.Scanf("%s", &args[1])
+				/// each transaction migrates a page, continued from the bookmark until the collection is scanned
+				for {
+					res, err := submitTransaction(chaincode, smartContract, org, args[:3]...)
+					if err != nil {
+						fmt.Println("ERROR: ", err)
+						return 
+					}
 
-				fmt.Println("Medical Record Added Successfully!")
-				
-			case "Exit", "exit":
-				os.Exit(0)
-				return
-			
-			default: 
-				fmt.Println("Enter valid smart contract name")
-		}
-	}
-	
-	connection.Close()
+					result, err :=  formatJSON(res)
+					if err != nil {
+						fmt.Printf("ERROR: %v\n", err)
+					}
 
-	return
-}
+					fmt.Printf("Result: %v\n", string(result))
 
-/// function takes in org, wallet, user and connect to gateway 
-/// and returns gateway
-func connect(org string, wallet *gateway.Wallet, user string) (*gateway.Gateway, error) {
-	
-	ccpPath := filepath.Join(
-		"..",
-		"..",
-		"fabric-samples",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		org + ".example.com",
-		"connection-" + org +".yaml",
-	)
+					var page struct {
+						Bookmark string `json:"bookmark"`
+					}
+					err = json.Unmarshal(res, &page)
+					if err != nil {
+						fmt.Printf("ERROR: Cannot unmarshal the migration result: %v\n", err)
+						return
+					}
 
+					if len(page.Bookmark) == 0 {
+						break
+					}
+					args[2] = page.Bookmark
+				}
 
-	gw, err := gateway.Connect(
-		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, user),
-		gateway.WithTimeout(3000*time.Second),
-	)
+			case "GetRetentionPolicies", "GetArchiveReceipts", "SweepExpiredRequests", "GetCollectedAtWindow":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
 
-	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to gateway: %v", err)
-	}
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
 
-	return gw, nil
-}
+				fmt.Printf("Result: %v\n", string(result))
 
+			case "ExportArchive":
+				fmt.Printf("Enter the object type: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the file to write the archive to: ")
+				fmt.Scanf("%s", &args[1])
+				err := exportArchive(chaincode, user, org, args[0], args[1])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("Signed Archive Written to %v\n", args[1])
 
-/// function to submit a transaction 
-func submitTransaction(chaincode *gateway.Contract, smartContractName string, org string, args ...string) ([]byte, error) {
+			/// patient record export signed by the hospital
+			case "ExportMyRecord":
+				fmt.Printf("Enter the file to write the export to: ")
+				fmt.Scanf("%s", &args[0])
+				err := exportMyRecord(chaincode, user, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("Signed Record Export Written to %v\n", args[0])
 
-	switch smartContractName {
-		case "InitPrivateDataCollection":
-			if valid := validArgs(args, 1); !valid {
-				return nil, fmt.Errorf("Error: parameters not valid")
-			}
-		case "AppointDoctor":
-			if valid := validArgs(args, 1); !valid {
-				return nil, fmt.Errorf("Error: parameters not valid")
-			}
-		case "ShareAssetData":
-			res, err := subTransactionWithOutArgs(chaincode, smartContractName, org)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to share asset data: %v", err)
-			}
-			return res, nil
-		case "CreateRequestAgreement":
-			if valid := validArgs(args, 4); !valid {
+			case "VerifyRecordExport":
+				fmt.Printf("Enter the signed record export file: ")
+				fmt.Scanf("%s", &args[0])
+				res, err := verifyRecordExport(args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "PurgeArchive":
+				fmt.Printf("Enter the signed archive file: ")
+				fmt.Scanf("%s", &args[0])
+				archive, err := ioutil.ReadFile(filepath.Clean(args[0]))
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				transientData := map[string][]byte{
+					"archive": archive,
+				}
+				res, err := subTransactionWithTransientData(chaincode, smartContract, org, transientData)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("Archive Purged Successfully, receipt %v\n", string(res))
+
+			case "VerifyMedicalRecordSignature":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the medical record id: ")
+				fmt.Scanf("%s", &args[1])
+				res, err := evaluateTransaction(chaincode, smartContract, org, args[:2]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			/// patient acknowledgement of medical records
+			case "AcknowledgeMedicalRecord", "DisputeMedicalRecord":
+				fmt.Printf("Enter the medical record id: ")
+				fmt.Scanf("%s", &args[0])
+				var comment string
+				if smartContract == "DisputeMedicalRecord" {
+					fmt.Printf("Enter the reason for the dispute: ")
+					comment = scanLine()
+				}
+				_, err := acknowledgeMedicalRecord(chaincode, user, org, args[0], comment, smartContract == "DisputeMedicalRecord")
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				if smartContract == "DisputeMedicalRecord" {
+					fmt.Println("Medical Record Disputed Successfully!")
+				} else {
+					fmt.Println("Medical Record Acknowledged Successfully!")
+				}
+
+			case "ResolveRecordDispute":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the medical record id: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the resolution: ")
+				args[2] = scanLine()
+				_, err := submitTransaction(chaincode, smartContract, org, args[:3]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Record Dispute Resolved Successfully!")
+
+			case "GetRecordDisputes", "GetInbox":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "MarkInboxRead":
+				fmt.Printf("Enter the inbox item id: ")
+				fmt.Scanf("%s", &args[0])
+				_, err := submitTransaction(chaincode, smartContract, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Inbox Item Marked As Read!")
+
+			/// read patient data
+			/// envelope encryption of medical reports
+			case "SetDataKey":
+				_, err := setDataKey(chaincode, user, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Data Key Set Successfully!")
+
+			case "RotateDataKey":
+				rotated, err := rotateDataKey(chaincode, user, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				if !rotated {
+					fmt.Println("Data key not set, nothing to rotate")
+					break
+				}
+				fmt.Println("Data Key Rotated Successfully!")
+
+			case "GetKeyRotationStatus", "GetAccessLog":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "PublishCertificate":
+				_, err := subTransactionWithOutArgs(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Certificate Published Successfully!")
+
+			case "GetMedicalReports":
+				res, err := readMedicalReports(chaincode, user, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "QueryMedicalRecords":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the start as YYYY-MM-DD or an RFC 3339 time (empty for none): ")
+				args[1] = scanLine()
+				fmt.Printf("Enter the end, excluded, as YYYY-MM-DD or an RFC 3339 time (empty for none): ")
+				args[2] = scanLine()
+				fmt.Printf("Enter the type of medical record (empty for all): ")
+				args[3] = scanLine()
+				res, err := queryMedicalRecords(chaincode, user, org, args[0], args[1], args[2], args[3])
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "GetAnalyteTrend":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the analyte (e.g. hb, creatinine): ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the CSV file to export to (empty to print): ")
+				args[2] = scanLine()
+				trend, err := getAnalyteTrend(chaincode, user, org, args[0], args[1])
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				if len(args[2]) == 0 {
+					printAnalyteTrend(trend)
+					return
+				}
+
+				err = writeAnalyteTrendCSV(trend, args[2])
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+				fmt.Printf("Analyte Trend Exported to %v\n", args[2])
+
+			case "GetPatientInfo", "GetDoctorInfo":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR : %v\n", err)
+				}
+
+				result, err :=  formatJSON(res)
+					if err != nil {
+						fmt.Printf("ERROR: %v\n", err)
+					}
+
+				fmt.Printf("Result: %v\n", string(result))
+			
+			case "GetPatientDataOrg", "GetPatientData":
+				res, err := submitTransaction(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				
+				result, err :=  formatJSON(res)
+					if err != nil {
+						fmt.Printf("ERROR: %v\n", err)
+					}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "GetDoctorDataOrg":
+				res, err := evaluateTransaction(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				
+				result, err :=  formatJSON(res)
+					if err != nil {
+						fmt.Printf("ERROR: %v\n", err)
+					}
+
+				fmt.Printf("Result: %v\n", string(result))
+			
+			//// read smart contracts 
+			case "ReadPatientData":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				res, err := readPatientData(chaincode, user, org, args[0])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "ReadRequestAgreement":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				res, err := evaluateTransaction(chaincode, smartContract, org, args...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+			
+			case "ReadPatientsData":
+				res, err := submitTransaction(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "NotifyRequestAgreement", "NotifyDataAccessRequest", "GetOrgStatistics", "GetProbableDuplicates":
+				res, err := evuTxn(chaincode, smartContract, org)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+			
+			case "ReadDoctorPrivateData":
+				fmt.Printf("Enter the doctor id: ")
+				fmt.Scanf("%s", &args[0])
+				res, err := evaluateTransaction(chaincode, smartContract, org, args...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "RegisterPatient", "RegisterDoctor":
+				_, err := submitTransactionWithTransient(chaincode, smartContract, user, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				// fmt.Println(res)
+
+				if smartContract == "RegisterPatient" {
+					fmt.Println("Patient Registered Successfully!")
+				}
+
+				if smartContract == "RegisterDoctor" {
+					fmt.Println("Doctor Registered Successfully!")
+				}
+			
+			/// patient transfer between orgs, requested by the patient
+			case "TransferPatient":
+				fmt.Printf("Enter the destination org name: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the receiving doctor id (empty for none): ")
+				args[1] = scanLine()
+				err := transferPatient(chaincode, user, org, args[0], args[1])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Patient Transfer Requested Successfully!")
+
+			/// approved by the admins of both orgs, released by the source and accepted by the destination
+			case "ApprovePatientTransfer", "ReleasePatientTransfer", "AcceptPatientTransfer", "CancelPatientTransfer":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				_, err := submitTransaction(chaincode, smartContract, org, args[:1]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Printf("%v Successful!\n", smartContract)
+
+			case "ReadPatientTransfer", "ReadPatientForward", "ReadPatientMerge":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				res, err := evaluateTransaction(chaincode, smartContract, org, args[:1]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+				if smartContract != "ReadPatientTransfer" {
+					break
+				}
+
+				/// the former doctors still hold the data key of the transferred patient
+				rotated, err := rotateTransferredDataKey(chaincode, user, org, args[0], res)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				if rotated {
+					fmt.Println("Data Key Rotated Successfully!")
+				}
+
+			/// master patient index, duplicates are reviewed and merged by the admin
+			case "SetPatientIndexSalt":
+				err := setPatientIndexSalt(chaincode, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Patient Index Salt Set Successfully!")
+
+			case "IndexPatient", "MergePatients":
+				nargs := 1
+				if smartContract == "MergePatients" {
+					fmt.Printf("Enter the id of the patient to keep: ")
+					fmt.Scanf("%s", &args[0])
+					fmt.Printf("Enter the id of the duplicate patient: ")
+					fmt.Scanf("%s", &args[1])
+					fmt.Printf("Enter the reason to merge patients not flagged as duplicates (empty for flagged): ")
+					args[2] = scanLine()
+					nargs = 3
+				} else {
+					fmt.Printf("Enter the patient id: ")
+					fmt.Scanf("%s", &args[0])
+				}
+				res, err := submitTransaction(chaincode, smartContract, org, args[:nargs]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				result, err :=  formatJSON(res)
+				if err != nil {
+					fmt.Printf("ERROR: %v\n", err)
+				}
+
+				fmt.Printf("Result: %v\n", string(result))
+
+			case "DismissProbableDuplicate":
+				fmt.Printf("Enter the patient id: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the org of the other patient: ")
+				fmt.Scanf("%s", &args[1])
+				fmt.Printf("Enter the id of the other patient: ")
+				fmt.Scanf("%s", &args[2])
+				otherOrg, err := getOrgMSPID(args[1])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				args[1] = otherOrg
+				_, err = submitTransaction(chaincode, smartContract, org, args[:3]...)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+				fmt.Println("Probable Duplicate Dismissed Successfully!")
+
+			/// bulk onboarding of patients by the hospital admin
+			case "RegisterPatients":
+				fmt.Printf("Enter the CSV or JSON Lines file of patients: ")
+				fmt.Scanf("%s", &args[0])
+				fmt.Printf("Enter the report file (an existing report resumes the onboarding): ")
+				fmt.Scanf("%s", &args[1])
+				entries, err := bulkRegisterPatients(chaincode, org, args[0], args[1])
+				summary := onboardingSummary(entries)
+				fmt.Printf("Created %v (%v probable duplicates), already registered %v, rejected %v, report written to %v\n", summary[onboardingCreated], summary[onboardingProbableDuplicates], summary[onboardingExisting], summary[onboardingRejected], args[1])
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+			case "AddMedicalRecord":
+				_, err := submitTransactionWithTransient(chaincode, smartContract, user, org)
+				if err != nil {
+					fmt.Println("ERROR: ", err)
+					return 
+				}
+
+				// fmt.Println(res)
+
+				fmt.Println("Medical Record Added Successfully!")
+				
+			case "Exit", "exit":
+				os.Exit(0)
+				return
+			
+			default: 
+				fmt.Println("Enter valid smart contract name")
+		}
+	}
+	
+	connection.Close()
+
+	return
+}
+
+/// function takes in org, wallet, user and connect to gateway 
+/// and returns gateway
+func connect(org string, wallet *gateway.Wallet, user string) (*gateway.Gateway, error) {
+	
+	ccpPath := filepath.Join(
+		"..",
+		"..",
+		"fabric-samples",
+		"test-network",
+		"organizations",
+		"peerOrganizations",
+		org + ".example.com",
+		"connection-" + org +".yaml",
+	)
+
+
+	gw, err := gateway.Connect(
+		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
+		gateway.WithIdentity(wallet, user),
+		gateway.WithTimeout(3000*time.Second),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to gateway: %v", err)
+	}
+
+	return gw, nil
+}
+
+
+/// function to submit a transaction 
+func submitTransaction(chaincode *gateway.Contract, smartContractName string, org string, args ...string) ([]byte, error) {
+
+	switch smartContractName {
+		case "InitPrivateDataCollection":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "InitLedger":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "GenerateSyntheticData":
+			if valid := validArgs(args, 4); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "AppointDoctor":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ShareAssetData":
+			res, err := subTransactionWithOutArgs(chaincode, smartContractName, org)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to share asset data: %v", err)
+			}
+			return res, nil
+		case "CreateRequestAgreement":
+			if valid := validArgs(args, 3); !valid {
 				return nil, fmt.Errorf("Error: parameters not valid")
 			}
-			args = append(args, org)
 		case "ValidateRequestAgreement", "ValidateDataAccessRequest":
 			if valid := validArgs(args, 1); !valid {
 				return nil, fmt.Errorf("Error: parameters not valid")
 			}
 		case "CreateDataAccessRequest":
-			if valid := validArgs(args, 3); !valid {
+			if valid := validArgs(args, 2); !valid {
 				return nil, fmt.Errorf("Error: parameters not valid")
 			}
-			args = append(args, org)
 		case "GrantDataAccess":
 			if valid := validArgs(args, 1); !valid {
 				return nil, fmt.Errorf("Error: parameters not valid")
@@ -379,496 +1171,1517 @@ func submitTransaction(chaincode *gateway.Contract, smartContractName string, or
 			if valid := validArgs(args, 1); !valid {
 				return nil, fmt.Errorf("Error: parameters not valid")
 			}
+		case "ReferPatient":
+			if valid := validArgs(args, 4); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "RespondToReferral":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "CompleteReferral":
+			if valid := validArgs(args, 3); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "CreateLabOrder":
+			if valid := validArgs(args, 3); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "SetRetentionPolicy":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "SetCollectedAtWindow":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "MigrateCollection":
+			/// the bookmark is empty on the first page
+			if valid := validArgs(args, 2) || validArgs(args, 3); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "SetResearchConsent":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "AcknowledgeMedicalRecord":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "DisputeMedicalRecord", "ResolveRecordDispute":
+			if valid := validArgs(args, 3); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "MarkInboxRead":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ApprovePatientTransfer", "ReleasePatientTransfer", "AcceptPatientTransfer", "CancelPatientTransfer":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "IndexPatient":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "MergePatients":
+			/// the override reason is only needed for patients the index did not flag
+			if len(args) != 3 || len(args[0]) == 0 || len(args[1]) == 0 {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "DismissProbableDuplicate":
+			if valid := validArgs(args, 3); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		/// reads of patient data are recorded in the access log of the patient,
+		/// they are submitted so the access log entry is committed
+		case "GetPatientDataOrg", "GetPatientData", "ReadPatientsData":
+			res, err := subTransactionWithOutArgs(chaincode, smartContractName, org)
+			if err != nil {
+				return nil, fmt.Errorf("cannot execute smart contract: %v", err)
+			}
+			return res, nil
+		case "ReadPatientData":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "QueryMedicalRecords":
+			/// the range and the type are optional
+			if len(args) != 4 || len(args[0]) == 0 {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "GetAnalyteTrend":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		default: 
+			return nil, fmt.Errorf("Error: invalid smart contract")		
+	}
+
+	var endorsingPeer string
+
+	if org == "org1" {
+		endorsingPeer = "peer0.org1.example.com:7051"
+	} 
+	if org == "org2" {
+		endorsingPeer = "peer0.org2.example.com:9051"
+	}
+
+	tnx, err := chaincode.CreateTransaction(
+		smartContractName,
+		gateway.WithEndorsingPeers(endorsingPeer),
+	)
+	
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+	}
+
+	res, err := tnx.Submit(args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+	}	
+
+	return res, nil
+}
+
+/// submit transaction with out any arguments
+func subTransactionWithOutArgs(chaincode *gateway.Contract, smartContractName string, org string) ([]byte, error) {
+
+	var endorsingPeer string
+
+	if org == "org1" {
+		endorsingPeer = "peer0.org1.example.com:7051"
+	} 
+	if org == "org2" {
+		endorsingPeer = "peer0.org2.example.com:9051"
+	}
+
+	tnx, err := chaincode.CreateTransaction(
+		smartContractName,
+		gateway.WithEndorsingPeers(endorsingPeer),
+	)
+	
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+	}
+
+	res, err := tnx.Submit()
+	if err != nil {
+		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+	}	
+
+	return res, nil
+
+}
+
+/// evaluate transaction and return the result
+func evaluateTransaction(chaincode *gateway.Contract, smartContractName string, org string, args ...string) ([]byte, error) {
+
+	switch smartContractName{
+		case "GetDoctorDataOrg":
+			res, err := evuTxn(chaincode, smartContractName, org)
+			if err != nil {
+				return nil, fmt.Errorf("cannot execute smart contract: %v", err)
+			}
+			return res, nil
+		case "ReadRequestAgreement", "ReadDataAccessRequest":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ReadDoctorPrivateData":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "GetIdentityAttribute":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ExportResearchData":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "VerifyMedicalRecordSignature":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ReadWrappedDataKey", "ReadClientCertificate":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ExportArchive":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ReadReferral":
+			if valid := validArgs(args, 2); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		case "ReadPatientTransfer", "ReadPatientForward", "ReadPatientMerge":
+			if valid := validArgs(args, 1); !valid {
+				return nil, fmt.Errorf("Error: parameters not valid")
+			}
+		default: 
+			return nil, fmt.Errorf("Error: invalid smart contract")	
+	}
+
+	var endorsingPeer string
+
+	if org == "org1" {
+		endorsingPeer = "peer0.org1.example.com:7051"
+	} 
+	if org == "org2" {
+		endorsingPeer = "peer0.org2.example.com:9051"
+	}
+
+	tnx, err := chaincode.CreateTransaction(
+		smartContractName,
+		gateway.WithEndorsingPeers(endorsingPeer),
+	)
+	
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+	}
+
+	res, err := tnx.Submit(args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+	}	
+
+	return res, nil
+}
+
+/// eval transactions without any arguments 
+func evuTxn(chaincode *gateway.Contract, smartContractName string, org string) ([]byte, error) {
+	var endorsingPeer string
+
+			if org == "org1" {
+				endorsingPeer = "peer0.org1.example.com:7051"
+			} 
+			if org == "org2" {
+				endorsingPeer = "peer0.org2.example.com:9051"
+			}
+
+			tnx, err := chaincode.CreateTransaction(
+				smartContractName,
+				gateway.WithEndorsingPeers(endorsingPeer),
+			)
+			
+			if err != nil {
+				return nil, fmt.Errorf("Error while creating transaction: %v", err)
+			}
+		
+			res, err := tnx.Submit()
+			if err != nil {
+				return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+			}	
+		
+			return res, nil
+}
+
+/// submit transaction with transient data to network
+func submitTransactionWithTransient(chaincode *gateway.Contract, smartContractName string, user string, org string) ([]byte, error) {
+
+	var id string
+	var transientData map[string][]byte
+	var err error
+
+	if smartContractName == "AddMedicalRecord" {
+		fmt.Printf("Enter patient id:  ")
+		fmt.Scanf("%s", &id)
+		transientData, err = createMedicalData(chaincode, user, org, id)
+		if err != nil {
+			return nil, fmt.Errorf("Error cannot get transient data: %v", err)
+		}
+	} else {
+		transientData, err = getTransientData(smartContractName)
+		if err != nil {
+			return nil, fmt.Errorf("Error cannot get transient data: %v", err)
+		}
+	}
+
+	var endorsingPeer string
+
+	if org == "org1" {
+		endorsingPeer = "peer0.org1.example.com:7051"
+	} 
+	if org == "org2" {
+		endorsingPeer = "peer0.org2.example.com:9051"
+	}
+
+	tnx, err := chaincode.CreateTransaction(
+		smartContractName,
+		gateway.WithTransient(transientData),
+		gateway.WithEndorsingPeers(endorsingPeer),
+	)
+	
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+	}
+
+	if (smartContractName == "AddMedicalRecord") {
+		res, err := tnx.Submit(id)
+		if err != nil {
+			return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+		}	
+		return res, nil
+	}
+
+	res, err := tnx.Submit()
+	if err != nil {
+		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+	}	
+
+	return res, nil
+}
+
+/// submit transaction with the given transient data to network
+func subTransactionWithTransientData(chaincode *gateway.Contract, smartContractName string, org string, transientData map[string][]byte, args ...string) ([]byte, error) {
+
+	var endorsingPeer string
+
+	if org == "org1" {
+		endorsingPeer = "peer0.org1.example.com:7051"
+	} 
+	if org == "org2" {
+		endorsingPeer = "peer0.org2.example.com:9051"
+	}
+
+	tnx, err := chaincode.CreateTransaction(
+		smartContractName,
+		gateway.WithTransient(transientData),
+		gateway.WithEndorsingPeers(endorsingPeer),
+	)
+	
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+	}
+
+	res, err := tnx.Submit(args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
+	}	
+
+	return res, nil
+}
+
+///functions helps create the transaction data based on the smartcontract 
+func getTransientData(smartContractName string) (map[string][]byte, error) {
+
+	switch smartContractName {
+		case "RegisterPatient", "RegisterDoctor":
+			return createRegisterData(smartContractName)
 		default: 
-			return nil, fmt.Errorf("Error: invalid smart contract")		
+			return nil, fmt.Errorf("smart contract is invalid")
+	}
+}
+
+
+/// get Org wallet function returns the wallet for the org
+func getOrgWallet(org string) (*gateway.Wallet, error) {
+
+	walletPath := org + "/wallet"
+	wallet, err := gateway.NewFileSystemWallet(walletPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create wallet: %s\n", err)
+	}
+
+	return wallet, nil
+}
+
+/// get org msp id from the org name 
+func getOrgMSPID(org string) (string, error) {
+	if len(org) == 0 {
+		return "", fmt.Errorf("Org name is needed")
+	}
+	return (strings.ToUpper(string(org[0])) + string(org[1:]) + "MSP"), nil
+}
+
+/// populateWallet function inserts the identities (client certs) into wallet 
+func populateWallet(wallet *gateway.Wallet, user string, org string) error {
+	credPath := filepath.Join(
+		"..",
+		"..",
+		"fabric-samples",
+		"test-network",
+		"organizations",
+		"peerOrganizations",
+		org + ".example.com",
+		"users",
+		user + "@" + org + ".example.com",
+		"msp",
+	)
+
+	certPath := filepath.Join(credPath, "signcerts", "cert.pem")
+	// read the certificate pem
+	cert, err := ioutil.ReadFile(filepath.Clean(certPath))
+	if err != nil {
+		return err
+	}
+
+	keyDir := filepath.Join(credPath, "keystore")
+	// there's a single file in this dir containing the private key
+	files, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return errors.New("keystore folder should have contain one file")
+	}
+	keyPath := filepath.Join(keyDir, files[0].Name())
+	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
+	if err != nil {
+		return err
+	}
+
+	orgMSPID, err := getOrgMSPID(org)
+	if err  != nil {
+		return fmt.Errorf("Error while populating the wallet: %v", err)
+	}
+
+	identity := gateway.NewX509Identity(orgMSPID, string(cert), string(key))
+
+	err = wallet.Put(user, identity)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/// channel and chaincode the application is connected to
+type requestTarget struct {
+	channel string
+	chaincode string
+}
+
+/// request data to sign, bound to the channel and chaincode and used once
+/// an empty expiry lets the chaincode use its default
+func newRequestPayload(objectType string, meta ds.MetaDataReq, pid, hid, expiryDays string, target requestTarget) ([]byte, error) {
+
+	days := 0
+	if len(strings.TrimSpace(expiryDays)) != 0 {
+		var err error
+		days, err = strconv.Atoi(strings.TrimSpace(expiryDays))
+		if err != nil {
+			return nil, fmt.Errorf("Expiry days is not a number: %v", err)
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Cannot generate nonce: %v", err)
+	}
+
+	payload := ds.SignedRequestPayload{
+		Type: objectType,
+		MetaData: meta,
+		PID: pid,
+		HID: hid,
+		Nonce: hex.EncodeToString(nonce),
+		IssuedAt: time.Now().UTC(),
+		Channel: target.channel,
+		Chaincode: target.chaincode,
+		ExpiryDays: days,
+	}
+
+	payloadBytes, err := canonical.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Error cannot marshal data: %v", err)
+	}
+
+	return payloadBytes, nil
+}
+
+/// check the stored request matches the payload its signatures are on
+func readSignedPayload(payload string, objectType, pid, hid string, meta ds.MetaDataReq) error {
+
+	var request ds.SignedRequestPayload
+	err := json.Unmarshal([]byte(payload), &request)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal the signed payload: %v", err)
+	}
+
+	if request.Type != objectType || request.PID != pid || request.HID != hid || request.MetaData != meta {
+		return fmt.Errorf("Request does not match the signed payload")
+	}
+
+	return nil
+}
+
+/// 
+func createRequestAgreement(chaincode *gateway.Contract, user, org, id, expiryDays string, target requestTarget) ([]byte, error) {
+
+	/// get client id
+	clientID, err := evuTxn(chaincode, "GetInvokedClientIdentity", org,)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}	
+
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
+	}
+
+	/// request data 
+	meta := ds.MetaDataReq{
+		Org: org,
+		User: user,
+		ClientID: string(idAttr),
+	}
+
+	dataBytes, err := newRequestPayload("requestAgreement", meta, id, string(clientID), expiryDays, target)
+	if err != nil {
+		return nil, err
+	}
+
+	/// get wallet 
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	/// get digital signatures 
+	clientDigitalSign, err := sign.GetUserCanonicalSignature(user, org, dataBytes, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+	}
+
+	hospDigitalSign, err := sign.GetUserCanonicalSignature("Admin", org, dataBytes, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get digital signature of admin: %v", err)
+	}
+
+	/// invoke the create request agreement 
+	res, err := submitTransaction(chaincode, "CreateRequestAgreement", org, string(dataBytes), clientDigitalSign, hospDigitalSign)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot invoke create request agreement smart contract: %v", err)
+	}
+
+	return res, nil
+}
+
+/// 
+func verifyDigitalSignature(chaincode *gateway.Contract, org string) (bool, error) {
+
+	idBytes, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return false, fmt.Errorf("Failed to verify digital signature: %v", err)
+	}
+
+	/// convert byte to string 
+	id := string(idBytes)
+
+	data, err := evaluateTransaction(chaincode, "ReadRequestAgreement", org, id)
+	if err != nil {
+		return false, fmt.Errorf("Cannot verify digital signature: %v", err)
+	}
+
+	var requestAgreement ds.RequestAgreementWithSign
+	err = json.Unmarshal(data, &requestAgreement)
+	if err != nil {
+		return false, fmt.Errorf("Cannot unmarshal the request agreement data: %v", err)
+	}
+
+	/// the signatures are on the payload, which must describe this agreement
+	payload := requestAgreement.GetPayload()
+	err = readSignedPayload(payload, "requestAgreement", requestAgreement.GetPID(), requestAgreement.GetHID(), requestAgreement.GetMetaInfo())
+	if err != nil {
+		return false, err
+	}
+
+	/// get digital signatures from request agreement
+	clientDSign := requestAgreement.GetClientDSign()
+	hospDSign := requestAgreement.GetOrgDSign()
+
+	/// get meta data from request agreement 
+	orgReq := requestAgreement.GetMetaDataOrg()
+	userReq := requestAgreement.GetMetaDataUser()
+
+	/// get wallet 
+	wallet, err := getOrgWallet(orgReq)
+	if err != nil {
+		return false, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	/// verify the digital signatures 
+	checkClientDSign, err := sign.VerifyCanonical(userReq, orgReq, []byte(payload), clientDSign, wallet)
+	if err != nil {
+		return false, fmt.Errorf("Client Digital Signature failed to verify: %v", err)
 	}
+	checkHospDSign, err := sign.VerifyCanonical("Admin", orgReq, []byte(payload), hospDSign, wallet)
+	if err != nil {
+		return false, fmt.Errorf("Organization Digital Signature failed to verify: %v", err)
+	}
+	valid := checkClientDSign && checkHospDSign
+	
+	// fmt.Printf("Client : %v", checkClientDSign)
+	// fmt.Printf("Admin : %v", checkHospDSign)
 
-	var endorsingPeer string
+	return valid, nil
+}
 
-	if org == "org1" {
-		endorsingPeer = "peer0.org1.example.com:7051"
-	} 
-	if org == "org2" {
-		endorsingPeer = "peer0.org2.example.com:9051"
+/// 
+func createDataAccessRequest(chaincode *gateway.Contract, user, org, id, expiryDays string, target requestTarget) ([]byte, error) {	
+
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
 	}
 
-	tnx, err := chaincode.CreateTransaction(
-		smartContractName,
-		gateway.WithEndorsingPeers(endorsingPeer),
-	)
-	
+	/// get wallet 
+	wallet, err := getOrgWallet(org)
 	if err != nil {
-		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
 	}
 
-	res, err := tnx.Submit(args...)
+	/// data access request data 
+	meta := ds.MetaDataReq {
+		Org: org,
+		User: user,
+		ClientID: string(idAttr),
+	}
+
+	dataBytes, err := newRequestPayload("dataAccessRequest", meta, id, "", expiryDays, target)
 	if err != nil {
-		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
-	}	
+		return nil, err
+	}
+
+	/// get digital signatures 
+	clientDigitalSign, err := sign.GetUserCanonicalSignature(user, org, dataBytes, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+	}
+
+	/// invoke the create request agreement 
+	res, err := submitTransaction(chaincode, "CreateDataAccessRequest", org, string(dataBytes), clientDigitalSign)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot invoke create request agreement smart contract: %v", err)
+	}
 
 	return res, nil
 }
 
-/// submit transaction with out any arguments
-func subTransactionWithOutArgs(chaincode *gateway.Contract, smartContractName string, org string) ([]byte, error) {
+/// 
+func verifyDSOnAccessRequest(chaincode *gateway.Contract, org string) (bool, error) { 
 
-	var endorsingPeer string
+	idBytes, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return false, fmt.Errorf("Failed to verify digital signature: %v", err)
+	}
 
-	if org == "org1" {
-		endorsingPeer = "peer0.org1.example.com:7051"
-	} 
-	if org == "org2" {
-		endorsingPeer = "peer0.org2.example.com:9051"
+	/// convert byte to string 
+	id := string(idBytes)
+
+	data, err := evaluateTransaction(chaincode, "ReadDataAccessRequest", org, id)
+	if err != nil {
+		return false, fmt.Errorf("Cannot verify digital signature: %v", err)
 	}
 
-	tnx, err := chaincode.CreateTransaction(
-		smartContractName,
-		gateway.WithEndorsingPeers(endorsingPeer),
-	)
-	
+	var accessRequest ds.DataAccessRequest
+	err = json.Unmarshal(data, &accessRequest)
+	if err != nil {
+		return false, fmt.Errorf("Cannot unmarshal the data access request data: %v", err)
+	}
+
+	/// the signature is on the payload, which must describe this request
+	payload := accessRequest.GetPayload()
+	err = readSignedPayload(payload, "dataAccessRequest", accessRequest.GetPatientID(), "", accessRequest.GetMetaInfo())
+	if err != nil {
+		return false, err
+	}
+
+	/// get digital signatures from request agreement
+	clientDSign := accessRequest.GetClientDSign()
+
+	/// get meta data from request agreement 
+	orgReq := accessRequest.GetMetaDataOrg()
+	userReq := accessRequest.GetMetaDataUser()
+
+	/// get wallet 
+	wallet, err := getOrgWallet(orgReq)
+	if err != nil {
+		return false, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	/// verify the digital signatures 
+	checkClientDSign, err := sign.VerifyCanonical(userReq, orgReq, []byte(payload), clientDSign, wallet)
+	if err != nil {
+		return false, fmt.Errorf("Client Digital Signature failed to verify: %v", err)
+	}
+
+	return checkClientDSign, nil
+}
+
+/// issue prescription signed by the doctor, returns the prescription id and
+/// the code the patient presents at the pharmacy
+func issuePrescription(chaincode *gateway.Contract, user, org, pid string) ([]byte, string, error) {
+
+	var drug, dose, frequency string
+	var durationDays, refills int
+
+	fmt.Printf("Enter drug: ")
+	fmt.Scanf("%s", &drug)
+	fmt.Printf("Enter dose: ")
+	fmt.Scanf("%s", &dose)
+	fmt.Printf("Enter frequency: ")
+	fmt.Scanf("%s", &frequency)
+	fmt.Printf("Enter duration in days: ")
+	fmt.Scanf("%d", &durationDays)
+	fmt.Printf("Enter number of refills: ")
+	fmt.Scanf("%d", &refills)
+
+	var content ds.PrescriptionContent
+	content.SetInfo(pid, drug, dose, frequency, durationDays, refills)
+
+	dataBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error cannot marshal data: %v", err)
+	}
+
+	/// get wallet 
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	doctorSign, err := sign.GetUserCanonicalSignature(user, org, dataBytes, wallet)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+	}
+
+	code, err := newPrescriptionCode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	input := ds.PrescriptionInput{
+		Content: content,
+		Code: code,
+		DoctorSign: doctorSign,
+	}
+
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error cannot marshal data: %v", err)
+	}
+
+	transientData := map[string][]byte{
+		"prescription_data": inputBytes,
+	}
+
+	res, err := subTransactionWithTransientData(chaincode, "IssuePrescription", org, transientData)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot invoke issue prescription smart contract: %v", err)
+	}
+
+	return res, code, nil
+}
+
+/// countersign a medical record of the patient client, or dispute it with a comment
+func acknowledgeMedicalRecord(chaincode *gateway.Contract, user, org, recordID, comment string, disputed bool) ([]byte, error) {
+
+	data, err := evuTxn(chaincode, "GetMedicalReports", org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read medical reports: %v", err)
+	}
+
+	var records ds.MedicalRecords
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the medical reports: %v", err)
+	}
+
+	var record *ds.MedicalInfo
+	for i := range records.Data {
+		if records.Data[i].ID == recordID {
+			record = &records.Data[i]
+			break
+		}
+	}
+
+	if record == nil {
+		return nil, fmt.Errorf("Medical record %v not found", recordID)
+	}
+
+	content := ds.AcknowledgementContent{
+		RecordID: recordID,
+		DoctorSign: record.DoctorSign,
+		Disputed: disputed,
+		Comment: comment,
+	}
+
+	dataBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("Error cannot marshal data: %v", err)
+	}
+
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	patientSign, err := sign.GetUserCanonicalSignature(user, org, dataBytes, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+	}
+
+	if disputed {
+		return submitTransaction(chaincode, "DisputeMedicalRecord", org, recordID, comment, patientSign)
+	}
+
+	return submitTransaction(chaincode, "AcknowledgeMedicalRecord", org, recordID, patientSign)
+}
+
+/// read the data key of the patient and unwrap it with the private key of the user
+/// the key is nil when the patient has not set a data key
+func readDataKey(chaincode *gateway.Contract, user, org, pid string) ([]byte, int, error) {
+
+	dataKeys, version, err := readDataKeys(chaincode, user, org, pid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return dataKeys[version], version, nil
+}
+
+/// read the data keys of the patient by version, the current key and the key
+/// of the rotation in progress when it is wrapped for the user
+func readDataKeys(chaincode *gateway.Contract, user, org, pid string) (map[int][]byte, int, error) {
+
+	wrapped, err := readWrappedDataKey(chaincode, org, pid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dataKeys := map[int][]byte{}
+	if wrapped.Version == 0 {
+		return dataKeys, 0, nil
+	}
+
+	/// referral access, only the record keys of the referred records are wrapped for the user
+	if len(wrapped.WrappedKey) == 0 {
+		return nil, 0, fmt.Errorf("Data key of %v is not wrapped for the client, referral access opens the referred records only", pid)
+	}
+
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	privateKey, err := sign.GetUserPrivateKey(user, org, wallet)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dataKeys[wrapped.Version], err = envelope.UnwrapKey(privateKey, wrapped.WrappedKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if wrapped.PendingVersion != 0 {
+		dataKeys[wrapped.PendingVersion], err = envelope.UnwrapKey(privateKey, wrapped.PendingWrappedKey)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return dataKeys, wrapped.Version, nil
+}
+
+/// data key of the patient as wrapped for the client, with the record keys
+/// of the referred records for a specialist with referral access
+func readWrappedDataKey(chaincode *gateway.Contract, org, pid string) (*ds.WrappedDataKey, error) {
+
+	data, err := evaluateTransaction(chaincode, "ReadWrappedDataKey", org, pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read data key: %v", err)
+	}
+
+	var wrapped ds.WrappedDataKey
+	err = json.Unmarshal(data, &wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the data key: %v", err)
+	}
+
+	return &wrapped, nil
+}
+
+/// record keys of the referred records wrapped for the user, by record id
+func readScopedRecordKeys(user, org string, wrapped *ds.WrappedDataKey) (map[string][]byte, error) {
+
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	privateKey, err := sign.GetUserPrivateKey(user, org, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	recordKeys := map[string][]byte{}
+	for recordID, wrappedKey := range wrapped.RecordKeys {
+		recordKeys[recordID], err = envelope.UnwrapKey(privateKey, wrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot unwrap record key of %v: %v", recordID, err)
+		}
+	}
+
+	return recordKeys, nil
+}
+
+/// key wrapped with the public key of the published certificate of the client
+func wrapKeyForClient(chaincode *gateway.Contract, org, clientID string, key []byte) (string, error) {
+
+	certPEM, err := evaluateTransaction(chaincode, "ReadClientCertificate", org, clientID)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read certificate of %v: %v", clientID, err)
+	}
+
+	publicKey, err := sign.PublicKeyFromCertificatePEM(certPEM)
+	if err != nil {
+		return "", err
+	}
+
+	return envelope.WrapKey(publicKey, key)
+}
+
+/// record key wrapped for each grantee with referral access whose scope has the record type
+func wrapScopedRecordKey(chaincode *gateway.Contract, org string, accessScopes map[string][]string, recordType string, recordKey []byte) (map[string]string, error) {
+
+	scopedKeys := map[string]string{}
+	for clientID, recordTypes := range accessScopes {
+		if !containsRecordType(recordTypes, recordType) {
+			continue
+		}
+
+		wrappedKey, err := wrapKeyForClient(chaincode, org, clientID, recordKey)
+		if err != nil {
+			return nil, err
+		}
+		scopedKeys[clientID] = wrappedKey
+	}
+
+	return scopedKeys, nil
+}
+
+/// record key of a new record of the patient wrapped for the grantees with referral access
+/// whose scope has the record type, the record key is unwrapped with the data key
+func wrapScopedRecordKeyOf(chaincode *gateway.Contract, org, pid, recordType string, dataKey []byte, wrappedRecordKey string) (map[string]string, error) {
+
+	wrapped, err := readWrappedDataKey(chaincode, org, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	recordKey, err := envelope.UnwrapRecordKey(dataKey, wrappedRecordKey)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unwrap record key: %v", err)
+	}
+
+	return wrapScopedRecordKey(chaincode, org, wrapped.AccessScopes, recordType, recordKey)
+}
+
+/// record type in the list, case insensitive as in the chaincode
+func containsRecordType(recordTypes []string, recordType string) bool {
+	for _, value := range recordTypes {
+		if strings.EqualFold(value, recordType) {
+			return true
+		}
+	}
+	return false
+}
+
+/// wrap the data key of the patient for the client, returned as transient data
+/// the transient data is empty when the patient has not set a data key
+func wrapDataKeyFor(chaincode *gateway.Contract, user, org, pid, clientID string) (map[string][]byte, error) {
+
+	dataKey, _, err := readDataKey(chaincode, user, org, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataKey == nil {
+		return map[string][]byte{}, nil
+	}
+
+	certPEM, err := evaluateTransaction(chaincode, "ReadClientCertificate", org, clientID)
 	if err != nil {
-		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+		return nil, fmt.Errorf("Cannot read certificate of %v: %v", clientID, err)
 	}
 
-	res, err := tnx.Submit()
+	publicKey, err := sign.PublicKeyFromCertificatePEM(certPEM)
 	if err != nil {
-		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
-	}	
+		return nil, err
+	}
 
-	return res, nil
+	wrappedKey, err := envelope.WrapKey(publicKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
 
+	return map[string][]byte{
+		"wrapped_key": []byte(wrappedKey),
+	}, nil
 }
 
-/// evaluate transaction and return the result
-func evaluateTransaction(chaincode *gateway.Contract, smartContractName string, org string, args ...string) ([]byte, error) {
+/// wrap the data key of the invoked patient for the client
+func wrapOwnDataKeyFor(chaincode *gateway.Contract, user, org, clientID string) (map[string][]byte, error) {
 
-	switch smartContractName{
-		case "GetPatientDataOrg", "GetPatientData", "GetDoctorDataOrg":
-			res, err := evuTxn(chaincode, smartContractName, org)
-			if err != nil {
-				return nil, fmt.Errorf("cannot execute smart contract: %v", err)
-			}
-			return res, nil
-		case "ReadRequestAgreement", "ReadDataAccessRequest", "ReadPatientData":
-			if valid := validArgs(args, 1); !valid {
-				return nil, fmt.Errorf("Error: parameters not valid")
-			}
-		case "ReadDoctorPrivateData":
-			if valid := validArgs(args, 1); !valid {
-				return nil, fmt.Errorf("Error: parameters not valid")
-			}
-		case "GetIdentityAttribute":
-			if valid := validArgs(args, 1); !valid {
-				return nil, fmt.Errorf("Error: parameters not valid")
-			}
-		default: 
-			return nil, fmt.Errorf("Error: invalid smart contract")	
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
 	}
 
-	var endorsingPeer string
+	return wrapDataKeyFor(chaincode, user, org, string(idAttr), clientID)
+}
 
-	if org == "org1" {
-		endorsingPeer = "peer0.org1.example.com:7051"
-	} 
-	if org == "org2" {
-		endorsingPeer = "peer0.org2.example.com:9051"
+/// wrap the data key of the invoked patient for the client of the pending data access request
+func wrapDataKeyForRequester(chaincode *gateway.Contract, user, org string) (map[string][]byte, error) {
+
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
 	}
 
-	tnx, err := chaincode.CreateTransaction(
-		smartContractName,
-		gateway.WithEndorsingPeers(endorsingPeer),
-	)
-	
+	data, err := evaluateTransaction(chaincode, "ReadDataAccessRequest", org, string(idAttr))
 	if err != nil {
-		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+		return nil, fmt.Errorf("Cannot read data access request: %v", err)
 	}
 
-	res, err := tnx.Submit(args...)
+	var accessRequest ds.DataAccessRequest
+	err = json.Unmarshal(data, &accessRequest)
 	if err != nil {
-		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
-	}	
+		return nil, fmt.Errorf("Cannot unmarshal the data access request data: %v", err)
+	}
 
-	return res, nil
+	return wrapDataKeyFor(chaincode, user, org, string(idAttr), accessRequest.GetMetaDataID())
 }
 
-/// eval transactions without any arguments 
-func evuTxn(chaincode *gateway.Contract, smartContractName string, org string) ([]byte, error) {
-	var endorsingPeer string
+/// wrap the record keys of the referred records of the invoked patient for the specialist of the referral
+/// the specialist never gets the data key, nothing is wrapped when the referral is declined
+func wrapRecordKeysForSpecialist(chaincode *gateway.Contract, user, org, referralID, accept string) (map[string][]byte, error) {
 
-			if org == "org1" {
-				endorsingPeer = "peer0.org1.example.com:7051"
-			} 
-			if org == "org2" {
-				endorsingPeer = "peer0.org2.example.com:9051"
-			}
+	if accepted, _ := strconv.ParseBool(accept); !accepted {
+		return map[string][]byte{}, nil
+	}
 
-			tnx, err := chaincode.CreateTransaction(
-				smartContractName,
-				gateway.WithEndorsingPeers(endorsingPeer),
-			)
-			
-			if err != nil {
-				return nil, fmt.Errorf("Error while creating transaction: %v", err)
-			}
-		
-			res, err := tnx.Submit()
-			if err != nil {
-				return nil, fmt.Errorf("Error while submiting transaction: %v", err)
-			}	
-		
-			return res, nil
-}
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
+	}
 
-/// submit transaction with transient data to network
-func submitTransactionWithTransient(chaincode *gateway.Contract, smartContractName string, org string) ([]byte, error) {
+	dataKey, _, err := readDataKey(chaincode, user, org, string(idAttr))
+	if err != nil {
+		return nil, err
+	}
 
-	var id string
-	var transientData map[string][]byte
-	var err error
+	if dataKey == nil {
+		return map[string][]byte{}, nil
+	}
 
-	if smartContractName == "AddMedicalRecord" {
-		fmt.Printf("Enter patient id:  ")
-		fmt.Scanf("%s", &id)
-		transientData, err = createMedicalData(id)
-	} else {
-		transientData, err = getTransientData(smartContractName)
-		if err != nil {
-			return nil, fmt.Errorf("Error cannot get transient data: %v", err)
-		}
+	data, err := evaluateTransaction(chaincode, "ReadReferral", org, string(idAttr), referralID)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read referral: %v", err)
 	}
 
-	var endorsingPeer string
+	var referral struct {
+		Specialist string `json:"specialist"`
+		RecordTypes []string `json:"recordTypes"`
+	}
+	err = json.Unmarshal(data, &referral)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the referral: %v", err)
+	}
 
-	if org == "org1" {
-		endorsingPeer = "peer0.org1.example.com:7051"
-	} 
-	if org == "org2" {
-		endorsingPeer = "peer0.org2.example.com:9051"
+	data, err = evuTxn(chaincode, "GetPatientInfo", org)
+	if err != nil {
+		return nil, err
 	}
 
-	tnx, err := chaincode.CreateTransaction(
-		smartContractName,
-		gateway.WithTransient(transientData),
-		gateway.WithEndorsingPeers(endorsingPeer),
-	)
-	
+	var patientData ds.PatientInfo
+	err = json.Unmarshal(data, &patientData)
 	if err != nil {
-		return nil, fmt.Errorf("Error while creating transaction: %v", err)
+		return nil, fmt.Errorf("Cannot unmarshal the patient data: %v", err)
 	}
 
-	if (smartContractName == "AddMedicalRecord") {
-		res, err := tnx.Submit(id)
+	recordKeys := map[string]string{}
+	for _, record := range patientData.MedicalRecords {
+		if !record.Encrypted || !containsRecordType(referral.RecordTypes, record.Type) {
+			continue
+		}
+
+		recordKey, err := envelope.UnwrapRecordKey(dataKey, record.RecordKey)
 		if err != nil {
-			return nil, fmt.Errorf("Error while submiting transaction: %v", err)
-		}	
-		return res, nil
+			return nil, fmt.Errorf("Cannot unwrap record key of %v: %v", record.ID, err)
+		}
+
+		recordKeys[record.ID], err = wrapKeyForClient(chaincode, org, referral.Specialist, recordKey)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	res, err := tnx.Submit()
+	recordKeysJSON, err := json.Marshal(recordKeys)
 	if err != nil {
-		return nil, fmt.Errorf("Error while submiting transaction: %v", err)
-	}	
+		return nil, fmt.Errorf("Cannot marshal the record keys: %v", err)
+	}
 
-	return res, nil
+	return map[string][]byte{
+		"record_keys": recordKeysJSON,
+	}, nil
 }
 
-///functions helps create the transaction data based on the smartcontract 
-func getTransientData(smartContractName string) (map[string][]byte, error) {
+/// generate the data key of the invoked patient, wrapped for the patient
+func setDataKey(chaincode *gateway.Contract, user, org string) ([]byte, error) {
 
-	switch smartContractName {
-		case "RegisterPatient", "RegisterDoctor":
-			return createRegisterData(smartContractName)
-		default: 
-			return nil, fmt.Errorf("smart contract is invalid")
+	dataKey, err := envelope.NewDataKey()
+	if err != nil {
+		return nil, err
 	}
-}
-
 
-/// get Org wallet function returns the wallet for the org
-func getOrgWallet(org string) (*gateway.Wallet, error) {
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
 
-	walletPath := org + "/wallet"
-	wallet, err := gateway.NewFileSystemWallet(walletPath)
+	publicKey, err := sign.GetUserPublicKey(user, org, wallet)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create wallet: %s\n", err)
+		return nil, err
 	}
 
-	return wallet, nil
-}
+	wrappedKey, err := envelope.WrapKey(publicKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
 
-/// get org msp id from the org name 
-func getOrgMSPID(org string) (string, error) {
-	if len(org) == 0 {
-		return "", fmt.Errorf("Org name is needed")
+	transientData := map[string][]byte{
+		"wrapped_key": []byte(wrappedKey),
 	}
-	return (strings.ToUpper(string(org[0])) + string(org[1:]) + "MSP"), nil
+
+	return subTransactionWithTransientData(chaincode, "SetDataKey", org, transientData)
 }
 
-/// populateWallet function inserts the identities (client certs) into wallet 
-func populateWallet(wallet *gateway.Wallet, user string, org string) error {
-	credPath := filepath.Join(
-		"..",
-		"..",
-		"fabric-samples",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		org + ".example.com",
-		"users",
-		user + "@" + org + ".example.com",
-		"msp",
-	)
+/// rotate the data key of the invoked patient, resumes a rotation in progress
+/// returns false when the patient has not set a data key
+func rotateDataKey(chaincode *gateway.Contract, user, org string) (bool, error) {
 
-	certPath := filepath.Join(credPath, "signcerts", "cert.pem")
-	// read the certificate pem
-	cert, err := ioutil.ReadFile(filepath.Clean(certPath))
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
 	if err != nil {
-		return err
+		return false, fmt.Errorf("Error getting client identity attribute: %v", err)
 	}
+	pid := string(idAttr)
 
-	keyDir := filepath.Join(credPath, "keystore")
-	// there's a single file in this dir containing the private key
-	files, err := ioutil.ReadDir(keyDir)
+	data, err := evaluateTransaction(chaincode, "ReadWrappedDataKey", org, pid)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("Cannot read data key: %v", err)
 	}
-	if len(files) != 1 {
-		return errors.New("keystore folder should have contain one file")
-	}
-	keyPath := filepath.Join(keyDir, files[0].Name())
-	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
+
+	var wrapped ds.WrappedDataKey
+	err = json.Unmarshal(data, &wrapped)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("Cannot unmarshal the data key: %v", err)
 	}
 
-	orgMSPID, err := getOrgMSPID(org)
-	if err  != nil {
-		return fmt.Errorf("Error while populating the wallet: %v", err)
+	if wrapped.Version == 0 {
+		return false, nil
 	}
 
-	identity := gateway.NewX509Identity(orgMSPID, string(cert), string(key))
+	/// start a new rotation unless one is in progress
+	if wrapped.PendingVersion == 0 {
+		err = startKeyRotation(chaincode, user, org)
+		if err != nil {
+			return false, err
+		}
+	}
 
-	err = wallet.Put(user, identity)
+	dataKeys, version, err := readDataKeys(chaincode, user, org, pid)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
-}
+	newVersion := version + 1
+	newDataKey, ok := dataKeys[newVersion]
+	if !ok {
+		return false, fmt.Errorf("Data key version %v not available", newVersion)
+	}
 
-/// 
-func createRequestAgreement(chaincode *gateway.Contract, user, org, id string) ([]byte, error) {
+	/// rewrap the record keys in batches
+	for {
+		res, err := evuTxn(chaincode, "GetPatientInfo", org)
+		if err != nil {
+			return false, err
+		}
 
-	/// get client id
-	clientID, err := evuTxn(chaincode, "GetInvokedClientIdentity", org,)
+		var patientData ds.PatientInfo
+		err = json.Unmarshal(res, &patientData)
+		if err != nil {
+			return false, fmt.Errorf("Cannot unmarshal the patient data: %v", err)
+		}
+
+		rotatedRecords := map[string]ds.RotatedRecord{}
+		for _, record := range patientData.MedicalRecords {
+			if !record.Encrypted || record.KeyVersion == newVersion {
+				continue
+			}
+
+			oldDataKey, ok := dataKeys[record.KeyVersion]
+			if !ok {
+				return false, fmt.Errorf("Data key version %v not available", record.KeyVersion)
+			}
+
+			/// records signed over their encrypted report can only be rewrapped
+			if len(record.ReportDigest) == 0 {
+				recordKey, err := envelope.RewrapRecordKey(oldDataKey, newDataKey, record.RecordKey)
+				if err != nil {
+					return false, fmt.Errorf("Cannot rewrap record key of %v: %v", record.ID, err)
+				}
+				rotatedRecords[record.ID] = ds.RotatedRecord{RecordKey: recordKey}
+			} else {
+				sealed, err := envelope.ReencryptReport(oldDataKey, newDataKey, record.RecordKey, record.MReport, record.ReportSalt)
+				if err != nil {
+					return false, fmt.Errorf("Cannot re-encrypt medical record %v: %v", record.ID, err)
+				}
+				if sealed.ReportDigest != record.ReportDigest {
+					return false, fmt.Errorf("Report of medical record %v does not match its signed digest", record.ID)
+				}
+				rotatedRecords[record.ID] = ds.RotatedRecord{MReport: sealed.MReport, RecordKey: sealed.RecordKey, ReportSalt: sealed.ReportSalt}
+			}
+
+			/// record key of the rotated record for the grantees with referral access
+			rotated := rotatedRecords[record.ID]
+			recordKey, err := envelope.UnwrapRecordKey(newDataKey, rotated.RecordKey)
+			if err != nil {
+				return false, fmt.Errorf("Cannot unwrap record key of %v: %v", record.ID, err)
+			}
+
+			rotated.ScopedKeys, err = wrapScopedRecordKey(chaincode, org, patientData.AccessScopes, record.Type, recordKey)
+			if err != nil {
+				return false, err
+			}
+			rotatedRecords[record.ID] = rotated
+
+			if len(rotatedRecords) == rotationBatchSize {
+				break
+			}
+		}
+
+		if len(rotatedRecords) == 0 {
+			break
+		}
+
+		rotatedRecordsJSON, err := json.Marshal(rotatedRecords)
+		if err != nil {
+			return false, fmt.Errorf("Cannot marshal the rotated records: %v", err)
+		}
+
+		transientData := map[string][]byte{
+			"rotated_records": rotatedRecordsJSON,
+		}
+
+		_, err = subTransactionWithTransientData(chaincode, "RotateRecordKeys", org, transientData)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = subTransactionWithOutArgs(chaincode, "CompleteKeyRotation", org)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting client identity: %v", err)
-	}	
+		return false, err
+	}
 
-	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	return true, nil
+}
+
+/// wrap a new data key for the invoked patient and the current grantees
+func startKeyRotation(chaincode *gateway.Contract, user, org string) error {
+
+	data, err := evuTxn(chaincode, "GetPatientInfo", org)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
+		return err
 	}
 
-	/// request data 
-	data := ds.RequestAgreement{
-		MetaInfo: ds.MetaDataReq{
-			Org: org,
-			User: user,
-			ClientID: string(idAttr),
-		},
-		PID: id,
-		HID: string(clientID),
+	var patientData ds.PatientInfo
+	err = json.Unmarshal(data, &patientData)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal the patient data: %v", err)
 	}
 
-	dataBytes, err := json.Marshal(data)
+	dataKey, err := envelope.NewDataKey()
 	if err != nil {
-		return nil, fmt.Errorf("Error cannot marshal data: %v", err)
+		return err
 	}
 
-	/// get wallet 
 	wallet, err := getOrgWallet(org)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+		return fmt.Errorf("Cannot get wallet: %v", err)
 	}
 
-	/// get digital signatures 
-	clientDigitalSign, err := sign.GetUserDigitalSignature(user, org, dataBytes, wallet)
+	publicKey, err := sign.GetUserPublicKey(user, org, wallet)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+		return err
 	}
 
-	hospDigitalSign, err := sign.GetUserDigitalSignature("Admin", org, dataBytes, wallet)
+	wrappedKeys := map[string]string{}
+	wrappedKeys[patientData.ID], err = envelope.WrapKey(publicKey, dataKey)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot get digital signature of admin: %v", err)
+		return err
 	}
 
-	/// invoke the create request agreement 
-	res, err := submitTransaction(chaincode, "CreateRequestAgreement", org, id, clientDigitalSign, hospDigitalSign, user)
+	/// grantees with referral access get the record keys of the referred records on rotation
+	for _, clientID := range patientData.TreatedBy {
+		if _, scoped := patientData.AccessScopes[clientID]; scoped {
+			continue
+		}
+
+		wrappedKeys[clientID], err = wrapKeyForClient(chaincode, org, clientID, dataKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	wrappedKeysJSON, err := json.Marshal(wrappedKeys)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot invoke create request agreement smart contract: %v", err)
+		return fmt.Errorf("Cannot marshal the wrapped keys: %v", err)
 	}
 
-	return res, nil
+	transientData := map[string][]byte{
+		"wrapped_keys": wrappedKeysJSON,
+	}
+
+	_, err = subTransactionWithTransientData(chaincode, "StartKeyRotation", org, transientData)
+	return err
 }
 
-/// 
-func verifyDigitalSignature(chaincode *gateway.Contract, org string) (bool, error) {
+/// decrypt the encrypted medical records of the patient in place
+/// a specialist with referral access opens the referred records with their record keys
+func decryptMedicalRecords(chaincode *gateway.Contract, user, org, pid string, records []ds.MedicalInfo) error {
 
-	idBytes, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
-	if err != nil {
-		return false, fmt.Errorf("Failed to verify digital signature: %v", err)
-	}
+	var dataKeys map[int][]byte
+	var recordKeys map[string][]byte
+	for i := range records {
+		if !records[i].Encrypted {
+			continue
+		}
 
-	/// convert byte to string 
-	id := string(idBytes)
+		if dataKeys == nil && recordKeys == nil {
+			wrapped, err := readWrappedDataKey(chaincode, org, pid)
+			if err != nil {
+				return err
+			}
 
-	data, err := evaluateTransaction(chaincode, "ReadRequestAgreement", org, id)
-	if err != nil {
-		return false, fmt.Errorf("Cannot verify digital signature: %v", err)
-	}
+			if len(wrapped.WrappedKey) == 0 {
+				recordKeys, err = readScopedRecordKeys(user, org, wrapped)
+			} else {
+				dataKeys, _, err = readDataKeys(chaincode, user, org, pid)
+			}
+			if err != nil {
+				return err
+			}
+		}
 
-	var requestAgreement ds.RequestAgreementWithSign
-	err = json.Unmarshal(data, &requestAgreement)
-	if err != nil {
-		return false, fmt.Errorf("Cannot unmarshal the request agreement data: %v", err)
+		var report map[string]string
+		var err error
+		if recordKeys != nil {
+			recordKey, ok := recordKeys[records[i].ID]
+			if !ok {
+				return fmt.Errorf("Record key of %v not available", records[i].ID)
+			}
+			report, err = envelope.DecryptReportWithRecordKey(recordKey, records[i].MReport)
+		} else {
+			dataKey, ok := dataKeys[records[i].KeyVersion]
+			if !ok {
+				return fmt.Errorf("Data key version %v of %v not available", records[i].KeyVersion, pid)
+			}
+			report, err = envelope.DecryptReport(dataKey, records[i].RecordKey, records[i].MReport)
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot decrypt medical record %v: %v", records[i].ID, err)
+		}
+		records[i].MReport = report
 	}
 
-	/// get request data 
-	var requestData ds.RequestAgreement
-	requestData.SetInfo(requestAgreement.GetMetaInfo(), requestAgreement.GetPID(), requestAgreement.GetHID())
-
-	/// get digital signatures from request agreement
-	clientDSign := requestAgreement.GetClientDSign()
-	hospDSign := requestAgreement.GetOrgDSign()
+	return nil
+}
 
-	/// get meta data from request agreement 
-	orgReq := requestAgreement.GetMetaDataOrg()
-	userReq := requestAgreement.GetMetaDataUser()
+/// medical reports of the invoked patient, decrypted
+func readMedicalReports(chaincode *gateway.Contract, user, org string) ([]byte, error) {
 
-	/// convert request data into bytes 
-	dataByte, err := json.Marshal(requestData)
+	data, err := evuTxn(chaincode, "GetMedicalReports", org)
 	if err != nil {
-		return false, fmt.Errorf("Cannot marshal the request agreement data: %v", err)
+		return nil, err
 	}
 
-	/// get wallet 
-	wallet, err := getOrgWallet(orgReq)
+	var records ds.MedicalRecords
+	err = json.Unmarshal(data, &records)
 	if err != nil {
-		return false, fmt.Errorf("Cannot get wallet: %v", err)
+		return nil, fmt.Errorf("Cannot unmarshal the medical reports: %v", err)
 	}
 
-	/// verify the digital signatures 
-	checkClientDSign, err := sign.Verify(userReq, orgReq, dataByte, clientDSign, wallet)
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
 	if err != nil {
-		return false, fmt.Errorf("Client Digital Signature failed to verify: %v", err)
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
 	}
-	checkHospDSign, err := sign.Verify("Admin", orgReq, dataByte, hospDSign, wallet)
+
+	err = decryptMedicalRecords(chaincode, user, org, string(idAttr), records.Data)
 	if err != nil {
-		return false, fmt.Errorf("Organization Digital Signature failed to verify: %v", err)
+		return nil, err
 	}
-	valid := checkClientDSign && checkHospDSign
-	
-	// fmt.Printf("Client : %v", checkClientDSign)
-	// fmt.Printf("Admin : %v", checkHospDSign)
 
-	return valid, nil
+	return json.Marshal(records)
 }
 
-/// 
-func createDataAccessRequest(chaincode *gateway.Contract, user, org, id string) ([]byte, error) {	
+/// patient data read by the treating doctor, decrypted
+func readPatientData(chaincode *gateway.Contract, user, org, pid string) ([]byte, error) {
 
-	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	data, err := submitTransaction(chaincode, "ReadPatientData", org, pid)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
+		return nil, err
 	}
 
-	/// get wallet 
-	wallet, err := getOrgWallet(org)
+	var patientData ds.PatientMainInfo
+	err = json.Unmarshal(data, &patientData)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+		return nil, fmt.Errorf("Cannot unmarshal the patient data: %v", err)
 	}
 
-	/// data access request data 
-	dataAccessReq := ds.DataAccessReq{
-		MetaData: ds.MetaDataReq {
-			Org: org,
-			User: user,
-			ClientID: string(idAttr),
-		},
-		PatientID: id,
+	err = decryptMedicalRecords(chaincode, user, org, pid, patientData.MedicalRecords)
+	if err != nil {
+		return nil, err
 	}
 
-	dataBytes, err := json.Marshal(dataAccessReq)
+	return json.Marshal(patientData)
+}
+
+/// a day as YYYY-MM-DD starts at midnight of the local time zone, other values are passed as given
+func queryBound(value string) string {
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
 	if err != nil {
-		return nil, fmt.Errorf("Error cannot marshal data: %v", err)
+		return value
 	}
+	return day.Format(time.RFC3339)
+}
 
-	/// get digital signatures 
-	clientDigitalSign, err := sign.GetUserDigitalSignature(user, org, dataBytes, wallet)
+/// medical records of the patient issued in the range [from, to) by type, decrypted
+/// the bounds and the type are optional
+func queryMedicalRecords(chaincode *gateway.Contract, user, org, pid, from, to, recordType string) ([]byte, error) {
+
+	data, err := submitTransaction(chaincode, "QueryMedicalRecords", org, pid, queryBound(from), queryBound(to), recordType)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+		return nil, err
 	}
 
-	/// invoke the create request agreement 
-	res, err := submitTransaction(chaincode, "CreateDataAccessRequest", org, id, clientDigitalSign, user)
+	var records ds.MedicalRecords
+	err = json.Unmarshal(data, &records)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot invoke create request agreement smart contract: %v", err)
+		return nil, fmt.Errorf("Cannot unmarshal the medical records: %v", err)
 	}
 
-	return res, nil
+	err = decryptMedicalRecords(chaincode, user, org, pid, records.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(records)
 }
 
-/// 
-func verifyDSOnAccessRequest(chaincode *gateway.Contract, org string) (bool, error) { 
+/// export the objects past retention and sign the archive with the key of the admin
+/// the signed archive is written to the file, PurgeArchive takes the file as is
+func exportArchive(chaincode *gateway.Contract, user, org, objectType, fileName string) error {
 
-	idBytes, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	bundle, err := evaluateTransaction(chaincode, "ExportArchive", org, objectType)
 	if err != nil {
-		return false, fmt.Errorf("Failed to verify digital signature: %v", err)
+		return err
 	}
 
-	/// convert byte to string 
-	id := string(idBytes)
+	var exported struct {
+		Digest string `json:"digest"`
+		Items []json.RawMessage `json:"items"`
+	}
+	err = json.Unmarshal(bundle, &exported)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal the archive: %v", err)
+	}
 
-	data, err := evaluateTransaction(chaincode, "ReadDataAccessRequest", org, id)
+	if len(exported.Items) == 0 {
+		return fmt.Errorf("No %v objects past retention", objectType)
+	}
+
+	wallet, err := getOrgWallet(org)
 	if err != nil {
-		return false, fmt.Errorf("Cannot verify digital signature: %v", err)
+		return fmt.Errorf("Cannot get wallet: %v", err)
 	}
 
-	var accessRequest ds.DataAccessRequest
-	err = json.Unmarshal(data, &accessRequest)
+	adminSign, err := sign.GetUserDigitalSignature(user, org, []byte(exported.Digest), wallet)
 	if err != nil {
-		return false, fmt.Errorf("Cannot unmarshal the data access request data: %v", err)
+		return fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
 	}
 
-	var accessReq ds.DataAccessReq
-	accessReq.SetInfo(accessRequest.GetMetaInfo(), accessRequest.GetPatientID())
+	archive, err := json.Marshal(ds.SignedArchive{Bundle: bundle, AdminSign: adminSign})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal the archive: %v", err)
+	}
 
-	/// get digital signatures from request agreement
-	clientDSign := accessRequest.GetClientDSign()
+	return ioutil.WriteFile(filepath.Clean(fileName), archive, 0600)
+}
 
-	/// get meta data from request agreement 
-	orgReq := accessRequest.GetMetaDataOrg()
-	userReq := accessRequest.GetMetaDataUser()
+/// record keys rewrapped per transaction, must not exceed the chaincode batch limit
+const rotationBatchSize = 50
 
-	/// get wallet 
-	wallet, err := getOrgWallet(orgReq)
-	if err != nil {
-		return false, fmt.Errorf("Cannot get wallet: %v", err)
-	}
+/// random code the patient presents at the pharmacy
+func newPrescriptionCode() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
 
-	dataBytes, err := json.Marshal(accessReq)
-	if err != nil {
-		return false, fmt.Errorf("Cannot marshal the request agreement data: %v", err)
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Cannot generate prescription code: %v", err)
 	}
 
-	/// verify the digital signatures 
-	checkClientDSign, err := sign.Verify(userReq, orgReq, dataBytes, clientDSign, wallet)
-	if err != nil {
-		return false, fmt.Errorf("Client Digital Signature failed to verify: %v", err)
+	for i := range buf {
+		buf[i] = alphabet[int(buf[i]) % len(alphabet)]
 	}
 
-	return checkClientDSign, nil
+	return string(buf), nil
 }
 
 /// 7. should include the shared data with DS ?
@@ -905,6 +2718,15 @@ func createRegisterData(smartContractName string) (map[string][]byte, error) {
 		var clientPersonaldata ds.ClientPersonalInfo
 		var clientType string = "Patient"
 		clientPersonaldata.SetInfo(age, firstName, lastName, gender, email, contactNumber, city, state, country, clientType)
+		/// optional, helps the hospital find duplicate registrations
+		fmt.Printf("Enter date of birth as YYYY-MM-DD (empty to skip): ")
+		if dateOfBirth := scanLine(); len(dateOfBirth) != 0 {
+			date, err := ds.ParseDate(dateOfBirth)
+			if err != nil {
+				return nil, err
+			}
+			clientPersonaldata.DateOfBirth = date
+		}
 		var patientData ds.PatientInfo 
 		patientData.SetDefault(clientPersonaldata)
 		assetData, err := json.Marshal(patientData)
@@ -938,7 +2760,8 @@ func createRegisterData(smartContractName string) (map[string][]byte, error) {
 }
 
 /// function to add medical data to the patient 
-func createMedicalData(id string) (map[string][]byte, error) {
+/// the record content is signed by the doctor adding it
+func createMedicalData(chaincode *gateway.Contract, user, org, id string) (map[string][]byte, error) {
 	
 	var data map[string][]byte
 	var medicalData ds.MedicalInfo
@@ -948,26 +2771,241 @@ func createMedicalData(id string) (map[string][]byte, error) {
 	// medical data
 	medicalRecord := createMedicalDataForm(mType)
 	
-	// date 
-	var date ds.Date 
-	year, month, day := time.Now().Date()
-	date.SetInfo(day, month, year)
+	// collected at time with the time zone of the client, the chaincode sets the issue time
+	fmt.Printf("Enter the collected at time as YYYY-MM-DD HH:MM or an RFC 3339 time (empty for now): ")
+	collectedAt, err := parseCollectedAt(scanLine())
+	if err != nil {
+		return nil, err
+	}
 
 	// owner	
 	owner := id
 
-	medicalData.SetInfo(mType, medicalRecord, date, owner, "")
+	// issued by
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity attribute: %v", err)
+	}
+
+	// report encrypted with the data key of the patient, if set
+	dataKey, keyVersion, err := readDataKey(chaincode, user, org, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := &envelope.SealedReport{MReport: medicalRecord}
+	scopedKeys := map[string]string{}
+	if dataKey != nil {
+		sealed, err = envelope.EncryptReport(dataKey, medicalRecord)
+		if err != nil {
+			return nil, err
+		}
+
+		// record key for the specialists with the record type in their referral
+		scopedKeys, err = wrapScopedRecordKeyOf(chaincode, org, id, mType, dataKey, sealed.RecordKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	medicalData.SetInfo(mType, sealed.MReport, collectedAt, owner, string(idAttr))
+	medicalData.Encrypted = dataKey != nil
+	medicalData.KeyVersion = keyVersion
+	medicalData.RecordKey = sealed.RecordKey
+	medicalData.ReportDigest = sealed.ReportDigest
+	medicalData.ReportSalt = sealed.ReportSalt
+
+	// doctor signature on the record content
+	var content ds.MedicalRecordContent
+	content.SetInfo(medicalData)
+
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal the data")
+	}
+
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	medicalData.DoctorSign, err = sign.GetUserCanonicalSignature(user, org, contentBytes, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+	}
+
 	assetData, err := json.Marshal(medicalData)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot marshal the data")
 	}
+	scopedKeysJSON, err := json.Marshal(scopedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal the record keys: %v", err)
+	}
+
 	data = map[string][]byte{
 		"medical_data" : assetData,
+		"scoped_keys" : scopedKeysJSON,
 	}
   
 	return data, nil
 }
 
+/// collected at time entered as local YYYY-MM-DD HH:MM or RFC 3339, now when empty
+func parseCollectedAt(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Now().Truncate(time.Second), nil
+	}
+
+	collectedAt, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err == nil {
+		return collectedAt, nil
+	}
+
+	collectedAt, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Collected at time must be YYYY-MM-DD HH:MM or an RFC 3339 time")
+	}
+
+	return collectedAt, nil
+}
+
+/// record key of the lab result of the patient, wrapped for the lab and with the data key
+/// the lab never gets the data key, the record key opens its result only
+/// the specialists with the record type in their referral get the record key too
+func wrapLabRecordKey(chaincode *gateway.Contract, user, org, pid, labID, recordType string) (map[string][]byte, error) {
+
+	dataKey, _, err := readDataKey(chaincode, user, org, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataKey == nil {
+		return map[string][]byte{}, nil
+	}
+
+	recordKey, wrappedRecordKey, err := envelope.NewRecordKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := wrapKeyForClient(chaincode, org, labID, recordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scopedKeys, err := wrapScopedRecordKeyOf(chaincode, org, pid, recordType, dataKey, wrappedRecordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scopedKeysJSON, err := json.Marshal(scopedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal the record keys: %v", err)
+	}
+
+	return map[string][]byte{
+		"wrapped_key": []byte(wrappedKey),
+		"record_key": []byte(wrappedRecordKey),
+		"scoped_keys": scopedKeysJSON,
+	}, nil
+}
+
+/// lab order visible to the invoked lab or doctor
+func readLabOrder(chaincode *gateway.Contract, org, orderID string) (*ds.LabOrder, error) {
+
+	data, err := evaluateTransaction(chaincode, "GetLabOrders", org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read lab orders: %v", err)
+	}
+
+	var orders ds.LabOrders
+	err = json.Unmarshal(data, &orders)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the lab orders: %v", err)
+	}
+
+	for i := range orders.Data {
+		if orders.Data[i].ID == orderID {
+			return &orders.Data[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Lab order %v not found", orderID)
+}
+
+/// lab result values, type, owner and issuer are taken from the lab order
+/// the result is encrypted with the record key wrapped for the lab on the order and signed by the lab
+func createLabResultData(chaincode *gateway.Contract, user, org, orderID string) (map[string][]byte, error) {
+
+	order, err := readLabOrder(chaincode, org, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := createMedicalDataForm(order.RecordType)
+
+	fmt.Printf("Enter the time the sample was collected at as YYYY-MM-DD HH:MM or an RFC 3339 time (empty for now): ")
+	collectedAt, err := parseCollectedAt(scanLine())
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	var medicalData ds.MedicalInfo
+	sealed := &envelope.SealedReport{MReport: report}
+	if len(order.WrappedKey) != 0 {
+		privateKey, err := sign.GetUserPrivateKey(user, org, wallet)
+		if err != nil {
+			return nil, err
+		}
+
+		recordKey, err := envelope.UnwrapKey(privateKey, order.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+
+		sealed, err = envelope.EncryptReportWithRecordKey(recordKey, order.RecordKey, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	medicalData.SetInfo(order.RecordType, sealed.MReport, collectedAt, order.PID, order.LabID)
+	medicalData.Encrypted = len(order.WrappedKey) != 0
+	medicalData.KeyVersion = order.KeyVersion
+	medicalData.RecordKey = sealed.RecordKey
+	medicalData.ReportDigest = sealed.ReportDigest
+	medicalData.ReportSalt = sealed.ReportSalt
+
+	// lab signature on the record content
+	var content ds.MedicalRecordContent
+	content.SetInfo(medicalData)
+
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal the data")
+	}
+
+	medicalData.DoctorSign, err = sign.GetUserCanonicalSignature(user, org, contentBytes, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get digital signature of invoked client: %v", err)
+	}
+
+	assetData, err := json.Marshal(medicalData)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal the data")
+	}
+
+	return map[string][]byte{
+		"medical_data" : assetData,
+	}, nil
+}
+
 func createMedicalDataForm(mType string) map[string]string {
 
 	if strings.ToUpper(mType) == "CBC" {
@@ -1050,6 +3088,20 @@ func formatJSON(data []byte) ([]byte, error) {
 	return prettyJSON, nil
 }
 
+/// read a whole line from stdin, used for free text such as notes
+func scanLine() string {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 0 || err != nil || buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return strings.TrimSpace(string(line))
+}
+
 func getMap(keys []string, values []string) map[string]string {
 
 	res := map[string]string{}
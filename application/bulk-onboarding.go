@@ -0,0 +1,349 @@
+package main
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"bufio"
+	"bytes"
+	"strings"
+	"strconv"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	ds "github.com/afrozahmed441/Capstone-Project/application/dataStructs"
+)
+
+/// patients registered per transaction, the chaincode accepts up to 50
+const onboardingBatchSize = 50
+
+/// columns of the onboarding CSV file, named by its header row in any order
+var onboardingColumns = []string{"pid", "firstName", "lastName", "age", "gender", "email", "contactNumber", "city", "state", "country"}
+
+/// optional column of the date of birth as YYYY-MM-DD, used to find duplicate patients
+const onboardingDateOfBirthColumn = "dateOfBirth"
+
+const (
+	onboardingCreated = "created"
+	onboardingExisting = "existing"
+	onboardingRejected = "rejected"
+	/// created patients flagged in the patient index, counted in the summary only
+	onboardingProbableDuplicates = "probableDuplicates"
+)
+
+/// patient of the onboarding file, the row is the line of the file
+type onboardingPatient struct {
+	Row int `json:"row"`
+	ID string `json:"pid"`
+	PersonalInfo ds.ClientPersonalInfo `json:"personalInfo"`
+}
+
+/// line of the JSON Lines onboarding file, the same fields as the CSV columns
+type onboardingLine struct {
+	ID string `json:"pid"`
+	ds.ClientPersonalInfo
+}
+
+/// result of a row in the report, one JSON object per line
+type onboardingReportEntry struct {
+	Row int `json:"row"`
+	ID string `json:"pid"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	ProbableDuplicate bool `json:"probableDuplicate,omitempty"`
+}
+
+type onboardingResult struct {
+	Created []string `json:"created"`
+	Existing []string `json:"existing"`
+	Rejected []onboardingReportEntry `json:"rejected"`
+	ProbableDuplicates []string `json:"probableDuplicates"`
+}
+
+func validateOnboardingPatient(patient onboardingPatient) error {
+	if len(patient.ID) == 0 {
+		return fmt.Errorf("pid field must be non-empty value")
+	}
+	if !strings.HasSuffix(patient.ID, "P") {
+		return fmt.Errorf("pid must end with P")
+	}
+	return patient.PersonalInfo.Validate()
+}
+
+/// read the patients of a CSV or JSON Lines file, rows that cannot be read are rejected
+func readOnboardingFile(fileName string) ([]onboardingPatient, []onboardingReportEntry, error) {
+
+	file, err := os.Open(filepath.Clean(fileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot open onboarding file: %v", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return readOnboardingCSV(file)
+	case ".jsonl", ".ndjson":
+		return readOnboardingJSONLines(file)
+	}
+
+	return nil, nil, fmt.Errorf("Onboarding file must be .csv or .jsonl")
+}
+
+func readOnboardingCSV(file io.Reader) ([]onboardingPatient, []onboardingReportEntry, error) {
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot read CSV header: %v", err)
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	for _, column := range onboardingColumns {
+		if _, ok := index[column]; !ok {
+			return nil, nil, fmt.Errorf("CSV header is missing column %v", column)
+		}
+	}
+
+	patients := []onboardingPatient{}
+	rejected := []onboardingReportEntry{}
+
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rejected = append(rejected, onboardingReportEntry{Row: row, Status: onboardingRejected, Reason: err.Error()})
+			continue
+		}
+
+		value := func(column string) string {
+			return strings.TrimSpace(record[index[column]])
+		}
+
+		age, err := strconv.Atoi(value("age"))
+		if err != nil {
+			rejected = append(rejected, onboardingReportEntry{Row: row, ID: value("pid"), Status: onboardingRejected, Reason: "age field value is not valid"})
+			continue
+		}
+
+		patient := onboardingPatient{Row: row, ID: value("pid")}
+		patient.PersonalInfo.SetInfo(age, value("firstName"), value("lastName"), value("gender"), value("email"), value("contactNumber"), value("city"), value("state"), value("country"), "Patient")
+
+		if _, ok := index[onboardingDateOfBirthColumn]; ok && len(value(onboardingDateOfBirthColumn)) != 0 {
+			patient.PersonalInfo.DateOfBirth, err = ds.ParseDate(value(onboardingDateOfBirthColumn))
+			if err != nil {
+				rejected = append(rejected, onboardingReportEntry{Row: row, ID: value("pid"), Status: onboardingRejected, Reason: "dateOfBirth field value is not valid"})
+				continue
+			}
+		}
+
+		patients = append(patients, patient)
+	}
+
+	return patients, rejected, nil
+}
+
+func readOnboardingJSONLines(file io.Reader) ([]onboardingPatient, []onboardingReportEntry, error) {
+
+	scanner := bufio.NewScanner(file)
+
+	patients := []onboardingPatient{}
+	rejected := []onboardingReportEntry{}
+
+	for row := 1; scanner.Scan(); row++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var data onboardingLine
+		err := json.Unmarshal(line, &data)
+		if err != nil {
+			rejected = append(rejected, onboardingReportEntry{Row: row, Status: onboardingRejected, Reason: err.Error()})
+			continue
+		}
+
+		data.ClientPersonalInfo.Type = "Patient"
+		patients = append(patients, onboardingPatient{Row: row, ID: strings.TrimSpace(data.ID), PersonalInfo: data.ClientPersonalInfo})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Cannot read onboarding file: %v", err)
+	}
+
+	return patients, rejected, nil
+}
+
+/// patients of an earlier run that were registered, an empty report when there was none
+func readOnboardingReport(reportName string) ([]onboardingReportEntry, error) {
+
+	file, err := os.Open(filepath.Clean(reportName))
+	if os.IsNotExist(err) {
+		return []onboardingReportEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open report: %v", err)
+	}
+	defer file.Close()
+
+	entries := []onboardingReportEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry onboardingReportEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read report: %v", err)
+		}
+
+		/// rejected rows are tried again, the file may have been corrected
+		if entry.Status != onboardingRejected {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read report: %v", err)
+	}
+
+	return entries, nil
+}
+
+/// replace the report, written to a temporary file first so a failure keeps the earlier report
+func writeOnboardingReport(reportName string, entries []onboardingReportEntry) error {
+
+	var report bytes.Buffer
+	for _, entry := range entries {
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("Cannot marshal report: %v", err)
+		}
+		report.Write(entryJSON)
+		report.WriteByte('\n')
+	}
+
+	tempName := reportName + ".tmp"
+	err := ioutil.WriteFile(filepath.Clean(tempName), report.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot write report: %v", err)
+	}
+
+	return os.Rename(tempName, reportName)
+}
+
+/// register the patients of the file in batches and write the result of every row to the report
+/// patients registered by an earlier run of the same report are skipped, so a failed run can be resumed
+func bulkRegisterPatients(chaincode *gateway.Contract, org, fileName, reportName string) ([]onboardingReportEntry, error) {
+
+	entries, err := readOnboardingReport(reportName)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := map[string]bool{}
+	for _, entry := range entries {
+		registered[entry.ID] = true
+	}
+
+	patients, rejected, err := readOnboardingFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, rejected...)
+
+	pending := []onboardingPatient{}
+	rows := map[string]int{}
+	for _, patient := range patients {
+		if registered[patient.ID] {
+			continue
+		}
+
+		if err := validateOnboardingPatient(patient); err != nil {
+			entries = append(entries, onboardingReportEntry{Row: patient.Row, ID: patient.ID, Status: onboardingRejected, Reason: err.Error()})
+			continue
+		}
+
+		if _, ok := rows[patient.ID]; ok {
+			entries = append(entries, onboardingReportEntry{Row: patient.Row, ID: patient.ID, Status: onboardingRejected, Reason: fmt.Sprintf("pid is repeated, first at row %v", rows[patient.ID])})
+			continue
+		}
+
+		rows[patient.ID] = patient.Row
+		pending = append(pending, patient)
+	}
+
+	err = writeOnboardingReport(reportName, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(pending); start += onboardingBatchSize {
+		end := start + onboardingBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		batch, err := json.Marshal(pending[start:end])
+		if err != nil {
+			return entries, fmt.Errorf("Cannot marshal patients: %v", err)
+		}
+
+		res, err := subTransactionWithTransientData(chaincode, "RegisterPatients", org, map[string][]byte{"patients": batch})
+		if err != nil {
+			return entries, fmt.Errorf("Batch from row %v failed, run again to resume: %v", pending[start].Row, err)
+		}
+
+		var result onboardingResult
+		err = json.Unmarshal(res, &result)
+		if err != nil {
+			return entries, fmt.Errorf("Cannot unmarshal result: %v", err)
+		}
+
+		/// flagged patients are reviewed by the admin with GetProbableDuplicates
+		duplicates := map[string]bool{}
+		for _, pid := range result.ProbableDuplicates {
+			duplicates[pid] = true
+		}
+
+		for _, pid := range result.Created {
+			entries = append(entries, onboardingReportEntry{Row: rows[pid], ID: pid, Status: onboardingCreated, ProbableDuplicate: duplicates[pid]})
+		}
+		for _, pid := range result.Existing {
+			entries = append(entries, onboardingReportEntry{Row: rows[pid], ID: pid, Status: onboardingExisting})
+		}
+		for _, entry := range result.Rejected {
+			entry.Status = onboardingRejected
+			entries = append(entries, entry)
+		}
+
+		err = writeOnboardingReport(reportName, entries)
+		if err != nil {
+			return entries, err
+		}
+	}
+
+	return entries, nil
+}
+
+/// number of report entries by status
+func onboardingSummary(entries []onboardingReportEntry) map[string]int {
+	summary := map[string]int{onboardingCreated: 0, onboardingExisting: 0, onboardingRejected: 0, onboardingProbableDuplicates: 0}
+	for _, entry := range entries {
+		summary[entry.Status]++
+		if entry.ProbableDuplicate {
+			summary[onboardingProbableDuplicates]++
+		}
+	}
+	return summary
+}
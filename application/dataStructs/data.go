@@ -1,7 +1,11 @@
 package dataStructs
 
 import (
+	"fmt"
 	"time"
+	"strconv"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/analyte"
 )
 
 type ClientPersonalInfo struct {
@@ -15,6 +19,7 @@ type ClientPersonalInfo struct {
 	State         string `json:"state"`
 	Country       string `json:"country"`
 	Type 		  string `json:"type"`
+	DateOfBirth   *Date  `json:"dateOfBirth,omitempty"`
 }
 
 type Date struct {
@@ -29,11 +34,60 @@ type MetaData struct {
 }
 
 type MedicalInfo struct {
+	ID string `json:"id,omitempty"`
 	Type string `json:"type"`
 	MReport map[string]string `json:"mReport"`
-	DateOfIssue  Date  `json:"dateOfIssue"`
+	IssuedAt time.Time `json:"issuedAt"`
+	/// day of issue of the records created before the issue time, kept for their signatures
+	DateOfIssue *Date `json:"dateOfIssue,omitempty"`
+	/// clinical time given by the doctor, the issue time is set by the chaincode
+	CollectedAt *time.Time `json:"collectedAt,omitempty"`
 	Owner string `json:"owner"`
 	IssuedBy string `json:"issuedBy"`
+	OrderedBy string `json:"orderedBy,omitempty"`
+	LabOrderID string `json:"labOrderId,omitempty"`
+	DoctorSign string `json:"doctorSign,omitempty"`
+	CertFingerprint string `json:"certFingerprint,omitempty"`
+	PatientAck json.RawMessage `json:"patientAck,omitempty"`
+	Encrypted bool `json:"encrypted,omitempty"`
+	KeyVersion int `json:"keyVersion,omitempty"`
+	RecordKey string `json:"recordKey,omitempty"`
+	ReportDigest string `json:"reportDigest,omitempty"`
+	ReportSalt string `json:"reportSalt,omitempty"`
+}
+
+/// content of the medical record signed by the doctor
+/// records with a day of issue were signed with it instead of the issue time,
+/// records with a collected at time are signed with it
+type MedicalRecordContent struct {
+	Type string `json:"type"`
+	MReport map[string]string `json:"mReport"`
+	ReportDigest string `json:"reportDigest,omitempty"`
+	IssuedAt string `json:"issuedAt,omitempty"`
+	DateOfIssue *Date `json:"dateOfIssue,omitempty"`
+	CollectedAt string `json:"collectedAt,omitempty"`
+	Owner string `json:"owner"`
+	IssuedBy string `json:"issuedBy"`
+}
+
+func (mrc *MedicalRecordContent) SetInfo(mr MedicalInfo) {
+	mrc.Type = mr.Type
+	mrc.MReport = mr.MReport
+	mrc.IssuedAt = ""
+	mrc.CollectedAt = ""
+	mrc.DateOfIssue = mr.DateOfIssue
+	if mr.DateOfIssue == nil && mr.CollectedAt != nil {
+		mrc.CollectedAt = mr.CollectedAt.Format(time.RFC3339Nano)
+	} else if mr.DateOfIssue == nil {
+		mrc.IssuedAt = mr.IssuedAt.Format(time.RFC3339Nano)
+	}
+	/// encrypted records are signed over the digest of the plaintext report
+	mrc.ReportDigest = mr.ReportDigest
+	if len(mr.ReportDigest) != 0 {
+		mrc.MReport = nil
+	}
+	mrc.Owner = mr.Owner
+	mrc.IssuedBy = mr.IssuedBy
 }
 
 type DoctorInfo struct {
@@ -52,6 +106,7 @@ type PatientInfo struct {
 	MedicalRecords []MedicalInfo  `json:"medicalRecords"`
 	TreatedBy  []string    `json:"doctorInfo"`
 	Owners  []string	`json:"owners"`	
+	AccessScopes map[string][]string `json:"accessScopes,omitempty"`
 }
 
 /*
@@ -71,6 +126,43 @@ func (cpi *ClientPersonalInfo) SetInfo(age int, firstName, lastName, gender, ema
 	cpi.Type = Ctype
 }
 
+/// same rules as the chaincode applies on registration
+func (cpi *ClientPersonalInfo) Validate() error {
+	cpiFields := [][2]string {
+		{"firstName", cpi.FirstName},
+		{"lastName", cpi.LastName},
+		{"gender", cpi.Gender},
+		{"age", strconv.Itoa(cpi.Age)}, 
+		{"email", cpi.Email},
+		{"contactNumber", cpi.ContactNumber},
+		{"city", cpi.City}, 
+		{"state", cpi.State}, 
+		{"country", cpi.Country},
+		{"type", cpi.Type},
+	} 
+
+	for _, field := range cpiFields {
+		key, value := field[0], field[1]
+		if len(value) == 0 {
+			return fmt.Errorf("%v field must be non-empty value", key)
+		}
+		if key == "age" {
+			if ageVal, _ := strconv.Atoi(value); ageVal <= 0 {
+				return fmt.Errorf("%v field value is not valid", key)
+			}
+		}
+	}
+
+	/// date of birth is optional, it is used to find duplicate patients
+	if cpi.DateOfBirth != nil {
+		if _, err := ParseDate(cpi.DateOfBirth.String()); err != nil {
+			return fmt.Errorf("dateOfBirth field value is not valid")
+		}
+	}
+
+	return nil
+}
+
 
 /**
  * PatientInfo 
@@ -122,10 +214,12 @@ func (di *DoctorInfo) SetDefault(personalInfo ClientPersonalInfo, specialization
 * MedicalInfo
 */ 
 
-func (mr *MedicalInfo) SetInfo(reportType string, report map[string]string, dateOfIssue Date, owner string, issuedBy string) {
+/// the issue time is left to the chaincode, it stamps the record with the transaction time
+func (mr *MedicalInfo) SetInfo(reportType string, report map[string]string, collectedAt time.Time, owner string, issuedBy string) {
 	mr.Type = reportType
 	mr.MReport = report 
-	mr.DateOfIssue = dateOfIssue
+	mr.IssuedAt = time.Time{}
+	mr.CollectedAt = &collectedAt
 	mr.Owner = owner
 	mr.IssuedBy = issuedBy
 }
@@ -133,17 +227,52 @@ func (mr *MedicalInfo) SetInfo(reportType string, report map[string]string, date
 func (mr *MedicalInfo)SetDefault(reportType string) {
 	mr.Type = reportType
 	mr.MReport = map[string]string{}
-	mr.DateOfIssue = Date{}
+	mr.IssuedAt = time.Time{}
+	mr.DateOfIssue = nil
+	mr.CollectedAt = nil
 	mr.Owner = ""
 	mr.IssuedBy = ""
 }
 
+/// issue time of the record, the start of the day of issue for the records created before the issue time
+func (mr *MedicalInfo) IssueTime() time.Time {
+	if !mr.IssuedAt.IsZero() || mr.DateOfIssue == nil {
+		return mr.IssuedAt
+	}
+	return time.Date(mr.DateOfIssue.Year, mr.DateOfIssue.Month, mr.DateOfIssue.Day, 0, 0, 0, 0, time.UTC)
+}
+
+/// time the record data was collected at, the issue time when the doctor did not give it
+func (mr *MedicalInfo) ClinicalTime() time.Time {
+	if mr.CollectedAt != nil {
+		return *mr.CollectedAt
+	}
+	return mr.IssueTime()
+}
+
 func (d *Date) SetInfo(day int, month time.Month, year int) {
 	d.Day = day 
 	d.Month = month 
 	d.Year = year
 }
 
+/// date as YYYY-MM-DD
+func (d *Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, int(d.Month), d.Day)
+}
+
+/// date of a YYYY-MM-DD value, days that are not in the calendar are rejected
+func ParseDate(value string) (*Date, error) {
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("Date %q must be YYYY-MM-DD: %v", value, err)
+	}
+
+	var date Date
+	date.SetInfo(parsed.Day(), parsed.Month(), parsed.Year())
+	return &date, nil
+}
+
 type signatures struct {
 	ClientSign string `json:"clientSign"`
 	OrgSign string `json:"orgSign"`
@@ -155,25 +284,17 @@ type MetaDataReq struct {
 	ClientID string `json:"id"`
 }
 
-/// request agreement data 
-type RequestAgreement struct {
-	MetaInfo MetaDataReq `json:"metaData"`
-	PID  string `json:"pid"`
-	HID  string `json:"hid"`
-}
-
-func (rd *RequestAgreement)SetInfo(metaInfo MetaDataReq, pid, hid string) {
-	rd.PID = pid
-	rd.HID = hid
-	rd.MetaInfo = metaInfo
-}
-
 type RequestAgreementWithSign struct {
 	MetaData MetaDataReq `json:"metaData"`
 	PID  string `json:"pid"`
 	HID  string `json:"hid"`
 	DigitalSignatures signatures `json:"digitalSignatures"`
 	Valid bool `json:"valid"`
+	Payload string `json:"payload"`
+}
+
+func (r *RequestAgreementWithSign)GetPayload() string {
+	return r.Payload
 }
 
 func (r *RequestAgreementWithSign)GetPID() string {
@@ -206,10 +327,15 @@ func (r *RequestAgreementWithSign)GetMetaDataOrg() string {
 
 /// Data Access Request 
 type DataAccessRequest struct {
-	MetaData MetaDataReq `json:"meta_data"`
-	PatientID string `json:"patient_id"`
-	ClientSign string `json:"client_sign"`
+	MetaData MetaDataReq `json:"metaData"`
+	PatientID string `json:"patientId"`
+	ClientSign string `json:"clientSign"`
 	Valid bool `json:"valid"`
+	Payload string `json:"payload"`
+}
+
+func (dar *DataAccessRequest) GetPayload() string {
+	return dar.Payload
 }
 
 func (dar *DataAccessRequest) GetMetaInfo() MetaDataReq {
@@ -236,13 +362,121 @@ func (dar *DataAccessRequest) GetPatientID() string {
 	return dar.PatientID
 }
 
-/* data access request without digital signature */
-type DataAccessReq struct {
-	MetaData MetaDataReq `json:"meta_data"`
-	PatientID string `json:"patient_id"`
+/// request data signed by the requester, used once on the channel and chaincode it names
+type SignedRequestPayload struct {
+	Type string `json:"type"`
+	MetaData MetaDataReq `json:"metaData"`
+	PID string `json:"pid"`
+	HID string `json:"hid,omitempty"`
+	Nonce string `json:"nonce"`
+	IssuedAt time.Time `json:"issuedAt"`
+	Channel string `json:"channel"`
+	Chaincode string `json:"chaincode"`
+	ExpiryDays int `json:"expiryDays"`
+}
+
+/// prescription content signed by the doctor
+type PrescriptionContent struct {
+	PID string `json:"pid"`
+	Drug string `json:"drug"`
+	Dose string `json:"dose"`
+	Frequency string `json:"frequency"`
+	DurationDays int `json:"durationDays"`
+	Refills int `json:"refills"`
+}
+
+func (pc *PrescriptionContent) SetInfo(pid, drug, dose, frequency string, durationDays, refills int) {
+	pc.PID = pid
+	pc.Drug = drug
+	pc.Dose = dose
+	pc.Frequency = frequency
+	pc.DurationDays = durationDays
+	pc.Refills = refills
 }
 
-func (r *DataAccessReq)SetInfo(metaInfo MetaDataReq, pid string) {
-	r.MetaData = metaInfo
-	r.PatientID = pid
-}
\ No newline at end of file
+/// prescription data passed in the transient map
+type PrescriptionInput struct {
+	Content PrescriptionContent `json:"content"`
+	Code string `json:"code"`
+	DoctorSign string `json:"doctorSign"`
+}
+
+/// content the patient countersigns on a medical record
+type AcknowledgementContent struct {
+	RecordID string `json:"recordId"`
+	DoctorSign string `json:"doctorSign"`
+	Disputed bool `json:"disputed"`
+	Comment string `json:"comment"`
+}
+
+type MedicalRecords struct {
+	Data []MedicalInfo `json:"data"`
+}
+
+/// patient data returned to the treating doctor
+type PatientMainInfo struct {
+	ID  string  `json:"pid"`
+	PersonalInfo ClientPersonalInfo `json:"personalInfo"`
+	MedicalRecords []MedicalInfo  `json:"medicalRecords"`
+}
+
+/// data key of the patient wrapped for the reader, version 0 when not set
+type WrappedDataKey struct {
+	PID string `json:"pid"`
+	Version int `json:"version"`
+	WrappedKey string `json:"wrappedKey"`
+	PendingVersion int `json:"pendingVersion,omitempty"`
+	PendingWrappedKey string `json:"pendingWrappedKey,omitempty"`
+	RotationRequired bool `json:"rotationRequired,omitempty"`
+	RecordKeys map[string]string `json:"recordKeys,omitempty"`
+	AccessScopes map[string][]string `json:"accessScopes,omitempty"`
+}
+
+/// medical record re-encrypted for the new data key on key rotation
+type RotatedRecord struct {
+	MReport map[string]string `json:"mReport,omitempty"`
+	RecordKey string `json:"recordKey"`
+	ReportSalt string `json:"reportSalt,omitempty"`
+	ScopedKeys map[string]string `json:"scopedKeys,omitempty"`
+}
+
+/// archive bundle exported by the chaincode and the admin signature on its digest
+/// the bundle is kept as returned, the chaincode checks it byte for byte
+type SignedArchive struct {
+	Bundle json.RawMessage `json:"bundle"`
+	AdminSign string `json:"adminSign"`
+}
+
+/// patient record export and the hospital signature on its digest
+/// the bundle is kept as returned, the recipient recomputes the digest from it
+/// record keys of the encrypted reports are given with the export, by record id
+type SignedRecordExport struct {
+	Bundle json.RawMessage `json:"bundle"`
+	HospitalSign string `json:"hospitalSign"`
+	HospitalCertificate string `json:"hospitalCertificate"`
+	RecordKeys map[string]string `json:"recordKeys,omitempty"`
+}
+
+/// value of an analyte in one medical record, the delta is from the previous value with the same unit
+type AnalytePoint = analyte.Point
+
+/// numeric series of one analyte of the patient, in the order the values were collected
+type AnalyteTrend = analyte.Trend
+
+/// lab order as read by the lab, the record key of the result is wrapped for the lab
+/// and with the data key when the patient has one
+type LabOrder struct {
+	ID string `json:"id"`
+	PID string `json:"pid"`
+	OrderedBy string `json:"orderedBy"`
+	LabID string `json:"labId"`
+	RecordType string `json:"recordType"`
+	Status string `json:"status"`
+	KeyVersion int `json:"keyVersion,omitempty"`
+	WrappedKey string `json:"wrappedKey,omitempty"`
+	RecordKey string `json:"recordKey,omitempty"`
+}
+
+type LabOrders struct {
+	Data []LabOrder `json:"data"`
+}
@@ -0,0 +1,381 @@
+package envelope
+
+import (
+	"fmt"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+)
+
+/// size of the patient data key and the record keys (AES-256)
+const dataKeySize = 32
+
+/// additional data binding a record key to its use
+var recordKeyLabel = []byte("recordKey")
+
+/// additional data binding the digest salt to its use
+var reportSaltLabel = []byte("reportSalt")
+
+/// encrypted medical report, its record key wrapped with the data key
+/// and the salted digest of the plaintext report the issuing doctor signs
+type SealedReport struct {
+	MReport map[string]string
+	RecordKey string
+	ReportDigest string
+	ReportSalt string
+}
+
+/// generate a new random data key
+func NewDataKey() ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate data key: %v", err)
+	}
+	return dataKey, nil
+}
+
+/// wrap the data key for the owner of the public key
+/// ECDH with an ephemeral key, the shared secret hashed into the key encryption key
+/// result is base64(ephemeral public key | nonce | sealed data key)
+func WrapKey(publicKey *ecdsa.PublicKey, dataKey []byte) (string, error) {
+
+	recipientKey, err := publicKey.ECDH()
+	if err != nil {
+		return "", fmt.Errorf("Cannot use public key for key agreement: %v", err)
+	}
+
+	ephemeralKey, err := recipientKey.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("Cannot generate ephemeral key: %v", err)
+	}
+
+	secret, err := ephemeralKey.ECDH(recipientKey)
+	if err != nil {
+		return "", fmt.Errorf("Key agreement failed: %v", err)
+	}
+
+	ephemeralPublic := ephemeralKey.PublicKey().Bytes()
+	sealed, err := seal(keyEncryptionKey(secret), dataKey, ephemeralPublic)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(append(ephemeralPublic, sealed...)), nil
+}
+
+/// unwrap the data key with the private key of the reader
+func UnwrapKey(privateKey *ecdsa.PrivateKey, wrappedKey string) ([]byte, error) {
+
+	ownKey, err := privateKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot use private key for key agreement: %v", err)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode wrapped key: %v", err)
+	}
+
+	/// uncompressed point of the same curve as the reader key
+	pointSize := len(ownKey.PublicKey().Bytes())
+	if len(wrapped) <= pointSize {
+		return nil, fmt.Errorf("Wrapped key is too short")
+	}
+
+	ephemeralPublic, err := ownKey.Curve().NewPublicKey(wrapped[:pointSize])
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read ephemeral key: %v", err)
+	}
+
+	secret, err := ownKey.ECDH(ephemeralPublic)
+	if err != nil {
+		return nil, fmt.Errorf("Key agreement failed: %v", err)
+	}
+
+	dataKey, err := open(keyEncryptionKey(secret), wrapped[pointSize:], wrapped[:pointSize])
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unwrap data key: %v", err)
+	}
+
+	return dataKey, nil
+}
+
+/// encrypt each field of the medical report with a new record key
+/// the field name is bound to the value, so values cannot be swapped between fields
+/// the digest of the plaintext is salted so it cannot be matched against guessed reports
+func EncryptReport(dataKey []byte, report map[string]string) (*SealedReport, error) {
+
+	salt, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := ReportDigest(salt, report)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealReport(dataKey, report, salt, digest)
+}
+
+/// new record key for a single report, with its copy wrapped with the data key
+/// the record key can be given to the issuer of the report in place of the data key
+func NewRecordKey(dataKey []byte) ([]byte, string, error) {
+
+	recordKey, err := NewDataKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	wrappedRecordKey, err := seal(dataKey, recordKey, recordKeyLabel)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return recordKey, base64.StdEncoding.EncodeToString(wrappedRecordKey), nil
+}
+
+/// encrypt the medical report with the given record key, the wrapped record key is kept as is
+func EncryptReportWithRecordKey(recordKey []byte, wrappedRecordKey string, report map[string]string) (*SealedReport, error) {
+
+	salt, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := ReportDigest(salt, report)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealFields(recordKey, report, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.RecordKey = wrappedRecordKey
+	sealed.ReportDigest = digest
+	return sealed, nil
+}
+
+/// base64 sha256 of the salt and the canonical JSON of the plaintext report
+func ReportDigest(salt []byte, report map[string]string) (string, error) {
+
+	reportJSON, err := canonical.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal the report: %v", err)
+	}
+
+	hash := sha256.Sum256(append(append([]byte{}, salt...), reportJSON...))
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
+}
+
+/// re-encrypt the report with a new record key wrapped with the new data key
+/// the plaintext and the salt are unchanged, so the digest and the signature stay valid
+func ReencryptReport(oldDataKey, newDataKey []byte, wrappedRecordKey string, report map[string]string, reportSalt string) (*SealedReport, error) {
+
+	recordKey, err := UnwrapRecordKey(oldDataKey, wrappedRecordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, digest, err := OpenReport(recordKey, report, reportSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := openSalt(recordKey, reportSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealReport(newDataKey, plain, salt, digest)
+}
+
+/// decrypt the report with its record key and compute the digest of the plaintext,
+/// the digest must match the one the issuing doctor signed
+func OpenReport(recordKey []byte, report map[string]string, reportSalt string) (map[string]string, string, error) {
+
+	plain, err := DecryptReportWithRecordKey(recordKey, report)
+	if err != nil {
+		return nil, "", err
+	}
+
+	salt, err := openSalt(recordKey, reportSalt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	digest, err := ReportDigest(salt, plain)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return plain, digest, nil
+}
+
+func sealReport(dataKey []byte, report map[string]string, salt []byte, digest string) (*SealedReport, error) {
+
+	recordKey, wrappedRecordKey, err := NewRecordKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealFields(recordKey, report, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.RecordKey = wrappedRecordKey
+	sealed.ReportDigest = digest
+	return sealed, nil
+}
+
+/// fields and salt of the report sealed with the record key
+func sealFields(recordKey []byte, report map[string]string, salt []byte) (*SealedReport, error) {
+
+	encrypted := map[string]string{}
+	for field, value := range report {
+		sealed, err := seal(recordKey, []byte(value), []byte(field))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot encrypt %v: %v", field, err)
+		}
+		encrypted[field] = base64.StdEncoding.EncodeToString(sealed)
+	}
+
+	sealedSalt, err := seal(recordKey, salt, reportSaltLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SealedReport{
+		MReport: encrypted,
+		ReportSalt: base64.StdEncoding.EncodeToString(sealedSalt),
+	}, nil
+}
+
+func openSalt(recordKey []byte, reportSalt string) ([]byte, error) {
+
+	sealed, err := base64.StdEncoding.DecodeString(reportSalt)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode report salt: %v", err)
+	}
+
+	salt, err := open(recordKey, sealed, reportSaltLabel)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decrypt report salt: %v", err)
+	}
+
+	return salt, nil
+}
+
+/// decrypt the fields of the medical report with the record key wrapped with the data key
+func DecryptReport(dataKey []byte, wrappedRecordKey string, report map[string]string) (map[string]string, error) {
+
+	recordKey, err := UnwrapRecordKey(dataKey, wrappedRecordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptReportWithRecordKey(recordKey, report)
+}
+
+/// decrypt the fields of the medical report with its record key
+/// a record key given to a reader opens that record only
+func DecryptReportWithRecordKey(recordKey []byte, report map[string]string) (map[string]string, error) {
+
+	decrypted := map[string]string{}
+	for field, value := range report {
+		sealed, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decode %v: %v", field, err)
+		}
+		plain, err := open(recordKey, sealed, []byte(field))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decrypt %v: %v", field, err)
+		}
+		decrypted[field] = string(plain)
+	}
+	return decrypted, nil
+}
+
+/// rewrap the record key with the new data key, the report itself is unchanged
+/// only for the records signed over their encrypted report, before the report digest
+func RewrapRecordKey(oldDataKey, newDataKey []byte, wrappedRecordKey string) (string, error) {
+
+	recordKey, err := UnwrapRecordKey(oldDataKey, wrappedRecordKey)
+	if err != nil {
+		return "", err
+	}
+
+	rewrapped, err := seal(newDataKey, recordKey, recordKeyLabel)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(rewrapped), nil
+}
+
+/// record key of a medical report, unwrapped with the data key
+func UnwrapRecordKey(dataKey []byte, wrappedRecordKey string) ([]byte, error) {
+
+	sealed, err := base64.StdEncoding.DecodeString(wrappedRecordKey)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode record key: %v", err)
+	}
+
+	recordKey, err := open(dataKey, sealed, recordKeyLabel)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unwrap record key: %v", err)
+	}
+
+	return recordKey, nil
+}
+
+func keyEncryptionKey(secret []byte) []byte {
+	hash := sha256.Sum256(secret)
+	return hash[:]
+}
+
+/// AES-GCM, result is nonce | ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate nonce: %v", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("Ciphertext is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
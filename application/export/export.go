@@ -0,0 +1,231 @@
+package export
+
+import (
+	"fmt"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	ds "github.com/afrozahmed441/Capstone-Project/application/dataStructs"
+	envelope "github.com/afrozahmed441/Capstone-Project/application/envelope"
+	"github.com/afrozahmed441/Capstone-Project/application/sign"
+)
+
+/// patient record bundle as exported by the chaincode
+type recordBundle struct {
+	Export json.RawMessage `json:"export"`
+	Digest string `json:"digest"`
+}
+
+/// fields of the export the verification needs, the rest is kept as exported
+type recordExport struct {
+	PID string `json:"pid"`
+	MergedIDs []string `json:"mergedIds,omitempty"`
+	Org string `json:"org"`
+	MedicalRecords []ds.MedicalInfo `json:"medicalRecords"`
+	Certificates map[string]string `json:"certificates"`
+}
+
+/// result of the offline verification of a medical record of the export
+type RecordVerification struct {
+	RecordID string `json:"recordId"`
+	IssuedBy string `json:"issuedBy"`
+	Signed bool `json:"signed"`
+	ValidSignature bool `json:"validSignature"`
+	Encrypted bool `json:"encrypted"`
+	Decrypted bool `json:"decrypted"`
+	Error string `json:"error,omitempty"`
+}
+
+/// result of the offline verification of a signed record export
+/// the recipient compares the hospital certificate with the one of the hospital they trust
+type Verification struct {
+	PID string `json:"pid"`
+	Org string `json:"org"`
+	Digest string `json:"digest"`
+	HospitalSubject string `json:"hospitalSubject"`
+	HospitalIssuer string `json:"hospitalIssuer"`
+	HospitalFingerprint string `json:"hospitalFingerprint"`
+	Records []RecordVerification `json:"records"`
+	MedicalRecords []ds.MedicalInfo `json:"medicalRecords"`
+}
+
+/// hex sha256 of the canonical form (RFC 8785) of the export, as the chaincode computes it
+func Digest(export []byte) (string, error) {
+	exportJSON, err := canonical.Transform(export)
+	if err != nil {
+		return "", fmt.Errorf("Cannot canonicalize export: %v", err)
+	}
+	hash := sha256.Sum256(exportJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+/// verify the signed record export without the ledger
+/// the digest and the hospital signature must match, otherwise the export is rejected
+/// the doctor signature of each record is checked with the exported certificates
+/// and encrypted reports are decrypted with the record keys given with the export
+func Verify(signedExportJSON []byte) (*Verification, error) {
+
+	var signedExport ds.SignedRecordExport
+	err := json.Unmarshal(signedExportJSON, &signedExport)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the signed export: %v", err)
+	}
+
+	var bundle recordBundle
+	err = json.Unmarshal(signedExport.Bundle, &bundle)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the export bundle: %v", err)
+	}
+
+	digest, err := Digest(bundle.Export)
+	if err != nil {
+		return nil, err
+	}
+
+	if digest != bundle.Digest {
+		return nil, fmt.Errorf("Export digest does not match the exported data")
+	}
+
+	hospitalCertificatePEM := []byte(signedExport.HospitalCertificate)
+	match, err := sign.VerifyWithCertificate(hospitalCertificatePEM, []byte(bundle.Digest), signedExport.HospitalSign)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot verify the hospital signature: %v", err)
+	}
+	if !match {
+		return nil, fmt.Errorf("Hospital signature is not valid")
+	}
+
+	hospitalCertificate, err := sign.CertificateFromPEM(hospitalCertificatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse hospital certificate: %v", err)
+	}
+
+	var export recordExport
+	err = json.Unmarshal(bundle.Export, &export)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal the export: %v", err)
+	}
+
+	fingerprint := sha256.Sum256(hospitalCertificate.Raw)
+	verification := &Verification{
+		PID: export.PID,
+		Org: export.Org,
+		Digest: digest,
+		HospitalSubject: hospitalCertificate.Subject.String(),
+		HospitalIssuer: hospitalCertificate.Issuer.String(),
+		HospitalFingerprint: hex.EncodeToString(fingerprint[:]),
+		Records: []RecordVerification{},
+		MedicalRecords: export.MedicalRecords,
+	}
+
+	for i := range verification.MedicalRecords {
+		owners := append([]string{export.PID}, export.MergedIDs...)
+		verification.Records = append(verification.Records, verifyRecord(&verification.MedicalRecords[i], owners, export.Certificates, signedExport.RecordKeys))
+	}
+
+	return verification, nil
+}
+
+/// verify the doctor signature on the record and decrypt its report in place
+/// the record must be owned by the patient or by a patient merged into it
+func verifyRecord(medicalData *ds.MedicalInfo, owners []string, certificates map[string]string, recordKeys map[string]string) RecordVerification {
+
+	result := RecordVerification{
+		RecordID: medicalData.ID,
+		IssuedBy: medicalData.IssuedBy,
+		Signed: len(medicalData.DoctorSign) != 0 && len(medicalData.CertFingerprint) != 0,
+		Encrypted: medicalData.Encrypted,
+	}
+
+	/// the signature covers the report as stored, or the digest of the plaintext of encrypted reports
+	if result.Signed {
+		valid, err := verifyRecordSignature(medicalData, certificates)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.ValidSignature = valid
+	}
+
+	if !containsOwner(owners, medicalData.Owner) {
+		result.ValidSignature = false
+		result.Error = fmt.Sprintf("Record was issued for %v, not for the exported patient", medicalData.Owner)
+	}
+
+	if !medicalData.Encrypted {
+		return result
+	}
+
+	encodedKey, ok := recordKeys[medicalData.ID]
+	if !ok {
+		if len(result.Error) == 0 {
+			result.Error = "Record key not given with the export"
+		}
+		return result
+	}
+
+	recordKey, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err == nil {
+		var report map[string]string
+		if len(medicalData.ReportDigest) == 0 {
+			report, err = envelope.DecryptReportWithRecordKey(recordKey, medicalData.MReport)
+		} else {
+			var digest string
+			report, digest, err = envelope.OpenReport(recordKey, medicalData.MReport, medicalData.ReportSalt)
+			if err == nil && digest != medicalData.ReportDigest {
+				/// the signature is valid for another report
+				result.ValidSignature = false
+				result.Error = "Report does not match its signed digest"
+				return result
+			}
+		}
+		if err == nil {
+			medicalData.MReport = report
+			result.Decrypted = true
+		}
+	}
+	if err != nil && len(result.Error) == 0 {
+		result.Error = fmt.Sprintf("Cannot decrypt report: %v", err)
+	}
+
+	return result
+}
+
+func containsOwner(owners []string, owner string) bool {
+	for _, id := range owners {
+		if id == owner {
+			return true
+		}
+	}
+	return false
+}
+
+/// the certificate must be the one of the fingerprint stamped on the record
+func verifyRecordSignature(medicalData *ds.MedicalInfo, certificates map[string]string) (bool, error) {
+
+	certificatePEM, ok := certificates[medicalData.CertFingerprint]
+	if !ok {
+		return false, fmt.Errorf("Certificate %v not in the export", medicalData.CertFingerprint)
+	}
+
+	certificate, err := sign.CertificateFromPEM([]byte(certificatePEM))
+	if err != nil {
+		return false, fmt.Errorf("Cannot parse certificate: %v", err)
+	}
+
+	fingerprint := sha256.Sum256(certificate.Raw)
+	if hex.EncodeToString(fingerprint[:]) != medicalData.CertFingerprint {
+		return false, fmt.Errorf("Certificate does not match fingerprint %v", medicalData.CertFingerprint)
+	}
+
+	var content ds.MedicalRecordContent
+	content.SetInfo(*medicalData)
+
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return false, fmt.Errorf("Cannot marshal medical record content: %v", err)
+	}
+
+	return sign.VerifyCanonicalWithCertificate([]byte(certificatePEM), contentJSON, medicalData.DoctorSign)
+}
@@ -0,0 +1,11 @@
+module github.com/afrozahmed441/Capstone-Project/application
+
+go 1.20
+
+require (
+	github.com/TylerBrock/colorjson v0.0.0-20200706003622-8a50f05110d2
+	github.com/afrozahmed441/Capstone-Project/chaincode-go v0.0.0
+	github.com/hyperledger/fabric-sdk-go v1.0.0
+)
+
+replace github.com/afrozahmed441/Capstone-Project/chaincode-go => ../chaincode-go
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"crypto/rand"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+/// bytes of the salt of the patient index hashes
+const patientIndexSaltSize = 32
+
+/// set a random salt for the patient index, patients registered from then on are indexed
+func setPatientIndexSalt(chaincode *gateway.Contract, org string) error {
+
+	salt := make([]byte, patientIndexSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("Cannot generate patient index salt: %v", err)
+	}
+
+	_, err := subTransactionWithTransientData(chaincode, "SetPatientIndexSalt", org, map[string][]byte{"salt": salt})
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"encoding/json"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	ds "github.com/afrozahmed441/Capstone-Project/application/dataStructs"
+	envelope "github.com/afrozahmed441/Capstone-Project/application/envelope"
+	"github.com/afrozahmed441/Capstone-Project/application/sign"
+)
+
+/// data key of the patient wrapped for the patient identity at the destination org
+/// and for the receiving doctor, empty when the patient has not set a data key
+func wrapDataKeyForTransfer(chaincode *gateway.Contract, user, org, pid, destinationOrg, doctorID string) (map[string][]byte, error) {
+
+	dataKey, _, err := readDataKey(chaincode, user, org, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataKey == nil {
+		return map[string][]byte{}, nil
+	}
+
+	/// the patient is enrolled at the destination under the same user name
+	destinationWallet, err := getOrgWallet(destinationOrg)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	publicKey, err := sign.GetUserPublicKey(user, destinationOrg, destinationWallet)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := envelope.WrapKey(publicKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	transientData := map[string][]byte{
+		"wrapped_key": []byte(wrappedKey),
+	}
+
+	if len(doctorID) == 0 {
+		return transientData, nil
+	}
+
+	certPEM, err := evaluateTransaction(chaincode, "ReadClientCertificate", org, doctorID)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read certificate of %v: %v", doctorID, err)
+	}
+
+	doctorPublicKey, err := sign.PublicKeyFromCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	doctorWrappedKey, err := envelope.WrapKey(doctorPublicKey, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	transientData["doctor_wrapped_key"] = []byte(doctorWrappedKey)
+
+	return transientData, nil
+}
+
+/// request the transfer of the invoked patient to the destination org
+/// the receiving doctor is optional, an empty id leaves the patient without doctors
+func transferPatient(chaincode *gateway.Contract, user, org, destinationOrg, doctorID string) error {
+
+	destinationMSPID, err := getOrgMSPID(destinationOrg)
+	if err != nil {
+		return err
+	}
+
+	idAttr, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity attribute: %v", err)
+	}
+
+	transientData, err := wrapDataKeyForTransfer(chaincode, user, org, string(idAttr), destinationOrg, doctorID)
+	if err != nil {
+		return err
+	}
+
+	_, err = subTransactionWithTransientData(chaincode, "TransferPatient", org, transientData, destinationMSPID, doctorID)
+	return err
+}
+
+/// the doctors of the source org keep the data key of the transferred patient,
+/// the release marks it for rotation and the patient rotates it once the transfer is completed
+func rotateTransferredDataKey(chaincode *gateway.Contract, user, org, pid string, transferJSON []byte) (bool, error) {
+
+	var transfer struct {
+		Status string `json:"status"`
+	}
+	err := json.Unmarshal(transferJSON, &transfer)
+	if err != nil {
+		return false, fmt.Errorf("Cannot unmarshal the patient transfer: %v", err)
+	}
+
+	if transfer.Status != "completed" {
+		return false, nil
+	}
+
+	role, err := evaluateTransaction(chaincode, "GetIdentityAttribute", org, "role")
+	if err != nil {
+		return false, fmt.Errorf("Error getting client identity attribute: %v", err)
+	}
+
+	if !strings.EqualFold(string(role), "patient") {
+		return false, nil
+	}
+
+	data, err := evaluateTransaction(chaincode, "ReadWrappedDataKey", org, pid)
+	if err != nil {
+		return false, fmt.Errorf("Cannot read data key: %v", err)
+	}
+
+	var wrapped ds.WrappedDataKey
+	err = json.Unmarshal(data, &wrapped)
+	if err != nil {
+		return false, fmt.Errorf("Cannot unmarshal the data key: %v", err)
+	}
+
+	if wrapped.Version == 0 || (!wrapped.RotationRequired && wrapped.PendingVersion == 0) {
+		return false, nil
+	}
+
+	return rotateDataKey(chaincode, user, org)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	ds "github.com/afrozahmed441/Capstone-Project/application/dataStructs"
+	envelope "github.com/afrozahmed441/Capstone-Project/application/envelope"
+	"github.com/afrozahmed441/Capstone-Project/application/export"
+	"github.com/afrozahmed441/Capstone-Project/application/sign"
+)
+
+/// identity of the wallet that signs record exports for the hospital
+const hospitalSigner = "Admin"
+
+/// record keys of the encrypted records, so the recipient can read them without the data key
+func exportRecordKeys(chaincode *gateway.Contract, user, org, pid string, records []ds.MedicalInfo) (map[string]string, error) {
+
+	recordKeys := map[string]string{}
+
+	var dataKeys map[int][]byte
+	for _, record := range records {
+		if !record.Encrypted {
+			continue
+		}
+
+		if dataKeys == nil {
+			var err error
+			dataKeys, _, err = readDataKeys(chaincode, user, org, pid)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		dataKey, ok := dataKeys[record.KeyVersion]
+		if !ok {
+			return nil, fmt.Errorf("Data key version %v of %v not available", record.KeyVersion, pid)
+		}
+
+		recordKey, err := envelope.UnwrapRecordKey(dataKey, record.RecordKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot unwrap record key of %v: %v", record.ID, err)
+		}
+		recordKeys[record.ID] = base64.StdEncoding.EncodeToString(recordKey)
+	}
+
+	return recordKeys, nil
+}
+
+/// export the record of the invoked patient, signed by the hospital, to the file
+/// the export is verified offline with verifyRecordExport
+func exportMyRecord(chaincode *gateway.Contract, user, org, fileName string) error {
+
+	bundle, err := subTransactionWithOutArgs(chaincode, "ExportMyRecord", org)
+	if err != nil {
+		return err
+	}
+
+	var exported struct {
+		Export struct {
+			PID string `json:"pid"`
+			MedicalRecords []ds.MedicalInfo `json:"medicalRecords"`
+		} `json:"export"`
+		Digest string `json:"digest"`
+	}
+	err = json.Unmarshal(bundle, &exported)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal the export: %v", err)
+	}
+
+	recordKeys, err := exportRecordKeys(chaincode, user, org, exported.Export.PID, exported.Export.MedicalRecords)
+	if err != nil {
+		return err
+	}
+
+	wallet, err := getOrgWallet(org)
+	if err != nil {
+		return fmt.Errorf("Cannot get wallet: %v", err)
+	}
+
+	hospitalSign, err := sign.GetUserDigitalSignature(hospitalSigner, org, []byte(exported.Digest), wallet)
+	if err != nil {
+		return fmt.Errorf("Cannot get digital signature of the hospital: %v", err)
+	}
+
+	hospitalCertificate, err := sign.GetUserCertificatePEM(hospitalSigner, org, wallet)
+	if err != nil {
+		return err
+	}
+
+	signedExport, err := json.Marshal(ds.SignedRecordExport{
+		Bundle: bundle,
+		HospitalSign: hospitalSign,
+		HospitalCertificate: hospitalCertificate,
+		RecordKeys: recordKeys,
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal the export: %v", err)
+	}
+
+	return ioutil.WriteFile(filepath.Clean(fileName), signedExport, 0600)
+}
+
+/// verify the signed record export in the file, no connection to the network is needed
+func verifyRecordExport(fileName string) ([]byte, error) {
+
+	signedExport, err := ioutil.ReadFile(filepath.Clean(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read export: %v", err)
+	}
+
+	verification, err := export.Verify(signedExport)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(verification)
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"io/ioutil"
 	"strings"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
@@ -85,8 +86,20 @@ func GetUserDigitalSignature(user string, org string, data []byte, wallet *gatew
 	return encodedDigSign, nil
 }
 
+/// digital signature of the canonical form (RFC 8785) of the JSON data
+/// the chaincode verifies JSON signatures on the same canonical bytes
+func GetUserCanonicalSignature(user string, org string, data []byte, wallet *gateway.Wallet) (string, error) {
+
+	canonicalJSON, err := canonical.Transform(data)
+	if err != nil {
+		return "", fmt.Errorf("Cannot canonicalize data: %v", err)
+	}
+
+	return GetUserDigitalSignature(user, org, canonicalJSON, wallet)
+}
+
 /// get public key from the certificate 
-func getUserPublicKey(user string, org string, wallet *gateway.Wallet) (*ecdsa.PublicKey, error) {
+func GetUserPublicKey(user string, org string, wallet *gateway.Wallet) (*ecdsa.PublicKey, error) {
 
 	userWalletContent, err := getUserWalletContent(user, org, wallet)
 	if err != nil {
@@ -109,6 +122,45 @@ func getUserPublicKey(user string, org string, wallet *gateway.Wallet) (*ecdsa.P
 }
 
 
+/// get private key of the user from the wallet
+func GetUserPrivateKey(user string, org string, wallet *gateway.Wallet) (*ecdsa.PrivateKey, error) {
+
+	userWalletContent, err := getUserWalletContent(user, org, wallet)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get user private key: %v", err)
+	}
+
+	userPrivateKeyPEM := []byte(userWalletContent.(*gateway.X509Identity).Key())
+
+	privateKey, err := PrivateKeyFromPEM(userPrivateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get private key: %v", err)
+	}
+
+	ecdsaPrivateKey, ok := privateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Not a ECDSA private key")
+	}
+
+	return ecdsaPrivateKey, nil
+}
+
+/// get public key from the PEM encoded certificate
+func PublicKeyFromCertificatePEM(certificatePEM []byte) (*ecdsa.PublicKey, error) {
+
+	cert, err := CertificateFromPEM(certificatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse certificate: %v", err)
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Not a ECDSA public key")
+	}
+
+	return publicKey, nil
+}
+
 /// verify the digital signature 
 func verifyDigitalSignature(publicKey *ecdsa.PublicKey, data []byte, digitalSignature string) (bool, error) {
 
@@ -132,7 +184,7 @@ func verifyDigitalSignature(publicKey *ecdsa.PublicKey, data []byte, digitalSign
 func Verify(user string, org string, data []byte, digitalSignature string, wallet *gateway.Wallet) (bool, error) {
 
 	/// get user public key
-	userPublicKey, err := getUserPublicKey(user, org, wallet)
+	userPublicKey, err := GetUserPublicKey(user, org, wallet)
 	if err != nil {
 		return false, fmt.Errorf("Cannot get user public key: %v", err)
 	}
@@ -146,6 +198,56 @@ func Verify(user string, org string, data []byte, digitalSignature string, walle
 	return match, nil
 }
 
+/// verify the digital signature on the canonical form (RFC 8785) of the JSON data
+func VerifyCanonical(user string, org string, data []byte, digitalSignature string, wallet *gateway.Wallet) (bool, error) {
+
+	canonicalJSON, err := canonical.Transform(data)
+	if err != nil {
+		return false, fmt.Errorf("Cannot canonicalize data: %v", err)
+	}
+
+	return Verify(user, org, canonicalJSON, digitalSignature, wallet)
+}
+
+/// verify the digital signature with the public key of the PEM encoded certificate
+/// the signer is identified by the certificate, no wallet is needed
+func VerifyWithCertificate(certificatePEM []byte, data []byte, digitalSignature string) (bool, error) {
+
+	publicKey, err := PublicKeyFromCertificatePEM(certificatePEM)
+	if err != nil {
+		return false, err
+	}
+
+	match, err := verifyDigitalSignature(publicKey, data, digitalSignature)
+	if err != nil {
+		return false, fmt.Errorf("Cannot verify the digital signature: %v", err)
+	}
+
+	return match, nil
+}
+
+/// verify the digital signature on the canonical form (RFC 8785) of the JSON data
+/// with the public key of the PEM encoded certificate
+func VerifyCanonicalWithCertificate(certificatePEM []byte, data []byte, digitalSignature string) (bool, error) {
+
+	canonicalJSON, err := canonical.Transform(data)
+	if err != nil {
+		return false, fmt.Errorf("Cannot canonicalize data: %v", err)
+	}
+
+	return VerifyWithCertificate(certificatePEM, canonicalJSON, digitalSignature)
+}
+
+/// PEM encoded certificate of the user
+func GetUserCertificatePEM(user string, org string, wallet *gateway.Wallet) (string, error) {
+
+	userWalletContent, err := getUserWalletContent(user, org, wallet)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get user certificate: %v", err)
+	}
+
+	return userWalletContent.(*gateway.X509Identity).Certificate(), nil
+}
 
 /// util functions 
 func populateWallet(wallet *gateway.Wallet, user string, org string) error {
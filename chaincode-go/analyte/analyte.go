@@ -0,0 +1,196 @@
+/// numeric series of an analyte (e.g. hb or creatinine) across medical records
+/// the chaincode builds the series of the reports it can read, the application the series
+/// of the encrypted reports once it decrypted them, both with the same rules
+package analyte
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/// units of the analytes of the medical record forms of the application,
+/// used when the value of the report has no unit of its own
+var analyteUnits = map[string]string{
+	"hb": "g/dL",
+	"wbc": "10^3/uL",
+	"rbc": "10^6/uL",
+	"platelets": "10^3/uL",
+	"mcv": "fL",
+	"mch": "pg",
+	"mchc": "g/dL",
+	"mpv": "fL",
+	"neutrophils": "%",
+	"lymphocyte": "%",
+	"eosinophils": "%",
+	"basophils": "%",
+	"creatinine": "mg/dL",
+	"egfr": "mL/min/1.73m2",
+	"bun": "mg/dL",
+	"na": "mmol/L",
+	"k": "mmol/L",
+	"cl": "mmol/L",
+	"bicarb": "mmol/L",
+}
+
+/// value of the analyte in one medical record
+/// the delta is the change from the previous point, nil for the first point and when the unit changed
+type Point struct {
+	RecordID string `json:"recordId"`
+	RecordType string `json:"recordType"`
+	Time time.Time `json:"time"`
+	IssuedAt time.Time `json:"issuedAt"`
+	Value float64 `json:"value"`
+	Unit string `json:"unit,omitempty"`
+	Delta *float64 `json:"delta,omitempty"`
+	DeltaPercent *float64 `json:"deltaPercent,omitempty"`
+}
+
+/// numeric series of one analyte of the patient, in the order the values were collected
+/// records with a value that is not a number, or that are still encrypted, are counted
+type Trend struct {
+	PID string `json:"pid"`
+	Analyte string `json:"analyte"`
+	Unit string `json:"unit,omitempty"`
+	Points []Point `json:"points"`
+	NotNumeric int `json:"notNumeric"`
+	Encrypted int `json:"encrypted"`
+}
+
+/// report of a medical record with the time its data was collected at and the time it was issued
+type Sample struct {
+	RecordID string
+	RecordType string
+	Time time.Time
+	IssuedAt time.Time
+	Report map[string]string
+	Encrypted bool
+}
+
+/// number at the start of the value and the unit after it, e.g. "13.5 g/dL"
+func parseAnalyteValue(value string) (float64, int, string, error) {
+
+	value = strings.TrimSpace(value)
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.+-", r)
+	})
+	if end < 0 {
+		end = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%q is not a number", value)
+	}
+
+	decimals := 0
+	if dot := strings.IndexRune(value[:end], '.'); dot >= 0 {
+		decimals = end - dot - 1
+	}
+
+	return number, decimals, strings.TrimSpace(value[end:]), nil
+}
+
+/// round to the decimals of the values the difference was computed from
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value * scale) / scale
+}
+
+/// value of the analyte in the report, the keys are matched ignoring case in sorted order
+/// so that every endorser picks the same value
+func reportValue(report map[string]string, analyte string) (string, bool) {
+
+	keys := make([]string, 0, len(report))
+	for key := range report {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if strings.EqualFold(key, analyte) {
+			return report[key], true
+		}
+	}
+
+	return "", false
+}
+
+/// series of the analyte in the samples
+func NewTrend(samples []Sample, analyte string) *Trend {
+
+	trend := &Trend{Analyte: strings.ToLower(analyte), Points: []Point{}}
+	/// decimals of each point, deltas are rounded to them
+	decimals := []int{}
+
+	/// samples collected at the same time are kept in the order they were added
+	sorted := append([]Sample{}, samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	for _, sample := range sorted {
+		value, ok := reportValue(sample.Report, analyte)
+		if !ok {
+			continue
+		}
+
+		/// encrypted reports cannot be read without the data key
+		if sample.Encrypted {
+			trend.Encrypted++
+			continue
+		}
+
+		number, places, unit, err := parseAnalyteValue(value)
+		if err != nil {
+			trend.NotNumeric++
+			continue
+		}
+		if len(unit) == 0 {
+			unit = analyteUnits[trend.Analyte]
+		}
+
+		trend.Points = append(trend.Points, Point{
+			RecordID: sample.RecordID,
+			RecordType: sample.RecordType,
+			Time: sample.Time,
+			IssuedAt: sample.IssuedAt,
+			Value: number,
+			Unit: unit,
+		})
+		decimals = append(decimals, places)
+	}
+
+	for i := range trend.Points {
+		point := &trend.Points[i]
+		if i == 0 {
+			trend.Unit = point.Unit
+			continue
+		}
+
+		previous := trend.Points[i - 1]
+		if !strings.EqualFold(point.Unit, trend.Unit) {
+			trend.Unit = ""
+		}
+		if !strings.EqualFold(point.Unit, previous.Unit) {
+			continue
+		}
+
+		places := decimals[i]
+		if decimals[i - 1] > places {
+			places = decimals[i - 1]
+		}
+
+		delta := roundTo(point.Value - previous.Value, places)
+		point.Delta = &delta
+		if previous.Value != 0 {
+			percent := roundTo(delta / math.Abs(previous.Value) * 100, 1)
+			point.DeltaPercent = &percent
+		}
+	}
+
+	return trend
+}
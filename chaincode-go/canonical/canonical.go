@@ -0,0 +1,256 @@
+/// canonical JSON (RFC 8785, JSON Canonicalization Scheme) for signed payloads
+/// the chaincode and the application sign and verify the same bytes,
+/// whatever the field order or the encoder that produced the JSON
+package canonical
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"sort"
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"encoding/json"
+)
+
+/// canonical JSON of the value
+func Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal JSON: %v", err)
+	}
+	return Transform(data)
+}
+
+/// canonical form of the JSON document
+/// duplicate object keys and numbers out of the IEEE 754 double range are rejected
+func Transform(data []byte) ([]byte, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	value, err := decodeValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Unexpected data after the JSON document")
+	}
+
+	var buf bytes.Buffer
+	err = encodeValue(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/// object members in the order they are decoded, sorted when encoded
+type member struct {
+	key string
+	value interface{}
+}
+
+type object []member
+
+func decodeValue(decoder *json.Decoder) (interface{}, error) {
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %v", err)
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			return decodeObject(decoder)
+		case '[':
+			return decodeArray(decoder)
+		}
+		return nil, fmt.Errorf("Invalid JSON: unexpected %v", token)
+	default:
+		return token, nil
+	}
+}
+
+func decodeObject(decoder *json.Decoder) (object, error) {
+
+	members := object{}
+	seen := map[string]bool{}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid JSON: %v", err)
+		}
+
+		key, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid JSON: object key is not a string")
+		}
+
+		if seen[key] {
+			return nil, fmt.Errorf("Duplicate object key %q", key)
+		}
+		seen[key] = true
+
+		value, err := decodeValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member{key: key, value: value})
+	}
+
+	/// closing brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %v", err)
+	}
+
+	return members, nil
+}
+
+func decodeArray(decoder *json.Decoder) ([]interface{}, error) {
+
+	values := []interface{}{}
+
+	for decoder.More() {
+		value, err := decodeValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	/// closing bracket
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %v", err)
+	}
+
+	return values, nil
+}
+
+func encodeValue(buf *bytes.Buffer, value interface{}) error {
+
+	switch value := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(value))
+	case string:
+		encodeString(buf, value)
+	case json.Number:
+		number, err := formatNumber(value)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, element := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, element); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case object:
+		/// keys are sorted by their UTF-16 code units
+		sort.Slice(value, func(i, j int) bool {
+			return lessUTF16(value[i].key, value[j].key)
+		})
+		buf.WriteByte('{')
+		for i, member := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeString(buf, member.key)
+			buf.WriteByte(':')
+			if err := encodeValue(buf, member.value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("Unsupported JSON value %T", value)
+	}
+
+	return nil
+}
+
+func lessUTF16(a, b string) bool {
+	unitsA := utf16.Encode([]rune(a))
+	unitsB := utf16.Encode([]rune(b))
+
+	for i := 0; i < len(unitsA) && i < len(unitsB); i++ {
+		if unitsA[i] != unitsB[i] {
+			return unitsA[i] < unitsB[i]
+		}
+	}
+
+	return len(unitsA) < len(unitsB)
+}
+
+/// only the quotation mark, the reverse solidus and the control characters are escaped
+func encodeString(buf *bytes.Buffer, value string) {
+
+	buf.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+/// number serialized the way ECMAScript serializes a double
+func formatNumber(number json.Number) (string, error) {
+
+	value, err := strconv.ParseFloat(string(number), 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return "", fmt.Errorf("Number %v is not a valid IEEE 754 double", number)
+	}
+
+	/// also -0
+	if value == 0 {
+		return "0", nil
+	}
+
+	abs := math.Abs(value)
+	if abs >= 1e21 || abs < 1e-6 {
+		/// shortest digits with the exponent as ECMAScript writes it: 1e+21, 1.5e-7
+		formatted := strconv.FormatFloat(value, 'e', -1, 64)
+		mantissa, exponent, _ := strings.Cut(formatted, "e")
+		sign := exponent[:1]
+		exponent = strings.TrimLeft(exponent[1:], "0")
+		return mantissa + "e" + sign + exponent, nil
+	}
+
+	return strconv.FormatFloat(value, 'f', -1, 64), nil
+}
@@ -0,0 +1,96 @@
+package canonical
+
+import (
+	"math"
+	"testing"
+	"strconv"
+	"encoding/json"
+)
+
+/// number serialization samples of RFC 8785 appendix B, by IEEE 754 bit pattern
+func TestNumberVectors(t *testing.T) {
+	vectors := []struct {
+		bits uint64
+		want string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+	}
+
+	for _, vector := range vectors {
+		value := math.Float64frombits(vector.bits)
+		got, err := formatNumber(json.Number(strconv.FormatFloat(value, 'g', -1, 64)))
+		if err != nil || got != vector.want {
+			t.Errorf("%016x: got %q, %v, want %q", vector.bits, got, err, vector.want)
+		}
+	}
+
+	/// NaN and Infinity have no JSON text, a number out of the double range is rejected
+	if got, err := formatNumber(json.Number("1e400")); err == nil {
+		t.Errorf("1e400 serialized as %q", got)
+	}
+}
+
+/// example of RFC 8785 section 3.2.2: literals, numbers and string escaping
+func TestTransformVector(t *testing.T) {
+	input := `{
+  "numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+  "string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+  "literals": [null, true, false]
+}`
+	want := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+
+	got, err := Transform([]byte(input))
+	if err != nil || string(got) != want {
+		t.Errorf("Transform: got %s, %v\nwant %s", got, err, want)
+	}
+}
+
+/// example of RFC 8785 section 3.2.3: keys sorted by UTF-16 code units,
+/// the emoji (surrogate 0xd83d) before the Hebrew letter (0xfb33) though its code point is larger
+func TestKeyOrderUTF16(t *testing.T) {
+	input := `{
+  "\u20ac": "Euro Sign",
+  "\r": "Carriage Return",
+  "\ufb33": "Hebrew Letter Dalet With Dagesh",
+  "1": "One",
+  "\ud83d\ude00": "Emoji: Grinning Face",
+  "\u0080": "Control",
+  "\u00f6": "Latin Small Letter O With Diaeresis"
+}`
+	want := "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\"," +
+		"\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"
+
+	got, err := Transform([]byte(input))
+	if err != nil || string(got) != want {
+		t.Errorf("Transform: got %s, %v\nwant %s", got, err, want)
+	}
+}
+
+func TestDuplicateKeyRejected(t *testing.T) {
+	if got, err := Transform([]byte(`{"a":1,"a":2}`)); err == nil {
+		t.Errorf("duplicate key serialized as %s", got)
+	}
+}
@@ -5,15 +5,19 @@ import (
 	"log"
 	"strings"
 	"strconv"
-	"encoding/json"
+	"time"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 type dataAccessRequest struct {
-	MetaData metaData `json:"meta_data"`
-	PatientID string `json:"patient_id"`
-	ClientSign string `json:"client_sign"`
+	SchemaVersion int `json:"schemaVersion"`
+	MetaData metaData `json:"metaData"`
+	PatientID string `json:"patientId"`
+	ClientSign string `json:"clientSign"`
 	Valid bool `json:"valid"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Payload string `json:"payload"`
 }
 
 func (dar *dataAccessRequest)assignData(pid, clientSign, user, org, id string) error {
@@ -35,6 +39,14 @@ func (dar *dataAccessRequest)assignData(pid, clientSign, user, org, id string) e
 	return nil
 }
 
+func (dar *dataAccessRequest) objectType() string {
+	return dataAccessRequestObjectType
+}
+
+func (dar *dataAccessRequest) setSchemaVersion(version int) {
+	dar.SchemaVersion = version
+}
+
 func (dar *dataAccessRequest) getClientID() (string, error) {
 	if len(dar.MetaData.ClientID) == 0 {
 		return "", fmt.Errorf("Client Id not found in meta data")
@@ -45,8 +57,9 @@ func (dar *dataAccessRequest) getClientID() (string, error) {
 
 const dataAccessRequestObjectType = "dataAccessRequest"
 
-/// create data access request 
-func (s *SmartContract) CreateDataAccessRequest(ctx contractapi.TransactionContextInterface, pid string, clientSign string, user string, org string) error {
+/// create data access request from the payload signed by the doctor
+/// the request expires after the expiry days of the payload, the default expiry is used for 0
+func (s *SmartContract) CreateDataAccessRequest(ctx contractapi.TransactionContextInterface, payload string, clientSign string) error {
 	
 	/// check if the client is doctor 
 	client, err := s.GetIdentityAttribute(ctx, "role")
@@ -70,6 +83,22 @@ func (s *SmartContract) CreateDataAccessRequest(ctx contractapi.TransactionConte
 		return fmt.Errorf("Create data access request cannot be performed: Error %v", err)
 	}
 
+	request, err := parseRequestPayload(payload, dataAccessRequestObjectType)
+	if err != nil {
+		return fmt.Errorf("Cannot create data access request: %v", err)
+	}
+
+	if request.MetaData.ClientID != id {
+		return fmt.Errorf("Signed payload is not issued by %v", id)
+	}
+
+	err = verifyClientJSONSignature(ctx, []byte(payload), clientSign)
+	if err != nil {
+		return fmt.Errorf("Cannot create data access request: %v", err)
+	}
+
+	pid := request.PID
+
 	/// check client already has access to asset data 
 	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
 	if err != nil {
@@ -86,18 +115,35 @@ func (s *SmartContract) CreateDataAccessRequest(ctx contractapi.TransactionConte
 	}
 
 	var accessRequest dataAccessRequest
-	err = accessRequest.assignData(pid, clientSign, user, org, id)
+	err = accessRequest.assignData(pid, clientSign, request.MetaData.User, request.MetaData.Org, id)
+	if err != nil {
+		return fmt.Errorf("Cannot create data access request: %v", err)
+	}
+
+	accessRequest.CreatedAt, err = getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = checkRequestPayload(ctx, request, accessRequest.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Cannot create data access request: %v", err)
+	}
+
+	accessRequest.ExpiresAt, err = requestExpiry(accessRequest.CreatedAt, request.ExpiryDays)
 	if err != nil {
 		return fmt.Errorf("Cannot create data access request: %v", err)
 	}
 
+	accessRequest.Payload = payload
+
 	/// get org collection name 
 	orgCollectionName, err := getOrgCollectionName(ctx)
 	if err != nil {
 		return fmt.Errorf("Cannot create data access request: %v", err)
 	}
 
-	accessRequestJSON, err := json.Marshal(accessRequest)
+	accessRequestJSON, err := marshalStoredObject(&accessRequest)
 	if err != nil {
 		return fmt.Errorf("Cannot marshal data access request: %v", err)
 	}
@@ -148,7 +194,7 @@ func (s *SmartContract) ReadDataAccessRequest(ctx contractapi.TransactionContext
 
 	/// data access request structure 
 	var request dataAccessRequest
-	err = json.Unmarshal(dataAccessRequestJSON, &request)
+	err = unmarshalStoredObject(dataAccessRequestJSON, &request)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot unmarshal data access request: %v", err)
 	}
@@ -208,7 +254,7 @@ func (s *SmartContract) NotifyDataAccessRequest(ctx contractapi.TransactionConte
 
 	/// data access request structure 
 	var request dataAccessRequest
-	err = json.Unmarshal(dataAccessRequestJSON, &request)
+	err = unmarshalStoredObject(dataAccessRequestJSON, &request)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot unmarshal data access request: %v", err)
 	}
@@ -276,6 +322,16 @@ func (s *SmartContract) ValidateDataAccessRequest(ctx contractapi.TransactionCon
 		return fmt.Errorf("Cannot read data access request: %v", err)
 	}
 
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = checkRequestNotExpired(request.ExpiresAt, now)
+	if err != nil {
+		return fmt.Errorf("Cannot validate data access request: %v", err)
+	}
+
 	/// after verifying the digital signature on the data access request, assign the valid field 
 	request.Valid = check
 
@@ -291,7 +347,7 @@ func (s *SmartContract) ValidateDataAccessRequest(ctx contractapi.TransactionCon
 		return fmt.Errorf("failed to create composite key: %v", err)
 	}
 
-	dataAccessRequestJSON, err := json.Marshal(request)
+	dataAccessRequestJSON, err := marshalStoredObject(request)
 	if err != nil {
 		return fmt.Errorf("Cannot marshal data access request: %v", err)
 	}
@@ -333,7 +389,7 @@ func (s *SmartContract) VerifyDataAccessRequest(ctx contractapi.TransactionConte
 		return err
 	}
 
-	assetData, err := s.ReadAssetPrivateData(ctx, request.PatientID)
+	assetData, err := s.readAssetPrivateData(ctx, request.PatientID)
 	if err != nil {
 		return err
 	}
@@ -346,6 +402,16 @@ func (s *SmartContract) VerifyDataAccessRequest(ctx contractapi.TransactionConte
     if !request.Valid {
 		return fmt.Errorf("data access request is not valid request, digital signature falied to verify")
 	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = checkRequestNotExpired(request.ExpiresAt, now)
+	if err != nil {
+		return fmt.Errorf("data access request is not valid request: %v", err)
+	}
 	
 	return nil
 
@@ -387,7 +453,7 @@ func (s *SmartContract) GrantDataAccess(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("Error while verfiy request agreement: %v", err)
 	}
 
-	assetData, err := s.ReadAssetPrivateData(ctx, assetID)
+	assetData, err := s.readAssetPrivateData(ctx, assetID)
 	if err != nil {
 		return fmt.Errorf("Error reading asset data from the collection: %v", err)
 	}
@@ -399,17 +465,24 @@ func (s *SmartContract) GrantDataAccess(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("Error reading meta data of agreement")
 	}
 
-	err = assetData.addDoctorInfo(reqClientID)
+	/// a doctor with referral access is given direct access to all the records
+	added, err := assetData.addDirectAccess(reqClientID)
 	if err != nil {
 		return fmt.Errorf("Cannot add data to patient: %v", err)
 	}
 
+	/// data key wrapped for the new grantee
+	err = addWrappedKeyFromTransient(ctx, assetData, reqClientID)
+	if err != nil {
+		return fmt.Errorf("Cannot grant data access: %v", err)
+	}
+
 	orgCollectionName, err := assetData.getMetaData()
 	if err != nil {
 		return err
 	}
 
-	assetDataJSON, err := json.Marshal(assetData)
+	assetDataJSON, err := marshalStoredObject(assetData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -421,10 +494,17 @@ func (s *SmartContract) GrantDataAccess(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("failed to put asset private details: %v", err)
 	}
 
-	/// update doctor info 
-	err = s.updateDocInfo(ctx, reqClientID, assetID)
+	err = s.recordAccess(ctx, assetData, accessWrite)
 	if err != nil {
-		return fmt.Errorf("Error while adding patient id: %v", err)
+		return err
+	}
+
+	/// update doctor info 
+	if added {
+		err = s.updateDocInfo(ctx, reqClientID, assetID)
+		if err != nil {
+			return fmt.Errorf("Error while adding patient id: %v", err)
+		}
 	}
 
 	/// delete the data access request 
@@ -460,7 +540,7 @@ func (s *SmartContract) RevokeAccess(ctx contractapi.TransactionContextInterface
 	}
 
 	/// get asset data 
-	assetData, err := s.ReadAssetPrivateData(ctx, assetID)
+	assetData, err := s.readAssetPrivateData(ctx, assetID)
 	if err != nil {
 		return fmt.Errorf("Cannot read client data: %v", err)
 	}
@@ -476,7 +556,7 @@ func (s *SmartContract) RevokeAccess(ctx contractapi.TransactionContextInterface
 		return err
 	}
 
-	assetDataJSON, err := json.Marshal(assetData)
+	assetDataJSON, err := marshalStoredObject(assetData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -488,6 +568,11 @@ func (s *SmartContract) RevokeAccess(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("failed to put asset private details: %v", err)
 	}
 
+	err = s.recordAccess(ctx, assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+
 
 	/// get doctor data 
 	doctorData, err := s.ReadDoctorPrivateData(ctx, clientID)
@@ -505,7 +590,7 @@ func (s *SmartContract) RevokeAccess(ctx contractapi.TransactionContextInterface
 		return err
 	}
 
-	doctorDataJSON, err := json.Marshal(doctorData)
+	doctorDataJSON, err := marshalStoredObject(doctorData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
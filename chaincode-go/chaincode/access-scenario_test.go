@@ -0,0 +1,356 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const org1CollectionName = "Org1MSPPrivateCollection"
+
+/// network with the collections of the deployment and the clients of a scenario
+type scenario struct {
+	t *testing.T
+	network *fabrictest.Network
+	contract *SmartContract
+	nonces int
+}
+
+func newScenario(t *testing.T) *scenario {
+	network := fabrictest.NewNetwork("mychannel", "basic")
+
+	err := network.LoadCollectionsConfig("../collections_config.json")
+	if err != nil {
+		t.Fatalf("Cannot load collections: %v", err)
+	}
+
+	return &scenario{t: t, network: network, contract: &SmartContract{}}
+}
+
+func (sc *scenario) client(mspID, role, id string) *fabrictest.Identity {
+	identity, err := sc.network.NewIdentity(mspID, strings.ToLower(role) + id, map[string]string{"role": role, "id": id})
+	if err != nil {
+		sc.t.Fatalf("Cannot enroll %v: %v", id, err)
+	}
+	return identity
+}
+
+func testPersonalInfo(firstName, clientType string) ClientPersonalInfo {
+	var info ClientPersonalInfo
+	info.SetInfo(40, firstName, "Doe", "female", strings.ToLower(firstName) + "@example.com", "5550100", "Springfield", "IL", "USA", clientType)
+	return info
+}
+
+func (sc *scenario) registerPatient(patient *fabrictest.Identity) {
+	assetData, err := json.Marshal(PatientInfo{PersonalInfo: testPersonalInfo("Alice", "patient")})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	tx := sc.network.NewTransaction(patient, "RegisterPatient").WithTransient("asset_data", assetData)
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RegisterPatient(ctx)
+	})
+	if err != nil {
+		sc.t.Fatalf("RegisterPatient: %v", err)
+	}
+}
+
+func (sc *scenario) registerDoctor(doctor *fabrictest.Identity, firstName string) {
+	assetData, err := json.Marshal(DoctorInfo{PersonalInfo: testPersonalInfo(firstName, "doctor"), Specialization: "cardiology"})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	tx := sc.network.NewTransaction(doctor, "RegisterDoctor").WithTransient("asset_data", assetData)
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RegisterDoctor(ctx)
+	})
+	if err != nil {
+		sc.t.Fatalf("RegisterDoctor: %v", err)
+	}
+}
+
+/// data access request payload of the doctor for the patient, issued for the transaction
+func (sc *scenario) requestPayload(tx *fabrictest.Transaction, doctorID, pid string) string {
+	sc.nonces++
+	payload, err := json.Marshal(SignedRequestPayload{
+		Type: dataAccessRequestObjectType,
+		MetaData: metaData{Org: "org1", User: "doctor" + doctorID, ClientID: doctorID},
+		PID: pid,
+		Nonce: fmt.Sprintf("%032x", sc.nonces),
+		IssuedAt: tx.Timestamp(),
+		Channel: sc.network.Channel(),
+		Chaincode: sc.network.Chaincode(),
+	})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	return string(payload)
+}
+
+func (sc *scenario) createDataAccessRequest(doctor *fabrictest.Identity, doctorID, pid string) (string, string, error) {
+	tx := sc.network.NewTransaction(doctor, "CreateDataAccessRequest")
+
+	payload := sc.requestPayload(tx, doctorID, pid)
+	sign, err := doctor.SignJSON([]byte(payload))
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CreateDataAccessRequest(ctx, payload, sign)
+	})
+	return payload, sign, err
+}
+
+func (sc *scenario) submit(client *fabrictest.Identity, function string, invoke func(ctx contractapi.TransactionContextInterface) error) error {
+	return sc.network.NewTransaction(client, function).Submit(invoke)
+}
+
+func (sc *scenario) readPatient(patient *fabrictest.Identity, pid string) *PatientInfo {
+	var assetData *PatientInfo
+	err := sc.network.NewTransaction(patient, "readAssetPrivateData", pid).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		assetData, err = sc.contract.readAssetPrivateData(ctx, pid)
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("readAssetPrivateData: %v", err)
+	}
+	return assetData
+}
+
+func (sc *scenario) readDoctor(client *fabrictest.Identity, did string) *DoctorInfo {
+	var doctorData *DoctorInfo
+	err := sc.network.NewTransaction(client, "ReadDoctorPrivateData", did).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		doctorData, err = sc.contract.ReadDoctorPrivateData(ctx, did)
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("ReadDoctorPrivateData: %v", err)
+	}
+	return doctorData
+}
+
+func dataAccessRequestKey(pid string) string {
+	return "\x00" + dataAccessRequestObjectType + "\x00" + pid + "\x00"
+}
+
+func containsID(ids []string, id string) bool {
+	for _, value := range ids {
+		if value == id {
+			return true
+		}
+	}
+	return false
+}
+
+/// patient and two doctors of org1, the first doctor appointed
+func newAppointedScenario(t *testing.T) (*scenario, *fabrictest.Identity, *fabrictest.Identity, *fabrictest.Identity) {
+	sc := newScenario(t)
+
+	patient := sc.client("Org1MSP", "patient", "0001P")
+	doctor := sc.client("Org1MSP", "doctor", "0001D")
+	specialist := sc.client("Org1MSP", "doctor", "0002D")
+
+	sc.registerDoctor(doctor, "Bob")
+	sc.registerDoctor(specialist, "Carol")
+	sc.registerPatient(patient)
+
+	err := sc.submit(patient, "AppointDoctor", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AppointDoctor(ctx, "0001D")
+	})
+	if err != nil {
+		t.Fatalf("AppointDoctor: %v", err)
+	}
+
+	return sc, patient, doctor, specialist
+}
+
+func TestRegisterAndAppoint(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+
+	assetData := sc.readPatient(patient, "0001P")
+	if assetData.Meta.CollectionName != org1CollectionName {
+		t.Errorf("patient collection = %v", assetData.Meta.CollectionName)
+	}
+	if len(assetData.TreatedBy) != 1 || assetData.TreatedBy[0] != "0001D" {
+		t.Errorf("patient treated by %v", assetData.TreatedBy)
+	}
+
+	doctorData := sc.readDoctor(doctor, "0001D")
+	if !containsID(doctorData.PIDS, "0001P") {
+		t.Errorf("doctor patients %v", doctorData.PIDS)
+	}
+
+	/// second registration of the same patient
+	assetJSON, _ := json.Marshal(PatientInfo{PersonalInfo: testPersonalInfo("Alice", "patient")})
+	err := sc.network.NewTransaction(patient, "RegisterPatient").WithTransient("asset_data", assetJSON).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RegisterPatient(ctx)
+	})
+	if err == nil {
+		t.Errorf("patient registered twice")
+	}
+}
+
+func TestRequestGrantRevoke(t *testing.T) {
+	sc, patient, _, specialist := newAppointedScenario(t)
+
+	_, _, err := sc.createDataAccessRequest(specialist, "0002D", "0001P")
+	if err != nil {
+		t.Fatalf("CreateDataAccessRequest: %v", err)
+	}
+
+	/// the request waits for the patient to validate it
+	err = sc.submit(patient, "GrantDataAccess", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.GrantDataAccess(ctx)
+	})
+	if err == nil {
+		t.Fatalf("access granted on a request that is not validated")
+	}
+
+	err = sc.submit(patient, "ValidateDataAccessRequest", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.ValidateDataAccessRequest(ctx, "true")
+	})
+	if err != nil {
+		t.Fatalf("ValidateDataAccessRequest: %v", err)
+	}
+
+	err = sc.submit(patient, "GrantDataAccess", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.GrantDataAccess(ctx)
+	})
+	if err != nil {
+		t.Fatalf("GrantDataAccess: %v", err)
+	}
+
+	assetData := sc.readPatient(patient, "0001P")
+	if !containsID(assetData.TreatedBy, "0002D") {
+		t.Errorf("access not granted, patient treated by %v", assetData.TreatedBy)
+	}
+	if !containsID(sc.readDoctor(specialist, "0002D").PIDS, "0001P") {
+		t.Errorf("patient not added to the specialist")
+	}
+	if sc.network.PrivateData(org1CollectionName, dataAccessRequestKey("0001P")) != nil {
+		t.Errorf("data access request not deleted after the grant")
+	}
+
+	err = sc.submit(patient, "RevokeAccess", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RevokeAccess(ctx, "0002D")
+	})
+	if err != nil {
+		t.Fatalf("RevokeAccess: %v", err)
+	}
+
+	assetData = sc.readPatient(patient, "0001P")
+	if containsID(assetData.TreatedBy, "0002D") || !containsID(assetData.TreatedBy, "0001D") {
+		t.Errorf("after revoke patient treated by %v", assetData.TreatedBy)
+	}
+	if containsID(sc.readDoctor(specialist, "0002D").PIDS, "0001P") {
+		t.Errorf("patient not removed from the specialist")
+	}
+
+	/// the revoked doctor cannot add records anymore
+	err = sc.submit(specialist, "AddMedicalRecord", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+	if err == nil || !strings.Contains(err.Error(), "Cannot Add medical reports") {
+		t.Errorf("revoked doctor adding a record: %v", err)
+	}
+
+	/// grant and revoke were logged for the patient
+	var accessLog *AccessLog
+	err = sc.network.NewTransaction(patient, "GetAccessLog").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		accessLog, err = sc.contract.GetAccessLog(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("GetAccessLog: %v", err)
+	}
+
+	functions := []string{}
+	for _, entry := range accessLog.Data {
+		if entry.Action == accessWrite {
+			functions = append(functions, entry.Function)
+		}
+	}
+	if !containsID(functions, "GrantDataAccess") || !containsID(functions, "RevokeAccess") {
+		t.Errorf("access log writes %v", functions)
+	}
+}
+
+func TestDataAccessRequestReplay(t *testing.T) {
+	sc, _, _, specialist := newAppointedScenario(t)
+
+	payload, sign, err := sc.createDataAccessRequest(specialist, "0002D", "0001P")
+	if err != nil {
+		t.Fatalf("CreateDataAccessRequest: %v", err)
+	}
+
+	/// the same signed payload in a later transaction
+	err = sc.submit(specialist, "CreateDataAccessRequest", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CreateDataAccessRequest(ctx, payload, sign)
+	})
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("replayed payload: %v", err)
+	}
+}
+
+func TestDataAccessRequestSignature(t *testing.T) {
+	sc, _, doctor, specialist := newAppointedScenario(t)
+
+	/// payload of the specialist signed by another doctor
+	tx := sc.network.NewTransaction(specialist, "CreateDataAccessRequest")
+	payload := sc.requestPayload(tx, "0002D", "0001P")
+	sign, err := doctor.SignJSON([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CreateDataAccessRequest(ctx, payload, sign)
+	})
+	if err == nil || !strings.Contains(err.Error(), "Digital signature failed to verify") {
+		t.Errorf("payload signed by another client: %v", err)
+	}
+
+	/// payload issued in the name of another doctor
+	tx = sc.network.NewTransaction(specialist, "CreateDataAccessRequest")
+	payload = sc.requestPayload(tx, "0001D", "0001P")
+	sign, err = specialist.SignJSON([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CreateDataAccessRequest(ctx, payload, sign)
+	})
+	if err == nil || !strings.Contains(err.Error(), "not issued by") {
+		t.Errorf("payload of another doctor: %v", err)
+	}
+
+	if sc.network.PrivateData(org1CollectionName, dataAccessRequestKey("0001P")) != nil {
+		t.Errorf("rejected request was stored")
+	}
+}
+
+func TestClientOnPeerOfAnotherOrg(t *testing.T) {
+	sc := newScenario(t)
+	patient := sc.client("Org2MSP", "patient", "0002P")
+
+	assetData, _ := json.Marshal(PatientInfo{PersonalInfo: testPersonalInfo("Dana", "patient")})
+	err := sc.network.NewTransaction(patient, "RegisterPatient").OnPeer("Org1MSP").WithTransient("asset_data", assetData).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RegisterPatient(ctx)
+	})
+	if err == nil {
+		t.Fatalf("org2 patient registered on the org1 peer")
+	}
+
+	if sc.network.PrivateData("Org2MSPPrivateCollection", "0002P") != nil {
+		t.Errorf("failed registration was committed")
+	}
+}
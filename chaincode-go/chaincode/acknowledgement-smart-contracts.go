@@ -0,0 +1,253 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// review status of a disputed record
+const (
+	disputeOpen = "open"
+	disputeResolved = "resolved"
+)
+
+/// content the patient countersigns, binds the acknowledgement to the doctor signature
+type AcknowledgementContent struct {
+	RecordID string `json:"recordId"`
+	DoctorSign string `json:"doctorSign"`
+	Disputed bool `json:"disputed"`
+	Comment string `json:"comment"`
+}
+
+/// patient countersignature on a medical record, with the dispute if any
+type PatientAcknowledgement struct {
+	PatientSign string `json:"patientSign"`
+	CertFingerprint string `json:"certFingerprint"`
+	SignedAt time.Time `json:"signedAt"`
+	Disputed bool `json:"disputed"`
+	Comment string `json:"comment,omitempty"`
+	ReviewStatus string `json:"reviewStatus,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+/// disputed record awaiting review by the issuing doctor
+type RecordDispute struct {
+	PID string `json:"pid"`
+	RecordID string `json:"recordId"`
+	Type string `json:"type"`
+	Comment string `json:"comment"`
+	DisputedAt time.Time `json:"disputedAt"`
+}
+
+type RecordDisputes struct {
+	Data []RecordDispute `json:"data"`
+}
+
+func (pa *PatientAcknowledgement) isOpenDispute() bool {
+	return pa.Disputed && pa.ReviewStatus == disputeOpen
+}
+
+/// patient acknowledges a medical record by countersigning it
+func (s *SmartContract) AcknowledgeMedicalRecord(ctx contractapi.TransactionContextInterface, recordID string, patientSign string) error {
+	return s.countersignMedicalRecord(ctx, recordID, patientSign, false, "")
+}
+
+/// patient disputes a medical record, the record is flagged for review by the issuing doctor
+func (s *SmartContract) DisputeMedicalRecord(ctx contractapi.TransactionContextInterface, recordID string, comment string, patientSign string) error {
+	if len(strings.TrimSpace(comment)) == 0 {
+		return fmt.Errorf("Comment is required to dispute a medical record")
+	}
+	return s.countersignMedicalRecord(ctx, recordID, patientSign, true, comment)
+}
+
+/// issuing doctor resolves the dispute on a medical record
+func (s *SmartContract) ResolveRecordDispute(ctx contractapi.TransactionContextInterface, pid string, recordID string, resolution string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return fmt.Errorf("Only Doctor can resolve record dispute")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	if len(strings.TrimSpace(resolution)) == 0 {
+		return fmt.Errorf("Resolution is required")
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	medicalData, err := assetData.getMedicalRecord(recordID)
+	if err != nil {
+		return err
+	}
+
+	if medicalData.IssuedBy != id {
+		return fmt.Errorf("Only the issuing doctor can resolve the dispute on record %v", recordID)
+	}
+
+	if medicalData.PatientAck == nil || !medicalData.PatientAck.isOpenDispute() {
+		return fmt.Errorf("Medical record %v has no open dispute", recordID)
+	}
+
+	resolvedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	medicalData.PatientAck.ReviewStatus = disputeResolved
+	medicalData.PatientAck.Resolution = resolution
+	medicalData.PatientAck.ResolvedAt = resolvedAt
+
+	return s.putAssetData(ctx, assetData)
+}
+
+/// open disputes on the records issued by the doctor client
+func (s *SmartContract) GetRecordDisputes(ctx contractapi.TransactionContextInterface) (*RecordDisputes, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Doctor info: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return nil, fmt.Errorf("Cannot get Doctor info: client is not Doctor")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Doctor info: %v", err)
+	}
+
+	disputes, err := s.getOpenDisputes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordDisputes{Data: disputes}, nil
+}
+
+/// open disputes on the records issued by the doctor, over the patients the doctor treats
+func (s *SmartContract) getOpenDisputes(ctx contractapi.TransactionContextInterface, id string) ([]RecordDispute, error) {
+
+	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Doctor data not found: %v", err)
+	}
+
+	disputes := []RecordDispute{}
+	for _, pid := range doctorData.PIDS {
+		patientData, err := s.readAssetPrivateData(ctx, pid)
+		if err != nil {
+			return nil, fmt.Errorf("Error while reading patient data: %v", err)
+		}
+
+		for _, record := range patientData.MedicalRecords {
+			if record.IssuedBy != id || record.PatientAck == nil || !record.PatientAck.isOpenDispute() {
+				continue
+			}
+			disputes = append(disputes, RecordDispute{
+				PID: pid,
+				RecordID: record.ID,
+				Type: record.Type,
+				Comment: record.PatientAck.Comment,
+				DisputedAt: record.PatientAck.SignedAt,
+			})
+		}
+	}
+
+	return disputes, nil
+}
+
+/// verify the patient countersignature and store it on the record
+func (s *SmartContract) countersignMedicalRecord(ctx contractapi.TransactionContextInterface, recordID string, patientSign string, disputed bool, comment string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return fmt.Errorf("Only Patient can acknowledge medical records")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Cannot get Patient info: %v", err)
+	}
+
+	medicalData, err := assetData.getMedicalRecord(recordID)
+	if err != nil {
+		return err
+	}
+
+	if ack := medicalData.PatientAck; ack != nil {
+		if ack.isOpenDispute() {
+			return fmt.Errorf("Medical record %v is under review", recordID)
+		}
+		if !disputed && !ack.Disputed {
+			return fmt.Errorf("Medical record %v is already acknowledged", recordID)
+		}
+	}
+
+	content := AcknowledgementContent{
+		RecordID: recordID,
+		DoctorSign: medicalData.DoctorSign,
+		Disputed: disputed,
+		Comment: comment,
+	}
+
+	contentJSON, err := canonical.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal acknowledgement: %v", err)
+	}
+
+	err = verifyClientSignature(ctx, contentJSON, patientSign)
+	if err != nil {
+		return fmt.Errorf("Cannot acknowledge medical record: %v", err)
+	}
+
+	fingerprint, err := storeClientCertificate(ctx)
+	if err != nil {
+		return err
+	}
+
+	signedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	ack := &PatientAcknowledgement{
+		PatientSign: patientSign,
+		CertFingerprint: fingerprint,
+		SignedAt: signedAt,
+		Disputed: disputed,
+		Comment: comment,
+	}
+
+	if disputed {
+		ack.ReviewStatus = disputeOpen
+	}
+
+	medicalData.PatientAck = ack
+
+	return s.putAssetData(ctx, assetData)
+}
@@ -0,0 +1,68 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestDoctorCannotForgePatientAcknowledgement(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+
+	collectedAt := sc.network.Now().Add(-time.Hour)
+	medicalData := MedicalInfo{
+		Type: "CBC",
+		MReport: map[string]string{"hb": "13.5"},
+		CollectedAt: &collectedAt,
+		Owner: "0001P",
+		IssuedBy: "0001D",
+		PatientAck: &PatientAcknowledgement{PatientSign: "forged", CertFingerprint: "forged"},
+	}
+	content, err := medicalData.signedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	medicalData.DoctorSign, err = doctor.SignJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	medicalDataJSON, err := json.Marshal(medicalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sc.network.NewTransaction(doctor, "AddMedicalRecord", "0001P").WithTransient("medical_data", medicalDataJSON).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+	if err != nil {
+		t.Fatalf("AddMedicalRecord: %v", err)
+	}
+
+	record := sc.readPatient(patient, "0001P").MedicalRecords[0]
+	if record.PatientAck != nil {
+		t.Fatalf("acknowledgement of the doctor stored: %+v", record.PatientAck)
+	}
+
+	/// the patient can still acknowledge the record
+	ackJSON, err := canonical.Marshal(AcknowledgementContent{RecordID: record.ID, DoctorSign: record.DoctorSign})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patientSign, err := patient.Sign(ackJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sc.submit(patient, "AcknowledgeMedicalRecord", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AcknowledgeMedicalRecord(ctx, record.ID, patientSign)
+	})
+	if err != nil {
+		t.Fatalf("AcknowledgeMedicalRecord: %v", err)
+	}
+
+	record = sc.readPatient(patient, "0001P").MedicalRecords[0]
+	if record.PatientAck == nil || record.PatientAck.PatientSign != patientSign {
+		t.Errorf("patient acknowledgement %+v", record.PatientAck)
+	}
+}
@@ -0,0 +1,52 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/analyte"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// value of the analyte in one medical record, see the analyte package
+type AnalytePoint = analyte.Point
+
+/// numeric series of one analyte of the patient, see the analyte package
+type AnalyteTrend = analyte.Trend
+
+/// series of the analyte in the records, encrypted reports are only counted
+func analyteTrend(records []MedicalInfo, name string) *AnalyteTrend {
+
+	samples := make([]analyte.Sample, 0, len(records))
+	for _, record := range records {
+		samples = append(samples, analyte.Sample{
+			RecordID: record.ID,
+			RecordType: record.Type,
+			Time: record.clinicalTime(),
+			IssuedAt: record.issueTime(),
+			Report: record.MReport,
+			Encrypted: record.Encrypted,
+		})
+	}
+
+	return analyte.NewTrend(samples, name)
+}
+
+/// numeric series of one analyte (e.g. hb or creatinine) across the medical records of the patient
+/// with the unit of each value and the change from the previous value, for charting or export
+/// patients read their own trends, doctors the trends of their patients in the records they can see
+func (s *SmartContract) GetAnalyteTrend(ctx contractapi.TransactionContextInterface, pid string, name string) (*AnalyteTrend, error) {
+
+	if len(strings.TrimSpace(name)) == 0 {
+		return nil, fmt.Errorf("Analyte must be non-empty value")
+	}
+
+	records, err := s.readQueryableMedicalRecords(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	trend := analyteTrend(records, strings.TrimSpace(name))
+	trend.PID = pid
+
+	return trend, nil
+}
@@ -0,0 +1,93 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) analyteTrend(client *fabrictest.Identity, pid, analyte string) (*AnalyteTrend, error) {
+	var trend *AnalyteTrend
+	err := sc.network.NewTransaction(client, "GetAnalyteTrend", pid, analyte).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		trend, err = sc.contract.GetAnalyteTrend(ctx, pid, analyte)
+		return err
+	})
+	return trend, err
+}
+
+/// record of the report signed and added by the doctor, collected at the time
+func (sc *scenario) addReport(doctor *fabrictest.Identity, recordType string, report map[string]string, collectedAt time.Time) {
+	medicalData := MedicalInfo{Type: recordType, MReport: report, CollectedAt: &collectedAt, Owner: "0001P", IssuedBy: "0001D"}
+	content, err := medicalData.signedContent()
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	medicalData.DoctorSign, err = doctor.SignJSON(content)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	medicalDataJSON, err := json.Marshal(medicalData)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	err = sc.network.NewTransaction(doctor, "AddMedicalRecord", "0001P").WithTransient("medical_data", medicalDataJSON).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+	if err != nil {
+		sc.t.Fatalf("AddMedicalRecord: %v", err)
+	}
+}
+
+func TestGetAnalyteTrend(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+
+	/// added out of the order they were collected in
+	now := sc.network.Now()
+	sc.addReport(doctor, "CBC", map[string]string{"hb": "12.1", "wbc": "6.0"}, now.Add(-48*time.Hour))
+	sc.addReport(doctor, "CBC", map[string]string{"HB": "13.5 g/dL"}, now.Add(-12*time.Hour))
+	sc.addReport(doctor, "CBC", map[string]string{"hb": "11.9"}, now.Add(-72*time.Hour))
+	sc.addReport(doctor, "CBC", map[string]string{"hb": "haemolysed"}, now.Add(-24*time.Hour))
+	sc.addReport(doctor, "RFT", map[string]string{"creatinine": "1.1"}, now.Add(-6*time.Hour))
+
+	trend, err := sc.analyteTrend(doctor, "0001P", "Hb")
+	if err != nil {
+		t.Fatalf("GetAnalyteTrend: %v", err)
+	}
+	if len(trend.Points) != 3 || trend.NotNumeric != 1 || trend.Unit != "g/dL" {
+		t.Fatalf("trend %+v", trend)
+	}
+
+	values := []float64{11.9, 12.1, 13.5}
+	deltas := []float64{0, 0.2, 1.4}
+	for i, point := range trend.Points {
+		if point.Value != values[i] || point.Unit != "g/dL" {
+			t.Errorf("point %v: %+v", i, point)
+		}
+		if i == 0 && point.Delta != nil {
+			t.Errorf("delta of the first point %v", *point.Delta)
+		}
+		if i > 0 && (point.Delta == nil || *point.Delta != deltas[i]) {
+			t.Errorf("delta of point %v: %v", i, point.Delta)
+		}
+		if i > 0 && !trend.Points[i-1].Time.Before(point.Time) {
+			t.Errorf("points out of order at %v", i)
+		}
+	}
+	if percent := trend.Points[2].DeltaPercent; percent == nil || *percent != 11.6 {
+		t.Errorf("delta percent %v", percent)
+	}
+
+	trend, err = sc.analyteTrend(patient, "0001P", "creatinine")
+	if err != nil || len(trend.Points) != 1 || trend.Points[0].Unit != "mg/dL" {
+		t.Fatalf("creatinine trend %+v, %v", trend, err)
+	}
+
+	if _, err = sc.analyteTrend(specialist, "0001P", "hb"); err == nil {
+		t.Errorf("trend read by a doctor of another patient")
+	}
+}
@@ -0,0 +1,200 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"strings"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// access log entries are kept next to the patient data, in the same collection
+const accessLogObjectType = "accessLog"
+
+/// access log actions
+const (
+	accessRead = "read"
+	accessWrite = "write"
+)
+
+/// who accessed the patient data, with which function and when
+/// entries are only kept when the transaction is submitted, evaluated reads leave no trace
+type AccessLogEntry struct {
+	SchemaVersion int `json:"schemaVersion"`
+	PID string `json:"pid"`
+	Actor string `json:"actor"`
+	Role string `json:"role"`
+	Org string `json:"org"`
+	Function string `json:"function"`
+	Action string `json:"action"`
+	TxID string `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type AccessLog struct {
+	Data []AccessLogEntry `json:"data"`
+}
+
+func (ale *AccessLogEntry) objectType() string {
+	return accessLogObjectType
+}
+
+func (ale *AccessLogEntry) setSchemaVersion(version int) {
+	ale.SchemaVersion = version
+}
+
+/// record the access of the invoked client to the patient data
+func (s *SmartContract) recordAccess(ctx contractapi.TransactionContextInterface, assetData *PatientInfo, action string) error {
+	_, err := s.logAccess(ctx, assetData, action)
+	return err
+}
+
+/// write the access log entry of the invoked client and return it
+/// reads of the log in the same transaction do not see the entry yet
+func (s *SmartContract) logAccess(ctx contractapi.TransactionContextInterface, assetData *PatientInfo, action string) (*AccessLogEntry, error) {
+
+	collection, err := assetData.getMetaData()
+	if err != nil {
+		return nil, err
+	}
+
+	/// clients without the id attribute (admins) are logged by their identity
+	actor, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		actor, err = getInvokedClientIdentity(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	role, _ := s.GetIdentityAttribute(ctx, "role")
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	/// contract name prefix is dropped
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	if index := strings.LastIndex(function, ":"); index != -1 {
+		function = function[index+1:]
+	}
+
+	entry := AccessLogEntry{
+		PID: assetData.ID,
+		Actor: actor,
+		Role: strings.ToLower(role),
+		Org: org,
+		Function: function,
+		Action: action,
+		TxID: ctx.GetStub().GetTxID(),
+		Timestamp: timestamp,
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(accessLogObjectType, []string{entry.PID, entry.TxID, action})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	entryJSON, err := marshalStoredObject(&entry)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal access log entry: %v", err)
+	}
+
+	log.Printf("AccessLog Put: collection %v, ID %v, Key %v", collection, entry.PID, entryKey)
+	err = ctx.GetStub().PutPrivateData(collection, entryKey, entryJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put access log entry: %v", err)
+	}
+
+	return &entry, nil
+}
+
+/// access log of the invoked patient, oldest first
+func (s *SmartContract) GetAccessLog(ctx contractapi.TransactionContextInterface) (*AccessLog, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return nil, fmt.Errorf("Only Patient can read the access log")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get access log cannot be performed: Error %v", err)
+	}
+
+	entries, err := readAccessLog(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessLog{Data: entries}, nil
+}
+
+/// access log entries of the patient, oldest first
+func readAccessLog(ctx contractapi.TransactionContextInterface, id string) ([]AccessLogEntry, error) {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	/// shared patient data is logged in the shared collection
+	entries := []AccessLogEntry{}
+	for _, collection := range []string{orgCollectionName, org1AndOrg2PrivateCollection} {
+		collectionEntries, err := readCollectionAccessLog(ctx, collection, id)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, collectionEntries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	return entries, nil
+}
+
+/// access log entries of the patient kept in the collection
+func readCollectionAccessLog(ctx contractapi.TransactionContextInterface, collection string, id string) ([]AccessLogEntry, error) {
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, accessLogObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entries := []AccessLogEntry{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry AccessLogEntry
+		err = unmarshalStoredObject(response.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
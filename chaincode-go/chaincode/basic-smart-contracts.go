@@ -3,10 +3,14 @@ package chaincode
 import (
 	"fmt"
 	"log"
+	"time"
+	"crypto/ecdsa"
+	"crypto/sha256"
 	"encoding/json"
 	"encoding/base64"
 	"strings"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/google/uuid"
 )
@@ -53,6 +57,9 @@ func (s *SmartContract) RegisterPatient(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("Error cannot unmarshal: %v", err)
 	}
 
+	/// data key is set up with SetDataKey
+	assetData.DataKey = nil
+
 	/// add owner of patient data
 	err = addOwner(ctx, &assetData)
 	if err != nil {
@@ -102,7 +109,7 @@ func (s *SmartContract) RegisterPatient(ctx contractapi.TransactionContextInterf
 	}
 
 	// marshal the asset data 
-	assetPrivateData, err := json.Marshal(assetData)
+	assetPrivateData, err := marshalStoredObject(&assetData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -114,6 +121,23 @@ func (s *SmartContract) RegisterPatient(ctx contractapi.TransactionContextInterf
 	if err != nil {
 		return fmt.Errorf("failed to put asset private details: %v", err)
 	}
+
+	err = s.recordAccess(ctx, &assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+
+	/// probable duplicates are flagged for the admins, the registration goes on
+	_, err = indexPatient(ctx, &assetData)
+	if err != nil {
+		return err
+	}
+
+	/// certificate the data key of the patient is wrapped for
+	err = publishClientCertificate(ctx, assetData.ID)
+	if err != nil {
+		return err
+	}
 	
     return nil
 }
@@ -139,7 +163,7 @@ func (s *SmartContract) AppointDoctor(ctx contractapi.TransactionContextInterfac
 
 	/// check if the patient is registered (whethere data is present in the collection or not)
 	/// get patient info from the private data collection 
-	patientData, err := s.ReadAssetPrivateData(ctx, pid)
+	patientData, err := s.readAssetPrivateData(ctx, pid)
 	if err != nil {
 		return err
 	}
@@ -149,16 +173,24 @@ func (s *SmartContract) AppointDoctor(ctx contractapi.TransactionContextInterfac
 		return fmt.Errorf("Cannot appoint doctor: %v", err)
 	}
 
-	/// can also make it as array of doctor info pointers 
-	err = patientData.addDoctorInfo(id)
+	/// a doctor with referral access is given direct access to all the records
+	added, err := patientData.addDirectAccess(id)
 	if err != nil {
 		return err
 	}
 
-	/// update doctor PIDs 
-	err = s.updateDocInfo(ctx, doctorData.ID, pid)
+	/// data key wrapped for the appointed doctor
+	err = addWrappedKeyFromTransient(ctx, patientData, id)
 	if err != nil {
-		return fmt.Errorf("Error while adding patient id: %v", err)
+		return fmt.Errorf("Cannot appoint doctor: %v", err)
+	}
+
+	/// update doctor PIDs 
+	if added {
+		err = s.updateDocInfo(ctx, doctorData.ID, pid)
+		if err != nil {
+			return fmt.Errorf("Error while adding patient id: %v", err)
+		}
 	}
 
 	orgCollectionName, err := patientData.getMetaData()
@@ -166,7 +198,7 @@ func (s *SmartContract) AppointDoctor(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	assetPrivateData, err := json.Marshal(patientData)
+	assetPrivateData, err := marshalStoredObject(patientData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -178,6 +210,11 @@ func (s *SmartContract) AppointDoctor(ctx contractapi.TransactionContextInterfac
 		return fmt.Errorf("failed to put asset private details: %v", err)
 	}
 
+	err = s.recordAccess(ctx, patientData, accessWrite)
+	if err != nil {
+		return err
+	}
+
 
 	return nil
 }
@@ -227,25 +264,67 @@ func (s *SmartContract) AddMedicalRecord(ctx contractapi.TransactionContextInter
 		return fmt.Errorf("medical data not found in the transient map")
 	}
 
-	/// Medical info data 
+	/// Medical info data, the collected at time must be an RFC 3339 time
 	var medicalData MedicalInfo
 	err = json.Unmarshal(medicalDataJSON, &medicalData)
 	if err != nil {
 		return fmt.Errorf("Error cannot unmarshal: %v", err)
 	}
 
+	/// issue time of the transaction, checked against the collected at time of the doctor
+	err = stampMedicalRecord(ctx, &medicalData)
+	if err != nil {
+		return fmt.Errorf("Cannot add medical record: %v", err)
+	}
+
 	/// Check if the Patient is present in the private data collection of the invoked peer org
-	assetData, err := s.ReadAssetPrivateData(ctx, assetID)
+	assetData, err := s.readAssetPrivateData(ctx, assetID)
 	if err != nil {
 		return err
 	}
 
 	/// issued by 
 	medicalData.SetIssuedBy(id);
+	medicalData.SetLabOrder("", "")
+	medicalData.ID = ctx.GetStub().GetTxID()
+	/// only the patient can acknowledge the record, after it is added
+	medicalData.PatientAck = nil
+
+	err = medicalData.validate()
+	if err != nil {
+		return fmt.Errorf("Cannot add medical record: %v", err)
+	}
+
+	/// encrypted when the patient has a data key, signed by the doctor
+	err = protectMedicalRecord(ctx, assetData, &medicalData)
+	if err != nil {
+		return fmt.Errorf("Cannot add medical record: %v", err)
+	}
+
+	/// record key wrapped for the grantees with the record type in their scope,
+	/// a doctor with an access scope has no data key to encrypt the record with
+	if assetData.DataKey != nil {
+		if _, scoped := assetData.AccessScopes[id]; scoped {
+			return fmt.Errorf("Cannot add medical record: doctor with referral access cannot add records of an encrypted patient")
+		}
+
+		scopedKeys, err := getTransientKeys(ctx, "scoped_keys")
+		if err != nil {
+			return err
+		}
+
+		err = assetData.addScopedRecordKeys(&medicalData, scopedKeys)
+		if err != nil {
+			return fmt.Errorf("Cannot add medical record: %v", err)
+		}
+	}
 
 	/// if Patient is present in the private data collection 
 	/// then add the medical records 
-	assetData.addMedicalRecord(medicalData)
+	err = assetData.addMedicalRecord(medicalData)
+	if err != nil {
+		return err
+	}
 
 	/// get name of the collection stored in 
 	orgCollectionName, err := assetData.getMetaData()
@@ -253,7 +332,7 @@ func (s *SmartContract) AddMedicalRecord(ctx contractapi.TransactionContextInter
 		return err
 	}
 
-	assetPrivateData, err := json.Marshal(assetData)
+	assetPrivateData, err := marshalStoredObject(assetData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -265,6 +344,11 @@ func (s *SmartContract) AddMedicalRecord(ctx contractapi.TransactionContextInter
 		return fmt.Errorf("failed to put asset private details: %v", err)
 	}
 
+	err = s.recordAccess(ctx, assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+
 
 	return nil
 }
@@ -349,7 +433,7 @@ func (s *SmartContract) RegisterDoctor(ctx contractapi.TransactionContextInterfa
 	}
 
 	// marshal the doctor data 
-	doctorPrivateData, err := json.Marshal(doctorData)
+	doctorPrivateData, err := marshalStoredObject(&doctorData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -361,6 +445,12 @@ func (s *SmartContract) RegisterDoctor(ctx contractapi.TransactionContextInterfa
 		return fmt.Errorf("failed to put asset private details: %v", err)
 	}
 
+	/// certificate patients wrap their data key for
+	err = publishClientCertificate(ctx, doctorData.ID)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -384,7 +474,7 @@ func (s *SmartContract) updateDocInfo(ctx contractapi.TransactionContextInterfac
 	}
 
 	// marshal the doctor data 
-	doctorPrivateData, err := json.Marshal(doctorData)
+	doctorPrivateData, err := marshalStoredObject(doctorData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal asset data: %v", err)
 	}
@@ -419,7 +509,7 @@ func (s *SmartContract) GetPatientInfo(ctx contractapi.TransactionContextInterfa
 	}
 
 	/// get data 
-	patientInfo, err := s.ReadAssetPrivateData(ctx, id)
+	patientInfo, err := s.readAssetPrivateData(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get Patient info: %v", err)
 	}
@@ -447,7 +537,7 @@ func (s *SmartContract) GetDoctorInfo(ctx contractapi.TransactionContextInterfac
 	}
 
 	/// get data 
-	patientInfo, err := s.ReadAssetPrivateData(ctx, id)
+	patientInfo, err := s.readAssetPrivateData(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get Patient info: %v", err)
 	}
@@ -493,7 +583,7 @@ func (s *SmartContract) GetMedicalReports(ctx contractapi.TransactionContextInte
 	}
 
 	/// get data 
-	patientInfo, err := s.ReadAssetPrivateData(ctx, id)
+	patientInfo, err := s.readAssetPrivateData(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get Patient info: %v", err)
 	}
@@ -540,13 +630,19 @@ func (s *SmartContract) ReadPatientsData(ctx contractapi.TransactionContextInter
 	}
 
 	patientsData := []PatientMainInfo{}
-	for _, id := range patientIDs {
-		patientData, err  := s.ReadAssetPrivateData(ctx, id)
+	for _, pid := range patientIDs {
+		patientData, err  := s.readAssetPrivateData(ctx, pid)
 		if err != nil {
 			return nil, fmt.Errorf("Error while reading patient data: %v", err)
 		}
 		
+		err = s.recordAccess(ctx, patientData, accessRead)
+		if err != nil {
+			return nil, err
+		}
+
 		patientMainData := getPatientMainInfo(*patientData);
+		patientMainData.MedicalRecords = patientData.scopedMedicalRecords(id)
 
 		/// append patient data 
 		patientsData = append(patientsData, patientMainData)
@@ -589,18 +685,26 @@ func (s *SmartContract) ReadPatientData(ctx contractapi.TransactionContextInterf
 		return nil, fmt.Errorf("Cannot Read Patient Data of specified Patient id")
 	}
 
-	patientData, err := s.ReadAssetPrivateData(ctx, pid)
+	patientData, err := s.readAssetPrivateData(ctx, pid)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot get Patient Data: %v", err)
 	}
 
+	err = s.recordAccess(ctx, patientData, accessRead)
+	if err != nil {
+		return nil, err
+	}
+
 	patientMainData := getPatientMainInfo(*patientData);
+	patientMainData.MedicalRecords = patientData.scopedMedicalRecords(id)
 
 	return &patientMainData, nil
 }
 
 /// read asset data from the private collection of the organization
-func (s *SmartContract) ReadAssetPrivateData(ctx contractapi.TransactionContextInterface, assetID string) (*PatientInfo, error) {
+/// no access check and no access log entry, the callers check the access of the client
+/// patients are read through ReadPatientData, ReadPatientsData and GetPatientData
+func (s *SmartContract) readAssetPrivateData(ctx contractapi.TransactionContextInterface, assetID string) (*PatientInfo, error) {
 
 	/// get CollectionName
 	orgCollectionName, err := getOrgCollectionName(ctx)
@@ -614,7 +718,7 @@ func (s *SmartContract) ReadAssetPrivateData(ctx contractapi.TransactionContextI
 		return nil, fmt.Errorf("Cannot execute the smart contract: Error %v", err)
 	}
 
-	log.Printf("ReadAssetPrivateData: collection %v, ID %v", orgCollectionName, assetID)
+	log.Printf("readAssetPrivateData: collection %v, ID %v", orgCollectionName, assetID)
 	assetDataJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, assetID) //get the asset from chaincode state
 	if err != nil {
 		return nil, fmt.Errorf("failed to read asset: %v", err)
@@ -624,7 +728,15 @@ func (s *SmartContract) ReadAssetPrivateData(ctx contractapi.TransactionContextI
 	if assetDataJSON == nil {
 		log.Printf("%v does not exist in collection %v", assetID, orgCollectionName)
 		/// read data from the common private data collection
-		if assetData, errIn := s.ReadAssetData(ctx, assetID); errIn != nil {
+		if assetData, errIn := s.readAssetData(ctx, assetID); errIn != nil {
+			/// patients transferred to another org leave a forwarding pointer
+			if forward, errFw := readPatientForward(ctx, orgCollectionName, assetID); errFw == nil && forward != nil {
+				return nil, fmt.Errorf("Patient %v was transferred to %v", assetID, forward.DestinationOrg)
+			}
+			/// merged duplicates point to the surviving patient
+			if merge, errMg := readPatientMerge(ctx, orgCollectionName, assetID); errMg == nil && merge != nil {
+				return nil, fmt.Errorf("Patient %v was merged into %v", assetID, merge.SurvivorID)
+			}
 			return nil, fmt.Errorf("failed to read asset %v", assetID)
 		} else {
 			return assetData, nil
@@ -632,8 +744,8 @@ func (s *SmartContract) ReadAssetPrivateData(ctx contractapi.TransactionContextI
 	}
 
 
-	var assetData *PatientInfo
-	err = json.Unmarshal(assetDataJSON, &assetData)
+	assetData := &PatientInfo{}
+	err = unmarshalStoredObject(assetDataJSON, assetData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 	}
@@ -642,8 +754,8 @@ func (s *SmartContract) ReadAssetPrivateData(ctx contractapi.TransactionContextI
 	return assetData, nil
 }
 
-/// read asset data from the common private data collection 
-func (s *SmartContract) ReadAssetData(ctx contractapi.TransactionContextInterface, assetID string) (*PatientInfo, error) {
+/// read asset data from the common private data collection, no access check as readAssetPrivateData
+func (s *SmartContract) readAssetData(ctx contractapi.TransactionContextInterface, assetID string) (*PatientInfo, error) {
 
 	log.Printf("ReadAsset: collection %v, ID %v",  org1AndOrg2PrivateCollection, assetID)
 	assetDataJSON, err := ctx.GetStub().GetPrivateData(org1AndOrg2PrivateCollection, assetID) //get the asset from chaincode state
@@ -657,8 +769,8 @@ func (s *SmartContract) ReadAssetData(ctx contractapi.TransactionContextInterfac
 		return nil, fmt.Errorf("asset %v not found", assetID)
 	}
 
-	var assetData *PatientInfo
-	err = json.Unmarshal(assetDataJSON, &assetData)
+	assetData := &PatientInfo{}
+	err = unmarshalStoredObject(assetDataJSON, assetData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 	}
@@ -694,8 +806,8 @@ func (s *SmartContract) ReadDoctorPrivateData(ctx contractapi.TransactionContext
 		return nil, fmt.Errorf("%v does not exist in collection %v", doctorID, orgCollectionName)
 	}
 
-	var doctorData *DoctorInfo
-	err = json.Unmarshal(doctorDataJSON, &doctorData)
+	doctorData := &DoctorInfo{}
+	err = unmarshalStoredObject(doctorDataJSON, doctorData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 	}
@@ -745,11 +857,16 @@ func (s *SmartContract) GetPatientDataOrg(ctx contractapi.TransactionContextInte
 		var asset PatientInfo
 		/// check the data is Patient data 
 		if checkID(response.Key, "P"){
-			err = json.Unmarshal(response.Value, &asset)
+			err = unmarshalStoredObject(response.Value, &asset)
 			if err != nil {
 				return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 			}
 
+			err = s.recordAccess(ctx, &asset, accessRead)
+			if err != nil {
+				return nil, err
+			}
+
 			results = append(results, asset)
 		}
 	}
@@ -796,11 +913,16 @@ func (s *SmartContract) GetPatientData(ctx contractapi.TransactionContextInterfa
 		var asset PatientInfo
 		/// check the data is Patient data 
 		if checkID(response.Key, "P"){
-			err = json.Unmarshal(response.Value, &asset)
+			err = unmarshalStoredObject(response.Value, &asset)
 			if err != nil {
 				return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 			}
 
+			err = s.recordAccess(ctx, &asset, accessRead)
+			if err != nil {
+				return nil, err
+			}
+
 			results = append(results, asset)
 		}
 	}
@@ -812,6 +934,52 @@ func (s *SmartContract) GetPatientData(ctx contractapi.TransactionContextInterfa
 	return patients, nil
 }
 
+/// read all the patient data of the org collection and the shared collection
+/// patients shared with the other org are read once
+func getAllPatients(ctx contractapi.TransactionContextInterface) ([]PatientInfo, error) {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []PatientInfo{}
+	seen := map[string]bool{}
+
+	for _, collection := range []string{orgCollectionName, org1AndOrg2PrivateCollection} {
+		resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(collection, "", "")
+		if err != nil {
+			return nil, err
+		}
+
+		for resultsIterator.HasNext() {
+			response, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+
+			/// check the data is Patient data 
+			if !checkID(response.Key, "P") || seen[response.Key] {
+				continue
+			}
+
+			var asset PatientInfo
+			err = unmarshalStoredObject(response.Value, &asset)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+			}
+
+			seen[response.Key] = true
+			results = append(results, asset)
+		}
+		resultsIterator.Close()
+	}
+
+	return results, nil
+}
+
 /// get doctor data from private collection of the org
 func (s *SmartContract) GetDoctorDataOrg(ctx contractapi.TransactionContextInterface) (*Doctors, error) {
 
@@ -844,7 +1012,7 @@ func (s *SmartContract) GetDoctorDataOrg(ctx contractapi.TransactionContextInter
 		var asset DoctorInfo
 		/// check the data is Doctor data 
 		if checkID(response.Key, "D"){
-			err = json.Unmarshal(response.Value, &asset)
+			err = unmarshalStoredObject(response.Value, &asset)
 			if err != nil {
 				return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 			}
@@ -1026,6 +1194,54 @@ func getInvokedClientIdentity(ctx contractapi.TransactionContextInterface) (stri
 	return string(clientIDB64Decode), nil
 }
 
+/// get the transaction timestamp, same on every endorsing peer unlike the local clock
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to get transaction timestamp: %v", err)
+	}
+
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+/// verify the digital signature of the invoked client on the data
+/// the signature is the base64 encoded ASN.1 ECDSA signature of the sha256 hash of the data
+func verifyClientSignature(ctx contractapi.TransactionContextInterface, data []byte, digitalSignature string) error {
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("Failed to get client certificate: %v", err)
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("Not a ECDSA public key")
+	}
+
+	dSignBytes, err := base64.StdEncoding.DecodeString(digitalSignature)
+	if err != nil {
+		return fmt.Errorf("Cannot decode digital signature: %v", err)
+	}
+
+	hash := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(publicKey, hash[:], dSignBytes) {
+		return fmt.Errorf("Digital signature failed to verify")
+	}
+
+	return nil
+}
+
+/// verify the digital signature of the invoked client on the canonical form (RFC 8785) of the JSON data
+func verifyClientJSONSignature(ctx contractapi.TransactionContextInterface, data []byte, digitalSignature string) error {
+
+	canonicalJSON, err := canonical.Transform(data)
+	if err != nil {
+		return fmt.Errorf("Cannot canonicalize signed data: %v", err)
+	}
+
+	return verifyClientSignature(ctx, canonicalJSON, digitalSignature)
+}
+
 /// verify the client organization matches the peer organization
 /// only the client from same organization can invoke the smart contract on the peers 
 /// of same organization 
@@ -1047,6 +1263,55 @@ func verifyClientOrgMatchesPeerOrg(ctx contractapi.TransactionContextInterface)
 	return nil
 }
 
+/// rewrite the patient data into the collection named in its meta data
+func (s *SmartContract) putAssetData(ctx contractapi.TransactionContextInterface, assetData *PatientInfo) error {
+
+	orgCollectionName, err := assetData.getMetaData()
+	if err != nil {
+		return err
+	}
+
+	assetDataJSON, err := marshalStoredObject(assetData)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal asset data: %v", err)
+	}
+
+	log.Printf("Put: collection %v, ID %v", orgCollectionName, assetData.ID)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, assetData.ID, assetDataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put asset private details: %v", err)
+	}
+
+	err = s.recordAccess(ctx, assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/// rewrite the doctor data into the collection named in its meta data
+func (s *SmartContract) putDoctorData(ctx contractapi.TransactionContextInterface, doctorData *DoctorInfo) error {
+
+	orgCollectionName, err := doctorData.getMetaData()
+	if err != nil {
+		return err
+	}
+
+	doctorDataJSON, err := marshalStoredObject(doctorData)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal doctor data: %v", err)
+	}
+
+	log.Printf("Put: collection %v, ID %v", orgCollectionName, doctorData.ID)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, doctorData.ID, doctorDataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put doctor private details: %v", err)
+	}
+
+	return nil
+}
+
 /// add owner to the asset data 
 func addOwner(ctx contractapi.TransactionContextInterface, assetData *PatientInfo) error {
 
@@ -3,6 +3,8 @@ package chaincode
 import (
 	"fmt"
 	"strconv"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,7 @@ type ClientPersonalInfo struct {
 	State         string `json:"state"`
 	Country       string `json:"country"`
 	Type 		  string `json:"type"`
+	DateOfBirth   *Date  `json:"dateOfBirth,omitempty"`
 }
 
 type Date struct {
@@ -31,14 +34,50 @@ type MetaData struct {
 }
 
 type MedicalInfo struct {
+	ID string `json:"id,omitempty"`
 	Type string `json:"type"`
 	MReport map[string]string `json:"mReport"`
-	DateOfIssue  Date  `json:"dateOfIssue"`
+	IssuedAt time.Time `json:"issuedAt"`
+	/// day of issue of the records created before the issue time, kept for their signatures
+	DateOfIssue *Date `json:"dateOfIssue,omitempty"`
+	/// clinical time given by the doctor, the issue time is the transaction time
+	CollectedAt *time.Time `json:"collectedAt,omitempty"`
+	Owner string `json:"owner"`
+	IssuedBy string `json:"issuedBy"`
+	OrderedBy string `json:"orderedBy,omitempty"`
+	LabOrderID string `json:"labOrderId,omitempty"`
+	DoctorSign string `json:"doctorSign,omitempty"`
+	CertFingerprint string `json:"certFingerprint,omitempty"`
+	PatientAck *PatientAcknowledgement `json:"patientAck,omitempty"`
+	Encrypted bool `json:"encrypted,omitempty"`
+	KeyVersion int `json:"keyVersion,omitempty"`
+	RecordKey string `json:"recordKey,omitempty"`
+	/// salted sha256 of the plaintext report of an encrypted record, the doctor signs it
+	/// instead of the encrypted report so the signature survives the re-encryption on key rotation
+	ReportDigest string `json:"reportDigest,omitempty"`
+	/// salt of the digest, encrypted with the record key
+	ReportSalt string `json:"reportSalt,omitempty"`
+}
+
+/// content of the medical record signed by the issuing doctor
+/// records with a day of issue were signed with it instead of the issue time,
+/// records with a collected at time are signed with it since the issue time is set by the chaincode
+/// encrypted records with a report digest are signed with the digest instead of the encrypted report
+/// the owner is the patient the record was issued for, records moved by a merge keep the merged
+/// id as owner and the surviving patient lists it in its merged ids
+type MedicalRecordContent struct {
+	Type string `json:"type"`
+	MReport map[string]string `json:"mReport"`
+	ReportDigest string `json:"reportDigest,omitempty"`
+	IssuedAt string `json:"issuedAt,omitempty"`
+	DateOfIssue *Date `json:"dateOfIssue,omitempty"`
+	CollectedAt string `json:"collectedAt,omitempty"`
 	Owner string `json:"owner"`
 	IssuedBy string `json:"issuedBy"`
 }
 
 type DoctorInfo struct {
+	SchemaVersion int `json:"schemaVersion"`
 	Meta MetaData `json:"meta"`
 	ID string    `json:"did"`
 	PersonalInfo ClientPersonalInfo `json:"personalInfo"`
@@ -49,12 +88,18 @@ type DoctorInfo struct {
 
 
 type PatientInfo struct {
+	SchemaVersion int `json:"schemaVersion"`
 	Meta MetaData `json:"meta"`
 	ID  string  `json:"pid"`
 	PersonalInfo ClientPersonalInfo `json:"personalInfo"`
 	MedicalRecords []MedicalInfo  `json:"medicalRecords"`
 	TreatedBy  []string    `json:"doctorInfo"`
 	Owners  []string	`json:"owners"`	
+	AccessScopes map[string][]string `json:"accessScopes,omitempty"`
+	ResearchConsent bool `json:"researchConsent"`
+	DataKey *DataKey `json:"dataKey,omitempty"`
+	/// ids of the duplicate patients merged into this one, the owner of their moved records
+	MergedIDs []string `json:"mergedIds,omitempty"`
 }
 
 /*
@@ -75,20 +120,22 @@ func (cpi *ClientPersonalInfo) SetInfo(age int, firstName, lastName, gender, ema
 }
 
 func (cpi *ClientPersonalInfo) validate() error {
-	cpiMap := map[string]string {
-		"firstName": cpi.FirstName,
-		"lastName": cpi.LastName,
-		"gender": cpi.Gender,
-		"age": strconv.Itoa(cpi.Age), 
-		"email": cpi.Email,
-		"contactNumber": cpi.ContactNumber,
-		"city": cpi.City, 
-		"state": cpi.State, 
-		"country": cpi.Country,
-		"type": cpi.Type,
+	/// fields in a fixed order, the error is the same on every endorser
+	cpiFields := [][2]string {
+		{"firstName", cpi.FirstName},
+		{"lastName", cpi.LastName},
+		{"gender", cpi.Gender},
+		{"age", strconv.Itoa(cpi.Age)}, 
+		{"email", cpi.Email},
+		{"contactNumber", cpi.ContactNumber},
+		{"city", cpi.City}, 
+		{"state", cpi.State}, 
+		{"country", cpi.Country},
+		{"type", cpi.Type},
 	} 
 
-	for key, value := range cpiMap {
+	for _, field := range cpiFields {
+		key, value := field[0], field[1]
 		if len(value) == 0 {
 			return fmt.Errorf("%v field must be non-empty value", key)
 		}
@@ -99,6 +146,13 @@ func (cpi *ClientPersonalInfo) validate() error {
 			}
 		}
 	}
+
+	/// date of birth is optional, it is used to find duplicate patients
+	if cpi.DateOfBirth != nil {
+		if err := cpi.DateOfBirth.validate(); err != nil {
+			return fmt.Errorf("dateOfBirth field value is not valid: %v", err)
+		}
+	}
     
 	return nil
 
@@ -130,6 +184,14 @@ func (pi *PatientInfo) addMetaData(name string) error {
 	return nil
 }
 
+func (pi *PatientInfo) objectType() string {
+	return patientObjectType
+}
+
+func (pi *PatientInfo) setSchemaVersion(version int) {
+	pi.SchemaVersion = version
+}
+
 func (pi *PatientInfo) getMetaData() (string, error) {
 
 	if len(pi.Meta.CollectionName) == 0 { 
@@ -224,10 +286,9 @@ func (pi *PatientInfo) addMedicalRecord(medicalRecord MedicalInfo) error {
 /// check if the medical record already exists 
 func (pi *PatientInfo) checkMedicalRecordAlreadyExists(medicalRecord MedicalInfo) error {
 
-	/// checking based on the type of recrod 
-	/// TODO change to based on date later 
+	/// records of the same type issued at the same time are the same record
 	for _, value := range pi.MedicalRecords {
-		if value.Type == medicalRecord.Type {
+		if value.ID == medicalRecord.ID || (value.Type == medicalRecord.Type && value.issueTime().Equal(medicalRecord.issueTime())) {
 			return fmt.Errorf("Medical Record Already Exists in the Patient Data")
 		}
 	}
@@ -303,9 +364,71 @@ func (pi *PatientInfo) removeAccess(idVal string) error {
 
 	pi.TreatedBy = append(pi.TreatedBy[:index], pi.TreatedBy[index+1:]...)
 
+	/// scoped access ends with the access itself
+	delete(pi.AccessScopes, idVal)
+	/// the revoked client still holds the current key, rotate it
+	if pi.DataKey != nil {
+		pi.DataKey.revoke(idVal)
+	}
+
 	return nil
 }
 
+/// limit the record types a doctor can see (used for referrals)
+func (pi *PatientInfo) setAccessScope(doctorID string, recordTypes []string) {
+	if pi.AccessScopes == nil {
+		pi.AccessScopes = map[string][]string{}
+	}
+	pi.AccessScopes[doctorID] = recordTypes
+}
+
+/// doctors without a scope can read every record type
+func (pi *PatientInfo) inAccessScope(doctorID string, recordType string) bool {
+	scope, ok := pi.AccessScopes[doctorID]
+	if !ok {
+		return true
+	}
+
+	for _, scopedType := range scope {
+		if strings.EqualFold(recordType, scopedType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/// direct access of an appointed or granted doctor to all the records
+/// a doctor with referral access keeps the patient and loses the scope and the record keys,
+/// returns false when the doctor already had the patient
+func (pi *PatientInfo) addDirectAccess(doctorID string) (bool, error) {
+	if _, scoped := pi.AccessScopes[doctorID]; scoped {
+		delete(pi.AccessScopes, doctorID)
+		if pi.DataKey != nil {
+			delete(pi.DataKey.RecordKeys, doctorID)
+		}
+		return false, nil
+	}
+
+	return true, pi.addDoctorInfo(doctorID)
+}
+
+/// medical records visible to the doctor, doctors without a scope see all the records
+func (pi *PatientInfo) scopedMedicalRecords(doctorID string) []MedicalInfo {
+	if _, ok := pi.AccessScopes[doctorID]; !ok {
+		return pi.MedicalRecords
+	}
+
+	records := []MedicalInfo{}
+	for _, record := range pi.MedicalRecords {
+		if pi.inAccessScope(doctorID, record.Type) {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
 
 
 /**
@@ -330,6 +453,14 @@ func (di *DoctorInfo) addMetaData(name string) error {
 	return nil
 }
 
+func (di *DoctorInfo) objectType() string {
+	return doctorObjectType
+}
+
+func (di *DoctorInfo) setSchemaVersion(version int) {
+	di.SchemaVersion = version
+}
+
 func (di *DoctorInfo) getMetaData() (string, error) {
 
 	if len(di.Meta.CollectionName) == 0 { 
@@ -446,10 +577,10 @@ func (di *DoctorInfo) getDID() (string, error) {
 * MedicalInfo
 */ 
 
-func (mr *MedicalInfo) SetInfo(reportType string, report map[string]string, dateOfIssue Date, owner string, issuedBy string) {
+func (mr *MedicalInfo) SetInfo(reportType string, report map[string]string, issuedAt time.Time, owner string, issuedBy string) {
 	mr.Type = reportType
 	mr.MReport = report 
-	mr.DateOfIssue = dateOfIssue
+	mr.IssuedAt = issuedAt
 	mr.Owner = owner
 	mr.IssuedBy = issuedBy
 }
@@ -459,6 +590,59 @@ func (mr *MedicalInfo) SetIssuedBy(id string) {
 	mr.IssuedBy = id;
 }
 
+/// bytes of the record content the issuing doctor signs
+func (mr *MedicalInfo) signedContent() ([]byte, error) {
+	content := MedicalRecordContent{
+		Type: mr.Type,
+		MReport: mr.MReport,
+		DateOfIssue: mr.DateOfIssue,
+		Owner: mr.Owner,
+		IssuedBy: mr.IssuedBy,
+	}
+	if mr.DateOfIssue == nil && mr.CollectedAt != nil {
+		content.CollectedAt = mr.CollectedAt.Format(time.RFC3339Nano)
+	} else if mr.DateOfIssue == nil {
+		content.IssuedAt = mr.IssuedAt.Format(time.RFC3339Nano)
+	}
+	if len(mr.ReportDigest) != 0 {
+		content.MReport = nil
+		content.ReportDigest = mr.ReportDigest
+	}
+	return canonical.Marshal(content)
+}
+
+/// time the record was issued, records with only a day of issue were issued at its start (UTC)
+func (mr *MedicalInfo) issueTime() time.Time {
+	if !mr.IssuedAt.IsZero() || mr.DateOfIssue == nil {
+		return mr.IssuedAt
+	}
+	return mr.DateOfIssue.startTime()
+}
+
+/// time the record data was collected at, the issue time when the doctor did not give it
+func (mr *MedicalInfo) clinicalTime() time.Time {
+	if mr.CollectedAt != nil {
+		return *mr.CollectedAt
+	}
+	return mr.issueTime()
+}
+
+/// find medical record of the patient by record id
+func (pi *PatientInfo) getMedicalRecord(recordID string) (*MedicalInfo, error) {
+	for i := range pi.MedicalRecords {
+		if pi.MedicalRecords[i].ID == recordID {
+			return &pi.MedicalRecords[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Medical record %v not found", recordID)
+}
+
+/// lab results are issued by the lab against the order of a doctor
+func (mr *MedicalInfo) SetLabOrder(orderID string, orderedBy string) {
+	mr.LabOrderID = orderID
+	mr.OrderedBy = orderedBy
+}
+
 /// validate all the medical records of the Patient 
 /// validation of medical records means no empty fields 
 func validateMedicalRecords(medicalRecords []MedicalInfo) error {
@@ -485,8 +669,12 @@ func (mr *MedicalInfo) validate() error {
 		}
 	}
 
-	if err := mr.DateOfIssue.validate(); err != nil {
-		return err
+	if mr.DateOfIssue != nil {
+		if err := mr.DateOfIssue.validate(); err != nil {
+			return err
+		}
+	} else if mr.IssuedAt.IsZero() {
+		return fmt.Errorf("issuedAt field must be non-empty value")
 	}
 	
 	if len(mr.Owner) == 0 {
@@ -528,9 +716,20 @@ func (d *Date) validate() error {
 		return fmt.Errorf("Year field value is not valid")
 	}
 
+	/// the day must exist in the month of the year (31 February does not)
+	date := d.startTime()
+	if date.Year() != d.Year || date.Month() != d.Month || date.Day() != d.Day {
+		return fmt.Errorf("Date %04d-%02d-%02d is not a calendar date", d.Year, int(d.Month), d.Day)
+	}
+
 	return nil
 }
 
+/// start of the day in UTC
+func (d *Date) startTime() time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)
+}
+
 
 /// util structs 
 type PatientMainInfo struct {
@@ -0,0 +1,407 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"encoding/asn1"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// client id -> certificate fingerprint, used to find the public key
+/// a data key is wrapped for
+const clientCertificateObjectType = "clientCertificate"
+
+/// extension the Fabric CA stores the enrollment attributes (role, id) in
+var attributeExtensionOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+/// per patient data key, the key itself never reaches the chaincode,
+/// only copies wrapped for the public key of each authorized reader
+type DataKey struct {
+	Version int `json:"version"`
+	WrappedKeys map[string]string `json:"wrappedKeys"`
+	RotationRequired bool `json:"rotationRequired,omitempty"`
+	Rotation *KeyRotation `json:"rotation,omitempty"`
+	/// grantees with an access scope (referred specialists) never get the data key,
+	/// only the record keys of the records in their scope: client id -> record id -> wrapped record key
+	RecordKeys map[string]map[string]string `json:"recordKeys,omitempty"`
+}
+
+/// wrapped data key of a single reader
+type WrappedDataKey struct {
+	PID string `json:"pid"`
+	Version int `json:"version"`
+	WrappedKey string `json:"wrappedKey"`
+	PendingVersion int `json:"pendingVersion,omitempty"`
+	PendingWrappedKey string `json:"pendingWrappedKey,omitempty"`
+	/// a client lost access (or the patient was transferred) since the key was set
+	RotationRequired bool `json:"rotationRequired,omitempty"`
+	/// record keys of a client with an access scope, by record id, in place of the data key
+	RecordKeys map[string]string `json:"recordKeys,omitempty"`
+	/// access scopes of the grantees, their record keys are wrapped for them with each new record
+	AccessScopes map[string][]string `json:"accessScopes,omitempty"`
+}
+
+func (dk *DataKey) addWrappedKey(clientID string, wrappedKey string) error {
+	if len(clientID) == 0 || len(wrappedKey) == 0 {
+		return fmt.Errorf("Client id and wrapped key are required")
+	}
+	if dk.WrappedKeys == nil {
+		dk.WrappedKeys = map[string]string{}
+	}
+	dk.WrappedKeys[clientID] = wrappedKey
+	return nil
+}
+
+func (dk *DataKey) getWrappedKey(clientID string) (string, error) {
+	wrappedKey, ok := dk.WrappedKeys[clientID]
+	if !ok {
+		return "", fmt.Errorf("No data key wrapped for %v", clientID)
+	}
+	return wrappedKey, nil
+}
+
+/// medical record must be encrypted with the current data key of the patient
+func (dk *DataKey) checkEncrypted(medicalData *MedicalInfo) error {
+	if !medicalData.Encrypted {
+		return fmt.Errorf("Medical record must be encrypted with the patient data key")
+	}
+	if medicalData.KeyVersion != dk.Version {
+		return fmt.Errorf("Medical record is encrypted with data key version %v, current version is %v", medicalData.KeyVersion, dk.Version)
+	}
+	if len(medicalData.RecordKey) == 0 {
+		return fmt.Errorf("Record key not found in the medical record")
+	}
+	/// the signature must not cover the encrypted report, it changes on key rotation
+	if len(medicalData.ReportDigest) == 0 || len(medicalData.ReportSalt) == 0 {
+		return fmt.Errorf("Report digest not found in the medical record")
+	}
+	return nil
+}
+
+/// read the wrapped key passed in the transient map
+func getTransientWrappedKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	wrappedKey, ok := transientMap["wrapped_key"]
+	if !ok || len(wrappedKey) == 0 {
+		return "", fmt.Errorf("wrapped key not found in the transient map")
+	}
+
+	return string(wrappedKey), nil
+}
+
+/// give the client a copy of the patient data key, when the patient has one
+/// the copy is wrapped by the patient application and passed in the transient map
+func addWrappedKeyFromTransient(ctx contractapi.TransactionContextInterface, assetData *PatientInfo, clientID string) error {
+	if assetData.DataKey == nil {
+		return nil
+	}
+
+	/// the key of the new version is wrapped when the rotation starts
+	if assetData.DataKey.Rotation != nil {
+		return fmt.Errorf("Data key rotation in progress, complete the rotation first")
+	}
+
+	wrappedKey, err := getTransientWrappedKey(ctx)
+	if err != nil {
+		return fmt.Errorf("Patient data is encrypted: %v", err)
+	}
+
+	return assetData.DataKey.addWrappedKey(clientID, wrappedKey)
+}
+
+/// client id -> key map passed in the transient map, empty when not passed
+func getTransientKeys(ctx contractapi.TransactionContextInterface, name string) (map[string]string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	keys := map[string]string{}
+	keysJSON, ok := transientMap[name]
+	if !ok {
+		return keys, nil
+	}
+
+	err = json.Unmarshal(keysJSON, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("Error cannot unmarshal %v: %v", name, err)
+	}
+
+	return keys, nil
+}
+
+/// give the scoped grantee the record keys of the encrypted records in the scope,
+/// record id -> record key wrapped for the grantee, no more and no less
+func (pi *PatientInfo) setScopedRecordKeys(clientID string, recordKeys map[string]string) error {
+
+	scopedKeys := map[string]string{}
+	for _, record := range pi.scopedMedicalRecords(clientID) {
+		if !record.Encrypted {
+			continue
+		}
+		if len(recordKeys[record.ID]) == 0 {
+			return fmt.Errorf("Record key of %v not wrapped for %v", record.ID, clientID)
+		}
+		scopedKeys[record.ID] = recordKeys[record.ID]
+	}
+
+	if len(scopedKeys) != len(recordKeys) {
+		return fmt.Errorf("Record keys can only be wrapped for the encrypted records in the scope of %v", clientID)
+	}
+
+	if pi.DataKey.RecordKeys == nil {
+		pi.DataKey.RecordKeys = map[string]map[string]string{}
+	}
+	pi.DataKey.RecordKeys[clientID] = scopedKeys
+
+	return nil
+}
+
+/// set the record key of a new or re-encrypted record for the scoped grantees whose scope
+/// has the record type, client id -> record key wrapped for the client
+func (pi *PatientInfo) addScopedRecordKeys(medicalData *MedicalInfo, scopedKeys map[string]string) error {
+
+	readers := 0
+	for _, clientID := range pi.TreatedBy {
+		if _, scoped := pi.AccessScopes[clientID]; !scoped || !pi.inAccessScope(clientID, medicalData.Type) {
+			continue
+		}
+
+		if len(scopedKeys[clientID]) == 0 {
+			return fmt.Errorf("Record key of %v not wrapped for %v", medicalData.ID, clientID)
+		}
+
+		if pi.DataKey.RecordKeys == nil {
+			pi.DataKey.RecordKeys = map[string]map[string]string{}
+		}
+		if pi.DataKey.RecordKeys[clientID] == nil {
+			pi.DataKey.RecordKeys[clientID] = map[string]string{}
+		}
+		pi.DataKey.RecordKeys[clientID][medicalData.ID] = scopedKeys[clientID]
+		readers++
+	}
+
+	if readers != len(scopedKeys) {
+		return fmt.Errorf("Record key of %v can only be wrapped for the grantees with the record type in their scope", medicalData.ID)
+	}
+
+	return nil
+}
+
+/// store the certificate of the invoked client under the client id
+func publishClientCertificate(ctx contractapi.TransactionContextInterface, clientID string) error {
+
+	fingerprint, err := storeClientCertificate(ctx)
+	if err != nil {
+		return err
+	}
+
+	clientKey, err := ctx.GetStub().CreateCompositeKey(clientCertificateObjectType, []string{clientID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(clientKey, []byte(fingerprint))
+	if err != nil {
+		return fmt.Errorf("failed to put client certificate: %v", err)
+	}
+
+	return nil
+}
+
+/// publish the certificate of the invoked client, so that patients can
+/// wrap their data key for the client
+func (s *SmartContract) PublishCertificate(ctx contractapi.TransactionContextInterface) error {
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	return publishClientCertificate(ctx, id)
+}
+
+/// read the PEM encoded certificate published by the client
+func (s *SmartContract) ReadClientCertificate(ctx contractapi.TransactionContextInterface, clientID string) (string, error) {
+
+	clientKey, err := ctx.GetStub().CreateCompositeKey(clientCertificateObjectType, []string{clientID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	fingerprint, err := ctx.GetStub().GetState(clientKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read client certificate: %v", err)
+	}
+
+	if fingerprint == nil {
+		return "", fmt.Errorf("Certificate of %v not published", clientID)
+	}
+
+	certKey, err := ctx.GetStub().CreateCompositeKey(certificateObjectType, []string{string(fingerprint)})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	certPEM, err := ctx.GetStub().GetState(certKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	if certPEM == nil {
+		return "", fmt.Errorf("Certificate %v not found", string(fingerprint))
+	}
+
+	return string(certPEM), nil
+}
+
+/// role the CA enrolled the client with, read from the certificate the client published
+func publishedClientRole(ctx contractapi.TransactionContextInterface, clientID string) (string, error) {
+
+	clientKey, err := ctx.GetStub().CreateCompositeKey(clientCertificateObjectType, []string{clientID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	fingerprint, err := ctx.GetStub().GetState(clientKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read client certificate: %v", err)
+	}
+
+	if fingerprint == nil {
+		return "", fmt.Errorf("Certificate of %v not published", clientID)
+	}
+
+	cert, err := readCertificate(ctx, string(fingerprint))
+	if err != nil {
+		return "", err
+	}
+
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(attributeExtensionOID) {
+			continue
+		}
+
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		err = json.Unmarshal(extension.Value, &attrs)
+		if err != nil {
+			return "", fmt.Errorf("Cannot read attributes of %v: %v", clientID, err)
+		}
+
+		/// the certificate must be the one of the client id it was published under
+		if attrs.Attrs["id"] != clientID {
+			return "", fmt.Errorf("Certificate of %v was issued to %v", clientID, attrs.Attrs["id"])
+		}
+		return attrs.Attrs["role"], nil
+	}
+
+	return "", fmt.Errorf("Certificate of %v has no attributes", clientID)
+}
+
+/// patient sets up the data key for their records
+/// the key wrapped for the patient is passed in the transient map
+func (s *SmartContract) SetDataKey(ctx contractapi.TransactionContextInterface) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return fmt.Errorf("Only Patient can set data key")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Cannot get Patient info: %v", err)
+	}
+
+	if assetData.DataKey != nil {
+		return fmt.Errorf("Data key already set for %v", id)
+	}
+
+	wrappedKey, err := getTransientWrappedKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	dataKey := &DataKey{Version: 1}
+	err = dataKey.addWrappedKey(id, wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	assetData.DataKey = dataKey
+
+	err = publishClientCertificate(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.putAssetData(ctx, assetData)
+}
+
+/// read the data key of the patient wrapped for the invoked client
+/// version 0 is returned when the patient has not set a data key
+/// a client with an access scope gets the record keys of the records in the scope only
+func (s *SmartContract) ReadWrappedDataKey(ctx contractapi.TransactionContextInterface, pid string) (*WrappedDataKey, error) {
+
+	err := s.checkPatientReadAccess(ctx, pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read data key: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	/// version 0, the records of the patient are not encrypted
+	if assetData.DataKey == nil {
+		return &WrappedDataKey{PID: pid}, nil
+	}
+
+	if _, scoped := assetData.AccessScopes[id]; scoped {
+		return &WrappedDataKey{PID: pid, Version: assetData.DataKey.Version, RecordKeys: assetData.DataKey.RecordKeys[id]}, nil
+	}
+
+	wrappedKey, err := assetData.DataKey.getWrappedKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := &WrappedDataKey{
+		PID: pid,
+		Version: assetData.DataKey.Version,
+		WrappedKey: wrappedKey,
+		RotationRequired: assetData.DataKey.RotationRequired,
+		AccessScopes: assetData.AccessScopes,
+	}
+
+	/// key of the rotation in progress, lets the patient resume the rotation
+	if rotation := assetData.DataKey.Rotation; rotation != nil {
+		if pendingKey, ok := rotation.WrappedKeys[id]; ok {
+			dataKey.PendingVersion = rotation.Version
+			dataKey.PendingWrappedKey = pendingKey
+		}
+	}
+
+	return dataKey, nil
+}
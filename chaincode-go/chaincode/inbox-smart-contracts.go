@@ -0,0 +1,319 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"strconv"
+	"strings"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// read marker of an inbox item, kept per client in the org collection
+const inboxReadObjectType = "inboxRead"
+
+/// kinds of inbox items
+const (
+	inboxDataAccessRequest = "dataAccessRequest"
+	inboxRequestAgreement = "requestAgreement"
+	inboxReferral = "referral"
+	inboxRecordDispute = "recordDispute"
+)
+
+/// item awaiting the client
+/// the id changes when the source object is recreated, so a new request shows up unread
+type InboxItem struct {
+	ID string `json:"id"`
+	Kind string `json:"kind"`
+	Status string `json:"status"`
+	From string `json:"from"`
+	PID string `json:"pid"`
+	RefID string `json:"refId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Read bool `json:"read"`
+}
+
+/// read marker of an inbox item, older versions stored the read time only
+type inboxRead struct {
+	SchemaVersion int `json:"schemaVersion"`
+	ReadAt time.Time `json:"readAt"`
+}
+
+func (ir *inboxRead) objectType() string {
+	return inboxReadObjectType
+}
+
+func (ir *inboxRead) setSchemaVersion(version int) {
+	ir.SchemaVersion = version
+}
+
+type Inbox struct {
+	Unread int `json:"unread"`
+	Data []InboxItem `json:"data"`
+}
+
+func newInboxItem(kind, status, from, pid, refID string, createdAt time.Time) InboxItem {
+	return InboxItem{
+		ID: strings.Join([]string{kind, pid, refID, strconv.FormatInt(createdAt.UnixNano(), 10)}, ":"),
+		Kind: kind,
+		Status: status,
+		From: from,
+		PID: pid,
+		RefID: refID,
+		CreatedAt: createdAt,
+	}
+}
+
+/// status of a request the patient still has to validate or act on
+func requestStatus(valid bool) string {
+	if valid {
+		return "validated"
+	}
+	return "pending"
+}
+
+/// read the object stored under the patient id, false when there is none
+func readPatientObject(ctx contractapi.TransactionContextInterface, collection string, objectType string, pid string, object storedObject) (bool, error) {
+
+	objectKey, err := ctx.GetStub().CreateCompositeKey(objectType, []string{pid})
+	if err != nil {
+		return false, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	objectJSON, err := ctx.GetStub().GetPrivateData(collection, objectKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %v: %v", objectType, err)
+	}
+
+	if objectJSON == nil {
+		return false, nil
+	}
+
+	err = unmarshalStoredObject(objectJSON, object)
+	if err != nil {
+		return false, fmt.Errorf("Cannot unmarshal %v: %v", objectType, err)
+	}
+
+	return true, nil
+}
+
+/// access requests, share agreements, referrals and resolved disputes of the patient
+func (s *SmartContract) patientInboxItems(ctx contractapi.TransactionContextInterface, id string) ([]InboxItem, error) {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []InboxItem{}
+
+	/// expired requests cannot be validated anymore, they wait for the sweep
+	var request dataAccessRequest
+	found, err := readPatientObject(ctx, orgCollectionName, dataAccessRequestObjectType, id, &request)
+	if err != nil {
+		return nil, err
+	}
+	if found && !isRequestExpired(request.ExpiresAt, now) {
+		items = append(items, newInboxItem(inboxDataAccessRequest, requestStatus(request.Valid), request.MetaData.ClientID, id, "", request.CreatedAt))
+	}
+
+	var agreement requestAgreement
+	found, err = readPatientObject(ctx, org1AndOrg2PrivateCollection, requestAgreementObjectType, id, &agreement)
+	if err != nil {
+		return nil, err
+	}
+	if found && !isRequestExpired(agreement.ExpiresAt, now) {
+		items = append(items, newInboxItem(inboxRequestAgreement, requestStatus(agreement.Valid), agreement.MetaData.ClientID, id, agreement.HID, agreement.CreatedAt))
+	}
+
+	referrals, err := s.GetReferrals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, referralData := range referrals.Data {
+		if referralData.Status == referralPending {
+			items = append(items, newInboxItem(inboxReferral, referralData.Status, referralData.ReferredBy, id, referralData.ID, referralData.CreatedAt))
+		}
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Patient info: %v", err)
+	}
+	for _, record := range assetData.MedicalRecords {
+		if record.PatientAck != nil && record.PatientAck.Disputed && record.PatientAck.ReviewStatus == disputeResolved {
+			items = append(items, newInboxItem(inboxRecordDispute, disputeResolved, record.IssuedBy, id, record.ID, record.PatientAck.ResolvedAt))
+		}
+	}
+
+	return items, nil
+}
+
+/// referrals received and open disputes on the records issued by the doctor
+func (s *SmartContract) doctorInboxItems(ctx contractapi.TransactionContextInterface, id string) ([]InboxItem, error) {
+
+	items := []InboxItem{}
+
+	referrals, err := s.GetReferrals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, referralData := range referrals.Data {
+		if referralData.Specialist == id && referralData.Status == referralAccepted {
+			items = append(items, newInboxItem(inboxReferral, referralData.Status, referralData.ReferredBy, referralData.PID, referralData.ID, referralData.CreatedAt))
+		}
+	}
+
+	disputes, err := s.getOpenDisputes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, dispute := range disputes {
+		items = append(items, newInboxItem(inboxRecordDispute, disputeOpen, dispute.PID, dispute.PID, dispute.RecordID, dispute.DisputedAt))
+	}
+
+	return items, nil
+}
+
+/// inbox items of the invoked client, without the read markers
+func (s *SmartContract) readInboxItems(ctx contractapi.TransactionContextInterface) (string, []InboxItem, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return "", nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("Get inbox cannot be performed: Error %v", err)
+	}
+
+	var items []InboxItem
+	switch strings.ToLower(client) {
+	case "patient":
+		items, err = s.patientInboxItems(ctx, id)
+	case "doctor":
+		items, err = s.doctorInboxItems(ctx, id)
+	default:
+		return "", nil, fmt.Errorf("Only patient or doctor can check the inbox")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	return id, items, nil
+}
+
+func inboxReadKey(ctx contractapi.TransactionContextInterface, clientID string, itemID string) (string, error) {
+	readKey, err := ctx.GetStub().CreateCompositeKey(inboxReadObjectType, []string{clientID, itemID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return readKey, nil
+}
+
+/// everything awaiting the invoked client, newest first
+func (s *SmartContract) GetInbox(ctx contractapi.TransactionContextInterface) (*Inbox, error) {
+
+	id, items, err := s.readInboxItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := &Inbox{Data: items}
+	for i := range inbox.Data {
+		readKey, err := inboxReadKey(ctx, id, inbox.Data[i].ID)
+		if err != nil {
+			return nil, err
+		}
+
+		readJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, readKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inbox marker: %v", err)
+		}
+
+		if readJSON != nil {
+			var marker inboxRead
+			err = unmarshalStoredObject(readJSON, &marker)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot unmarshal inbox marker: %v", err)
+			}
+		}
+
+		inbox.Data[i].Read = readJSON != nil
+		if !inbox.Data[i].Read {
+			inbox.Unread++
+		}
+	}
+
+	sort.SliceStable(inbox.Data, func(i, j int) bool {
+		return inbox.Data[i].CreatedAt.After(inbox.Data[j].CreatedAt)
+	})
+
+	return inbox, nil
+}
+
+/// mark an item of the inbox of the invoked client as read
+func (s *SmartContract) MarkInboxRead(ctx contractapi.TransactionContextInterface, itemID string) error {
+
+	id, items, err := s.readInboxItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, item := range items {
+		if item.ID == itemID {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("Inbox item %v not found", itemID)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+
+	readKey, err := inboxReadKey(ctx, id, itemID)
+	if err != nil {
+		return err
+	}
+
+	readAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	readJSON, err := marshalStoredObject(&inboxRead{ReadAt: readAt})
+	if err != nil {
+		return fmt.Errorf("Cannot marshal inbox marker: %v", err)
+	}
+
+	log.Printf("MarkInboxRead Put: collection %v, ID %v, Key %v", orgCollectionName, id, readKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, readKey, readJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put inbox marker: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) getInbox(client *fabrictest.Identity) (*Inbox, error) {
+	var inbox *Inbox
+	err := sc.network.NewTransaction(client, "GetInbox").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		inbox, err = sc.contract.GetInbox(ctx)
+		return err
+	})
+	return inbox, err
+}
+
+func (sc *scenario) markInboxRead(client *fabrictest.Identity, itemID string) error {
+	return sc.submit(client, "MarkInboxRead", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.MarkInboxRead(ctx, itemID)
+	})
+}
+
+func (sc *scenario) referToSpecialist(doctor *fabrictest.Identity) string {
+	var referralID string
+	err := sc.submit(doctor, "ReferPatient", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		referralID, err = sc.contract.ReferPatient(ctx, "0001P", "0002D", "suspected anaemia", "CBC")
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("ReferPatient: %v", err)
+	}
+	return referralID
+}
+
+func (sc *scenario) acceptReferral(patient *fabrictest.Identity, referralID string) {
+	err := sc.submit(patient, "RespondToReferral", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RespondToReferral(ctx, referralID, "true")
+	})
+	if err != nil {
+		sc.t.Fatalf("RespondToReferral: %v", err)
+	}
+}
+
+/// record of the doctor disputed by the patient, returns the record id
+func (sc *scenario) disputeRecord(patient *fabrictest.Identity, doctor *fabrictest.Identity) string {
+	sc.addSignedMedicalRecord(doctor, "0001D", "0001P")
+	record := sc.readPatient(patient, "0001P").MedicalRecords[0]
+
+	contentJSON, err := canonical.Marshal(AcknowledgementContent{RecordID: record.ID, DoctorSign: record.DoctorSign, Disputed: true, Comment: "wrong patient"})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	patientSign, err := patient.Sign(contentJSON)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	err = sc.submit(patient, "DisputeMedicalRecord", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.DisputeMedicalRecord(ctx, record.ID, "wrong patient", patientSign)
+	})
+	if err != nil {
+		sc.t.Fatalf("DisputeMedicalRecord: %v", err)
+	}
+	return record.ID
+}
+
+func (sc *scenario) resolveDispute(doctor *fabrictest.Identity, recordID string) {
+	err := sc.submit(doctor, "ResolveRecordDispute", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.ResolveRecordDispute(ctx, "0001P", recordID, "corrected")
+	})
+	if err != nil {
+		sc.t.Fatalf("ResolveRecordDispute: %v", err)
+	}
+}
+
+/// share agreement of a doctor of org2 for the patient, as stored in the shared collection
+func (sc *scenario) putRequestAgreement(client *fabrictest.Identity, pid string, createdAt time.Time, expiresAt time.Time) {
+	err := sc.submit(client, "Put", func(ctx contractapi.TransactionContextInterface) error {
+		agreementKey, err := ctx.GetStub().CreateCompositeKey(requestAgreementObjectType, []string{pid})
+		if err != nil {
+			return err
+		}
+		agreement := &requestAgreement{
+			MetaData: metaData{Org: "org2", User: "doctor0003D", ClientID: "0003D"},
+			PID: pid,
+			HID: "hospital",
+			CreatedAt: createdAt,
+			ExpiresAt: expiresAt,
+		}
+		agreementJSON, err := marshalStoredObject(agreement)
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutPrivateData(org1AndOrg2PrivateCollection, agreementKey, agreementJSON)
+	})
+	if err != nil {
+		sc.t.Fatalf("Cannot put request agreement: %v", err)
+	}
+}
+
+func inboxItemsByKind(inbox *Inbox) map[string]InboxItem {
+	items := map[string]InboxItem{}
+	for _, item := range inbox.Data {
+		items[item.Kind] = item
+	}
+	return items
+}
+
+func TestPatientInbox(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+
+	if _, _, err := sc.createDataAccessRequest(specialist, "0002D", "0001P"); err != nil {
+		t.Fatalf("CreateDataAccessRequest: %v", err)
+	}
+	sc.putRequestAgreement(patient, "0001P", sc.network.Now(), sc.network.Now().AddDate(0, 0, 7))
+	referralID := sc.referToSpecialist(doctor)
+	recordID := sc.disputeRecord(patient, doctor)
+
+	/// an open dispute waits for the doctor, not the patient
+	inbox, err := sc.getInbox(patient)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if len(inbox.Data) != 3 || inbox.Unread != 3 {
+		t.Fatalf("inbox = %+v", inbox)
+	}
+
+	sc.resolveDispute(doctor, recordID)
+
+	inbox, err = sc.getInbox(patient)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	items := inboxItemsByKind(inbox)
+	if len(inbox.Data) != 4 || inbox.Unread != 4 || len(items) != 4 {
+		t.Fatalf("inbox = %+v", inbox)
+	}
+	if item := items[inboxDataAccessRequest]; item.From != "0002D" || item.Status != "pending" {
+		t.Errorf("data access request item = %+v", item)
+	}
+	if item := items[inboxRequestAgreement]; item.From != "0003D" || item.RefID != "hospital" {
+		t.Errorf("request agreement item = %+v", item)
+	}
+	if item := items[inboxReferral]; item.From != "0001D" || item.RefID != referralID || item.Status != referralPending {
+		t.Errorf("referral item = %+v", item)
+	}
+	if item := items[inboxRecordDispute]; item.From != "0001D" || item.RefID != recordID || item.Status != disputeResolved {
+		t.Errorf("record dispute item = %+v", item)
+	}
+
+	/// newest first
+	for i := 1; i < len(inbox.Data); i++ {
+		if inbox.Data[i].CreatedAt.After(inbox.Data[i-1].CreatedAt) {
+			t.Errorf("inbox not sorted newest first: %+v", inbox.Data)
+		}
+	}
+
+	if err := sc.markInboxRead(patient, items[inboxReferral].ID); err != nil {
+		t.Fatalf("MarkInboxRead: %v", err)
+	}
+	if err := sc.markInboxRead(patient, "referral:0001P:unknown:0"); err == nil {
+		t.Errorf("unknown inbox item marked read")
+	}
+
+	inbox, err = sc.getInbox(patient)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	items = inboxItemsByKind(inbox)
+	if inbox.Unread != 3 || !items[inboxReferral].Read || items[inboxDataAccessRequest].Read {
+		t.Errorf("inbox after marking the referral read = %+v", inbox)
+	}
+
+	/// answered referrals leave the inbox of the patient
+	sc.acceptReferral(patient, referralID)
+
+	inbox, err = sc.getInbox(patient)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if _, ok := inboxItemsByKind(inbox)[inboxReferral]; ok || len(inbox.Data) != 3 || inbox.Unread != 3 {
+		t.Errorf("inbox after accepting the referral = %+v", inbox)
+	}
+}
+
+func TestDoctorInbox(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+
+	referralID := sc.referToSpecialist(doctor)
+
+	/// the specialist sees the referral once the patient accepted it
+	inbox, err := sc.getInbox(specialist)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if len(inbox.Data) != 0 {
+		t.Fatalf("specialist inbox before acceptance = %+v", inbox)
+	}
+
+	sc.acceptReferral(patient, referralID)
+	recordID := sc.disputeRecord(patient, doctor)
+
+	inbox, err = sc.getInbox(specialist)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if len(inbox.Data) != 1 || inbox.Unread != 1 || inbox.Data[0].Kind != inboxReferral || inbox.Data[0].Status != referralAccepted || inbox.Data[0].From != "0001D" {
+		t.Fatalf("specialist inbox = %+v", inbox)
+	}
+	referralItem := inbox.Data[0]
+
+	/// the referring doctor gets the dispute on their record, not the referral
+	inbox, err = sc.getInbox(doctor)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if len(inbox.Data) != 1 || inbox.Data[0].Kind != inboxRecordDispute || inbox.Data[0].RefID != recordID || inbox.Data[0].Status != disputeOpen || inbox.Data[0].From != "0001P" {
+		t.Fatalf("doctor inbox = %+v", inbox)
+	}
+
+	/// read markers are kept per client
+	if err := sc.markInboxRead(doctor, referralItem.ID); err == nil {
+		t.Errorf("item of another inbox marked read")
+	}
+	if err := sc.markInboxRead(specialist, referralItem.ID); err != nil {
+		t.Fatalf("MarkInboxRead: %v", err)
+	}
+
+	inbox, err = sc.getInbox(specialist)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if inbox.Unread != 0 || !inbox.Data[0].Read {
+		t.Errorf("specialist inbox after marking read = %+v", inbox)
+	}
+
+	inbox, err = sc.getInbox(doctor)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if inbox.Unread != 1 {
+		t.Errorf("doctor inbox = %+v", inbox)
+	}
+
+	/// resolved disputes leave the inbox of the doctor
+	sc.resolveDispute(doctor, recordID)
+
+	inbox, err = sc.getInbox(doctor)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if len(inbox.Data) != 0 || inbox.Unread != 0 {
+		t.Errorf("doctor inbox after resolving = %+v", inbox)
+	}
+
+	pharmacy := sc.client("Org1MSP", "pharmacy", "0001H")
+	if _, err := sc.getInbox(pharmacy); err == nil {
+		t.Errorf("inbox read by a pharmacy")
+	}
+}
@@ -0,0 +1,331 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// records re-encrypted in a single transaction, keeps the transaction size bounded
+const maxRotationBatch = 50
+
+/// rotation of the patient data key in progress
+/// each record is re-encrypted with a new record key wrapped with the new data key
+type KeyRotation struct {
+	Version int `json:"version"`
+	WrappedKeys map[string]string `json:"wrappedKeys"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+/// progress of the data key rotation of the patient
+type KeyRotationStatus struct {
+	PID string `json:"pid"`
+	Version int `json:"version"`
+	RotationRequired bool `json:"rotationRequired"`
+	PendingVersion int `json:"pendingVersion,omitempty"`
+	RecordsRemaining int `json:"recordsRemaining"`
+	RecordsTotal int `json:"recordsTotal"`
+}
+
+/// remove the key of the revoked client, a rotation in progress
+/// must not give the client the new key either
+func (dk *DataKey) revoke(clientID string) {
+	delete(dk.WrappedKeys, clientID)
+	delete(dk.RecordKeys, clientID)
+	if dk.Rotation != nil {
+		delete(dk.Rotation.WrappedKeys, clientID)
+	}
+	dk.RotationRequired = true
+}
+
+/// encrypted records not yet re-encrypted for the new data key
+func (pi *PatientInfo) recordsToRotate() int {
+	count := 0
+	for _, record := range pi.MedicalRecords {
+		if record.Encrypted && record.KeyVersion != pi.DataKey.Rotation.Version {
+			count++
+		}
+	}
+	return count
+}
+
+/// new key must be wrapped for the patient and the current grantees, and only for them
+/// grantees with an access scope get the new record keys of their records instead
+func checkRotationReaders(assetData *PatientInfo, wrappedKeys map[string]string) error {
+
+	readers := []string{assetData.ID}
+	for _, clientID := range assetData.TreatedBy {
+		if _, scoped := assetData.AccessScopes[clientID]; !scoped {
+			readers = append(readers, clientID)
+		}
+	}
+
+	for _, reader := range readers {
+		if len(wrappedKeys[reader]) == 0 {
+			return fmt.Errorf("New data key not wrapped for %v", reader)
+		}
+	}
+
+	if len(wrappedKeys) != len(readers) {
+		return fmt.Errorf("New data key can only be wrapped for the patient and the current grantees")
+	}
+
+	return nil
+}
+
+/// read the patient data with the data key, for the key rotation contracts
+func (s *SmartContract) readRotationData(ctx contractapi.TransactionContextInterface) (*PatientInfo, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return nil, fmt.Errorf("Only Patient can rotate data key")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Data key rotation cannot be performed: Error %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Patient info: %v", err)
+	}
+
+	if assetData.DataKey == nil {
+		return nil, fmt.Errorf("Data key not set for %v", id)
+	}
+
+	return assetData, nil
+}
+
+/// start the rotation of the patient data key
+/// the new key wrapped for the patient and each current grantee is passed in the transient map
+func (s *SmartContract) StartKeyRotation(ctx contractapi.TransactionContextInterface) error {
+
+	assetData, err := s.readRotationData(ctx)
+	if err != nil {
+		return err
+	}
+
+	if assetData.DataKey.Rotation != nil {
+		return fmt.Errorf("Data key rotation to version %v already in progress", assetData.DataKey.Rotation.Version)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	wrappedKeysJSON, ok := transientMap["wrapped_keys"]
+	if !ok {
+		return fmt.Errorf("wrapped keys not found in the transient map")
+	}
+
+	var wrappedKeys map[string]string
+	err = json.Unmarshal(wrappedKeysJSON, &wrappedKeys)
+	if err != nil {
+		return fmt.Errorf("Error cannot unmarshal: %v", err)
+	}
+
+	err = checkRotationReaders(assetData, wrappedKeys)
+	if err != nil {
+		return err
+	}
+
+	startedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	assetData.DataKey.Rotation = &KeyRotation{
+		Version: assetData.DataKey.Version + 1,
+		WrappedKeys: wrappedKeys,
+		StartedAt: startedAt,
+	}
+
+	return s.putAssetData(ctx, assetData)
+}
+
+/// record re-encrypted with a new record key, wrapped with the new data key
+/// records signed before the report digest have no salt and keep their report
+/// the new record key is wrapped for the grantees with the record type in their scope
+type RotatedRecord struct {
+	MReport map[string]string `json:"mReport,omitempty"`
+	RecordKey string `json:"recordKey"`
+	ReportSalt string `json:"reportSalt,omitempty"`
+	ScopedKeys map[string]string `json:"scopedKeys,omitempty"`
+}
+
+/// re-encrypted report must have the fields of the signed one
+func sameReportFields(report map[string]string, rotated map[string]string) bool {
+	if len(report) != len(rotated) {
+		return false
+	}
+	for field := range report {
+		if _, ok := rotated[field]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+/// re-encrypt a batch of records with new record keys wrapped with the new data key,
+/// so the old data key and the old record keys held by revoked readers open nothing
+/// the records are passed in the transient map as record id -> rotated record
+func (s *SmartContract) RotateRecordKeys(ctx contractapi.TransactionContextInterface) (int, error) {
+
+	assetData, err := s.readRotationData(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rotation := assetData.DataKey.Rotation
+	if rotation == nil {
+		return 0, fmt.Errorf("No data key rotation in progress")
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return 0, fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	recordsJSON, ok := transientMap["rotated_records"]
+	if !ok {
+		return 0, fmt.Errorf("rotated records not found in the transient map")
+	}
+
+	var rotatedRecords map[string]RotatedRecord
+	err = json.Unmarshal(recordsJSON, &rotatedRecords)
+	if err != nil {
+		return 0, fmt.Errorf("Error cannot unmarshal: %v", err)
+	}
+
+	if len(rotatedRecords) == 0 || len(rotatedRecords) > maxRotationBatch {
+		return 0, fmt.Errorf("Batch must contain between 1 and %v records", maxRotationBatch)
+	}
+
+	for recordID, rotated := range rotatedRecords {
+		medicalData, err := assetData.getMedicalRecord(recordID)
+		if err != nil {
+			return 0, err
+		}
+
+		if !medicalData.Encrypted {
+			return 0, fmt.Errorf("Medical record %v is not encrypted", recordID)
+		}
+
+		if medicalData.KeyVersion == rotation.Version {
+			return 0, fmt.Errorf("Medical record %v is already rotated", recordID)
+		}
+
+		if len(rotated.RecordKey) == 0 {
+			return 0, fmt.Errorf("Record key of %v is empty", recordID)
+		}
+
+		if rotated.ScopedKeys == nil {
+			rotated.ScopedKeys = map[string]string{}
+		}
+		err = assetData.addScopedRecordKeys(medicalData, rotated.ScopedKeys)
+		if err != nil {
+			return 0, err
+		}
+
+		/// the signature of records without a digest covers the encrypted report,
+		/// only their record key can be rewrapped
+		if len(medicalData.ReportDigest) == 0 {
+			if rotated.MReport != nil || len(rotated.ReportSalt) != 0 {
+				return 0, fmt.Errorf("Medical record %v is signed over its encrypted report and cannot be re-encrypted", recordID)
+			}
+			medicalData.RecordKey = rotated.RecordKey
+			medicalData.KeyVersion = rotation.Version
+			continue
+		}
+
+		if !sameReportFields(medicalData.MReport, rotated.MReport) || len(rotated.ReportSalt) == 0 {
+			return 0, fmt.Errorf("Medical record %v must be re-encrypted with the same fields and its salt", recordID)
+		}
+
+		medicalData.MReport = rotated.MReport
+		medicalData.ReportSalt = rotated.ReportSalt
+		medicalData.RecordKey = rotated.RecordKey
+		medicalData.KeyVersion = rotation.Version
+	}
+
+	err = s.putAssetData(ctx, assetData)
+	if err != nil {
+		return 0, err
+	}
+
+	return assetData.recordsToRotate(), nil
+}
+
+/// complete the rotation once every record is re-encrypted, the new key replaces the old one
+func (s *SmartContract) CompleteKeyRotation(ctx contractapi.TransactionContextInterface) error {
+
+	assetData, err := s.readRotationData(ctx)
+	if err != nil {
+		return err
+	}
+
+	rotation := assetData.DataKey.Rotation
+	if rotation == nil {
+		return fmt.Errorf("No data key rotation in progress")
+	}
+
+	/// records added or grants changed while the rotation was running
+	if remaining := assetData.recordsToRotate(); remaining != 0 {
+		return fmt.Errorf("%v medical records are not rotated yet", remaining)
+	}
+
+	err = checkRotationReaders(assetData, rotation.WrappedKeys)
+	if err != nil {
+		return err
+	}
+
+	assetData.DataKey.Version = rotation.Version
+	assetData.DataKey.WrappedKeys = rotation.WrappedKeys
+	assetData.DataKey.Rotation = nil
+	assetData.DataKey.RotationRequired = false
+
+	return s.putAssetData(ctx, assetData)
+}
+
+/// progress of the data key rotation of the invoked patient
+func (s *SmartContract) GetKeyRotationStatus(ctx contractapi.TransactionContextInterface) (*KeyRotationStatus, error) {
+
+	assetData, err := s.readRotationData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &KeyRotationStatus{
+		PID: assetData.ID,
+		Version: assetData.DataKey.Version,
+		RotationRequired: assetData.DataKey.RotationRequired,
+	}
+
+	for _, record := range assetData.MedicalRecords {
+		if record.Encrypted {
+			status.RecordsTotal++
+		}
+	}
+
+	/// nothing remains to re-encrypt until a rotation is started
+	if assetData.DataKey.Rotation != nil {
+		status.PendingVersion = assetData.DataKey.Rotation.Version
+		status.RecordsRemaining = assetData.recordsToRotate()
+	}
+
+	return status, nil
+}
@@ -0,0 +1,157 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) submitWithTransient(client *fabrictest.Identity, function, key string, value interface{}, invoke func(ctx contractapi.TransactionContextInterface) error) error {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	return sc.network.NewTransaction(client, function).WithTransient(key, valueJSON).Submit(invoke)
+}
+
+func (sc *scenario) getKeyRotationStatus(patient *fabrictest.Identity) *KeyRotationStatus {
+	var status *KeyRotationStatus
+	err := sc.network.NewTransaction(patient, "GetKeyRotationStatus").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		status, err = sc.contract.GetKeyRotationStatus(ctx)
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("GetKeyRotationStatus: %v", err)
+	}
+	return status
+}
+
+func TestKeyRotationLocksOutRevokedReader(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+	sc.setDataKey(patient)
+
+	dataKey, err := fabrictest.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := map[string]string{"hb": "13.5", "wbc": "6.1"}
+	sealed, err := fabrictest.EncryptReport(dataKey, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collectedAt := sc.network.Now().Add(-time.Hour)
+	medicalData := MedicalInfo{Type: "CBC", MReport: sealed.MReport, CollectedAt: &collectedAt, Owner: "0001P", IssuedBy: "0001D",
+		Encrypted: true, KeyVersion: 1, RecordKey: sealed.RecordKey, ReportDigest: sealed.ReportDigest, ReportSalt: sealed.ReportSalt}
+	content, err := medicalData.signedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	medicalData.DoctorSign, err = doctor.SignJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sc.submitWithTransient(doctor, "AddMedicalRecord", "medical_data", medicalData, func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+	if err != nil {
+		t.Fatalf("AddMedicalRecord: %v", err)
+	}
+
+	/// the revoked doctor keeps the data key and the record key
+	revokedRecordKey, err := fabrictest.UnwrapRecordKey(dataKey, sealed.RecordKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sc.submit(patient, "RevokeAccess", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RevokeAccess(ctx, "0001D")
+	})
+	if err != nil {
+		t.Fatalf("RevokeAccess: %v", err)
+	}
+
+	/// nothing remains to rotate until the rotation is started
+	if status := sc.getKeyRotationStatus(patient); !status.RotationRequired || status.RecordsRemaining != 0 || status.RecordsTotal != 1 {
+		t.Errorf("status before the rotation = %+v", status)
+	}
+
+	err = sc.submitWithTransient(patient, "StartKeyRotation", "wrapped_keys", map[string]string{"0001P": "patient-wrapped-key-2"}, func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.StartKeyRotation(ctx)
+	})
+	if err != nil {
+		t.Fatalf("StartKeyRotation: %v", err)
+	}
+	if status := sc.getKeyRotationStatus(patient); status.PendingVersion != 2 || status.RecordsRemaining != 1 {
+		t.Errorf("status of the started rotation = %+v", status)
+	}
+
+	newDataKey, err := fabrictest.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := sc.readPatient(patient, "0001P").MedicalRecords[0]
+
+	/// rewrapping the record key alone leaves the report open to the old record key
+	rewrapOnly := map[string]RotatedRecord{stored.ID: {RecordKey: "rewrapped-record-key"}}
+	err = sc.submitWithTransient(patient, "RotateRecordKeys", "rotated_records", rewrapOnly, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.RotateRecordKeys(ctx)
+		return err
+	})
+	if err == nil {
+		t.Errorf("record rotated without re-encrypting its report")
+	}
+
+	rotated, err := fabrictest.ReencryptReport(dataKey, newDataKey, stored.RecordKey, stored.MReport, stored.ReportSalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := map[string]RotatedRecord{stored.ID: {MReport: rotated.MReport, RecordKey: rotated.RecordKey, ReportSalt: rotated.ReportSalt}}
+	var remaining int
+	err = sc.submitWithTransient(patient, "RotateRecordKeys", "rotated_records", batch, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		remaining, err = sc.contract.RotateRecordKeys(ctx)
+		return err
+	})
+	if err != nil || remaining != 0 {
+		t.Fatalf("RotateRecordKeys: %v remaining, %v", remaining, err)
+	}
+
+	err = sc.submit(patient, "CompleteKeyRotation", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CompleteKeyRotation(ctx)
+	})
+	if err != nil {
+		t.Fatalf("CompleteKeyRotation: %v", err)
+	}
+	if status := sc.getKeyRotationStatus(patient); status.Version != 2 || status.RotationRequired || status.RecordsRemaining != 0 || status.RecordsTotal != 1 {
+		t.Errorf("status of the completed rotation = %+v", status)
+	}
+
+	record := sc.readPatient(patient, "0001P").MedicalRecords[0]
+	if record.KeyVersion != 2 {
+		t.Errorf("record key version %v", record.KeyVersion)
+	}
+	if _, err := fabrictest.DecryptReport(dataKey, record.RecordKey, record.MReport); err == nil {
+		t.Errorf("old data key opens the rotated record")
+	}
+	if _, err := fabrictest.DecryptReportWithRecordKey(revokedRecordKey, record.MReport); err == nil {
+		t.Errorf("old record key opens the rotated record")
+	}
+	decrypted, err := fabrictest.DecryptReport(newDataKey, record.RecordKey, record.MReport)
+	if err != nil || decrypted["hb"] != "13.5" || decrypted["wbc"] != "6.1" {
+		t.Errorf("new data key: %v, %v", decrypted, err)
+	}
+
+	/// the doctor signed the digest of the plaintext, not the replaced ciphertext
+	var check *RecordSignatureCheck
+	err = sc.network.NewTransaction(patient, "VerifyMedicalRecordSignature", "0001P", record.ID).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		check, err = sc.contract.VerifyMedicalRecordSignature(ctx, "0001P", record.ID)
+		return err
+	})
+	if err != nil || !check.Valid {
+		t.Errorf("VerifyMedicalRecordSignature after rotation: %+v, %v", check, err)
+	}
+}
@@ -0,0 +1,489 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"strings"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const labOrderObjectType = "labOrder"
+
+/// lab order status values
+const (
+	labOrderOrdered = "ordered"
+	labOrderResulted = "resulted"
+)
+
+/// lab order placed by a treating doctor
+type labOrder struct {
+	SchemaVersion int `json:"schemaVersion"`
+	ID string `json:"id"`
+	PID string `json:"pid"`
+	OrderedBy string `json:"orderedBy"`
+	LabID string `json:"labId"`
+	RecordType string `json:"recordType"`
+	Status string `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	ResultedAt time.Time `json:"resultedAt"`
+	/// record key of the result of an encrypted patient, set by the ordering doctor
+	/// wrapped for the lab and wrapped with the data key of the version, the lab never
+	/// gets the data key and the record key opens the result only, removed once the result is posted
+	KeyVersion int `json:"keyVersion,omitempty"`
+	WrappedKey string `json:"wrappedKey,omitempty"`
+	RecordKey string `json:"recordKey,omitempty"`
+	/// record key wrapped for the grantees with the record type in their access scope
+	ScopedKeys map[string]string `json:"scopedKeys,omitempty"`
+}
+
+type LabOrders struct {
+	Data []labOrder `json:"data"`
+}
+
+func (lo *labOrder) objectType() string {
+	return labOrderObjectType
+}
+
+func (lo *labOrder) setSchemaVersion(version int) {
+	lo.SchemaVersion = version
+}
+
+func (lo *labOrder) assignData(id, pid, orderedBy, labID, recordType string, createdAt time.Time) error {
+	if len(id) == 0 || len(pid) == 0 {
+		return fmt.Errorf("Lab order id and patient id are required")
+	}
+
+	if len(orderedBy) == 0 || len(labID) == 0 {
+		return fmt.Errorf("Ordering doctor and lab are required")
+	}
+
+	if len(recordType) == 0 {
+		return fmt.Errorf("Record type is required")
+	}
+
+	lo.ID = id
+	lo.PID = pid
+	lo.OrderedBy = orderedBy
+	lo.LabID = labID
+	lo.RecordType = strings.ToUpper(recordType)
+	lo.Status = labOrderOrdered
+	lo.CreatedAt = createdAt
+
+	return nil
+}
+
+/// record key of the result passed by the ordering doctor in the transient map,
+/// wrapped for the lab, with the current data key of the patient and for the scoped grantees
+func (lo *labOrder) setResultKey(ctx contractapi.TransactionContextInterface, dataKey *DataKey) error {
+
+	if dataKey.Rotation != nil {
+		return fmt.Errorf("Data key rotation in progress, complete the rotation first")
+	}
+
+	wrappedKey, err := getTransientWrappedKey(ctx)
+	if err != nil {
+		return fmt.Errorf("Patient data is encrypted: %v", err)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	recordKey, ok := transientMap["record_key"]
+	if !ok || len(recordKey) == 0 {
+		return fmt.Errorf("Patient data is encrypted: record key not found in the transient map")
+	}
+
+	scopedKeys, err := getTransientKeys(ctx, "scoped_keys")
+	if err != nil {
+		return err
+	}
+
+	lo.KeyVersion = dataKey.Version
+	lo.WrappedKey = wrappedKey
+	lo.RecordKey = string(recordKey)
+	lo.ScopedKeys = scopedKeys
+
+	return nil
+}
+
+/// create lab order for a patient, only a treating doctor can order from a lab
+/// that published its certificate, the record key of the result of an encrypted
+/// patient is passed in the transient map (wrapped_key for the lab, record_key with the data key)
+func (s *SmartContract) CreateLabOrder(ctx contractapi.TransactionContextInterface, pid string, labID string, recordType string) (string, error) {
+
+	/// check if the client is doctor
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return "", fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return "", fmt.Errorf("Only Doctor can create lab order")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return "", fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	/// verify client org and peer org
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Create lab order cannot be performed: Error %v", err)
+	}
+
+	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("Cannot Read doctor data: %v", err)
+	}
+
+	if !doctorData.checkPIDExists(pid) {
+		return "", fmt.Errorf("Cannot create lab order for this patient")
+	}
+
+	createdAt, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	/// only clients enrolled as a lab can be given the order
+	role, err := publishedClientRole(ctx, labID)
+	if err != nil {
+		return "", fmt.Errorf("Cannot create lab order: %v", err)
+	}
+
+	if strings.ToLower(role) != "lab" {
+		return "", fmt.Errorf("%v is not a lab", labID)
+	}
+
+	var order labOrder
+	err = order.assignData(ctx.GetStub().GetTxID(), pid, id, labID, recordType, createdAt)
+	if err != nil {
+		return "", fmt.Errorf("Cannot create lab order: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	/// the lab encrypts the result with a record key the doctor generates for the order
+	if assetData.DataKey != nil {
+		err = order.setResultKey(ctx, assetData.DataKey)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = s.putLabOrder(ctx, &order)
+	if err != nil {
+		return "", err
+	}
+
+	return order.ID, nil
+}
+
+/// ordering doctor sets a new record key on an open order after the data key of the
+/// patient was rotated, the key of the order is wrapped with the data key it replaced
+func (s *SmartContract) RenewLabOrderKey(ctx contractapi.TransactionContextInterface, labID string, orderID string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return fmt.Errorf("Only Doctor can renew lab order key")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	order, err := s.readLabOrder(ctx, labID, orderID)
+	if err != nil {
+		return err
+	}
+
+	if order.OrderedBy != id {
+		return fmt.Errorf("Lab order %v was not placed by %v", orderID, id)
+	}
+
+	if order.Status != labOrderOrdered {
+		return fmt.Errorf("Lab order %v is already %v", orderID, order.Status)
+	}
+
+	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Cannot Read doctor data: %v", err)
+	}
+
+	if !doctorData.checkPIDExists(order.PID) {
+		return fmt.Errorf("Cannot renew lab order key for this patient")
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, order.PID)
+	if err != nil {
+		return fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	if assetData.DataKey == nil {
+		return fmt.Errorf("Patient %v has no data key", order.PID)
+	}
+
+	err = order.setResultKey(ctx, assetData.DataKey)
+	if err != nil {
+		return err
+	}
+
+	return s.putLabOrder(ctx, order)
+}
+
+/// list lab orders of the client
+/// lab gets the orders placed with it, doctor gets the orders they placed
+func (s *SmartContract) GetLabOrders(ctx contractapi.TransactionContextInterface) (*LabOrders, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get lab orders cannot be performed: Error %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	switch strings.ToLower(client) {
+	case "lab":
+		keys = []string{id}
+	case "doctor":
+		keys = []string{}
+	default:
+		return nil, fmt.Errorf("Only lab or doctor can check lab orders")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(orgCollectionName, labOrderObjectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results := []labOrder{}
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var order labOrder
+		err = unmarshalStoredObject(response.Value, &order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		if strings.ToLower(client) == "doctor" && order.OrderedBy != id {
+			continue
+		}
+
+		results = append(results, order)
+	}
+
+	return &LabOrders{Data: results}, nil
+}
+
+/// lab posts the result of an order, the report values are passed in the transient map
+/// the medical record is issued by the lab and keeps the ordering doctor, the lab signs it
+/// and encrypts it with the record key of the order when the patient has a data key
+func (s *SmartContract) PostLabResult(ctx contractapi.TransactionContextInterface, orderID string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "lab" {
+		return fmt.Errorf("Only Lab can post lab results")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	order, err := s.readLabOrder(ctx, id, orderID)
+	if err != nil {
+		return err
+	}
+
+	if order.Status != labOrderOrdered {
+		return fmt.Errorf("Lab order %v is already %v", orderID, order.Status)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	medicalDataJSON, ok := transientMap["medical_data"]
+	if !ok {
+		return fmt.Errorf("medical data not found in the transient map")
+	}
+
+	var labInput MedicalInfo
+	err = json.Unmarshal(medicalDataJSON, &labInput)
+	if err != nil {
+		return fmt.Errorf("Error cannot unmarshal: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, order.PID)
+	if err != nil {
+		return err
+	}
+
+	/// the record key of the order is wrapped with the data key of its version, the data key
+	/// was rotated (or set) after the order was placed when the version is not the current one
+	if assetData.DataKey != nil && (len(order.RecordKey) == 0 || order.KeyVersion != assetData.DataKey.Version) {
+		return fmt.Errorf("Lab order %v has no record key for data key version %v, the ordering doctor must renew it", orderID, assetData.DataKey.Version)
+	}
+
+	/// the result must be encrypted with the record key of the order
+	if labInput.Encrypted && labInput.RecordKey != order.RecordKey {
+		return fmt.Errorf("Lab result must be encrypted with the record key of the order")
+	}
+
+	/// type, owner and issuer come from the order, the report, its encryption,
+	/// the collected at time and the signature of the lab from the lab input
+	var medicalData MedicalInfo
+	medicalData.SetInfo(order.RecordType, labInput.MReport, time.Time{}, order.PID, id)
+	medicalData.CollectedAt = labInput.CollectedAt
+	medicalData.Encrypted = labInput.Encrypted
+	medicalData.KeyVersion = labInput.KeyVersion
+	medicalData.RecordKey = labInput.RecordKey
+	medicalData.ReportDigest = labInput.ReportDigest
+	medicalData.ReportSalt = labInput.ReportSalt
+	medicalData.DoctorSign = labInput.DoctorSign
+	medicalData.SetLabOrder(order.ID, order.OrderedBy)
+	medicalData.ID = ctx.GetStub().GetTxID()
+
+	err = stampMedicalRecord(ctx, &medicalData)
+	if err != nil {
+		return fmt.Errorf("Cannot post lab result: %v", err)
+	}
+	resultedAt := medicalData.IssuedAt
+
+	err = medicalData.validate()
+	if err != nil {
+		return fmt.Errorf("Cannot post lab result: %v", err)
+	}
+
+	/// encrypted like the records of the doctors, signed by the lab
+	err = protectMedicalRecord(ctx, assetData, &medicalData)
+	if err != nil {
+		return fmt.Errorf("Cannot post lab result: %v", err)
+	}
+
+	/// the scopes changed since the order when the keys do not match them
+	if assetData.DataKey != nil {
+		err = assetData.addScopedRecordKeys(&medicalData, order.ScopedKeys)
+		if err != nil {
+			return fmt.Errorf("Cannot post lab result, the ordering doctor must renew the order key: %v", err)
+		}
+	}
+
+	err = assetData.addMedicalRecord(medicalData)
+	if err != nil {
+		return fmt.Errorf("Cannot post lab result: %v", err)
+	}
+
+	err = s.putAssetData(ctx, assetData)
+	if err != nil {
+		return err
+	}
+
+	order.Status = labOrderResulted
+	order.ResultedAt = resultedAt
+	order.WrappedKey = ""
+	order.RecordKey = ""
+	order.ScopedKeys = nil
+
+	return s.putLabOrder(ctx, order)
+}
+
+func (s *SmartContract) readLabOrder(ctx contractapi.TransactionContextInterface, labID string, orderID string) (*labOrder, error) {
+
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Read lab order cannot be performed: Error %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orderKey, err := ctx.GetStub().CreateCompositeKey(labOrderObjectType, []string{labID, orderID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("ReadLabOrder: collection %v, ID %v", orgCollectionName, orderID)
+	orderJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, orderKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lab order: %v", err)
+	}
+
+	if orderJSON == nil {
+		return nil, fmt.Errorf("Lab order %v does not exist for lab %v", orderID, labID)
+	}
+
+	var order labOrder
+	err = unmarshalStoredObject(orderJSON, &order)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal lab order: %v", err)
+	}
+
+	return &order, nil
+}
+
+func (s *SmartContract) putLabOrder(ctx contractapi.TransactionContextInterface, order *labOrder) error {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+
+	orderKey, err := ctx.GetStub().CreateCompositeKey(labOrderObjectType, []string{order.LabID, order.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	orderJSON, err := marshalStoredObject(order)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal lab order: %v", err)
+	}
+
+	log.Printf("LabOrder Put: collection %v, ID %v, Key %v", orgCollectionName, order.ID, orderKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, orderKey, orderJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put lab order: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package chaincode
+
+import (
+	"time"
+	"strings"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// data key of the patient, the wrapped keys are opaque to the chaincode
+func (sc *scenario) setDataKey(patient *fabrictest.Identity) {
+	err := sc.network.NewTransaction(patient, "SetDataKey").WithTransient("wrapped_key", []byte("patient-wrapped-key")).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.SetDataKey(ctx)
+	})
+	if err != nil {
+		sc.t.Fatalf("SetDataKey: %v", err)
+	}
+}
+
+func (sc *scenario) postLabResult(lab *fabrictest.Identity, orderID string, medicalData MedicalInfo) error {
+	medicalDataJSON, err := json.Marshal(medicalData)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	tx := sc.network.NewTransaction(lab, "PostLabResult", orderID).WithTransient("medical_data", medicalDataJSON)
+	return tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.PostLabResult(ctx, orderID)
+	})
+}
+
+func (sc *scenario) createLabOrder(doctor *fabrictest.Identity, labID string, transient map[string]string) (string, error) {
+	tx := sc.network.NewTransaction(doctor, "CreateLabOrder", "0001P", labID, "CBC")
+	for key, value := range transient {
+		tx = tx.WithTransient(key, []byte(value))
+	}
+
+	var orderID string
+	err := tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		orderID, err = sc.contract.CreateLabOrder(ctx, "0001P", labID, "CBC")
+		return err
+	})
+	return orderID, err
+}
+
+func (sc *scenario) publishCertificate(client *fabrictest.Identity) {
+	err := sc.submit(client, "PublishCertificate", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.PublishCertificate(ctx)
+	})
+	if err != nil {
+		sc.t.Fatalf("PublishCertificate: %v", err)
+	}
+}
+
+func TestLabResultEncryptedAndSigned(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+	lab := sc.client("Org1MSP", "lab", "0001L")
+	sc.setDataKey(patient)
+
+	/// the lab is given the order once it published the certificate it was enrolled with
+	resultKey := map[string]string{"wrapped_key": "lab-wrapped-record-key", "record_key": "record-key"}
+	if _, err := sc.createLabOrder(doctor, "0001L", resultKey); err == nil {
+		t.Errorf("lab order created for a lab without a published certificate")
+	}
+	sc.publishCertificate(lab)
+	sc.publishCertificate(specialist)
+	if _, err := sc.createLabOrder(doctor, "0002D", resultKey); err == nil || !strings.Contains(err.Error(), "not a lab") {
+		t.Errorf("lab order created for a doctor: %v", err)
+	}
+
+	/// the lab cannot encrypt the result without the record key
+	if _, err := sc.createLabOrder(doctor, "0001L", map[string]string{"wrapped_key": "lab-wrapped-record-key"}); err == nil {
+		t.Errorf("lab order of an encrypted patient created without a record key")
+	}
+
+	orderID, err := sc.createLabOrder(doctor, "0001L", resultKey)
+	if err != nil {
+		t.Fatalf("CreateLabOrder: %v", err)
+	}
+
+	collectedAt := sc.network.Now().Add(-time.Hour)
+	result := MedicalInfo{Type: "CBC", MReport: map[string]string{"hb": "13.5"}, CollectedAt: &collectedAt, Owner: "0001P", IssuedBy: "0001L"}
+	content, err := result.signedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.DoctorSign, err = lab.SignJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sc.postLabResult(lab, orderID, result)
+	if err == nil || !strings.Contains(err.Error(), "must be encrypted") {
+		t.Errorf("plaintext lab result of an encrypted patient: %v", err)
+	}
+
+	result.MReport = map[string]string{"hb": "ciphertext"}
+	result.Encrypted = true
+	result.KeyVersion = 1
+	result.RecordKey = "another-record-key"
+	result.ReportDigest = "report-digest"
+	result.ReportSalt = "report-salt"
+	err = sc.postLabResult(lab, orderID, result)
+	if err == nil || !strings.Contains(err.Error(), "record key of the order") {
+		t.Errorf("lab result encrypted with another record key: %v", err)
+	}
+
+	result.RecordKey = "record-key"
+	result.DoctorSign = ""
+	err = sc.postLabResult(lab, orderID, result)
+	if err == nil || !strings.Contains(err.Error(), "signature not found") {
+		t.Errorf("unsigned lab result: %v", err)
+	}
+
+	content, err = result.signedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.DoctorSign, err = lab.SignJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sc.postLabResult(lab, orderID, result); err != nil {
+		t.Fatalf("PostLabResult: %v", err)
+	}
+
+	record := sc.readPatient(patient, "0001P").MedicalRecords[0]
+	if !record.Encrypted || len(record.CertFingerprint) == 0 || record.LabOrderID != orderID || record.OrderedBy != "0001D" {
+		t.Fatalf("lab record %+v", record)
+	}
+
+	var check *RecordSignatureCheck
+	err = sc.network.NewTransaction(patient, "VerifyMedicalRecordSignature", "0001P", record.ID).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		check, err = sc.contract.VerifyMedicalRecordSignature(ctx, "0001P", record.ID)
+		return err
+	})
+	if err != nil || !check.Valid {
+		t.Errorf("VerifyMedicalRecordSignature: %+v, %v", check, err)
+	}
+
+	var orders *LabOrders
+	err = sc.network.NewTransaction(lab, "GetLabOrders").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		orders, err = sc.contract.GetLabOrders(ctx)
+		return err
+	})
+	if err != nil || len(orders.Data) != 1 || len(orders.Data[0].WrappedKey) != 0 || len(orders.Data[0].RecordKey) != 0 {
+		t.Errorf("resulted lab order kept the wrapped key: %+v, %v", orders, err)
+	}
+}
+
+func TestLabOrderKeyRenewedAfterRotation(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+	lab := sc.client("Org1MSP", "lab", "0001L")
+	sc.setDataKey(patient)
+	sc.publishCertificate(lab)
+
+	orderID, err := sc.createLabOrder(doctor, "0001L", map[string]string{"wrapped_key": "lab-wrapped-record-key", "record_key": "record-key-1"})
+	if err != nil {
+		t.Fatalf("CreateLabOrder: %v", err)
+	}
+
+	/// the data key is rotated before the lab posts the result
+	err = sc.submitWithTransient(patient, "StartKeyRotation", "wrapped_keys", map[string]string{"0001P": "patient-wrapped-key-2", "0001D": "doctor-wrapped-key-2"}, func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.StartKeyRotation(ctx)
+	})
+	if err != nil {
+		t.Fatalf("StartKeyRotation: %v", err)
+	}
+	err = sc.submit(patient, "CompleteKeyRotation", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CompleteKeyRotation(ctx)
+	})
+	if err != nil {
+		t.Fatalf("CompleteKeyRotation: %v", err)
+	}
+
+	collectedAt := sc.network.Now().Add(-time.Hour)
+	result := MedicalInfo{Type: "CBC", MReport: map[string]string{"hb": "ciphertext"}, CollectedAt: &collectedAt, Owner: "0001P", IssuedBy: "0001L",
+		Encrypted: true, KeyVersion: 1, RecordKey: "record-key-1", ReportDigest: "report-digest", ReportSalt: "report-salt"}
+	err = sc.postLabResult(lab, orderID, result)
+	if err == nil || !strings.Contains(err.Error(), "must renew") {
+		t.Fatalf("lab result with the key of the rotated data key: %v", err)
+	}
+
+	renew := func(client *fabrictest.Identity) error {
+		tx := sc.network.NewTransaction(client, "RenewLabOrderKey", "0001L", orderID).WithTransient("wrapped_key", []byte("lab-wrapped-record-key-2")).WithTransient("record_key", []byte("record-key-2"))
+		return tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+			return sc.contract.RenewLabOrderKey(ctx, "0001L", orderID)
+		})
+	}
+	if err = renew(lab); err == nil {
+		t.Errorf("lab order key renewed by the lab")
+	}
+	if err = renew(doctor); err != nil {
+		t.Fatalf("RenewLabOrderKey: %v", err)
+	}
+
+	result.KeyVersion = 2
+	result.RecordKey = "record-key-2"
+	content, err := result.signedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.DoctorSign, err = lab.SignJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sc.postLabResult(lab, orderID, result); err != nil {
+		t.Fatalf("PostLabResult: %v", err)
+	}
+
+	/// the lab got a record key for its result only, the data key is not wrapped for it
+	assetData := sc.readPatient(patient, "0001P")
+	if _, ok := assetData.DataKey.WrappedKeys["0001L"]; ok || assetData.MedicalRecords[0].KeyVersion != 2 {
+		t.Errorf("data key %+v, record %+v", assetData.DataKey, assetData.MedicalRecords[0])
+	}
+}
@@ -0,0 +1,417 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"bytes"
+	"strings"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const patientObjectType = "patient"
+const doctorObjectType = "doctor"
+
+/// objects scanned in a single MigrateCollection transaction
+const maxMigrationItems = 100
+
+/// composite keys start with the namespace, the keys of the objects stored under ids never do
+const compositeKeyNamespace = "\x00"
+
+/// current schema version of the stored objects, objects stored without a version are version 0
+var schemaVersions = map[string]int{
+	patientObjectType: 2,
+	doctorObjectType: 1,
+	dataAccessRequestObjectType: 1,
+	requestAgreementObjectType: 1,
+	patientTransferObjectType: 1,
+	patientForwardObjectType: 1,
+	patientIndexObjectType: 1,
+	patientMergeObjectType: 1,
+	referralObjectType: 1,
+	prescriptionObjectType: 1,
+	prescriptionCodeObjectType: 1,
+	labOrderObjectType: 1,
+	accessLogObjectType: 1,
+	retentionPolicyObjectType: 1,
+	archiveReceiptObjectType: 1,
+	collectedAtWindowObjectType: 1,
+	inboxReadObjectType: 1,
+}
+
+/// object types stored as a bare JSON value by older versions, the value is kept under the field
+var legacyValueFields = map[string]string{
+	inboxReadObjectType: "readAt",
+}
+
+/// upgrade of a stored object from its version to the next one, on the raw JSON object
+/// so that fields of the old schema can be read after the struct has changed
+type schemaMigration func(object map[string]interface{}) error
+
+/// migrations of the object types by the version they upgrade from
+var schemaMigrations = map[string]map[int]schemaMigration{}
+
+/// register the migration of the object type from the version to the next one
+func registerMigration(objectType string, fromVersion int, migration schemaMigration) {
+	if _, ok := schemaMigrations[objectType]; !ok {
+		schemaMigrations[objectType] = map[int]schemaMigration{}
+	}
+	schemaMigrations[objectType][fromVersion] = migration
+}
+
+func init() {
+	registerMigration(patientObjectType, 0, emptyListMigration("medicalRecords", "doctorInfo", "owners"))
+	registerMigration(patientObjectType, 1, recordIssueTimeMigration)
+	registerMigration(doctorObjectType, 0, emptyListMigration("pids"))
+	registerMigration(dataAccessRequestObjectType, 0, renameFieldsMigration(map[string]string{
+		"meta_data": "metaData",
+		"patient_id": "patientId",
+		"client_sign": "clientSign",
+		"created_at": "createdAt",
+		"expires_at": "expiresAt",
+	}))
+	registerMigration(requestAgreementObjectType, 0, versionOnlyMigration)
+	for _, objectType := range []string{referralObjectType, prescriptionObjectType, prescriptionCodeObjectType, labOrderObjectType, accessLogObjectType, retentionPolicyObjectType, archiveReceiptObjectType, collectedAtWindowObjectType, inboxReadObjectType} {
+		registerMigration(objectType, 0, versionOnlyMigration)
+	}
+}
+
+/// the schema is unchanged, only the version is recorded
+func versionOnlyMigration(object map[string]interface{}) error {
+	return nil
+}
+
+/// medical records of a day of issue are issued at the start of the day (UTC)
+/// the day of issue is kept, the doctor signature covers it
+func recordIssueTimeMigration(object map[string]interface{}) error {
+
+	records, _ := object["medicalRecords"].([]interface{})
+	for _, value := range records {
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Medical record is not an object")
+		}
+		if _, ok := record["issuedAt"]; ok {
+			continue
+		}
+
+		dateOfIssue, ok := record["dateOfIssue"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Medical record %v has no day of issue", record["id"])
+		}
+
+		var date Date
+		for field, target := range map[string]*int{"day": &date.Day, "year": &date.Year} {
+			number, _ := dateOfIssue[field].(json.Number)
+			value, err := number.Int64()
+			if err != nil {
+				return fmt.Errorf("Day of issue of medical record %v is not valid: %v", record["id"], err)
+			}
+			*target = int(value)
+		}
+		number, _ := dateOfIssue["month"].(json.Number)
+		month, err := number.Int64()
+		if err != nil {
+			return fmt.Errorf("Day of issue of medical record %v is not valid: %v", record["id"], err)
+		}
+		date.Month = time.Month(month)
+
+		record["issuedAt"] = date.startTime().Format(time.RFC3339Nano)
+	}
+
+	return nil
+}
+
+/// lists stored as null by older versions become empty lists
+func emptyListMigration(fields ...string) schemaMigration {
+	return func(object map[string]interface{}) error {
+		for _, field := range fields {
+			if value, ok := object[field]; !ok || value == nil {
+				object[field] = []interface{}{}
+			}
+		}
+		return nil
+	}
+}
+
+/// fields renamed from the old name to the new one
+func renameFieldsMigration(names map[string]string) schemaMigration {
+	return func(object map[string]interface{}) error {
+		for oldName, newName := range names {
+			value, ok := object[oldName]
+			if !ok {
+				continue
+			}
+			if _, exists := object[newName]; exists {
+				return fmt.Errorf("Cannot rename %v, %v already exists", oldName, newName)
+			}
+			object[newName] = value
+			delete(object, oldName)
+		}
+		return nil
+	}
+}
+
+/// object stored in a collection with a schema version
+type storedObject interface {
+	objectType() string
+	setSchemaVersion(version int)
+}
+
+/// marshal the object at the current schema version of its type
+func marshalStoredObject(object storedObject) ([]byte, error) {
+	object.setSchemaVersion(schemaVersions[object.objectType()])
+	return json.Marshal(object)
+}
+
+/// unmarshal the stored object, objects of older versions are migrated on read
+/// and stored at the current version when they are written again
+func unmarshalStoredObject(data []byte, object storedObject) error {
+
+	current, _, err := migrateStoredObject(object.objectType(), data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(current, object)
+}
+
+/// the stored object at the current schema version of the type, true when it was migrated
+func migrateStoredObject(objectType string, data []byte) ([]byte, bool, error) {
+
+	currentVersion, ok := schemaVersions[objectType]
+	if !ok {
+		return nil, false, fmt.Errorf("Schema version of %v is not defined", objectType)
+	}
+
+	/// numbers are kept as they are stored
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot unmarshal %v: %v", objectType, err)
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		field, legacy := legacyValueFields[objectType]
+		if !legacy {
+			return nil, false, fmt.Errorf("Cannot unmarshal %v: not an object", objectType)
+		}
+		object = map[string]interface{}{field: value}
+	}
+
+	version := 0
+	if value, ok := object["schemaVersion"]; ok {
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, false, fmt.Errorf("Schema version of %v is not a number", objectType)
+		}
+		version64, err := number.Int64()
+		if err != nil {
+			return nil, false, fmt.Errorf("Schema version of %v is not valid: %v", objectType, err)
+		}
+		version = int(version64)
+	}
+
+	if version == currentVersion {
+		return data, false, nil
+	}
+
+	if version > currentVersion {
+		return nil, false, fmt.Errorf("%v schema version %v is newer than the supported version %v", objectType, version, currentVersion)
+	}
+
+	for ; version < currentVersion; version++ {
+		migration, ok := schemaMigrations[objectType][version]
+		if !ok {
+			return nil, false, fmt.Errorf("No migration of %v from schema version %v", objectType, version)
+		}
+
+		err = migration(object)
+		if err != nil {
+			return nil, false, fmt.Errorf("Cannot migrate %v from schema version %v: %v", objectType, version, err)
+		}
+	}
+	object["schemaVersion"] = currentVersion
+
+	migrated, err := json.Marshal(object)
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot marshal %v: %v", objectType, err)
+	}
+
+	return migrated, true, nil
+}
+
+type MigrationResult struct {
+	Collection string `json:"collection"`
+	Migrated map[string]int `json:"migrated"`
+	Scanned int `json:"scanned"`
+	/// key to continue the migration from, empty once the whole collection is scanned
+	Bookmark string `json:"bookmark"`
+}
+
+/// object type of the patient and doctor data stored under their ids
+func idObjectType(key string) (string, bool) {
+	if checkID(key, "P") {
+		return patientObjectType, true
+	}
+	if checkID(key, "D") {
+		return doctorObjectType, true
+	}
+	return "", false
+}
+
+/// upgrade the stored objects of the collection to the current schema versions
+/// each call scans at most pageSize objects from the bookmark (empty to start),
+/// call again with the returned bookmark until it is empty
+func (s *SmartContract) MigrateCollection(ctx contractapi.TransactionContextInterface, collection string, bookmark string, pageSize int) (*MigrationResult, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	/// objects with composite keys kept in the org collection and in the shared one,
+	/// patient and doctor data are kept under their ids in the org collection
+	var compositeTypes []string
+	switch collection {
+	case orgCollectionName:
+		compositeTypes = []string{dataAccessRequestObjectType, patientForwardObjectType, patientMergeObjectType, referralObjectType, prescriptionObjectType, prescriptionCodeObjectType, labOrderObjectType, accessLogObjectType, retentionPolicyObjectType, archiveReceiptObjectType, collectedAtWindowObjectType, inboxReadObjectType}
+	case org1AndOrg2PrivateCollection:
+		compositeTypes = []string{requestAgreementObjectType, patientTransferObjectType, patientIndexObjectType}
+	default:
+		return nil, fmt.Errorf("Cannot migrate collection %v", collection)
+	}
+
+	if pageSize <= 0 || pageSize > maxMigrationItems {
+		pageSize = maxMigrationItems
+	}
+
+	/// the objects under ids come first, then the composite keys type by type,
+	/// the bookmark is the last key scanned
+	startType := ""
+	if strings.HasPrefix(bookmark, compositeKeyNamespace) {
+		startType, _, err = ctx.GetStub().SplitCompositeKey(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("Bookmark is not valid: %v", err)
+		}
+	}
+
+	result := &MigrationResult{Collection: collection, Migrated: map[string]int{}}
+
+	/// false once the page is full
+	migrate := func(objectType string, key string, value []byte) (bool, error) {
+		current, changed, err := migrateStoredObject(objectType, value)
+		if err != nil {
+			return false, fmt.Errorf("Cannot migrate %v: %v", key, err)
+		}
+
+		if changed {
+			log.Printf("MigrateCollection Put: collection %v, Key %v", collection, key)
+			err = ctx.GetStub().PutPrivateData(collection, key, current)
+			if err != nil {
+				return false, fmt.Errorf("failed to put %v: %v", objectType, err)
+			}
+			result.Migrated[objectType]++
+		}
+
+		result.Scanned++
+		if result.Scanned == pageSize {
+			result.Bookmark = key
+			return false, nil
+		}
+		return true, nil
+	}
+
+	if len(startType) == 0 {
+		/// next key after the bookmark
+		startKey := ""
+		if len(bookmark) != 0 {
+			startKey = bookmark + "\x00"
+		}
+
+		resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(collection, startKey, "")
+		if err != nil {
+			return nil, err
+		}
+		defer resultsIterator.Close()
+
+		for resultsIterator.HasNext() {
+			response, err := resultsIterator.Next()
+			if err != nil {
+				return nil, err
+			}
+
+			objectType, ok := idObjectType(response.Key)
+			if !ok {
+				continue
+			}
+
+			more, err := migrate(objectType, response.Key, response.Value)
+			if err != nil || !more {
+				return result, err
+			}
+		}
+	}
+
+	started := len(startType) == 0
+	for _, objectType := range compositeTypes {
+		after := ""
+		if !started {
+			if objectType != startType {
+				continue
+			}
+			started = true
+			after = bookmark
+		}
+
+		more, err := migrateCompositeType(ctx, collection, objectType, after, migrate)
+		if err != nil || !more {
+			return result, err
+		}
+	}
+
+	if !started {
+		return nil, fmt.Errorf("Bookmark is not valid for collection %v", collection)
+	}
+
+	return result, nil
+}
+
+/// migrate the objects of the composite key type after the key, false once the page is full
+func migrateCompositeType(ctx contractapi.TransactionContextInterface, collection string, objectType string, after string, migrate func(string, string, []byte) (bool, error)) (bool, error) {
+
+	compositeIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, objectType, []string{})
+	if err != nil {
+		return false, err
+	}
+	defer compositeIterator.Close()
+
+	for compositeIterator.HasNext() {
+		response, err := compositeIterator.Next()
+		if err != nil {
+			return false, err
+		}
+
+		/// keys come in order, the ones up to the bookmark were scanned by an earlier call
+		if response.Key <= after {
+			continue
+		}
+
+		more, err := migrate(objectType, response.Key, response.Value)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
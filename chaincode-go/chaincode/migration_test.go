@@ -0,0 +1,172 @@
+package chaincode
+
+import (
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// patient and data access request as stored before schema versions
+const legacyPatient = `{"meta":{"collectionName":"Org1MSPPrivateCollection"},"pid":"0001P","personalInfo":{"firstName":"Alice","lastName":"Doe","age":40,"gender":"female","email":"alice@example.com","contactNumber":"5550100","city":"Springfield","state":"IL","country":"USA","type":"patient"},"medicalRecords":null,"doctorInfo":null,"owners":["owner"],"researchConsent":false}`
+const legacyDataAccessRequest = `{"meta_data":{"org":"org1","user":"doctor0001D","id":"0001D"},"patient_id":"0001P","client_sign":"sign","valid":false,"created_at":"2024-01-01T09:00:00Z","expires_at":"2024-01-08T09:00:00Z","payload":"{}"}`
+
+func (sc *scenario) putLegacyData(client *fabrictest.Identity) {
+	err := sc.submit(client, "Put", func(ctx contractapi.TransactionContextInterface) error {
+		requestKey, err := ctx.GetStub().CreateCompositeKey(dataAccessRequestObjectType, []string{"0001P"})
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutPrivateData(org1CollectionName, requestKey, []byte(legacyDataAccessRequest))
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutPrivateData(org1CollectionName, "0001P", []byte(legacyPatient))
+	})
+	if err != nil {
+		sc.t.Fatalf("Cannot put legacy data: %v", err)
+	}
+}
+
+func (sc *scenario) migrateCollection(admin *fabrictest.Identity, collection string, bookmark string, pageSize int) *MigrationResult {
+	var result *MigrationResult
+	err := sc.submit(admin, "MigrateCollection", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = sc.contract.MigrateCollection(ctx, collection, bookmark, pageSize)
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("MigrateCollection: %v", err)
+	}
+	return result
+}
+
+func TestLazyMigrationOnRead(t *testing.T) {
+	sc := newScenario(t)
+	patient := sc.client("Org1MSP", "patient", "0001P")
+	sc.putLegacyData(patient)
+
+	assetData := sc.readPatient(patient, "0001P")
+	if assetData.SchemaVersion != schemaVersions[patientObjectType] || assetData.TreatedBy == nil || assetData.MedicalRecords == nil {
+		t.Fatalf("migrated patient = %+v", assetData)
+	}
+
+	var request *dataAccessRequest
+	err := sc.network.NewTransaction(patient, "ReadDataAccessRequest", "0001P").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		request, err = sc.contract.ReadDataAccessRequest(ctx, "0001P")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ReadDataAccessRequest: %v", err)
+	}
+	if request.PatientID != "0001P" || request.MetaData.ClientID != "0001D" || request.ExpiresAt.IsZero() {
+		t.Fatalf("migrated request = %+v", request)
+	}
+
+	/// reads do not rewrite the stored object
+	if stored := sc.network.PrivateData(org1CollectionName, "0001P"); string(stored) != legacyPatient {
+		t.Fatalf("stored patient changed on read: %s", stored)
+	}
+}
+
+func TestMigrateCollection(t *testing.T) {
+	sc := newScenario(t)
+	patient := sc.client("Org1MSP", "patient", "0001P")
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	sc.putLegacyData(patient)
+
+	if err := sc.submit(patient, "MigrateCollection", func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.MigrateCollection(ctx, org1CollectionName, "", 0)
+		return err
+	}); err == nil {
+		t.Fatalf("collection migrated by a patient")
+	}
+
+	result := sc.migrateCollection(admin, org1CollectionName, "", 0)
+	if result.Migrated[patientObjectType] != 1 || result.Migrated[dataAccessRequestObjectType] != 1 || len(result.Bookmark) != 0 {
+		t.Fatalf("result = %+v", result)
+	}
+
+	var stored map[string]interface{}
+	err := json.Unmarshal(sc.network.PrivateData(org1CollectionName, "0001P"), &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored["schemaVersion"] != float64(schemaVersions[patientObjectType]) {
+		t.Fatalf("stored patient = %v", stored)
+	}
+
+	/// migrated objects are not migrated again
+	result = sc.migrateCollection(admin, org1CollectionName, "", 0)
+	if len(result.Migrated) != 0 {
+		t.Fatalf("second migration = %+v", result)
+	}
+}
+
+func TestMigrateCollectionPaged(t *testing.T) {
+	sc := newScenario(t)
+	patient := sc.client("Org1MSP", "patient", "0001P")
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	sc.putLegacyData(patient)
+
+	/// read marker stored as the bare read time
+	err := sc.submit(patient, "Put", func(ctx contractapi.TransactionContextInterface) error {
+		readKey, err := inboxReadKey(ctx, "0001P", "item")
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutPrivateData(org1CollectionName, readKey, []byte(`"2024-01-02T09:00:00Z"`))
+	})
+	if err != nil {
+		t.Fatalf("Cannot put legacy inbox marker: %v", err)
+	}
+
+	migrated := map[string]int{}
+	bookmark := ""
+	pages := 0
+	for {
+		result := sc.migrateCollection(admin, org1CollectionName, bookmark, 1)
+		if result.Scanned > 1 {
+			t.Fatalf("page scanned %v objects", result.Scanned)
+		}
+		for objectType, count := range result.Migrated {
+			migrated[objectType] += count
+		}
+		pages++
+		if len(result.Bookmark) == 0 {
+			break
+		}
+		if pages > 10 {
+			t.Fatalf("migration does not end, bookmark %q", result.Bookmark)
+		}
+		bookmark = result.Bookmark
+	}
+
+	if migrated[patientObjectType] != 1 || migrated[dataAccessRequestObjectType] != 1 || migrated[inboxReadObjectType] != 1 || pages < 3 {
+		t.Fatalf("migrated = %v in %v pages", migrated, pages)
+	}
+
+	var marker inboxRead
+	err = sc.submit(patient, "Read", func(ctx contractapi.TransactionContextInterface) error {
+		readKey, err := inboxReadKey(ctx, "0001P", "item")
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(sc.network.PrivateData(org1CollectionName, readKey), &marker)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.SchemaVersion != schemaVersions[inboxReadObjectType] || marker.ReadAt.IsZero() {
+		t.Fatalf("migrated inbox marker = %+v", marker)
+	}
+}
+
+func TestNewerSchemaVersionRejected(t *testing.T) {
+	object := &PatientInfo{}
+	err := unmarshalStoredObject([]byte(`{"schemaVersion":99,"pid":"0001P"}`), object)
+	if err == nil {
+		t.Fatalf("object of a newer schema version unmarshalled")
+	}
+}
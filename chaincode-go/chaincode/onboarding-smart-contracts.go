@@ -0,0 +1,156 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// patients registered in a single RegisterPatients transaction
+const maxOnboardingBatch = 50
+
+/// patient of an onboarding file, the row locates the patient in the file
+/// the id is the one the hospital enrolls the patient with
+type PatientOnboarding struct {
+	Row int `json:"row"`
+	ID string `json:"pid"`
+	PersonalInfo ClientPersonalInfo `json:"personalInfo"`
+}
+
+type OnboardingRejection struct {
+	Row int `json:"row"`
+	ID string `json:"pid"`
+	Reason string `json:"reason"`
+}
+
+/// patients already registered are reported as existing, so a batch can be submitted again
+/// created patients flagged in the patient index are listed as probable duplicates
+type OnboardingResult struct {
+	Created []string `json:"created"`
+	Existing []string `json:"existing"`
+	Rejected []OnboardingRejection `json:"rejected"`
+	ProbableDuplicates []string `json:"probableDuplicates"`
+}
+
+func validateOnboardingID(id string) error {
+	if len(id) == 0 {
+		return fmt.Errorf("pid field must be non-empty value")
+	}
+	if !checkID(id, "P") {
+		return fmt.Errorf("pid must end with P")
+	}
+	if strings.ContainsAny(id, " \t\r\n\x00") {
+		return fmt.Errorf("pid must not contain spaces or control characters")
+	}
+	return nil
+}
+
+/// register the patients of the batch passed in the transient map under "patients"
+/// into the org collection, owned by the registering admin
+/// invalid rows are rejected without failing the batch
+func (s *SmartContract) RegisterPatients(ctx contractapi.TransactionContextInterface) (*OnboardingResult, error) {
+
+	adminID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	patientsJSON, ok := transientMap["patients"]
+	if !ok {
+		return nil, fmt.Errorf("Patients not found in the transient map")
+	}
+
+	var patients []PatientOnboarding
+	err = json.Unmarshal(patientsJSON, &patients)
+	if err != nil {
+		return nil, fmt.Errorf("Error cannot unmarshal: %v", err)
+	}
+
+	if len(patients) == 0 || len(patients) > maxOnboardingBatch {
+		return nil, fmt.Errorf("Batch must have between 1 and %v patients", maxOnboardingBatch)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OnboardingResult{Created: []string{}, Existing: []string{}, Rejected: []OnboardingRejection{}, ProbableDuplicates: []string{}}
+	seen := map[string]bool{}
+
+	for _, patient := range patients {
+		reject := func(reason error) {
+			result.Rejected = append(result.Rejected, OnboardingRejection{Row: patient.Row, ID: patient.ID, Reason: reason.Error()})
+		}
+
+		err = validateOnboardingID(patient.ID)
+		if err != nil {
+			reject(err)
+			continue
+		}
+
+		if seen[patient.ID] {
+			reject(fmt.Errorf("pid %v is repeated in the batch", patient.ID))
+			continue
+		}
+		seen[patient.ID] = true
+
+		existing, err := ctx.GetStub().GetPrivateData(orgCollectionName, patient.ID)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get the asset: %v", err)
+		}
+		if existing != nil {
+			result.Existing = append(result.Existing, patient.ID)
+			continue
+		}
+
+		var assetData PatientInfo
+		assetData.SetInfo(patient.ID, patient.PersonalInfo, []MedicalInfo{}, []string{}, []string{adminID})
+
+		err = checkValidData(assetData, 0)
+		if err != nil {
+			reject(err)
+			continue
+		}
+
+		err = assetData.addMetaData(orgCollectionName)
+		if err != nil {
+			return nil, fmt.Errorf("Error executing the smart contract: %v", err)
+		}
+
+		assetDataJSON, err := marshalStoredObject(&assetData)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal asset data: %v", err)
+		}
+
+		log.Printf("RegisterPatients Put: collection %v, ID %v", orgCollectionName, assetData.ID)
+		err = ctx.GetStub().PutPrivateData(orgCollectionName, assetData.ID, assetDataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put asset private details: %v", err)
+		}
+
+		err = s.recordAccess(ctx, &assetData, accessWrite)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := indexPatient(ctx, &assetData)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && len(entry.ProbableDuplicates) != 0 {
+			result.ProbableDuplicates = append(result.ProbableDuplicates, assetData.ID)
+		}
+
+		result.Created = append(result.Created, assetData.ID)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package chaincode
+
+import (
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) registerPatients(client *fabrictest.Identity, patients []PatientOnboarding) (*OnboardingResult, error) {
+	patientsJSON, err := json.Marshal(patients)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	var result *OnboardingResult
+	tx := sc.network.NewTransaction(client, "RegisterPatients").WithTransient("patients", patientsJSON)
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = sc.contract.RegisterPatients(ctx)
+		return err
+	})
+	return result, err
+}
+
+func TestRegisterPatients(t *testing.T) {
+	sc := newScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	patient := sc.client("Org1MSP", "patient", "0101P")
+
+	missingEmail := testPersonalInfo("Carol", "Patient")
+	missingEmail.Email = ""
+
+	patients := []PatientOnboarding{
+		{Row: 2, ID: "0101P", PersonalInfo: testPersonalInfo("Alice", "Patient")},
+		{Row: 3, ID: "0102P", PersonalInfo: testPersonalInfo("Bob", "Patient")},
+		{Row: 4, ID: "0103P", PersonalInfo: missingEmail},
+		{Row: 5, ID: "0104D", PersonalInfo: testPersonalInfo("Dan", "Patient")},
+		{Row: 6, ID: "0101P", PersonalInfo: testPersonalInfo("Alice", "Patient")},
+	}
+
+	if _, err := sc.registerPatients(patient, patients); err == nil {
+		t.Fatalf("patients registered by a patient")
+	}
+
+	result, err := sc.registerPatients(admin, patients)
+	if err != nil {
+		t.Fatalf("RegisterPatients: %v", err)
+	}
+	if len(result.Created) != 2 || len(result.Existing) != 0 || len(result.Rejected) != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	if result.Rejected[0].Row != 4 || result.Rejected[0].Reason != "email field must be non-empty value" {
+		t.Errorf("rejection = %+v", result.Rejected[0])
+	}
+
+	/// the patient enrolled with the onboarded id reads its data
+	if assetData := sc.readPatient(patient, "0101P"); assetData.PersonalInfo.FirstName != "Alice" {
+		t.Errorf("onboarded patient = %+v", assetData)
+	}
+
+	/// submitting the batch again after a failure does not register twice
+	result, err = sc.registerPatients(admin, patients[:2])
+	if err != nil {
+		t.Fatalf("RegisterPatients: %v", err)
+	}
+	if len(result.Created) != 0 || len(result.Existing) != 2 {
+		t.Fatalf("resubmitted result = %+v", result)
+	}
+}
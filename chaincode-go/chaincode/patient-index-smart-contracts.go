@@ -0,0 +1,901 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// the master patient index is kept in the shared collection, so duplicates are found across orgs
+/// it holds salted hashes of the identifying fields of the patients, never the fields themselves
+const patientIndexObjectType = "patientIndex"
+
+/// lookup keys of the index, one per hash of a patient
+const patientIndexHashObjectType = "patientIndexHash"
+
+/// salt of the index hashes, set once by an admin
+const patientIndexSaltObjectType = "patientIndexSalt"
+
+/// merge record left in the org collection under the id of the merged patient
+const patientMergeObjectType = "patientMerge"
+
+const minIndexSaltLength = 16
+
+/// identifying fields the index hashes
+const (
+	indexNameBirthDate = "nameBirthDate"
+	indexEmail = "email"
+	indexPhone = "phone"
+)
+
+/// patient of an org, patient ids are given by the orgs
+type PatientRef struct {
+	PID string `json:"pid"`
+	Org string `json:"org"`
+}
+
+/// patient the indexed patient is probably the same person as
+type DuplicateMatch struct {
+	PID string `json:"pid"`
+	Org string `json:"org"`
+	Matches []string `json:"matches"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+/// index entry of a patient, merged patients keep their entry without hashes
+type PatientIndexEntry struct {
+	SchemaVersion int `json:"schemaVersion"`
+	PID string `json:"pid"`
+	Org string `json:"org"`
+	Hashes map[string]string `json:"hashes"`
+	ProbableDuplicates []DuplicateMatch `json:"probableDuplicates"`
+	Dismissed []PatientRef `json:"dismissed,omitempty"`
+	MergedInto string `json:"mergedInto,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (dm *DuplicateMatch) ref() PatientRef {
+	return PatientRef{PID: dm.PID, Org: dm.Org}
+}
+
+func (pie *PatientIndexEntry) objectType() string {
+	return patientIndexObjectType
+}
+
+func (pie *PatientIndexEntry) setSchemaVersion(version int) {
+	pie.SchemaVersion = version
+}
+
+func (pie *PatientIndexEntry) ref() PatientRef {
+	return PatientRef{PID: pie.PID, Org: pie.Org}
+}
+
+func (pie *PatientIndexEntry) isDismissed(ref PatientRef) bool {
+	for _, dismissed := range pie.Dismissed {
+		if dismissed == ref {
+			return true
+		}
+	}
+	return false
+}
+
+/// flag the patient as a probable duplicate, a patient flagged again gets the new matches
+func (pie *PatientIndexEntry) flagDuplicate(ref PatientRef, matches []string, flaggedAt time.Time) {
+	for i, duplicate := range pie.ProbableDuplicates {
+		if duplicate.ref() == ref {
+			pie.ProbableDuplicates[i].Matches = matches
+			return
+		}
+	}
+	pie.ProbableDuplicates = append(pie.ProbableDuplicates, DuplicateMatch{PID: ref.PID, Org: ref.Org, Matches: matches, FlaggedAt: flaggedAt})
+}
+
+/// remove the flag of the patient, the removed match is returned
+func (pie *PatientIndexEntry) unflagDuplicate(ref PatientRef) *DuplicateMatch {
+	for i, duplicate := range pie.ProbableDuplicates {
+		if duplicate.ref() == ref {
+			pie.ProbableDuplicates = append(pie.ProbableDuplicates[:i], pie.ProbableDuplicates[i+1:]...)
+			return &duplicate
+		}
+	}
+	return nil
+}
+
+/// consolidation of a duplicate patient into the surviving one
+type PatientMerge struct {
+	SchemaVersion int `json:"schemaVersion"`
+	SurvivorID string `json:"survivorId"`
+	MergedID string `json:"mergedId"`
+	Org string `json:"org"`
+	MergedBy string `json:"mergedBy"`
+	MergedAt time.Time `json:"mergedAt"`
+	TxID string `json:"txId"`
+	Matches []string `json:"matches"`
+	RecordIDs []string `json:"recordIds"`
+	Doctors []string `json:"doctors"`
+	/// reason given by the admin to merge patients the index did not flag
+	OverrideReason string `json:"overrideReason,omitempty"`
+}
+
+func (pm *PatientMerge) objectType() string {
+	return patientMergeObjectType
+}
+
+func (pm *PatientMerge) setSchemaVersion(version int) {
+	pm.SchemaVersion = version
+}
+
+/// letters and digits only, so case, spacing and punctuation do not matter
+func normalizeIndexName(name string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+	return normalized.String()
+}
+
+/// the last ten digits, so the country code and the formatting do not matter
+func normalizeIndexPhone(phone string) string {
+	var digits strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	normalized := digits.String()
+	if len(normalized) < 7 {
+		return ""
+	}
+	if len(normalized) > 10 {
+		normalized = normalized[len(normalized)-10:]
+	}
+	return normalized
+}
+
+/// normalized identifying fields of the personal info by index field, missing fields are left out
+func normalizedIndexFields(info ClientPersonalInfo) map[string]string {
+
+	fields := map[string]string{}
+
+	firstName := normalizeIndexName(info.FirstName)
+	lastName := normalizeIndexName(info.LastName)
+	if len(firstName) != 0 && len(lastName) != 0 && info.DateOfBirth != nil {
+		dob := info.DateOfBirth
+		fields[indexNameBirthDate] = fmt.Sprintf("%v %v %04d-%02d-%02d", firstName, lastName, dob.Year, int(dob.Month), dob.Day)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(info.Email))
+	if strings.Contains(email, "@") {
+		fields[indexEmail] = email
+	}
+
+	if phone := normalizeIndexPhone(info.ContactNumber); len(phone) != 0 {
+		fields[indexPhone] = phone
+	}
+
+	return fields
+}
+
+/// hex HMAC-SHA256 of the normalized fields with the index salt
+func patientIndexHashes(salt []byte, info ClientPersonalInfo) map[string]string {
+
+	hashes := map[string]string{}
+	for field, value := range normalizedIndexFields(info) {
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(field + ":" + value))
+		hashes[field] = hex.EncodeToString(mac.Sum(nil))
+	}
+	return hashes
+}
+
+/// the same name and birth date, or the same email and phone, make a probable duplicate
+/// a shared email or phone alone is common in families
+func isProbableDuplicate(matches []string) bool {
+	matched := map[string]bool{}
+	for _, match := range matches {
+		matched[match] = true
+	}
+	return matched[indexNameBirthDate] || (matched[indexEmail] && matched[indexPhone])
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/// salt of the index hashes, nil when no admin has set it
+func readIndexSalt(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+
+	saltKey, err := ctx.GetStub().CreateCompositeKey(patientIndexSaltObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	salt, err := ctx.GetStub().GetPrivateData(org1AndOrg2PrivateCollection, saltKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient index salt: %v", err)
+	}
+
+	return salt, nil
+}
+
+/// index entry of the patient of the org, nil when the patient is not indexed
+func readPatientIndexEntry(ctx contractapi.TransactionContextInterface, ref PatientRef) (*PatientIndexEntry, error) {
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(patientIndexObjectType, []string{ref.Org, ref.PID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	entryJSON, err := ctx.GetStub().GetPrivateData(org1AndOrg2PrivateCollection, entryKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient index entry: %v", err)
+	}
+
+	if entryJSON == nil {
+		return nil, nil
+	}
+
+	var entry PatientIndexEntry
+	err = unmarshalStoredObject(entryJSON, &entry)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal patient index entry: %v", err)
+	}
+
+	return &entry, nil
+}
+
+func putPatientIndexEntry(ctx contractapi.TransactionContextInterface, entry *PatientIndexEntry) error {
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(patientIndexObjectType, []string{entry.Org, entry.PID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	entryJSON, err := marshalStoredObject(entry)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal patient index entry: %v", err)
+	}
+
+	log.Printf("PatientIndex Put: collection %v, ID %v, Key %v", org1AndOrg2PrivateCollection, entry.PID, entryKey)
+	err = ctx.GetStub().PutPrivateData(org1AndOrg2PrivateCollection, entryKey, entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put patient index entry: %v", err)
+	}
+
+	return nil
+}
+
+/// remove the lookup keys of the hashes of the entry
+func deleteIndexHashes(ctx contractapi.TransactionContextInterface, entry *PatientIndexEntry) error {
+
+	for _, field := range sortedKeys(entry.Hashes) {
+		hashKey, err := ctx.GetStub().CreateCompositeKey(patientIndexHashObjectType, []string{field, entry.Hashes[field], entry.Org, entry.PID})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+
+		err = ctx.GetStub().DelPrivateData(org1AndOrg2PrivateCollection, hashKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete patient index hash: %v", err)
+		}
+	}
+
+	entry.Hashes = map[string]string{}
+	return nil
+}
+
+/// remove the flags other patients hold on the patient of the entry
+func unlinkDuplicates(ctx contractapi.TransactionContextInterface, entry *PatientIndexEntry) error {
+
+	for _, duplicate := range entry.ProbableDuplicates {
+		other, err := readPatientIndexEntry(ctx, duplicate.ref())
+		if err != nil {
+			return err
+		}
+		if other == nil || other.unflagDuplicate(entry.ref()) == nil {
+			continue
+		}
+
+		err = putPatientIndexEntry(ctx, other)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry.ProbableDuplicates = []DuplicateMatch{}
+	return nil
+}
+
+/// index the patient of the invoked client org and flag the probable duplicates on both patients
+/// patients are not indexed until an admin sets the index salt
+func indexPatient(ctx contractapi.TransactionContextInterface, assetData *PatientInfo) (*PatientIndexEntry, error) {
+
+	salt, err := readIndexSalt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if salt == nil {
+		return nil, nil
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := PatientRef{PID: assetData.ID, Org: org}
+	entry, err := readPatientIndexEntry(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		entry = &PatientIndexEntry{PID: ref.PID, Org: ref.Org, ProbableDuplicates: []DuplicateMatch{}}
+	} else {
+		err = deleteIndexHashes(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry.Hashes = patientIndexHashes(salt, assetData.PersonalInfo)
+	entry.MergedInto = ""
+	entry.UpdatedAt = now
+
+	/// fields each other patient has in common with the indexed one
+	matches := map[PatientRef][]string{}
+	candidates := []PatientRef{}
+
+	for _, field := range sortedKeys(entry.Hashes) {
+		hash := entry.Hashes[field]
+
+		resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(org1AndOrg2PrivateCollection, patientIndexHashObjectType, []string{field, hash})
+		if err != nil {
+			return nil, err
+		}
+
+		for resultsIterator.HasNext() {
+			response, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(response.Key)
+			if err != nil || len(attributes) != 4 {
+				continue
+			}
+
+			other := PatientRef{PID: attributes[3], Org: attributes[2]}
+			if other == ref {
+				continue
+			}
+			if _, ok := matches[other]; !ok {
+				candidates = append(candidates, other)
+			}
+			matches[other] = append(matches[other], field)
+		}
+		resultsIterator.Close()
+
+		hashKey, err := ctx.GetStub().CreateCompositeKey(patientIndexHashObjectType, []string{field, hash, ref.Org, ref.PID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+
+		/// an empty value would delete the key
+		err = ctx.GetStub().PutPrivateData(org1AndOrg2PrivateCollection, hashKey, []byte{0x00})
+		if err != nil {
+			return nil, fmt.Errorf("failed to put patient index hash: %v", err)
+		}
+	}
+
+	for _, other := range candidates {
+		if !isProbableDuplicate(matches[other]) || entry.isDismissed(other) {
+			continue
+		}
+
+		otherEntry, err := readPatientIndexEntry(ctx, other)
+		if err != nil {
+			return nil, err
+		}
+		if otherEntry == nil || len(otherEntry.MergedInto) != 0 || otherEntry.isDismissed(ref) {
+			continue
+		}
+
+		log.Printf("PatientIndex: %v of %v is a probable duplicate of %v of %v, matches %v", ref.PID, ref.Org, other.PID, other.Org, matches[other])
+		entry.flagDuplicate(other, matches[other], now)
+		otherEntry.flagDuplicate(ref, matches[other], now)
+		otherEntry.UpdatedAt = now
+
+		err = putPatientIndexEntry(ctx, otherEntry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = putPatientIndexEntry(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+/// remove the patient of the org from the index, used when the patient leaves the org
+func removePatientIndex(ctx contractapi.TransactionContextInterface, ref PatientRef) error {
+
+	entry, err := readPatientIndexEntry(ctx, ref)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	err = deleteIndexHashes(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = unlinkDuplicates(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(patientIndexObjectType, []string{ref.Org, ref.PID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("PatientIndex Delete: collection %v, ID %v, Key %v", org1AndOrg2PrivateCollection, ref.PID, entryKey)
+	return ctx.GetStub().DelPrivateData(org1AndOrg2PrivateCollection, entryKey)
+}
+
+/// merge record of the patient in the collection, nil when the patient was not merged
+func readPatientMerge(ctx contractapi.TransactionContextInterface, collection string, pid string) (*PatientMerge, error) {
+
+	mergeKey, err := ctx.GetStub().CreateCompositeKey(patientMergeObjectType, []string{pid})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	mergeJSON, err := ctx.GetStub().GetPrivateData(collection, mergeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient merge: %v", err)
+	}
+
+	if mergeJSON == nil {
+		return nil, nil
+	}
+
+	var merge PatientMerge
+	err = unmarshalStoredObject(mergeJSON, &merge)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal patient merge: %v", err)
+	}
+
+	return &merge, nil
+}
+
+/// patient data stored in the org collection, shared patient data cannot be merged or moved
+func readOrgPatient(ctx contractapi.TransactionContextInterface, collection string, pid string) (*PatientInfo, error) {
+
+	assetDataJSON, err := ctx.GetStub().GetPrivateData(collection, pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read asset: %v", err)
+	}
+	if assetDataJSON == nil {
+		return nil, fmt.Errorf("Asset %v does not exists in collection %v", pid, collection)
+	}
+
+	assetData := &PatientInfo{}
+	err = unmarshalStoredObject(assetDataJSON, assetData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	return assetData, nil
+}
+
+/// set the salt of the index hashes passed in the transient map under "salt"
+/// the salt cannot be changed, the stored hashes would not match anymore
+func (s *SmartContract) SetPatientIndexSalt(ctx contractapi.TransactionContextInterface) error {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	salt, ok := transientMap["salt"]
+	if !ok || len(salt) < minIndexSaltLength {
+		return fmt.Errorf("Salt of at least %v bytes not found in the transient map", minIndexSaltLength)
+	}
+
+	existing, err := readIndexSalt(ctx)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("Patient index salt is already set")
+	}
+
+	saltKey, err := ctx.GetStub().CreateCompositeKey(patientIndexSaltObjectType, []string{})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("SetPatientIndexSalt Put: collection %v, Key %v", org1AndOrg2PrivateCollection, saltKey)
+	return ctx.GetStub().PutPrivateData(org1AndOrg2PrivateCollection, saltKey, salt)
+}
+
+/// index a patient of the org collection, for patients registered before the salt was set
+func (s *SmartContract) IndexPatient(ctx contractapi.TransactionContextInterface, pid string) (*PatientIndexEntry, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assetData, err := readOrgPatient(ctx, orgCollectionName, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := indexPatient(ctx, assetData)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, fmt.Errorf("Patient index salt is not set")
+	}
+
+	return entry, nil
+}
+
+/// index entries of the patients of the org that have probable duplicates
+func (s *SmartContract) GetProbableDuplicates(ctx contractapi.TransactionContextInterface) ([]*PatientIndexEntry, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(org1AndOrg2PrivateCollection, patientIndexObjectType, []string{org})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entries := []*PatientIndexEntry{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry PatientIndexEntry
+		err = unmarshalStoredObject(response.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot unmarshal patient index entry: %v", err)
+		}
+
+		if len(entry.ProbableDuplicates) != 0 {
+			entries = append(entries, &entry)
+		}
+	}
+
+	return entries, nil
+}
+
+/// record that the two patients are different people, they are not flagged again
+func (s *SmartContract) DismissProbableDuplicate(ctx contractapi.TransactionContextInterface, pid string, otherOrg string, otherPID string) error {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	ref := PatientRef{PID: pid, Org: org}
+	other := PatientRef{PID: otherPID, Org: otherOrg}
+
+	entry, err := readPatientIndexEntry(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if entry == nil || entry.unflagDuplicate(other) == nil {
+		return fmt.Errorf("Patient %v of %v is not flagged as a duplicate of %v of %v", otherPID, otherOrg, pid, org)
+	}
+
+	entry.Dismissed = append(entry.Dismissed, other)
+	entry.UpdatedAt = now
+
+	err = putPatientIndexEntry(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	otherEntry, err := readPatientIndexEntry(ctx, other)
+	if err != nil || otherEntry == nil {
+		return err
+	}
+
+	otherEntry.unflagDuplicate(ref)
+	otherEntry.Dismissed = append(otherEntry.Dismissed, ref)
+	otherEntry.UpdatedAt = now
+
+	return putPatientIndexEntry(ctx, otherEntry)
+}
+
+/// merge the duplicate patient of the org into the surviving one
+/// the medical records, doctors and owners of the duplicate move to the survivor,
+/// the personal info and research consent of the survivor are kept
+/// records keep the owner they were issued and signed for, the survivor keeps the merged ids
+/// patients must be flagged as probable duplicates by the index, otherwise the admin
+/// gives the reason to override it, kept in the merge record
+/// patient data with a data key cannot be merged, the records are encrypted with different keys
+func (s *SmartContract) MergePatients(ctx contractapi.TransactionContextInterface, survivorID string, duplicateID string, overrideReason string) (*PatientMerge, error) {
+
+	adminID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if survivorID == duplicateID {
+		return nil, fmt.Errorf("Cannot merge a patient into itself")
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	survivor, err := readOrgPatient(ctx, orgCollectionName, survivorID)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot merge patients: %v", err)
+	}
+
+	duplicate, err := readOrgPatient(ctx, orgCollectionName, duplicateID)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot merge patients: %v", err)
+	}
+
+	if survivor.DataKey != nil || duplicate.DataKey != nil {
+		return nil, fmt.Errorf("Cannot merge patients: patient data with a data key cannot be merged")
+	}
+
+	for _, pid := range []string{survivorID, duplicateID} {
+		transfer, err := readPatientTransfer(ctx, pid)
+		if err == nil && transfer.Status != transferCompleted {
+			return nil, fmt.Errorf("Cannot merge patients: transfer of %v is %v", pid, transfer.Status)
+		}
+	}
+
+	/// the duplicate must be flagged on the survivor, unless the admin overrides the index
+	survivorRef := PatientRef{PID: survivorID, Org: org}
+	duplicateRef := PatientRef{PID: duplicateID, Org: org}
+
+	survivorEntry, err := readPatientIndexEntry(ctx, survivorRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var match *DuplicateMatch
+	if survivorEntry != nil {
+		match = survivorEntry.unflagDuplicate(duplicateRef)
+	}
+
+	overrideReason = strings.TrimSpace(overrideReason)
+	if match == nil && len(overrideReason) == 0 {
+		return nil, fmt.Errorf("Cannot merge patients: %v is not flagged as a probable duplicate of %v, give the reason to override", duplicateID, survivorID)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	merge := &PatientMerge{
+		SurvivorID: survivorID,
+		MergedID: duplicateID,
+		Org: org,
+		MergedBy: adminID,
+		MergedAt: now,
+		TxID: ctx.GetStub().GetTxID(),
+		Matches: []string{},
+		RecordIDs: []string{},
+		Doctors: []string{},
+		OverrideReason: overrideReason,
+	}
+	if match != nil {
+		merge.Matches = match.Matches
+	}
+
+	for _, record := range duplicate.MedicalRecords {
+		survivor.MedicalRecords = append(survivor.MedicalRecords, record)
+		merge.RecordIDs = append(merge.RecordIDs, record.ID)
+	}
+
+	for _, doctorID := range duplicate.TreatedBy {
+		merge.Doctors = append(merge.Doctors, doctorID)
+
+		/// a doctor of both patients keeps the access they had to the survivor
+		if survivor.addDoctorInfo(doctorID) == nil {
+			if scope, ok := duplicate.AccessScopes[doctorID]; ok {
+				survivor.setAccessScope(doctorID, scope)
+			}
+		}
+
+		doctorData, err := s.ReadDoctorPrivateData(ctx, doctorID)
+		if err != nil {
+			/// doctor of another org, nothing to update here
+			continue
+		}
+
+		doctorData.removePID(duplicateID)
+		if !doctorData.checkPIDExists(survivorID) {
+			doctorData.AddPID(survivorID)
+		}
+
+		err = s.putDoctorData(ctx, doctorData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, owner := range duplicate.Owners {
+		if survivor.checkOwner(owner) != nil {
+			survivor.addOwner(owner)
+		}
+	}
+
+	/// the moved records are signed for the duplicate id, or the ids merged into it
+	survivor.MergedIDs = append(survivor.MergedIDs, duplicateID)
+	survivor.MergedIDs = append(survivor.MergedIDs, duplicate.MergedIDs...)
+
+	/// the merge is logged on both patients
+	err = s.recordAccess(ctx, duplicate, accessWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.putAssetData(ctx, survivor)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("MergePatients Delete: collection %v, ID %v", orgCollectionName, duplicateID)
+	err = ctx.GetStub().DelPrivateData(orgCollectionName, duplicateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete asset: %v", err)
+	}
+
+	/// a pending access request to the duplicate cannot be granted anymore
+	requestKey, err := ctx.GetStub().CreateCompositeKey(dataAccessRequestObjectType, []string{duplicateID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().DelPrivateData(orgCollectionName, requestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete data access request: %v", err)
+	}
+
+	/// the merged patient stays in the index, without hashes, pointing to the survivor
+	if survivorEntry != nil {
+		survivorEntry.UpdatedAt = now
+		err = putPatientIndexEntry(ctx, survivorEntry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	duplicateEntry, err := readPatientIndexEntry(ctx, duplicateRef)
+	if err != nil {
+		return nil, err
+	}
+	if duplicateEntry != nil {
+		err = deleteIndexHashes(ctx, duplicateEntry)
+		if err != nil {
+			return nil, err
+		}
+		err = unlinkDuplicates(ctx, duplicateEntry)
+		if err != nil {
+			return nil, err
+		}
+		duplicateEntry.MergedInto = survivorID
+		duplicateEntry.UpdatedAt = now
+		err = putPatientIndexEntry(ctx, duplicateEntry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mergeKey, err := ctx.GetStub().CreateCompositeKey(patientMergeObjectType, []string{duplicateID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	mergeJSON, err := marshalStoredObject(merge)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal patient merge: %v", err)
+	}
+
+	log.Printf("MergePatients Put: collection %v, ID %v, Key %v", orgCollectionName, duplicateID, mergeKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, mergeKey, mergeJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put patient merge: %v", err)
+	}
+
+	return merge, nil
+}
+
+/// read the merge record of a patient merged in the org of the client
+func (s *SmartContract) ReadPatientMerge(ctx contractapi.TransactionContextInterface, pid string) (*PatientMerge, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	merge, err := readPatientMerge(ctx, orgCollectionName, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if merge == nil {
+		return nil, fmt.Errorf("Patient %v was not merged in %v", pid, orgCollectionName)
+	}
+
+	return merge, nil
+}
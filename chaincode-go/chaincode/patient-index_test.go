@@ -0,0 +1,155 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) registerPatientWithInfo(patient *fabrictest.Identity, info ClientPersonalInfo) {
+	assetData, err := json.Marshal(PatientInfo{PersonalInfo: info})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	tx := sc.network.NewTransaction(patient, "RegisterPatient").WithTransient("asset_data", assetData)
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.RegisterPatient(ctx)
+	})
+	if err != nil {
+		sc.t.Fatalf("RegisterPatient: %v", err)
+	}
+}
+
+func (sc *scenario) probableDuplicates(admin *fabrictest.Identity) []*PatientIndexEntry {
+	var entries []*PatientIndexEntry
+	err := sc.network.NewTransaction(admin, "GetProbableDuplicates").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		entries, err = sc.contract.GetProbableDuplicates(ctx)
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("GetProbableDuplicates: %v", err)
+	}
+	return entries
+}
+
+func TestPatientIndexAndMerge(t *testing.T) {
+	sc, _, _, specialist := newAppointedScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	duplicate := sc.client("Org1MSP", "patient", "0002P")
+	sibling := sc.client("Org1MSP", "patient", "0003P")
+
+	err := sc.network.NewTransaction(admin, "SetPatientIndexSalt").WithTransient("salt", []byte("0123456789abcdef0123456789abcdef")).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.SetPatientIndexSalt(ctx)
+	})
+	if err != nil {
+		t.Fatalf("SetPatientIndexSalt: %v", err)
+	}
+
+	/// registered before the salt was set
+	err = sc.submit(admin, "IndexPatient", func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.IndexPatient(ctx, "0001P")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("IndexPatient: %v", err)
+	}
+
+	/// the same email and phone written differently
+	info := testPersonalInfo("Alice", "patient")
+	info.Email = " Alice@Example.com"
+	info.ContactNumber = "555-0100"
+	sc.registerPatientWithInfo(duplicate, info)
+
+	/// shares the phone only
+	info = testPersonalInfo("Alice", "patient")
+	info.Email = "sister@example.com"
+	sc.registerPatientWithInfo(sibling, info)
+
+	err = sc.submit(duplicate, "AppointDoctor", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AppointDoctor(ctx, "0002D")
+	})
+	if err != nil {
+		t.Fatalf("AppointDoctor: %v", err)
+	}
+
+	flagged := map[string][]DuplicateMatch{}
+	for _, entry := range sc.probableDuplicates(admin) {
+		flagged[entry.PID] = entry.ProbableDuplicates
+		if len(entry.Hashes[indexEmail]) == 0 || strings.Contains(entry.Hashes[indexEmail], "example") {
+			t.Errorf("email hash of %v = %q", entry.PID, entry.Hashes[indexEmail])
+		}
+	}
+	if len(flagged) != 2 || len(flagged["0001P"]) != 1 || flagged["0001P"][0].PID != "0002P" {
+		t.Fatalf("flagged duplicates %v", flagged)
+	}
+
+	var merge *PatientMerge
+	err = sc.submit(admin, "MergePatients", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		merge, err = sc.contract.MergePatients(ctx, "0001P", "0002P", "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("MergePatients: %v", err)
+	}
+	if !containsID(merge.Matches, indexEmail) || !containsID(merge.Matches, indexPhone) || !containsID(merge.Doctors, "0002D") {
+		t.Errorf("merge record %+v", merge)
+	}
+
+	survivor := sc.readPatient(admin, "0001P")
+	if !containsID(survivor.TreatedBy, "0001D") || !containsID(survivor.TreatedBy, "0002D") {
+		t.Errorf("survivor treated by %v", survivor.TreatedBy)
+	}
+
+	doctorData := sc.readDoctor(specialist, "0002D")
+	if !containsID(doctorData.PIDS, "0001P") || containsID(doctorData.PIDS, "0002P") {
+		t.Errorf("doctor patients %v", doctorData.PIDS)
+	}
+
+	err = sc.network.NewTransaction(specialist, "readAssetPrivateData", "0002P").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.readAssetPrivateData(ctx, "0002P")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "merged into 0001P") {
+		t.Errorf("read of the merged patient: %v", err)
+	}
+
+	if entries := sc.probableDuplicates(admin); len(entries) != 0 {
+		t.Errorf("duplicates left after the merge: %v", len(entries))
+	}
+
+	err = sc.submit(admin, "MergePatients", func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.MergePatients(ctx, "0001P", "0002P", "")
+		return err
+	})
+	if err == nil {
+		t.Errorf("merged patient merged again")
+	}
+
+	/// the sibling is not flagged, the admin must give the reason to override the index
+	err = sc.submit(admin, "MergePatients", func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.MergePatients(ctx, "0001P", "0003P", " ")
+		return err
+	})
+	if err == nil {
+		t.Fatalf("patient merged without a flagged match")
+	}
+	err = sc.submit(admin, "MergePatients", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		merge, err = sc.contract.MergePatients(ctx, "0001P", "0003P", "same patient, confirmed at the front desk")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("MergePatients with override: %v", err)
+	}
+	if merge.OverrideReason != "same patient, confirmed at the front desk" || len(merge.Matches) != 0 {
+		t.Errorf("override merge record %+v", merge)
+	}
+	if mergedIDs := sc.readPatient(admin, "0001P").MergedIDs; !containsID(mergedIDs, "0002P") || !containsID(mergedIDs, "0003P") {
+		t.Errorf("survivor merged ids %v", mergedIDs)
+	}
+}
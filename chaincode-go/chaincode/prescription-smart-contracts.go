@@ -0,0 +1,472 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"strings"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const prescriptionObjectType = "prescription"
+const prescriptionCodeObjectType = "prescriptionCode"
+
+/// prescription can be filled for this many days after it is issued,
+/// every refill extends the window by the duration of the prescription
+const prescriptionValidityDays = 30
+
+/// content of the prescription signed by the issuing doctor
+type PrescriptionContent struct {
+	PID string `json:"pid"`
+	Drug string `json:"drug"`
+	Dose string `json:"dose"`
+	Frequency string `json:"frequency"`
+	DurationDays int `json:"durationDays"`
+	Refills int `json:"refills"`
+}
+
+/// dispensing event recorded by the pharmacy
+type Dispensing struct {
+	PharmacyID string `json:"pharmacyId"`
+	TxID string `json:"txId"`
+	DispensedAt time.Time `json:"dispensedAt"`
+}
+
+type Prescription struct {
+	SchemaVersion int `json:"schemaVersion"`
+	ID string `json:"id"`
+	Content PrescriptionContent `json:"content"`
+	IssuedBy string `json:"issuedBy"`
+	DoctorSign string `json:"doctorSign"`
+	CodeHash string `json:"codeHash"`
+	IssuedAt time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Dispensings []Dispensing `json:"dispensings"`
+}
+
+type Prescriptions struct {
+	Data []Prescription `json:"data"`
+}
+
+/// prescription input passed in the transient map
+type prescriptionInput struct {
+	Content PrescriptionContent `json:"content"`
+	Code string `json:"code"`
+	DoctorSign string `json:"doctorSign"`
+}
+
+/// pointer from the patient presented code to the prescription
+type prescriptionCodeIndex struct {
+	SchemaVersion int `json:"schemaVersion"`
+	PID string `json:"pid"`
+	ID string `json:"id"`
+}
+
+func (p *Prescription) objectType() string {
+	return prescriptionObjectType
+}
+
+func (p *Prescription) setSchemaVersion(version int) {
+	p.SchemaVersion = version
+}
+
+func (pci *prescriptionCodeIndex) objectType() string {
+	return prescriptionCodeObjectType
+}
+
+func (pci *prescriptionCodeIndex) setSchemaVersion(version int) {
+	pci.SchemaVersion = version
+}
+
+func (pc *PrescriptionContent) validate() error {
+	if len(pc.PID) == 0 {
+		return fmt.Errorf("Patient id is required")
+	}
+	if len(pc.Drug) == 0 || len(pc.Dose) == 0 || len(pc.Frequency) == 0 {
+		return fmt.Errorf("Drug, dose and frequency are required")
+	}
+	if pc.DurationDays <= 0 {
+		return fmt.Errorf("Duration field value is not valid")
+	}
+	if pc.Refills < 0 {
+		return fmt.Errorf("Refills field value is not valid")
+	}
+	return nil
+}
+
+/// dispensing is allowed once plus once per refill, and only before expiry
+func (p *Prescription) addDispensing(dispensing Dispensing) error {
+	if dispensing.DispensedAt.After(p.ExpiresAt) {
+		return fmt.Errorf("Prescription %v expired on %v", p.ID, p.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if len(p.Dispensings) > p.Content.Refills {
+		return fmt.Errorf("Prescription %v has already been dispensed %v times", p.ID, len(p.Dispensings))
+	}
+
+	p.Dispensings = append(p.Dispensings, dispensing)
+
+	return nil
+}
+
+/// only the hash of the code is stored, the code itself stays with the patient
+func hashPrescriptionCode(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == 0 {
+		return "", fmt.Errorf("Prescription code is required")
+	}
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+/// issue prescription to the patient
+/// the prescription content, code and doctor signature are passed in the transient map
+func (s *SmartContract) IssuePrescription(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	/// check if the client is doctor
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return "", fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return "", fmt.Errorf("Only Doctor can issue prescription")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return "", fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	/// verify client org and peer org
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Issue prescription cannot be performed: Error %v", err)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	prescriptionJSON, ok := transientMap["prescription_data"]
+	if !ok {
+		return "", fmt.Errorf("prescription data not found in the transient map")
+	}
+
+	var input prescriptionInput
+	err = json.Unmarshal(prescriptionJSON, &input)
+	if err != nil {
+		return "", fmt.Errorf("Error cannot unmarshal: %v", err)
+	}
+
+	err = input.Content.validate()
+	if err != nil {
+		return "", fmt.Errorf("Cannot issue prescription: %v", err)
+	}
+
+	/// only a treating doctor can issue prescription
+	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("Cannot Read doctor data: %v", err)
+	}
+
+	if !doctorData.checkPIDExists(input.Content.PID) {
+		return "", fmt.Errorf("Cannot issue prescription to this patient")
+	}
+
+	/// doctor signature on the prescription content
+	contentJSON, err := canonical.Marshal(input.Content)
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal prescription content: %v", err)
+	}
+
+	err = verifyClientSignature(ctx, contentJSON, input.DoctorSign)
+	if err != nil {
+		return "", fmt.Errorf("Cannot issue prescription: %v", err)
+	}
+
+	codeHash, err := hashPrescriptionCode(input.Code)
+	if err != nil {
+		return "", err
+	}
+
+	/// code must not be in use already
+	if _, err = s.readPrescriptionCodeIndex(ctx, codeHash); err == nil {
+		return "", fmt.Errorf("Prescription code already in use")
+	}
+
+	issuedAt, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	validDays := prescriptionValidityDays + input.Content.DurationDays * input.Content.Refills
+
+	prescription := Prescription{
+		ID: ctx.GetStub().GetTxID(),
+		Content: input.Content,
+		IssuedBy: id,
+		DoctorSign: input.DoctorSign,
+		CodeHash: codeHash,
+		IssuedAt: issuedAt,
+		ExpiresAt: issuedAt.AddDate(0, 0, validDays),
+		Dispensings: []Dispensing{},
+	}
+
+	err = s.putPrescription(ctx, &prescription)
+	if err != nil {
+		return "", err
+	}
+
+	/// code index
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	codeKey, err := ctx.GetStub().CreateCompositeKey(prescriptionCodeObjectType, []string{codeHash})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	indexJSON, err := marshalStoredObject(&prescriptionCodeIndex{PID: prescription.Content.PID, ID: prescription.ID})
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal prescription code index: %v", err)
+	}
+
+	log.Printf("Prescription code Put: collection %v, Key %v", orgCollectionName, codeKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, codeKey, indexJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to put prescription code: %v", err)
+	}
+
+	return prescription.ID, nil
+}
+
+/// pharmacy looks up the prescription using the code presented by the patient
+/// the code is passed in the transient map
+func (s *SmartContract) ReadPrescriptionByCode(ctx contractapi.TransactionContextInterface) (*Prescription, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "pharmacy" {
+		return nil, fmt.Errorf("Only Pharmacy can look up prescription by code")
+	}
+
+	return s.readPrescriptionFromTransientCode(ctx)
+}
+
+/// pharmacy records dispensing of the prescription
+/// expired and fully dispensed prescriptions are rejected
+func (s *SmartContract) DispensePrescription(ctx contractapi.TransactionContextInterface) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "pharmacy" {
+		return fmt.Errorf("Only Pharmacy can dispense prescription")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	prescription, err := s.readPrescriptionFromTransientCode(ctx)
+	if err != nil {
+		return err
+	}
+
+	dispensedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = prescription.addDispensing(Dispensing{PharmacyID: id, TxID: ctx.GetStub().GetTxID(), DispensedAt: dispensedAt})
+	if err != nil {
+		return fmt.Errorf("Cannot dispense prescription: %v", err)
+	}
+
+	return s.putPrescription(ctx, prescription)
+}
+
+/// get prescriptions of the patient client
+func (s *SmartContract) GetPrescriptions(ctx contractapi.TransactionContextInterface) (*Prescriptions, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get prescriptions: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return nil, fmt.Errorf("Cannot get prescriptions: client is not patient")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get prescriptions: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot execute the smart contract: Error %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(orgCollectionName, prescriptionObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results := []Prescription{}
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var prescription Prescription
+		err = unmarshalStoredObject(response.Value, &prescription)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		results = append(results, prescription)
+	}
+
+	return &Prescriptions{Data: results}, nil
+}
+
+/// read prescription of the code passed in the transient map
+func (s *SmartContract) readPrescriptionFromTransientCode(ctx contractapi.TransactionContextInterface) (*Prescription, error) {
+
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Read prescription cannot be performed: Error %v", err)
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	code, ok := transientMap["prescription_code"]
+	if !ok {
+		return nil, fmt.Errorf("prescription code not found in the transient map")
+	}
+
+	codeHash, err := hashPrescriptionCode(string(code))
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := s.readPrescriptionCodeIndex(ctx, codeHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.readPrescription(ctx, index.PID, index.ID)
+}
+
+func (s *SmartContract) readPrescriptionCodeIndex(ctx contractapi.TransactionContextInterface, codeHash string) (*prescriptionCodeIndex, error) {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codeKey, err := ctx.GetStub().CreateCompositeKey(prescriptionCodeObjectType, []string{codeHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	indexJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, codeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prescription code: %v", err)
+	}
+
+	if indexJSON == nil {
+		return nil, fmt.Errorf("Prescription not found for the code")
+	}
+
+	var index prescriptionCodeIndex
+	err = unmarshalStoredObject(indexJSON, &index)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal prescription code index: %v", err)
+	}
+
+	return &index, nil
+}
+
+func (s *SmartContract) readPrescription(ctx contractapi.TransactionContextInterface, pid string, prescriptionID string) (*Prescription, error) {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prescriptionKey, err := ctx.GetStub().CreateCompositeKey(prescriptionObjectType, []string{pid, prescriptionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("ReadPrescription: collection %v, ID %v", orgCollectionName, prescriptionID)
+	prescriptionJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, prescriptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prescription: %v", err)
+	}
+
+	if prescriptionJSON == nil {
+		return nil, fmt.Errorf("Prescription %v does not exist", prescriptionID)
+	}
+
+	var prescription Prescription
+	err = unmarshalStoredObject(prescriptionJSON, &prescription)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal prescription: %v", err)
+	}
+
+	return &prescription, nil
+}
+
+func (s *SmartContract) putPrescription(ctx contractapi.TransactionContextInterface, prescription *Prescription) error {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+
+	prescriptionKey, err := ctx.GetStub().CreateCompositeKey(prescriptionObjectType, []string{prescription.Content.PID, prescription.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	prescriptionJSON, err := marshalStoredObject(prescription)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal prescription: %v", err)
+	}
+
+	log.Printf("Prescription Put: collection %v, ID %v, Key %v", orgCollectionName, prescription.ID, prescriptionKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, prescriptionKey, prescriptionJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put prescription: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package chaincode
+
+import (
+	"time"
+	"bytes"
+	"strings"
+	"testing"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) issuePrescription(doctor *fabrictest.Identity, code string, content PrescriptionContent) (string, error) {
+	contentJSON, err := canonical.Marshal(content)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	doctorSign, err := doctor.Sign(contentJSON)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	inputJSON, err := json.Marshal(prescriptionInput{Content: content, Code: code, DoctorSign: doctorSign})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	var prescriptionID string
+	tx := sc.network.NewTransaction(doctor, "IssuePrescription").WithTransient("prescription_data", inputJSON)
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		prescriptionID, err = sc.contract.IssuePrescription(ctx)
+		return err
+	})
+	return prescriptionID, err
+}
+
+func (sc *scenario) dispensePrescription(pharmacy *fabrictest.Identity, code string) error {
+	tx := sc.network.NewTransaction(pharmacy, "DispensePrescription").WithTransient("prescription_code", []byte(code))
+	return tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.DispensePrescription(ctx)
+	})
+}
+
+func (sc *scenario) readPrescriptionByCode(pharmacy *fabrictest.Identity, code string) (*Prescription, error) {
+	var prescription *Prescription
+	tx := sc.network.NewTransaction(pharmacy, "ReadPrescriptionByCode").WithTransient("prescription_code", []byte(code))
+	err := tx.Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		prescription, err = sc.contract.ReadPrescriptionByCode(ctx)
+		return err
+	})
+	return prescription, err
+}
+
+func testPrescriptionContent(refills int) PrescriptionContent {
+	return PrescriptionContent{PID: "0001P", Drug: "amoxicillin", Dose: "500mg", Frequency: "3/day", DurationDays: 10, Refills: refills}
+}
+
+func TestDispensePrescriptionRefills(t *testing.T) {
+	sc, _, doctor, _ := newAppointedScenario(t)
+	pharmacy := sc.client("Org1MSP", "pharmacy", "0001H")
+
+	if _, err := sc.issuePrescription(doctor, "code-refills", testPrescriptionContent(1)); err != nil {
+		t.Fatalf("IssuePrescription: %v", err)
+	}
+
+	/// dispensed once plus once per refill
+	for i := 0; i < 2; i++ {
+		if err := sc.dispensePrescription(pharmacy, "code-refills"); err != nil {
+			t.Fatalf("DispensePrescription %v: %v", i, err)
+		}
+	}
+
+	err := sc.dispensePrescription(pharmacy, "code-refills")
+	if err == nil || !strings.Contains(err.Error(), "already been dispensed 2 times") {
+		t.Errorf("prescription dispensed beyond its refills: %v", err)
+	}
+
+	prescription, err := sc.readPrescriptionByCode(pharmacy, "code-refills")
+	if err != nil {
+		t.Fatalf("ReadPrescriptionByCode: %v", err)
+	}
+	if len(prescription.Dispensings) != 2 || prescription.Dispensings[0].PharmacyID != "0001H" {
+		t.Errorf("dispensings = %+v", prescription.Dispensings)
+	}
+}
+
+func TestDispensePrescriptionExpired(t *testing.T) {
+	sc, _, doctor, _ := newAppointedScenario(t)
+	pharmacy := sc.client("Org1MSP", "pharmacy", "0001H")
+
+	if _, err := sc.issuePrescription(doctor, "code-expiry", testPrescriptionContent(0)); err != nil {
+		t.Fatalf("IssuePrescription: %v", err)
+	}
+
+	prescription, err := sc.readPrescriptionByCode(pharmacy, "code-expiry")
+	if err != nil {
+		t.Fatalf("ReadPrescriptionByCode: %v", err)
+	}
+	if !prescription.ExpiresAt.Equal(prescription.IssuedAt.AddDate(0, 0, prescriptionValidityDays)) {
+		t.Fatalf("prescription issued at %v expires at %v", prescription.IssuedAt, prescription.ExpiresAt)
+	}
+
+	sc.network.Advance(time.Duration(prescriptionValidityDays) * 24 * time.Hour)
+
+	err = sc.dispensePrescription(pharmacy, "code-expiry")
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("prescription dispensed after its expiry: %v", err)
+	}
+
+	prescription, err = sc.readPrescriptionByCode(pharmacy, "code-expiry")
+	if err != nil {
+		t.Fatalf("ReadPrescriptionByCode: %v", err)
+	}
+	if len(prescription.Dispensings) != 0 {
+		t.Errorf("expired prescription dispensings = %+v", prescription.Dispensings)
+	}
+}
+
+func TestPrescriptionLookupByHashedCode(t *testing.T) {
+	sc, _, doctor, _ := newAppointedScenario(t)
+	pharmacy := sc.client("Org1MSP", "pharmacy", "0001H")
+
+	prescriptionID, err := sc.issuePrescription(doctor, "secret-code", testPrescriptionContent(0))
+	if err != nil {
+		t.Fatalf("IssuePrescription: %v", err)
+	}
+
+	/// only the hash of the code is stored
+	hash := sha256.Sum256([]byte("secret-code"))
+	codeHash := hex.EncodeToString(hash[:])
+	if sc.network.PrivateData(org1CollectionName, "\x00"+prescriptionCodeObjectType+"\x00"+codeHash+"\x00") == nil {
+		t.Fatalf("prescription code not indexed by its hash")
+	}
+	stored := sc.network.PrivateData(org1CollectionName, "\x00"+prescriptionObjectType+"\x000001P\x00"+prescriptionID+"\x00")
+	if stored == nil || bytes.Contains(stored, []byte("secret-code")) {
+		t.Fatalf("stored prescription = %s", stored)
+	}
+
+	/// the presented code is trimmed before it is hashed
+	prescription, err := sc.readPrescriptionByCode(pharmacy, " secret-code\n")
+	if err != nil {
+		t.Fatalf("ReadPrescriptionByCode: %v", err)
+	}
+	if prescription.ID != prescriptionID || prescription.CodeHash != codeHash {
+		t.Errorf("prescription = %+v", prescription)
+	}
+
+	if _, err := sc.readPrescriptionByCode(pharmacy, "wrong-code"); err == nil {
+		t.Errorf("prescription found for a wrong code")
+	}
+	if err := sc.dispensePrescription(pharmacy, "wrong-code"); err == nil {
+		t.Errorf("prescription dispensed for a wrong code")
+	}
+
+	/// the code hash is unique
+	if _, err := sc.issuePrescription(doctor, "secret-code", testPrescriptionContent(1)); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("second prescription issued with the same code: %v", err)
+	}
+
+	/// the code lookup is for the pharmacy only
+	if _, err := sc.readPrescriptionByCode(doctor, "secret-code"); err == nil {
+		t.Errorf("prescription looked up by a doctor")
+	}
+}
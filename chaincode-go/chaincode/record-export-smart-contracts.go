@@ -0,0 +1,159 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// doctor granted access to the patient data, all record types when none are listed
+type RecordGrant struct {
+	DoctorID string `json:"doctorId"`
+	RecordTypes []string `json:"recordTypes,omitempty"`
+}
+
+/// everything the ledger holds about the patient
+/// records are exported as stored, encrypted reports stay encrypted
+/// the certificates of the signing doctors are included by fingerprint,
+/// so the record signatures can be verified without the ledger
+type PatientRecordExport struct {
+	PID string `json:"pid"`
+	/// ids merged into the patient, records moved by a merge are owned by one of them
+	MergedIDs []string `json:"mergedIds,omitempty"`
+	Org string `json:"org"`
+	PersonalInfo ClientPersonalInfo `json:"personalInfo"`
+	MedicalRecords []MedicalInfo `json:"medicalRecords"`
+	Grants []RecordGrant `json:"grants"`
+	ResearchConsent bool `json:"researchConsent"`
+	AccessLog []AccessLogEntry `json:"accessLog"`
+	SignatureChecks []RecordSignatureCheck `json:"signatureChecks"`
+	Certificates map[string]string `json:"certificates"`
+	CreatedAt time.Time `json:"createdAt"`
+	TxID string `json:"txId"`
+}
+
+/// the digest is the hex sha256 of the canonical form (RFC 8785) of the export
+/// the hospital signs the digest, the recipient recomputes it from the export
+type PatientRecordBundle struct {
+	Export PatientRecordExport `json:"export"`
+	Digest string `json:"digest"`
+}
+
+/// sha256 of the patient record export
+func recordExportDigest(export *PatientRecordExport) (string, error) {
+	exportJSON, err := canonical.Marshal(export)
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal record export: %v", err)
+	}
+	hash := sha256.Sum256(exportJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+/// doctors treating the patient with the record types they are limited to
+func (pi *PatientInfo) grants() []RecordGrant {
+	grants := []RecordGrant{}
+	for _, doctorID := range pi.TreatedBy {
+		grants = append(grants, RecordGrant{DoctorID: doctorID, RecordTypes: pi.AccessScopes[doctorID]})
+	}
+	return grants
+}
+
+/// export the demographics, medical records, grants and access log of the invoked patient
+/// the export is logged as a read of the patient data
+/// unsigned records have no signature check
+func (s *SmartContract) ExportMyRecord(ctx contractapi.TransactionContextInterface) (*PatientRecordBundle, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return nil, fmt.Errorf("Only Patient can export their record")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	accessLog, err := readAccessLog(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	createdAt, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	export := PatientRecordExport{
+		PID: assetData.ID,
+		MergedIDs: assetData.MergedIDs,
+		Org: org,
+		PersonalInfo: assetData.PersonalInfo,
+		MedicalRecords: assetData.MedicalRecords,
+		Grants: assetData.grants(),
+		ResearchConsent: assetData.ResearchConsent,
+		AccessLog: accessLog,
+		SignatureChecks: []RecordSignatureCheck{},
+		Certificates: map[string]string{},
+		CreatedAt: createdAt,
+		TxID: ctx.GetStub().GetTxID(),
+	}
+
+	for i := range export.MedicalRecords {
+		medicalData := &export.MedicalRecords[i]
+		if len(medicalData.DoctorSign) == 0 || len(medicalData.CertFingerprint) == 0 {
+			continue
+		}
+
+		valid, err := verifyMedicalRecordSignature(ctx, medicalData)
+		if err != nil {
+			return nil, err
+		}
+
+		export.SignatureChecks = append(export.SignatureChecks, RecordSignatureCheck{
+			RecordID: medicalData.ID,
+			IssuedBy: medicalData.IssuedBy,
+			CertFingerprint: medicalData.CertFingerprint,
+			Valid: valid,
+		})
+
+		if _, ok := export.Certificates[medicalData.CertFingerprint]; ok {
+			continue
+		}
+
+		certPEM, err := readCertificatePEM(ctx, medicalData.CertFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		export.Certificates[medicalData.CertFingerprint] = string(certPEM)
+	}
+
+	digest, err := recordExportDigest(&export)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordAccess(ctx, assetData, accessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PatientRecordBundle{Export: export, Digest: digest}, nil
+}
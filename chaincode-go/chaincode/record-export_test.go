@@ -0,0 +1,106 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// medical record of the patient signed by the doctor
+func (sc *scenario) addSignedMedicalRecord(doctor *fabrictest.Identity, doctorID, pid string) {
+	err := sc.addSignedRecord(doctor, doctorID, pid, "CBC", sc.network.Now().Add(-time.Hour))
+	if err != nil {
+		sc.t.Fatalf("AddMedicalRecord: %v", err)
+	}
+}
+
+func (sc *scenario) addSignedRecord(doctor *fabrictest.Identity, doctorID, pid, recordType string, collectedAt time.Time) error {
+	medicalData := MedicalInfo{
+		Type: recordType,
+		MReport: map[string]string{"haemoglobin": "13.5"},
+		CollectedAt: &collectedAt,
+		Owner: pid,
+		IssuedBy: doctorID,
+	}
+
+	content, err := json.Marshal(MedicalRecordContent{Type: medicalData.Type, MReport: medicalData.MReport, CollectedAt: collectedAt.Format(time.RFC3339Nano), Owner: medicalData.Owner, IssuedBy: medicalData.IssuedBy})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	medicalData.DoctorSign, err = doctor.SignJSON(content)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	medicalDataJSON, err := json.Marshal(medicalData)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	tx := sc.network.NewTransaction(doctor, "AddMedicalRecord", pid).WithTransient("medical_data", medicalDataJSON)
+	return tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, pid)
+	})
+}
+
+func (sc *scenario) exportMyRecord(client *fabrictest.Identity) (*PatientRecordBundle, error) {
+	var bundle *PatientRecordBundle
+	err := sc.submit(client, "ExportMyRecord", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		bundle, err = sc.contract.ExportMyRecord(ctx)
+		return err
+	})
+	return bundle, err
+}
+
+func TestExportMyRecord(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+	sc.addSignedMedicalRecord(doctor, "0001D", "0001P")
+
+	if _, err := sc.exportMyRecord(doctor); err == nil {
+		t.Fatalf("record exported by a doctor")
+	}
+
+	bundle, err := sc.exportMyRecord(patient)
+	if err != nil {
+		t.Fatalf("ExportMyRecord: %v", err)
+	}
+
+	export := bundle.Export
+	if export.PID != "0001P" || export.Org != "Org1MSP" || len(export.MedicalRecords) != 1 {
+		t.Fatalf("export = %+v", export)
+	}
+	if len(export.Grants) != 1 || export.Grants[0].DoctorID != "0001D" {
+		t.Errorf("grants = %+v", export.Grants)
+	}
+	if len(export.AccessLog) == 0 {
+		t.Errorf("access log not exported")
+	}
+
+	if len(export.SignatureChecks) != 1 || !export.SignatureChecks[0].Valid {
+		t.Fatalf("signature checks = %+v", export.SignatureChecks)
+	}
+	if _, ok := export.Certificates[export.SignatureChecks[0].CertFingerprint]; !ok {
+		t.Errorf("certificate of the signing doctor not exported")
+	}
+
+	/// the digest is recomputed from the JSON the recipient receives
+	bundleJSON, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received PatientRecordBundle
+	err = json.Unmarshal(bundleJSON, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := recordExportDigest(&received.Export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != bundle.Digest {
+		t.Errorf("digest %v, recomputed %v", bundle.Digest, digest)
+	}
+}
@@ -0,0 +1,124 @@
+package chaincode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// bound of a record query, an empty value leaves the range open
+func parseQueryTime(name string, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	bound, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v must be an RFC 3339 time: %v", name, err)
+	}
+
+	return bound, nil
+}
+
+/// records issued from the start time and before the end time, of the type when given,
+/// in the order they were issued
+func filterMedicalRecords(records []MedicalInfo, from time.Time, to time.Time, recordType string) []MedicalInfo {
+
+	filtered := []MedicalInfo{}
+	for _, record := range records {
+		issuedAt := record.issueTime()
+		if !from.IsZero() && issuedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !issuedAt.Before(to) {
+			continue
+		}
+		if len(recordType) != 0 && !strings.EqualFold(record.Type, recordType) {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+
+	/// records issued at the same time are kept in the order they were added
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].issueTime().Before(filtered[j].issueTime())
+	})
+
+	return filtered
+}
+
+/// medical records of the patient visible to the client, the read is recorded
+func (s *SmartContract) readQueryableMedicalRecords(ctx contractapi.TransactionContextInterface, pid string) ([]MedicalInfo, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	switch strings.ToLower(client) {
+	case "patient":
+		if pid != id {
+			return nil, fmt.Errorf("Patient can only query their own medical records")
+		}
+	case "doctor":
+		doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("Doctor data not found: %v", err)
+		}
+		if !doctorData.checkPIDExists(pid) {
+			return nil, fmt.Errorf("Cannot Read Patient Data of specified Patient id")
+		}
+	default:
+		return nil, fmt.Errorf("Only patient or doctor can query medical records")
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	err = s.recordAccess(ctx, assetData, accessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(client) == "doctor" {
+		return assetData.scopedMedicalRecords(id), nil
+	}
+
+	return assetData.MedicalRecords, nil
+}
+
+/// query the medical records of the patient issued in the range [from, to) by type
+/// the bounds are RFC 3339 times and the type is matched ignoring case, empty values do not filter
+/// patients query their own records, doctors the records of their patients they can see
+func (s *SmartContract) QueryMedicalRecords(ctx contractapi.TransactionContextInterface, pid string, from string, to string, recordType string) (*MedicalRecords, error) {
+
+	fromTime, err := parseQueryTime("from", from)
+	if err != nil {
+		return nil, err
+	}
+
+	toTime, err := parseQueryTime("to", to)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fromTime.IsZero() && !toTime.IsZero() && !fromTime.Before(toTime) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	records, err := s.readQueryableMedicalRecords(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MedicalRecords{Data: filterMedicalRecords(records, fromTime, toTime, recordType)}, nil
+}
@@ -0,0 +1,119 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) queryMedicalRecords(client *fabrictest.Identity, pid, from, to, recordType string) ([]MedicalInfo, error) {
+	var records *MedicalRecords
+	err := sc.network.NewTransaction(client, "QueryMedicalRecords", pid, from, to, recordType).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		records, err = sc.contract.QueryMedicalRecords(ctx, pid, from, to, recordType)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records.Data, nil
+}
+
+func TestQueryMedicalRecords(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+
+	/// records are issued at the transaction time, an hour apart
+	issued := []struct {
+		recordType string
+		issuedAt time.Time
+	}{}
+	for _, recordType := range []string{"CBC", "Lipid", "cbc"} {
+		issuedAt := sc.network.Now()
+		if err := sc.addSignedRecord(doctor, "0001D", "0001P", recordType, issuedAt.Add(-10*time.Minute)); err != nil {
+			t.Fatalf("AddMedicalRecord: %v", err)
+		}
+		issued = append(issued, struct {
+			recordType string
+			issuedAt time.Time
+		}{recordType, issuedAt})
+		sc.network.Advance(time.Hour)
+	}
+
+	records, err := sc.queryMedicalRecords(patient, "0001P", "", "", "CBC")
+	if err != nil {
+		t.Fatalf("QueryMedicalRecords: %v", err)
+	}
+	if len(records) != 2 || !records[0].IssuedAt.Equal(issued[0].issuedAt) || !records[1].IssuedAt.Equal(issued[2].issuedAt) {
+		t.Fatalf("CBC records %+v", records)
+	}
+
+	/// the range includes its start and excludes its end, bounds can have any offset
+	cet := time.FixedZone("CET", 3600)
+	records, err = sc.queryMedicalRecords(doctor, "0001P", issued[1].issuedAt.In(cet).Format(time.RFC3339), issued[2].issuedAt.Add(time.Second).Format(time.RFC3339), "")
+	if err != nil {
+		t.Fatalf("QueryMedicalRecords: %v", err)
+	}
+	if len(records) != 2 || records[0].Type != "Lipid" || records[1].Type != "cbc" {
+		t.Fatalf("records in range %+v", records)
+	}
+
+	records, err = sc.queryMedicalRecords(doctor, "0001P", "", issued[2].issuedAt.Format(time.RFC3339), "")
+	if err != nil {
+		t.Fatalf("QueryMedicalRecords: %v", err)
+	}
+	if len(records) != 2 || records[1].Type != "Lipid" {
+		t.Fatalf("records before the end %+v", records)
+	}
+
+	if _, err = sc.queryMedicalRecords(patient, "0001P", "2024-02-30T00:00:00Z", "", ""); err == nil {
+		t.Errorf("query from an impossible date")
+	}
+	if _, err = sc.queryMedicalRecords(specialist, "0001P", "", "", ""); err == nil {
+		t.Errorf("records queried by a doctor of another patient")
+	}
+}
+
+func TestMedicalRecordImpossibleDateRejected(t *testing.T) {
+	sc, _, doctor, _ := newAppointedScenario(t)
+
+	medicalDataJSON := []byte(`{"type":"CBC","mReport":{"haemoglobin":"13.5"},"collectedAt":"2024-02-30T09:00:00Z","owner":"0001P"}`)
+	err := sc.network.NewTransaction(doctor, "AddMedicalRecord", "0001P").WithTransient("medical_data", medicalDataJSON).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+	if err == nil {
+		t.Errorf("record issued on 30 February added")
+	}
+
+	date := Date{Day: 31, Month: time.February, Year: 2024}
+	if date.validate() == nil {
+		t.Errorf("31 February is a valid date")
+	}
+}
+
+func TestRecordIssueTimeMigration(t *testing.T) {
+	object, _, err := migrateStoredObject(patientObjectType, []byte(`{"schemaVersion":1,"pid":"0001P","medicalRecords":[{"id":"r1","type":"CBC","mReport":{},"dateOfIssue":{"day":1,"month":2,"year":2024},"owner":"0001P","issuedBy":"0001D"}]}`))
+	if err != nil {
+		t.Fatalf("migrateStoredObject: %v", err)
+	}
+
+	var assetData PatientInfo
+	err = unmarshalStoredObject(object, &assetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := assetData.MedicalRecords[0]
+	if !record.IssuedAt.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) || record.DateOfIssue == nil {
+		t.Fatalf("migrated record %+v", record)
+	}
+
+	/// the signature of the record covers its day of issue
+	content, err := record.signedContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `{"dateOfIssue":{"day":1,"month":2,"year":2024},"issuedBy":"0001D","mReport":{},"owner":"0001P","type":"CBC"}` {
+		t.Errorf("signed content %s", content)
+	}
+}
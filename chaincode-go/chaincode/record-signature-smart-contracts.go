@@ -0,0 +1,266 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// certificates are public, they are kept in the world state so that
+/// readers of every org can verify the signatures
+const certificateObjectType = "certificate"
+
+/// result of the signature verification of a medical record
+type RecordSignatureCheck struct {
+	RecordID string `json:"recordId"`
+	IssuedBy string `json:"issuedBy"`
+	CertFingerprint string `json:"certFingerprint"`
+	Valid bool `json:"valid"`
+}
+
+/// sha256 fingerprint of the DER encoded certificate
+func certFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+/// store the certificate of the invoked client and return its fingerprint
+func storeClientCertificate(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get client certificate: %v", err)
+	}
+
+	fingerprint := certFingerprint(cert)
+
+	certKey, err := ctx.GetStub().CreateCompositeKey(certificateObjectType, []string{fingerprint})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	existing, err := ctx.GetStub().GetState(certKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	if existing == nil {
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		err = ctx.GetStub().PutState(certKey, certPEM)
+		if err != nil {
+			return "", fmt.Errorf("failed to put certificate: %v", err)
+		}
+	}
+
+	return fingerprint, nil
+}
+
+/// PEM encoded certificate stored under the fingerprint
+func readCertificatePEM(ctx contractapi.TransactionContextInterface, fingerprint string) ([]byte, error) {
+
+	certKey, err := ctx.GetStub().CreateCompositeKey(certificateObjectType, []string{fingerprint})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	certPEM, err := ctx.GetStub().GetState(certKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	if certPEM == nil {
+		return nil, fmt.Errorf("Certificate %v not found", fingerprint)
+	}
+
+	return certPEM, nil
+}
+
+/// read certificate stored under the fingerprint
+func readCertificate(ctx contractapi.TransactionContextInterface, fingerprint string) (*x509.Certificate, error) {
+
+	certPEM, err := readCertificatePEM(ctx, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse certificate PEM")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+/// verify the signature of the invoked doctor on the medical record
+/// and stamp the record with the fingerprint of the signing certificate
+func signMedicalRecord(ctx contractapi.TransactionContextInterface, medicalData *MedicalInfo) error {
+
+	if len(medicalData.DoctorSign) == 0 {
+		return fmt.Errorf("Doctor signature not found in the medical record")
+	}
+
+	content, err := medicalData.signedContent()
+	if err != nil {
+		return fmt.Errorf("Cannot marshal medical record content: %v", err)
+	}
+
+	err = verifyClientSignature(ctx, content, medicalData.DoctorSign)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := storeClientCertificate(ctx)
+	if err != nil {
+		return err
+	}
+
+	medicalData.CertFingerprint = fingerprint
+
+	return nil
+}
+
+/// the report must be encrypted when the patient has a data key and signed by the issuer,
+/// the same for the records of doctors and labs
+func protectMedicalRecord(ctx contractapi.TransactionContextInterface, assetData *PatientInfo, medicalData *MedicalInfo) error {
+
+	if assetData.DataKey != nil {
+		err := assetData.DataKey.checkEncrypted(medicalData)
+		if err != nil {
+			return err
+		}
+	} else {
+		medicalData.Encrypted = false
+		medicalData.KeyVersion = 0
+		medicalData.RecordKey = ""
+		medicalData.ReportDigest = ""
+		medicalData.ReportSalt = ""
+	}
+
+	return signMedicalRecord(ctx, medicalData)
+}
+
+/// check that the record content has not changed since the issuing doctor signed it
+func verifyMedicalRecordSignature(ctx contractapi.TransactionContextInterface, medicalData *MedicalInfo) (bool, error) {
+
+	if len(medicalData.DoctorSign) == 0 || len(medicalData.CertFingerprint) == 0 {
+		return false, fmt.Errorf("Medical record %v is not signed", medicalData.ID)
+	}
+
+	cert, err := readCertificate(ctx, medicalData.CertFingerprint)
+	if err != nil {
+		return false, err
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false, fmt.Errorf("Not a ECDSA public key")
+	}
+
+	dSignBytes, err := base64.StdEncoding.DecodeString(medicalData.DoctorSign)
+	if err != nil {
+		return false, fmt.Errorf("Cannot decode digital signature: %v", err)
+	}
+
+	content, err := medicalData.signedContent()
+	if err != nil {
+		return false, fmt.Errorf("Cannot marshal medical record content: %v", err)
+	}
+
+	hash := sha256.Sum256(content)
+
+	return ecdsa.VerifyASN1(publicKey, hash[:], dSignBytes), nil
+}
+
+/// verify the doctor signature on a medical record of the patient
+/// patient can verify their own records, doctors the records of their patients
+func (s *SmartContract) VerifyMedicalRecordSignature(ctx contractapi.TransactionContextInterface, pid string, recordID string) (*RecordSignatureCheck, error) {
+
+	err := s.checkPatientReadAccess(ctx, pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot verify medical record: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	medicalData, err := assetData.getMedicalRecord(recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkRecordReadAccess(ctx, assetData, medicalData)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot verify medical record: %v", err)
+	}
+
+	valid, err := verifyMedicalRecordSignature(ctx, medicalData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordSignatureCheck{
+		RecordID: medicalData.ID,
+		IssuedBy: medicalData.IssuedBy,
+		CertFingerprint: medicalData.CertFingerprint,
+		Valid: valid,
+	}, nil
+}
+
+/// check the record is in the access scope of the invoked doctor, after checkPatientReadAccess
+/// a referred specialist reads the record types of the referral only
+func (s *SmartContract) checkRecordReadAccess(ctx contractapi.TransactionContextInterface, assetData *PatientInfo, medicalData *MedicalInfo) error {
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	if id != assetData.ID && !assetData.inAccessScope(id, medicalData.Type) {
+		return fmt.Errorf("Medical record %v is not in the access scope of %v", medicalData.ID, id)
+	}
+
+	return nil
+}
+
+/// check the invoked client can read the data of the patient
+/// patient reads their own data, doctor the data of the patients they treat
+/// the records a doctor with an access scope reads are checked by checkRecordReadAccess
+func (s *SmartContract) checkPatientReadAccess(ctx contractapi.TransactionContextInterface, pid string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	switch strings.ToLower(client) {
+	case "patient":
+		if id != pid {
+			return fmt.Errorf("Patient can only read their own data")
+		}
+	case "doctor":
+		doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Doctor data not found: %v", err)
+		}
+		if !doctorData.checkPIDExists(pid) {
+			return fmt.Errorf("Cannot Read Patient Data of specified Patient id")
+		}
+	default:
+		return fmt.Errorf("Only patient or doctor can read patient data")
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// medical records are issued at the transaction time, the doctor only gives the
+/// clinical time the data was collected at, within the window of the org
+const collectedAtWindowObjectType = "collectedAtWindow"
+
+const defaultCollectedAtMaxAgeHours = 30 * 24
+const defaultCollectedAtMaxFutureMinutes = 5
+
+/// how far the collected at time may be before or after the issue time
+type CollectedAtWindow struct {
+	SchemaVersion int `json:"schemaVersion"`
+	MaxAgeHours int `json:"maxAgeHours"`
+	MaxFutureMinutes int `json:"maxFutureMinutes"`
+	UpdatedBy string `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (w *CollectedAtWindow) objectType() string {
+	return collectedAtWindowObjectType
+}
+
+func (w *CollectedAtWindow) setSchemaVersion(version int) {
+	w.SchemaVersion = version
+}
+
+/// check the collected at time is inside the window around the issue time
+func (w *CollectedAtWindow) check(collectedAt time.Time, issuedAt time.Time) error {
+
+	if collectedAt.Before(issuedAt.Add(-time.Duration(w.MaxAgeHours) * time.Hour)) {
+		return fmt.Errorf("collectedAt %v is more than %v hours before the issue time %v", collectedAt.Format(time.RFC3339), w.MaxAgeHours, issuedAt.Format(time.RFC3339))
+	}
+
+	if collectedAt.After(issuedAt.Add(time.Duration(w.MaxFutureMinutes) * time.Minute)) {
+		return fmt.Errorf("collectedAt %v is after the issue time %v", collectedAt.Format(time.RFC3339), issuedAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+/// issue the record at the transaction time, the client only gives the collected at time
+/// and it must be inside the window of the org
+func stampMedicalRecord(ctx contractapi.TransactionContextInterface, medicalData *MedicalInfo) error {
+
+	/// the day of issue is only kept for the records issued before the issue time
+	if medicalData.DateOfIssue != nil {
+		return fmt.Errorf("dateOfIssue is replaced by collectedAt")
+	}
+
+	if !medicalData.IssuedAt.IsZero() {
+		return fmt.Errorf("issuedAt is set by the chaincode, use collectedAt")
+	}
+
+	if medicalData.CollectedAt == nil {
+		return fmt.Errorf("collectedAt field must be non-empty value")
+	}
+
+	issuedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	window, err := readCollectedAtWindow(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = window.check(*medicalData.CollectedAt, issuedAt)
+	if err != nil {
+		return err
+	}
+
+	medicalData.IssuedAt = issuedAt
+
+	return nil
+}
+
+/// window of the org of the peer, the default window when the admin has not set one
+func readCollectedAtWindow(ctx contractapi.TransactionContextInterface) (*CollectedAtWindow, error) {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	windowKey, err := ctx.GetStub().CreateCompositeKey(collectedAtWindowObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	windowJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, windowKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collected at window: %v", err)
+	}
+
+	if windowJSON == nil {
+		return &CollectedAtWindow{MaxAgeHours: defaultCollectedAtMaxAgeHours, MaxFutureMinutes: defaultCollectedAtMaxFutureMinutes}, nil
+	}
+
+	var window CollectedAtWindow
+	err = unmarshalStoredObject(windowJSON, &window)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal collected at window: %v", err)
+	}
+
+	return &window, nil
+}
+
+/// set how long before and after the issue time a medical record can be collected at
+/// only the hospital admin of the org can set the window
+func (s *SmartContract) SetCollectedAtWindow(ctx contractapi.TransactionContextInterface, maxAgeHours int, maxFutureMinutes int) error {
+
+	clientID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if maxAgeHours <= 0 {
+		return fmt.Errorf("Max age hours must be positive")
+	}
+
+	if maxFutureMinutes < 0 {
+		return fmt.Errorf("Max future minutes cannot be negative")
+	}
+
+	updatedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	window := CollectedAtWindow{
+		MaxAgeHours: maxAgeHours,
+		MaxFutureMinutes: maxFutureMinutes,
+		UpdatedBy: clientID,
+		UpdatedAt: updatedAt,
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+
+	windowKey, err := ctx.GetStub().CreateCompositeKey(collectedAtWindowObjectType, []string{})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	windowJSON, err := marshalStoredObject(&window)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal collected at window: %v", err)
+	}
+
+	log.Printf("CollectedAtWindow Put: collection %v, Key %v", orgCollectionName, windowKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, windowKey, windowJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put collected at window: %v", err)
+	}
+
+	return nil
+}
+
+/// collected at window of the org, readable by the clients of the org
+func (s *SmartContract) GetCollectedAtWindow(ctx contractapi.TransactionContextInterface) (*CollectedAtWindow, error) {
+
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get collected at window cannot be performed: Error %v", err)
+	}
+
+	return readCollectedAtWindow(ctx)
+}
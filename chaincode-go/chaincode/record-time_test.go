@@ -0,0 +1,70 @@
+package chaincode
+
+import (
+	"time"
+	"strings"
+	"testing"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestMedicalRecordIssuedAtTxTime(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+
+	issuedAt := sc.network.Now()
+	collectedAt := issuedAt.Add(-2 * time.Hour)
+	if err := sc.addSignedRecord(doctor, "0001D", "0001P", "CBC", collectedAt); err != nil {
+		t.Fatalf("AddMedicalRecord: %v", err)
+	}
+
+	record := sc.readPatient(patient, "0001P").MedicalRecords[0]
+	if !record.IssuedAt.Equal(issuedAt) || record.CollectedAt == nil || !record.CollectedAt.Equal(collectedAt) {
+		t.Fatalf("record issued at %v collected at %v", record.IssuedAt, record.CollectedAt)
+	}
+
+	/// backdated and future dated collections are outside the default window
+	err := sc.addSignedRecord(doctor, "0001D", "0001P", "CBC", sc.network.Now().Add(-31*24*time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "hours before the issue time") {
+		t.Errorf("backdated record added: %v", err)
+	}
+	err = sc.addSignedRecord(doctor, "0001D", "0001P", "CBC", sc.network.Now().Add(time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "after the issue time") {
+		t.Errorf("future dated record added: %v", err)
+	}
+
+	/// the issue time cannot be given by the doctor
+	medicalDataJSON := []byte(`{"type":"CBC","mReport":{"haemoglobin":"13.5"},"issuedAt":"2024-01-01T09:00:00Z","collectedAt":"2024-01-01T09:00:00Z","owner":"0001P"}`)
+	err = sc.network.NewTransaction(doctor, "AddMedicalRecord", "0001P").WithTransient("medical_data", medicalDataJSON).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+	if err == nil || !strings.Contains(err.Error(), "issuedAt is set by the chaincode") {
+		t.Errorf("record with an issue time added: %v", err)
+	}
+
+	err = sc.submit(doctor, "SetCollectedAtWindow", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.SetCollectedAtWindow(ctx, 90*24, 0)
+	})
+	if err == nil {
+		t.Errorf("collected at window set by a doctor")
+	}
+
+	err = sc.submit(admin, "SetCollectedAtWindow", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.SetCollectedAtWindow(ctx, 90*24, 0)
+	})
+	if err != nil {
+		t.Fatalf("SetCollectedAtWindow: %v", err)
+	}
+
+	var window *CollectedAtWindow
+	err = sc.network.NewTransaction(doctor, "GetCollectedAtWindow").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		window, err = sc.contract.GetCollectedAtWindow(ctx)
+		return err
+	})
+	if err != nil || window.MaxAgeHours != 90*24 || len(window.UpdatedBy) == 0 {
+		t.Fatalf("GetCollectedAtWindow: %+v, %v", window, err)
+	}
+
+	if err = sc.addSignedRecord(doctor, "0001D", "0001P", "Lipid", sc.network.Now().Add(-31*24*time.Hour)); err != nil {
+		t.Errorf("record collected inside the configured window: %v", err)
+	}
+}
@@ -0,0 +1,484 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const referralObjectType = "referral"
+
+/// referral status values
+const (
+	referralPending = "pending"
+	referralAccepted = "accepted"
+	referralDeclined = "declined"
+	referralCompleted = "completed"
+)
+
+/// referral of a patient from the treating doctor to a specialist
+type referral struct {
+	SchemaVersion int `json:"schemaVersion"`
+	ID string `json:"id"`
+	PID string `json:"pid"`
+	ReferredBy string `json:"referredBy"`
+	Specialist string `json:"specialist"`
+	Note string `json:"note"`
+	RecordTypes []string `json:"recordTypes"`
+	Status string `json:"status"`
+	Outcome string `json:"outcome"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Referrals struct {
+	Data []referral `json:"data"`
+}
+
+func (r *referral) objectType() string {
+	return referralObjectType
+}
+
+func (r *referral) setSchemaVersion(version int) {
+	r.SchemaVersion = version
+}
+
+func (r *referral) assignData(id, pid, referredBy, specialist, note string, recordTypes []string) error {
+	if len(id) == 0 || len(pid) == 0 {
+		return fmt.Errorf("Referral id and patient id are required")
+	}
+
+	if len(referredBy) == 0 || len(specialist) == 0 {
+		return fmt.Errorf("Referring doctor and specialist are required")
+	}
+
+	if referredBy == specialist {
+		return fmt.Errorf("Doctor cannot refer a patient to themselves")
+	}
+
+	if len(note) == 0 {
+		return fmt.Errorf("Clinical note is required")
+	}
+
+	if len(recordTypes) == 0 {
+		return fmt.Errorf("At least one record type must be shared with the specialist")
+	}
+
+	r.ID = id
+	r.PID = pid
+	r.ReferredBy = referredBy
+	r.Specialist = specialist
+	r.Note = note
+	r.RecordTypes = recordTypes
+	r.Status = referralPending
+
+	return nil
+}
+
+/// referral can only move forward: pending -> accepted/declined, accepted -> completed
+func (r *referral) setStatus(status string) error {
+	switch {
+	case r.Status == referralPending && (status == referralAccepted || status == referralDeclined):
+	case r.Status == referralAccepted && status == referralCompleted:
+	default:
+		return fmt.Errorf("Referral %v cannot move from %v to %v", r.ID, r.Status, status)
+	}
+
+	r.Status = status
+
+	return nil
+}
+
+/// split the comma separated record types passed by the client
+func parseRecordTypes(recordTypes string) []string {
+	types := []string{}
+	for _, value := range strings.Split(recordTypes, ",") {
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if len(value) != 0 {
+			types = append(types, value)
+		}
+	}
+	return types
+}
+
+/// refer patient to a specialist
+/// only a doctor treating the patient can refer, the patient has to accept the referral
+/// before the specialist can access any data
+func (s *SmartContract) ReferPatient(ctx contractapi.TransactionContextInterface, pid string, specialistID string, note string, recordTypes string) (string, error) {
+
+	/// check if the client is doctor
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return "", fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return "", fmt.Errorf("Only Doctor can refer a patient")
+	}
+
+	/// get client id from identity
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return "", fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	/// verify client org and peer org
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Refer patient cannot be performed: Error %v", err)
+	}
+
+	/// referring doctor must be treating the patient
+	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("Error reading referring doctor data: %v", err)
+	}
+
+	if !doctorData.checkPIDExists(pid) {
+		return "", fmt.Errorf("Only a treating doctor can refer patient %v", pid)
+	}
+
+	/// specialist must be registered and not already treating the patient
+	specialistData, err := s.ReadDoctorPrivateData(ctx, specialistID)
+	if err != nil {
+		return "", fmt.Errorf("Error reading specialist data: %v", err)
+	}
+
+	if specialistData.checkPIDExists(pid) {
+		return "", fmt.Errorf("Specialist already has access to patient %v", pid)
+	}
+
+	referralID := ctx.GetStub().GetTxID()
+
+	var referralData referral
+	err = referralData.assignData(referralID, pid, id, specialistID, note, parseRecordTypes(recordTypes))
+	if err != nil {
+		return "", fmt.Errorf("Cannot create referral: %v", err)
+	}
+
+	referralData.CreatedAt, err = getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.putReferral(ctx, &referralData)
+	if err != nil {
+		return "", err
+	}
+
+	return referralID, nil
+}
+
+/// read referral of a patient, only the patient, the referring doctor and the specialist can read it
+func (s *SmartContract) ReadReferral(ctx contractapi.TransactionContextInterface, pid string, referralID string) (*referral, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	/// verify client org and peer org
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Read referral cannot be performed: Error %v", err)
+	}
+
+	referralKey, err := ctx.GetStub().CreateCompositeKey(referralObjectType, []string{pid, referralID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	/// get collection name
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read referral: %v", err)
+	}
+
+	log.Printf("ReadReferral: collection %v, ID %v", orgCollectionName, referralID)
+	referralJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, referralKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read referral: %v", err)
+	}
+
+	/// referral not found
+	if referralJSON == nil {
+		log.Printf("Referral %v for %v does not exist", referralID, pid)
+		return nil, fmt.Errorf("Referral %v for %v does not exist", referralID, pid)
+	}
+
+	var referralData referral
+	err = unmarshalStoredObject(referralJSON, &referralData)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal referral: %v", err)
+	}
+
+	switch strings.ToLower(client) {
+	case "patient":
+		if referralData.PID != id {
+			return nil, fmt.Errorf("Referral %v for %v does not exist", referralID, pid)
+		}
+	case "doctor":
+		if referralData.ReferredBy != id && referralData.Specialist != id {
+			return nil, fmt.Errorf("Referral %v for %v does not exist", referralID, pid)
+		}
+	default:
+		return nil, fmt.Errorf("Only patient or doctor can read referrals")
+	}
+
+	return &referralData, nil
+}
+
+/// list referrals of the client
+/// patient gets the referrals made for them, doctor gets the referrals they made or received
+func (s *SmartContract) GetReferrals(ctx contractapi.TransactionContextInterface) (*Referrals, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	/// verify client org and peer org
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Get referrals cannot be performed: Error %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get referrals: %v", err)
+	}
+
+	var keys []string
+	switch strings.ToLower(client) {
+	case "patient":
+		keys = []string{id}
+	case "doctor":
+		keys = []string{}
+	default:
+		return nil, fmt.Errorf("Only patient or doctor can check referrals")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(orgCollectionName, referralObjectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results := []referral{}
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var referralData referral
+		err = unmarshalStoredObject(response.Value, &referralData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		if strings.ToLower(client) == "doctor" && referralData.ReferredBy != id && referralData.Specialist != id {
+			continue
+		}
+
+		results = append(results, referralData)
+	}
+
+	return &Referrals{Data: results}, nil
+}
+
+/// patient accepts or declines the referral
+/// on acceptance the specialist gets access to the referred record types only,
+/// for an encrypted patient the keys of those records and not the data key
+func (s *SmartContract) RespondToReferral(ctx contractapi.TransactionContextInterface, referralID string, accept string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return fmt.Errorf("Only Patient can respond to referral")
+	}
+
+	check, err := strconv.ParseBool(accept)
+	if err != nil {
+		return fmt.Errorf("Cannot convert to bool: %v", err)
+	}
+
+	/// get id
+	assetID, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Responding to referral failed: %v", err)
+	}
+
+	referralData, err := s.ReadReferral(ctx, assetID, referralID)
+	if err != nil {
+		return fmt.Errorf("Cannot read referral: %v", err)
+	}
+
+	if !check {
+		err = referralData.setStatus(referralDeclined)
+		if err != nil {
+			return err
+		}
+		return s.putReferral(ctx, referralData)
+	}
+
+	err = referralData.setStatus(referralAccepted)
+	if err != nil {
+		return err
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, assetID)
+	if err != nil {
+		return fmt.Errorf("Error reading asset data from the collection: %v", err)
+	}
+
+	err = assetData.addDoctorInfo(referralData.Specialist)
+	if err != nil {
+		return fmt.Errorf("Cannot add data to patient: %v", err)
+	}
+
+	assetData.setAccessScope(referralData.Specialist, referralData.RecordTypes)
+
+	/// the specialist never gets the data key, only the record keys of the referred records
+	/// wrapped for them, passed in the transient map as record id -> wrapped record key
+	if assetData.DataKey != nil {
+		if assetData.DataKey.Rotation != nil {
+			return fmt.Errorf("Data key rotation in progress, complete the rotation first")
+		}
+
+		recordKeys, err := getTransientKeys(ctx, "record_keys")
+		if err != nil {
+			return err
+		}
+
+		err = assetData.setScopedRecordKeys(referralData.Specialist, recordKeys)
+		if err != nil {
+			return fmt.Errorf("Cannot accept referral: %v", err)
+		}
+	}
+
+	err = s.putAssetData(ctx, assetData)
+	if err != nil {
+		return err
+	}
+
+	/// update specialist info
+	err = s.updateDocInfo(ctx, referralData.Specialist, assetID)
+	if err != nil {
+		return fmt.Errorf("Error while adding patient id: %v", err)
+	}
+
+	return s.putReferral(ctx, referralData)
+}
+
+/// specialist completes the referral with an outcome note
+/// the scoped access given for the referral is removed, direct access of the specialist is kept
+func (s *SmartContract) CompleteReferral(ctx contractapi.TransactionContextInterface, pid string, referralID string, outcome string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "doctor" {
+		return fmt.Errorf("Only Doctor can complete referral")
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	referralData, err := s.ReadReferral(ctx, pid, referralID)
+	if err != nil {
+		return fmt.Errorf("Cannot read referral: %v", err)
+	}
+
+	if referralData.Specialist != id {
+		return fmt.Errorf("Only the referred specialist can complete referral %v", referralID)
+	}
+
+	err = referralData.setStatus(referralCompleted)
+	if err != nil {
+		return err
+	}
+	referralData.Outcome = outcome
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return fmt.Errorf("Error reading asset data from the collection: %v", err)
+	}
+
+	/// only the access the referral gave is removed, the patient may already have revoked
+	/// the specialist or appointed them directly (the scope is gone then)
+	if _, scoped := assetData.AccessScopes[id]; !scoped {
+		return s.putReferral(ctx, referralData)
+	}
+
+	err = assetData.removeAccess(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.putAssetData(ctx, assetData)
+	if err != nil {
+		return err
+	}
+
+	doctorData, err := s.ReadDoctorPrivateData(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Cannot read client data: %v", err)
+	}
+
+	if err = doctorData.removePID(pid); err == nil {
+		err = s.putDoctorData(ctx, doctorData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.putReferral(ctx, referralData)
+}
+
+/// write referral into the private collection of the org
+func (s *SmartContract) putReferral(ctx contractapi.TransactionContextInterface, referralData *referral) error {
+
+	referralKey, err := ctx.GetStub().CreateCompositeKey(referralObjectType, []string{referralData.PID, referralData.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return fmt.Errorf("Cannot write referral: %v", err)
+	}
+
+	referralJSON, err := marshalStoredObject(referralData)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal referral: %v", err)
+	}
+
+	log.Printf("Referral Put: collection %v, ID %v, Key %v", orgCollectionName, referralData.ID, referralKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, referralKey, referralJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put referral: %v", err)
+	}
+
+	return nil
+}
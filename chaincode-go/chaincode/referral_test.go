@@ -0,0 +1,167 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) readReferral(client *fabrictest.Identity, pid, referralID string) (*referral, error) {
+	var referralData *referral
+	err := sc.network.NewTransaction(client, "ReadReferral", pid, referralID).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		referralData, err = sc.contract.ReadReferral(ctx, pid, referralID)
+		return err
+	})
+	return referralData, err
+}
+
+func TestReadReferralLimitedToParties(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+	other := sc.client("Org1MSP", "doctor", "0003D")
+	sc.registerDoctor(other, "Dave")
+	otherPatient := sc.client("Org1MSP", "patient", "0002P")
+	sc.registerPatient(otherPatient)
+
+	var referralID string
+	err := sc.submit(doctor, "ReferPatient", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		referralID, err = sc.contract.ReferPatient(ctx, "0001P", "0002D", "suspected anaemia", "CBC")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ReferPatient: %v", err)
+	}
+
+	for _, client := range []*fabrictest.Identity{patient, doctor, specialist} {
+		referralData, err := sc.readReferral(client, "0001P", referralID)
+		if err != nil || referralData.Note != "suspected anaemia" {
+			t.Errorf("referral read by a party: %+v, %v", referralData, err)
+		}
+	}
+
+	for _, client := range []*fabrictest.Identity{other, otherPatient} {
+		if _, err := sc.readReferral(client, "0001P", referralID); err == nil {
+			t.Errorf("referral read by a client who is not a party")
+		}
+	}
+}
+
+/// encrypted record with opaque ciphertext and keys, the scoped keys passed in the transient map
+func (sc *scenario) addEncryptedRecord(doctor *fabrictest.Identity, doctorID, recordType string, scopedKeys map[string]string) error {
+	collectedAt := sc.network.Now().Add(-time.Hour)
+	medicalData := MedicalInfo{Type: recordType, MReport: map[string]string{"value": "ciphertext"}, CollectedAt: &collectedAt, Owner: "0001P", IssuedBy: doctorID,
+		Encrypted: true, KeyVersion: 1, RecordKey: "record-key", ReportDigest: "report-digest", ReportSalt: "report-salt"}
+	content, err := medicalData.signedContent()
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	medicalData.DoctorSign, err = doctor.SignJSON(content)
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	medicalDataJSON, _ := json.Marshal(medicalData)
+	scopedKeysJSON, _ := json.Marshal(scopedKeys)
+	tx := sc.network.NewTransaction(doctor, "AddMedicalRecord", "0001P").WithTransient("medical_data", medicalDataJSON).WithTransient("scoped_keys", scopedKeysJSON)
+	return tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AddMedicalRecord(ctx, "0001P")
+	})
+}
+
+func TestScopedReferralGetsRecordKeysOnly(t *testing.T) {
+	sc, patient, doctor, specialist := newAppointedScenario(t)
+	sc.setDataKey(patient)
+
+	for _, recordType := range []string{"CBC", "RFT"} {
+		if err := sc.addEncryptedRecord(doctor, "0001D", recordType, nil); err != nil {
+			t.Fatalf("AddMedicalRecord %v: %v", recordType, err)
+		}
+	}
+	records := sc.readPatient(patient, "0001P").MedicalRecords
+	cbcID, rftID := records[0].ID, records[1].ID
+
+	var referralID string
+	err := sc.submit(doctor, "ReferPatient", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		referralID, err = sc.contract.ReferPatient(ctx, "0001P", "0002D", "suspected anaemia", "CBC")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ReferPatient: %v", err)
+	}
+
+	/// the patient wraps the keys of the referred records only, never the data key
+	respond := func(recordKeys map[string]string) error {
+		return sc.submitWithTransient(patient, "RespondToReferral", "record_keys", recordKeys, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.contract.RespondToReferral(ctx, referralID, "true")
+		})
+	}
+	if err = respond(map[string]string{cbcID: "specialist-cbc-key", rftID: "specialist-rft-key"}); err == nil {
+		t.Errorf("record key of a record outside the scope given to the specialist")
+	}
+	if err = respond(map[string]string{cbcID: "specialist-cbc-key"}); err != nil {
+		t.Fatalf("RespondToReferral: %v", err)
+	}
+
+	var dataKey *WrappedDataKey
+	err = sc.network.NewTransaction(specialist, "ReadWrappedDataKey", "0001P").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		dataKey, err = sc.contract.ReadWrappedDataKey(ctx, "0001P")
+		return err
+	})
+	if err != nil || len(dataKey.WrappedKey) != 0 || len(dataKey.RecordKeys) != 1 || dataKey.RecordKeys[cbcID] != "specialist-cbc-key" {
+		t.Fatalf("key of the scoped specialist %+v, %v", dataKey, err)
+	}
+
+	verify := func(recordID string) error {
+		return sc.network.NewTransaction(specialist, "VerifyMedicalRecordSignature", "0001P", recordID).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.contract.VerifyMedicalRecordSignature(ctx, "0001P", recordID)
+			return err
+		})
+	}
+	if err = verify(cbcID); err != nil {
+		t.Errorf("VerifyMedicalRecordSignature in scope: %v", err)
+	}
+	if err = verify(rftID); err == nil {
+		t.Errorf("record outside the scope verified by the specialist")
+	}
+
+	/// new records in the scope carry their key for the specialist
+	if err = sc.addEncryptedRecord(doctor, "0001D", "CBC", nil); err == nil {
+		t.Errorf("record in the scope added without the key of the specialist")
+	}
+	if err = sc.addEncryptedRecord(doctor, "0001D", "RFT", map[string]string{"0002D": "specialist-key"}); err == nil {
+		t.Errorf("key of a record outside the scope given to the specialist")
+	}
+	if err = sc.addEncryptedRecord(doctor, "0001D", "CBC", map[string]string{"0002D": "specialist-key"}); err != nil {
+		t.Errorf("AddMedicalRecord in scope: %v", err)
+	}
+	if err = sc.addEncryptedRecord(specialist, "0002D", "CBC", nil); err == nil {
+		t.Errorf("encrypted record added by the scoped specialist")
+	}
+
+	/// a direct appointment lifts the scope and survives the completion of the referral
+	err = sc.network.NewTransaction(patient, "AppointDoctor", "0002D").WithTransient("wrapped_key", []byte("specialist-wrapped-key")).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.AppointDoctor(ctx, "0002D")
+	})
+	if err != nil {
+		t.Fatalf("AppointDoctor: %v", err)
+	}
+	err = sc.submit(specialist, "CompleteReferral", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.CompleteReferral(ctx, "0001P", referralID, "iron deficiency")
+	})
+	if err != nil {
+		t.Fatalf("CompleteReferral: %v", err)
+	}
+
+	assetData := sc.readPatient(patient, "0001P")
+	if !containsID(assetData.TreatedBy, "0002D") || len(assetData.AccessScopes) != 0 || len(assetData.DataKey.RecordKeys) != 0 {
+		t.Errorf("direct access after the referral: %v, %v, %v", assetData.TreatedBy, assetData.AccessScopes, assetData.DataKey.RecordKeys)
+	}
+	if !containsID(sc.readDoctor(specialist, "0002D").PIDS, "0001P") {
+		t.Errorf("specialist lost the directly appointed patient")
+	}
+}
@@ -0,0 +1,140 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// expiry of access requests and share agreements in days, chosen by the requester
+/// up to the maximum, the default is used when the requester does not choose
+const defaultRequestExpiryDays = 7
+const maxRequestExpiryDays = 30
+
+/// requests removed in a single sweep transaction
+const maxSweepItems = 100
+
+type SweepResult struct {
+	DataAccessRequests int `json:"dataAccessRequests"`
+	RequestAgreements int `json:"requestAgreements"`
+}
+
+/// expiry of a request created at the given time
+func requestExpiry(createdAt time.Time, expiryDays int) (time.Time, error) {
+	if expiryDays == 0 {
+		expiryDays = defaultRequestExpiryDays
+	}
+
+	if expiryDays < 0 || expiryDays > maxRequestExpiryDays {
+		return time.Time{}, fmt.Errorf("Expiry must be between 1 and %v days", maxRequestExpiryDays)
+	}
+
+	return createdAt.AddDate(0, 0, expiryDays), nil
+}
+
+/// requests created before expiries were recorded have a zero expiry, they are kept
+/// as not expired so that the pending requests of the patients are not dropped on upgrade,
+/// they stay until the patient acts on them and are never swept
+func isRequestExpired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+func checkRequestNotExpired(expiresAt time.Time, now time.Time) error {
+	if isRequestExpired(expiresAt, now) {
+		return fmt.Errorf("Request expired at %v", expiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+/// expiry of the stored request, for the sweep
+func requestExpiresAt(objectType string, value []byte) (time.Time, error) {
+	switch objectType {
+	case dataAccessRequestObjectType:
+		var request dataAccessRequest
+		err := unmarshalStoredObject(value, &request)
+		return request.ExpiresAt, err
+	case requestAgreementObjectType:
+		var agreement requestAgreement
+		err := unmarshalStoredObject(value, &agreement)
+		return agreement.ExpiresAt, err
+	}
+
+	return time.Time{}, fmt.Errorf("Expiry is not supported for %v", objectType)
+}
+
+/// delete the expired requests of the type from the collection, up to the limit
+func (s *SmartContract) sweepExpired(ctx contractapi.TransactionContextInterface, objectType string, collection string, now time.Time, limit int) (int, error) {
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, objectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	expiredKeys := []string{}
+	for resultsIterator.HasNext() && len(expiredKeys) < limit {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		/// agreements of the other org are swept by its admin
+		if objectType == requestAgreementObjectType && !s.isOrgAgreement(ctx, response.Value) {
+			continue
+		}
+
+		expiresAt, err := requestExpiresAt(objectType, response.Value)
+		if err != nil {
+			return 0, fmt.Errorf("Cannot read %v: %v", objectType, err)
+		}
+
+		if isRequestExpired(expiresAt, now) {
+			expiredKeys = append(expiredKeys, response.Key)
+		}
+	}
+
+	for _, key := range expiredKeys {
+		log.Printf("SweepExpiredRequests Delete: collection %v, Key %v", collection, key)
+		err = ctx.GetStub().DelPrivateData(collection, key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete %v: %v", objectType, err)
+		}
+	}
+
+	return len(expiredKeys), nil
+}
+
+/// remove the expired data access requests and share agreements of the org
+/// call again while requests are removed, each call is bounded
+func (s *SmartContract) SweepExpiredRequests(ctx contractapi.TransactionContextInterface) (*SweepResult, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SweepResult{}
+
+	result.DataAccessRequests, err = s.sweepExpired(ctx, dataAccessRequestObjectType, orgCollectionName, now, maxSweepItems)
+	if err != nil {
+		return nil, err
+	}
+
+	result.RequestAgreements, err = s.sweepExpired(ctx, requestAgreementObjectType, org1AndOrg2PrivateCollection, now, maxSweepItems - result.DataAccessRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
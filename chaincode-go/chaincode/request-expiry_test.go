@@ -0,0 +1,92 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestIsRequestExpired(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		expiresAt time.Time
+		expired bool
+	}{
+		{"expired", now.Add(-time.Second), true},
+		{"expires now", now, true},
+		{"not expired", now.Add(time.Second), false},
+		/// stored before expiries were recorded
+		{"legacy", time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		if expired := isRequestExpired(c.expiresAt, now); expired != c.expired {
+			t.Errorf("%v: expired = %v", c.name, expired)
+		}
+		if err := checkRequestNotExpired(c.expiresAt, now); (err != nil) != c.expired {
+			t.Errorf("%v: check = %v", c.name, err)
+		}
+	}
+}
+
+func TestSweepKeepsLegacyRequests(t *testing.T) {
+	sc, _, _, specialist := newAppointedScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	otherPatient := sc.client("Org1MSP", "patient", "0002P")
+	sc.registerPatient(otherPatient)
+
+	/// request of the other patient stored without an expiry
+	err := sc.submit(otherPatient, "Put", func(ctx contractapi.TransactionContextInterface) error {
+		request := &dataAccessRequest{
+			MetaData: metaData{Org: "org1", User: "doctor0002D", ClientID: "0002D"},
+			PatientID: "0002P",
+			ClientSign: "sign",
+			CreatedAt: sc.network.Now(),
+		}
+		requestJSON, err := marshalStoredObject(request)
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutPrivateData(org1CollectionName, dataAccessRequestKey("0002P"), requestJSON)
+	})
+	if err != nil {
+		t.Fatalf("Cannot put legacy request: %v", err)
+	}
+
+	if _, _, err := sc.createDataAccessRequest(specialist, "0002D", "0001P"); err != nil {
+		t.Fatalf("CreateDataAccessRequest: %v", err)
+	}
+
+	sc.network.Advance(time.Duration(defaultRequestExpiryDays) * 24 * time.Hour)
+
+	var result *SweepResult
+	err = sc.submit(admin, "SweepExpiredRequests", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = sc.contract.SweepExpiredRequests(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("SweepExpiredRequests: %v", err)
+	}
+	if result.DataAccessRequests != 1 {
+		t.Errorf("swept = %+v", result)
+	}
+
+	if sc.network.PrivateData(org1CollectionName, dataAccessRequestKey("0001P")) != nil {
+		t.Errorf("expired request not swept")
+	}
+	if sc.network.PrivateData(org1CollectionName, dataAccessRequestKey("0002P")) == nil {
+		t.Errorf("legacy request without an expiry swept")
+	}
+
+	/// the patient still sees the legacy request
+	inbox, err := sc.getInbox(otherPatient)
+	if err != nil {
+		t.Fatalf("GetInbox: %v", err)
+	}
+	if len(inbox.Data) != 1 || inbox.Data[0].Kind != inboxDataAccessRequest || inbox.Data[0].From != "0002D" {
+		t.Errorf("inbox = %+v", inbox)
+	}
+}
@@ -0,0 +1,143 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// nonces of the signed request payloads, kept in the world state so that
+/// a payload cannot be replayed on either org
+const requestNonceObjectType = "requestNonce"
+
+/// signed payload must be submitted shortly after it was issued
+const maxPayloadAge = 5 * time.Minute
+const maxPayloadClockSkew = time.Minute
+
+/// nonce of at least 128 bits, hex encoded
+const minNonceLength = 32
+
+/// request data signed by the requester, bound to a single use on this channel and chaincode
+type SignedRequestPayload struct {
+	Type string `json:"type"`
+	MetaData metaData `json:"metaData"`
+	PID string `json:"pid"`
+	HID string `json:"hid,omitempty"`
+	Nonce string `json:"nonce"`
+	IssuedAt time.Time `json:"issuedAt"`
+	Channel string `json:"channel"`
+	Chaincode string `json:"chaincode"`
+	ExpiryDays int `json:"expiryDays"`
+}
+
+func parseRequestPayload(payload string, objectType string) (*SignedRequestPayload, error) {
+
+	var request SignedRequestPayload
+	err := json.Unmarshal([]byte(payload), &request)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal signed payload: %v", err)
+	}
+
+	/// a payload signed for one kind of request cannot create the other
+	if request.Type != objectType {
+		return nil, fmt.Errorf("Signed payload is for %v, not %v", request.Type, objectType)
+	}
+
+	if len(request.PID) == 0 {
+		return nil, fmt.Errorf("Patient id not found in the signed payload")
+	}
+
+	if len(request.Nonce) < minNonceLength {
+		return nil, fmt.Errorf("Nonce of the signed payload is too short")
+	}
+
+	return &request, nil
+}
+
+/// name of the chaincode the transaction was sent to, from the signed proposal
+func getChaincodeName(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	signedProposal, err := ctx.GetStub().GetSignedProposal()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get signed proposal: %v", err)
+	}
+
+	proposal := &peer.Proposal{}
+	err = proto.Unmarshal(signedProposal.ProposalBytes, proposal)
+	if err != nil {
+		return "", fmt.Errorf("Cannot unmarshal proposal: %v", err)
+	}
+
+	proposalPayload := &peer.ChaincodeProposalPayload{}
+	err = proto.Unmarshal(proposal.Payload, proposalPayload)
+	if err != nil {
+		return "", fmt.Errorf("Cannot unmarshal proposal payload: %v", err)
+	}
+
+	invocation := &peer.ChaincodeInvocationSpec{}
+	err = proto.Unmarshal(proposalPayload.Input, invocation)
+	if err != nil {
+		return "", fmt.Errorf("Cannot unmarshal invocation spec: %v", err)
+	}
+
+	if invocation.ChaincodeSpec == nil || invocation.ChaincodeSpec.ChaincodeId == nil {
+		return "", fmt.Errorf("Chaincode id not found in the proposal")
+	}
+
+	return invocation.ChaincodeSpec.ChaincodeId.Name, nil
+}
+
+/// record the nonce as used, a nonce seen before is rejected
+func useRequestNonce(ctx contractapi.TransactionContextInterface, nonce string) error {
+
+	nonceKey, err := ctx.GetStub().CreateCompositeKey(requestNonceObjectType, []string{nonce})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	used, err := ctx.GetStub().GetState(nonceKey)
+	if err != nil {
+		return fmt.Errorf("failed to read nonce: %v", err)
+	}
+
+	if used != nil {
+		return fmt.Errorf("Signed payload already used in transaction %v", string(used))
+	}
+
+	err = ctx.GetStub().PutState(nonceKey, []byte(ctx.GetStub().GetTxID()))
+	if err != nil {
+		return fmt.Errorf("failed to put nonce: %v", err)
+	}
+
+	return nil
+}
+
+/// check the payload is fresh, meant for this channel and chaincode, and not used before
+func checkRequestPayload(ctx contractapi.TransactionContextInterface, request *SignedRequestPayload, now time.Time) error {
+
+	if request.IssuedAt.Before(now.Add(-maxPayloadAge)) {
+		return fmt.Errorf("Signed payload issued at %v is too old", request.IssuedAt.Format(time.RFC3339))
+	}
+
+	if request.IssuedAt.After(now.Add(maxPayloadClockSkew)) {
+		return fmt.Errorf("Signed payload issued at %v is in the future", request.IssuedAt.Format(time.RFC3339))
+	}
+
+	if request.Channel != ctx.GetStub().GetChannelID() {
+		return fmt.Errorf("Signed payload is for channel %v", request.Channel)
+	}
+
+	chaincodeName, err := getChaincodeName(ctx)
+	if err != nil {
+		return err
+	}
+
+	if request.Chaincode != chaincodeName {
+		return fmt.Errorf("Signed payload is for chaincode %v", request.Chaincode)
+	}
+
+	return useRequestNonce(ctx, request.Nonce)
+}
@@ -0,0 +1,226 @@
+package chaincode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// smallest group size an export can be requested with, callers can only ask for more
+const minResearchGroupSize = 5
+
+/// top coded age band, ages above are reported together
+const maxResearchAgeBand = 90
+
+/// de-identified medical record, without owner, issuer or exact date
+type ResearchRecord struct {
+	Type string `json:"type"`
+	MReport map[string]string `json:"mReport"`
+	YearOfIssue int `json:"yearOfIssue"`
+}
+
+/// de-identified patient row, only the generalized quasi identifiers are kept
+type ResearchRow struct {
+	AgeBand string `json:"ageBand"`
+	Gender string `json:"gender"`
+	Region string `json:"region"`
+	MedicalRecords []ResearchRecord `json:"medicalRecords"`
+	/// encrypted records left out of the row, only reported in total
+	encrypted int
+}
+
+/// encrypted reports cannot be read by the chaincode, they are left out of the export
+/// and counted in Encrypted over the released rows
+type ResearchExport struct {
+	K int `json:"k"`
+	Released int `json:"released"`
+	Suppressed int `json:"suppressed"`
+	Encrypted int `json:"encrypted"`
+	Data []ResearchRow `json:"data"`
+}
+
+/// generalize age into ten year bands
+func ageBand(age int) string {
+	if age >= maxResearchAgeBand {
+		return fmt.Sprintf("%v+", maxResearchAgeBand)
+	}
+	low := (age / 10) * 10
+	return fmt.Sprintf("%v-%v", low, low+9)
+}
+
+/// generalize location to the region (state and country), city is dropped
+func region(cpi ClientPersonalInfo) string {
+	return strings.ToUpper(strings.TrimSpace(cpi.State)) + ", " + strings.ToUpper(strings.TrimSpace(cpi.Country))
+}
+
+/// strip direct identifiers from the patient data
+/// encrypted records are left out and counted, the chaincode cannot read their reports
+func deIdentify(patientData PatientInfo) ResearchRow {
+	records := []ResearchRecord{}
+	encrypted := 0
+	for _, value := range patientData.MedicalRecords {
+		if value.Encrypted {
+			encrypted++
+			continue
+		}
+		records = append(records, ResearchRecord{Type: value.Type, MReport: value.MReport, YearOfIssue: value.issueTime().Year()})
+	}
+
+	return ResearchRow{
+		AgeBand: ageBand(patientData.PersonalInfo.Age),
+		Gender: strings.ToUpper(strings.TrimSpace(patientData.PersonalInfo.Gender)),
+		Region: region(patientData.PersonalInfo),
+		MedicalRecords: records,
+		encrypted: encrypted,
+	}
+}
+
+/// every released attribute that can link a row to a person: the generalized age, gender
+/// and region, and the years the records were issued in
+func researchQuasiIdentifiers(row ResearchRow) []string {
+
+	years := []int{}
+	seen := map[int]bool{}
+	for _, record := range row.MedicalRecords {
+		if !seen[record.YearOfIssue] {
+			seen[record.YearOfIssue] = true
+			years = append(years, record.YearOfIssue)
+		}
+	}
+	sort.Ints(years)
+
+	identifiers := []string{row.AgeBand, row.Gender, row.Region}
+	for _, year := range years {
+		identifiers = append(identifiers, strconv.Itoa(year))
+	}
+
+	return identifiers
+}
+
+/// equivalence class of the row over all its quasi identifiers, encoded as JSON so that
+/// values containing a separator cannot merge two classes
+func researchEquivalenceClass(row ResearchRow) (string, error) {
+	key, err := json.Marshal(researchQuasiIdentifiers(row))
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal quasi identifiers: %v", err)
+	}
+	return string(key), nil
+}
+
+/// release only the equivalence classes (same quasi identifiers) with at least k rows
+func kAnonymize(rows []ResearchRow, k int) (ResearchExport, error) {
+	groups := map[string][]ResearchRow{}
+	groupKeys := []string{}
+	for _, row := range rows {
+		key, err := researchEquivalenceClass(row)
+		if err != nil {
+			return ResearchExport{}, err
+		}
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	/// deterministic order so that every endorsing peer returns the same result
+	sort.Strings(groupKeys)
+
+	export := ResearchExport{K: k, Data: []ResearchRow{}}
+	for _, key := range groupKeys {
+		if len(groups[key]) < k {
+			export.Suppressed += len(groups[key])
+			continue
+		}
+		export.Data = append(export.Data, groups[key]...)
+		export.Released += len(groups[key])
+		for _, row := range groups[key] {
+			export.Encrypted += row.encrypted
+		}
+	}
+
+	return export, nil
+}
+
+/// patient gives or withdraws consent to include their data in research exports
+func (s *SmartContract) SetResearchConsent(ctx contractapi.TransactionContextInterface, consent string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return fmt.Errorf("Only Patient can set research consent")
+	}
+
+	check, err := strconv.ParseBool(consent)
+	if err != nil {
+		return fmt.Errorf("Cannot convert to bool: %v", err)
+	}
+
+	id, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Setting research consent failed: %v", err)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Cannot read client data: %v", err)
+	}
+
+	assetData.ResearchConsent = check
+
+	return s.putAssetData(ctx, assetData)
+}
+
+/// export de-identified data of the consenting patients
+/// rows are released only in groups of at least k patients sharing the same quasi identifiers
+/// the records of patients with a data key are encrypted and not part of the export
+func (s *SmartContract) ExportResearchData(ctx contractapi.TransactionContextInterface, k string) (*ResearchExport, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "admin" {
+		return nil, fmt.Errorf("Cannot execute the smart contract, only admin can")
+	}
+
+	groupSize, err := strconv.Atoi(k)
+	if err != nil {
+		return nil, fmt.Errorf("k field value is not valid: %v", err)
+	}
+
+	if groupSize < minResearchGroupSize {
+		return nil, fmt.Errorf("k must be at least %v", minResearchGroupSize)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot execute the smart contract: Error %v", err)
+	}
+
+	patients, err := getAllPatients(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read patient data: %v", err)
+	}
+
+	rows := []ResearchRow{}
+	for _, patientData := range patients {
+		if !patientData.ResearchConsent {
+			continue
+		}
+		rows = append(rows, deIdentify(patientData))
+	}
+
+	export, err := kAnonymize(rows, groupSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
@@ -0,0 +1,73 @@
+package chaincode
+
+import (
+	"time"
+	"testing"
+)
+
+func researchRow(ageBand, gender, region string, years ...int) ResearchRow {
+	records := []ResearchRecord{}
+	for _, year := range years {
+		records = append(records, ResearchRecord{Type: "CBC", MReport: map[string]string{}, YearOfIssue: year})
+	}
+	return ResearchRow{AgeBand: ageBand, Gender: gender, Region: region, MedicalRecords: records}
+}
+
+func TestKAnonymizeGroupsAllQuasiIdentifiers(t *testing.T) {
+	rows := []ResearchRow{
+		/// would share a class if the identifiers were joined with a separator
+		researchRow("30-39", "F", "A|B, C", 2022),
+		researchRow("30-39", "F|A", "B, C", 2022),
+		/// same demographics, one differs by the year of a record
+		researchRow("40-49", "M", "KA, IN", 2021, 2023),
+		researchRow("40-49", "M", "KA, IN", 2023, 2021, 2021),
+		researchRow("40-49", "M", "KA, IN", 2021, 2023),
+		researchRow("40-49", "M", "KA, IN", 2022),
+	}
+
+	export, err := kAnonymize(rows, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Released != 3 || export.Suppressed != 3 {
+		t.Fatalf("released %v, suppressed %v", export.Released, export.Suppressed)
+	}
+	for _, row := range export.Data {
+		if row.AgeBand != "40-49" || len(researchQuasiIdentifiers(row)) != 5 {
+			t.Errorf("released row %+v", row)
+		}
+	}
+}
+
+func TestResearchExportCountsEncryptedRecords(t *testing.T) {
+	issuedAt := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	rows := []ResearchRow{}
+	for i := 0; i < 3; i++ {
+		patientData := PatientInfo{
+			PersonalInfo: ClientPersonalInfo{Age: 42, Gender: "m", State: "KA", Country: "IN"},
+			MedicalRecords: []MedicalInfo{
+				{Type: "CBC", MReport: map[string]string{"hb": "13.5"}, IssuedAt: issuedAt},
+				{Type: "CBC", MReport: map[string]string{"hb": "sealed"}, IssuedAt: issuedAt, Encrypted: true},
+			},
+		}
+		rows = append(rows, deIdentify(patientData))
+	}
+	/// suppressed rows are not counted
+	rows = append(rows, deIdentify(PatientInfo{
+		PersonalInfo: ClientPersonalInfo{Age: 71, Gender: "f", State: "KA", Country: "IN"},
+		MedicalRecords: []MedicalInfo{{Type: "CBC", IssuedAt: issuedAt, Encrypted: true}},
+	}))
+
+	export, err := kAnonymize(rows, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Released != 3 || export.Encrypted != 3 {
+		t.Fatalf("released %v, encrypted %v", export.Released, export.Encrypted)
+	}
+	for _, row := range export.Data {
+		if len(row.MedicalRecords) != 1 || row.MedicalRecords[0].MReport["hb"] != "13.5" {
+			t.Errorf("released row %+v", row)
+		}
+	}
+}
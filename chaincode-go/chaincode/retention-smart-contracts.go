@@ -0,0 +1,736 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"bytes"
+	"strings"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// collections keep data forever (blockToLive 0), retention is applied by
+/// archiving and purging the objects past the retention period of their type
+const retentionPolicyObjectType = "retentionPolicy"
+const archiveReceiptObjectType = "archiveReceipt"
+
+/// medical records are kept inside the patient data, not under their own key
+const medicalRecordObjectType = "medicalRecord"
+
+/// objects archived and purged in a single transaction
+const maxArchiveItems = 100
+
+/// retention period in days per object type, 0 keeps the objects forever
+var defaultRetentionDays = map[string]int{
+	requestAgreementObjectType: 30,
+	dataAccessRequestObjectType: 30,
+	labOrderObjectType: 365,
+	prescriptionObjectType: 365,
+	medicalRecordObjectType: 0,
+}
+
+type RetentionPolicy struct {
+	SchemaVersion int `json:"schemaVersion"`
+	ObjectType string `json:"objectType"`
+	RetentionDays int `json:"retentionDays"`
+	UpdatedBy string `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type RetentionPolicies struct {
+	Data []RetentionPolicy `json:"data"`
+}
+
+/// archived object, key is the patient id for medical records
+type ArchiveItem struct {
+	Key string `json:"key"`
+	RecordID string `json:"recordId,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+/// snapshot of the objects past retention, signed by the hospital admin before purging
+type ArchiveBundle struct {
+	ObjectType string `json:"objectType"`
+	Collection string `json:"collection"`
+	RetentionDays int `json:"retentionDays"`
+	Cutoff time.Time `json:"cutoff"`
+	CreatedAt time.Time `json:"createdAt"`
+	Items []ArchiveItem `json:"items"`
+	Digest string `json:"digest"`
+}
+
+/// archive bundle and the admin signature on its digest, passed in the transient map
+type archiveInput struct {
+	Bundle ArchiveBundle `json:"bundle"`
+	AdminSign string `json:"adminSign"`
+}
+
+/// proof kept on the ledger that the purged objects were archived
+type ArchiveReceipt struct {
+	SchemaVersion int `json:"schemaVersion"`
+	ID string `json:"id"`
+	ObjectType string `json:"objectType"`
+	Digest string `json:"digest"`
+	AdminSign string `json:"adminSign"`
+	CertFingerprint string `json:"certFingerprint"`
+	ItemCount int `json:"itemCount"`
+	PurgedBy string `json:"purgedBy"`
+	PurgedAt time.Time `json:"purgedAt"`
+}
+
+type ArchiveReceipts struct {
+	Data []ArchiveReceipt `json:"data"`
+}
+
+func (rp *RetentionPolicy) objectType() string {
+	return retentionPolicyObjectType
+}
+
+func (rp *RetentionPolicy) setSchemaVersion(version int) {
+	rp.SchemaVersion = version
+}
+
+func (ar *ArchiveReceipt) objectType() string {
+	return archiveReceiptObjectType
+}
+
+func (ar *ArchiveReceipt) setSchemaVersion(version int) {
+	ar.SchemaVersion = version
+}
+
+/// sha256 of the archived items
+func archiveDigest(items []ArchiveItem) (string, error) {
+	itemsJSON, err := canonical.Marshal(items)
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal archive items: %v", err)
+	}
+	hash := sha256.Sum256(itemsJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+/// time the retention period of the object starts from
+/// zero time when the object is not finished yet or predates the timestamps
+func retentionTime(objectType string, value []byte) (time.Time, error) {
+
+	switch objectType {
+	case requestAgreementObjectType:
+		var agreement requestAgreement
+		err := unmarshalStoredObject(value, &agreement)
+		return agreement.CreatedAt, err
+	case dataAccessRequestObjectType:
+		var request dataAccessRequest
+		err := unmarshalStoredObject(value, &request)
+		return request.CreatedAt, err
+	case labOrderObjectType:
+		var order labOrder
+		err := unmarshalStoredObject(value, &order)
+		if order.Status != labOrderResulted {
+			return time.Time{}, err
+		}
+		return order.ResultedAt, err
+	case prescriptionObjectType:
+		var prescription Prescription
+		err := unmarshalStoredObject(value, &prescription)
+		return prescription.ExpiresAt, err
+	case medicalRecordObjectType:
+		var record MedicalInfo
+		err := json.Unmarshal(value, &record)
+		return record.issueTime(), err
+	}
+
+	return time.Time{}, fmt.Errorf("Retention is not supported for %v", objectType)
+}
+
+func isPastRetention(objectType string, value []byte, cutoff time.Time) (bool, error) {
+	retainedFrom, err := retentionTime(objectType, value)
+	if err != nil {
+		return false, fmt.Errorf("Cannot read %v: %v", objectType, err)
+	}
+	return !retainedFrom.IsZero() && retainedFrom.Before(cutoff), nil
+}
+
+/// collection the objects of the type are kept in
+func retentionCollection(ctx contractapi.TransactionContextInterface, objectType string) (string, error) {
+	if _, ok := defaultRetentionDays[objectType]; !ok {
+		return "", fmt.Errorf("Retention is not supported for %v", objectType)
+	}
+	if objectType == requestAgreementObjectType {
+		return org1AndOrg2PrivateCollection, nil
+	}
+	return getOrgCollectionName(ctx)
+}
+
+/// check the client is the admin of the org of the peer
+func (s *SmartContract) checkAdmin(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return "", fmt.Errorf("Cannot get the client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "admin" {
+		return "", fmt.Errorf("Cannot execute the smart contract, only admin can")
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Cannot execute the smart contract: Error %v", err)
+	}
+
+	return getInvokedClientIdentity(ctx)
+}
+
+/// retention policy of the org for the object type, the default when not set
+func readRetentionPolicy(ctx contractapi.TransactionContextInterface, objectType string) (*RetentionPolicy, error) {
+
+	days, ok := defaultRetentionDays[objectType]
+	if !ok {
+		return nil, fmt.Errorf("Retention is not supported for %v", objectType)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policyKey, err := ctx.GetStub().CreateCompositeKey(retentionPolicyObjectType, []string{objectType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	policyJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, policyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policy: %v", err)
+	}
+
+	if policyJSON == nil {
+		return &RetentionPolicy{ObjectType: objectType, RetentionDays: days}, nil
+	}
+
+	var policy RetentionPolicy
+	err = unmarshalStoredObject(policyJSON, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal retention policy: %v", err)
+	}
+
+	return &policy, nil
+}
+
+/// set the retention period of the object type for the org, 0 keeps the objects forever
+func (s *SmartContract) SetRetentionPolicy(ctx contractapi.TransactionContextInterface, objectType string, retentionDays int) error {
+
+	clientID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := defaultRetentionDays[objectType]; !ok {
+		return fmt.Errorf("Retention is not supported for %v", objectType)
+	}
+
+	if retentionDays < 0 {
+		return fmt.Errorf("Retention days cannot be negative")
+	}
+
+	updatedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	policy := RetentionPolicy{
+		ObjectType: objectType,
+		RetentionDays: retentionDays,
+		UpdatedBy: clientID,
+		UpdatedAt: updatedAt,
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+
+	policyKey, err := ctx.GetStub().CreateCompositeKey(retentionPolicyObjectType, []string{objectType})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	policyJSON, err := marshalStoredObject(&policy)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal retention policy: %v", err)
+	}
+
+	log.Printf("RetentionPolicy Put: collection %v, ID %v, Key %v", orgCollectionName, objectType, policyKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, policyKey, policyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put retention policy: %v", err)
+	}
+
+	return nil
+}
+
+/// retention policies of the org for every supported object type
+func (s *SmartContract) GetRetentionPolicies(ctx contractapi.TransactionContextInterface) (*RetentionPolicies, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []RetentionPolicy{}
+	for _, objectType := range []string{requestAgreementObjectType, dataAccessRequestObjectType, labOrderObjectType, prescriptionObjectType, medicalRecordObjectType} {
+		policy, err := readRetentionPolicy(ctx, objectType)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *policy)
+	}
+
+	return &RetentionPolicies{Data: policies}, nil
+}
+
+/// snapshot of the objects of the type past retention, at most maxArchiveItems
+/// the admin signs the digest of the bundle and passes it to PurgeArchive
+func (s *SmartContract) ExportArchive(ctx contractapi.TransactionContextInterface, objectType string) (*ArchiveBundle, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := readRetentionPolicy(ctx, objectType)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.RetentionDays == 0 {
+		return nil, fmt.Errorf("%v objects are retained forever", objectType)
+	}
+
+	collection, err := retentionCollection(ctx, objectType)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := createdAt.AddDate(0, 0, -policy.RetentionDays)
+
+	items, err := s.archiveCandidates(ctx, objectType, collection, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := archiveDigest(items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveBundle{
+		ObjectType: objectType,
+		Collection: collection,
+		RetentionDays: policy.RetentionDays,
+		Cutoff: cutoff,
+		CreatedAt: createdAt,
+		Items: items,
+		Digest: digest,
+	}, nil
+}
+
+/// purge the objects of a signed archive bundle, passed in the transient map
+/// every object must be unchanged since the export and still past retention
+func (s *SmartContract) PurgeArchive(ctx contractapi.TransactionContextInterface) (string, error) {
+
+	clientID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	archiveJSON, ok := transientMap["archive"]
+	if !ok {
+		return "", fmt.Errorf("archive not found in the transient map")
+	}
+
+	var input archiveInput
+	err = json.Unmarshal(archiveJSON, &input)
+	if err != nil {
+		return "", fmt.Errorf("Error cannot unmarshal: %v", err)
+	}
+
+	bundle := input.Bundle
+	if len(bundle.Items) == 0 || len(bundle.Items) > maxArchiveItems {
+		return "", fmt.Errorf("Archive must contain between 1 and %v items", maxArchiveItems)
+	}
+
+	digest, err := archiveDigest(bundle.Items)
+	if err != nil {
+		return "", err
+	}
+
+	if digest != bundle.Digest {
+		return "", fmt.Errorf("Archive digest does not match the archived items")
+	}
+
+	err = verifyClientSignature(ctx, []byte(bundle.Digest), input.AdminSign)
+	if err != nil {
+		return "", fmt.Errorf("Cannot purge archive: %v", err)
+	}
+
+	policy, err := readRetentionPolicy(ctx, bundle.ObjectType)
+	if err != nil {
+		return "", err
+	}
+
+	if policy.RetentionDays == 0 {
+		return "", fmt.Errorf("%v objects are retained forever", bundle.ObjectType)
+	}
+
+	collection, err := retentionCollection(ctx, bundle.ObjectType)
+	if err != nil {
+		return "", err
+	}
+
+	purgedAt, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	cutoff := purgedAt.AddDate(0, 0, -policy.RetentionDays)
+
+	if bundle.ObjectType == medicalRecordObjectType {
+		err = s.purgeMedicalRecords(ctx, collection, bundle.Items, cutoff)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		for _, item := range bundle.Items {
+			err = s.purgeArchiveItem(ctx, bundle.ObjectType, collection, item, cutoff)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	fingerprint, err := storeClientCertificate(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	receipt := ArchiveReceipt{
+		ID: ctx.GetStub().GetTxID(),
+		ObjectType: bundle.ObjectType,
+		Digest: bundle.Digest,
+		AdminSign: input.AdminSign,
+		CertFingerprint: fingerprint,
+		ItemCount: len(bundle.Items),
+		PurgedBy: clientID,
+		PurgedAt: purgedAt,
+	}
+
+	err = putArchiveReceipt(ctx, &receipt)
+	if err != nil {
+		return "", err
+	}
+
+	return receipt.ID, nil
+}
+
+/// receipts of the archives purged in the org
+func (s *SmartContract) GetArchiveReceipts(ctx contractapi.TransactionContextInterface) (*ArchiveReceipts, error) {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(orgCollectionName, archiveReceiptObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	receipts := []ArchiveReceipt{}
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var receipt ArchiveReceipt
+		err = unmarshalStoredObject(response.Value, &receipt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	return &ArchiveReceipts{Data: receipts}, nil
+}
+
+/// objects of the type past the cutoff
+func (s *SmartContract) archiveCandidates(ctx contractapi.TransactionContextInterface, objectType string, collection string, cutoff time.Time) ([]ArchiveItem, error) {
+
+	items := []ArchiveItem{}
+
+	if objectType == medicalRecordObjectType {
+		patients, err := getAllPatients(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read patient data: %v", err)
+		}
+
+		for _, patientData := range patients {
+			if patientData.Meta.CollectionName != collection {
+				continue
+			}
+
+			for _, record := range patientData.MedicalRecords {
+				recordJSON, err := json.Marshal(record)
+				if err != nil {
+					return nil, fmt.Errorf("Cannot marshal medical record: %v", err)
+				}
+
+				expired, err := isPastRetention(objectType, recordJSON, cutoff)
+				if err != nil {
+					return nil, err
+				}
+
+				if expired && len(record.ID) != 0 {
+					items = append(items, ArchiveItem{Key: patientData.ID, RecordID: record.ID, Value: recordJSON})
+				}
+
+				if len(items) == maxArchiveItems {
+					return items, nil
+				}
+			}
+		}
+
+		return items, nil
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, objectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() && len(items) < maxArchiveItems {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		expired, err := isPastRetention(objectType, response.Value, cutoff)
+		if err != nil {
+			return nil, err
+		}
+
+		if !expired {
+			continue
+		}
+
+		if objectType == requestAgreementObjectType && !s.isOrgAgreement(ctx, response.Value) {
+			continue
+		}
+
+		items = append(items, ArchiveItem{Key: response.Key, Value: response.Value})
+	}
+
+	return items, nil
+}
+
+/// agreements are shared between the orgs, each org archives the ones of its doctors
+func (s *SmartContract) isOrgAgreement(ctx contractapi.TransactionContextInterface, value []byte) bool {
+	var agreement requestAgreement
+	if err := unmarshalStoredObject(value, &agreement); err != nil {
+		return false
+	}
+	_, err := s.ReadDoctorPrivateData(ctx, agreement.MetaData.ClientID)
+	return err == nil
+}
+
+/// archived value compacted the way it is stored, checked to be past retention
+func archivedValue(objectType string, item ArchiveItem, cutoff time.Time) ([]byte, error) {
+
+	var archived bytes.Buffer
+	err := json.Compact(&archived, item.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Archived value of %v is not valid: %v", item.Key, err)
+	}
+
+	expired, err := isPastRetention(objectType, archived.Bytes(), cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	if !expired {
+		return nil, fmt.Errorf("%v %v is not past retention", objectType, item.Key)
+	}
+
+	return archived.Bytes(), nil
+}
+
+/// purge a single archived object after checking it against the current state
+func (s *SmartContract) purgeArchiveItem(ctx contractapi.TransactionContextInterface, objectType string, collection string, item ArchiveItem, cutoff time.Time) error {
+
+	archived, err := archivedValue(objectType, item, cutoff)
+	if err != nil {
+		return err
+	}
+
+	/// the key must be an object of the archived type
+	keyType, _, err := ctx.GetStub().SplitCompositeKey(item.Key)
+	if err != nil || keyType != objectType {
+		return fmt.Errorf("%v is not a %v key", item.Key, objectType)
+	}
+
+	current, err := ctx.GetStub().GetPrivateData(collection, item.Key)
+	if err != nil {
+		return fmt.Errorf("failed to read %v: %v", objectType, err)
+	}
+
+	if current == nil {
+		return fmt.Errorf("%v %v does not exist", objectType, item.Key)
+	}
+
+	if !bytes.Equal(current, archived) {
+		return fmt.Errorf("%v %v changed since it was archived", objectType, item.Key)
+	}
+
+	if objectType == requestAgreementObjectType && !s.isOrgAgreement(ctx, current) {
+		return fmt.Errorf("Request agreement %v was not created by a doctor of the org", item.Key)
+	}
+
+	/// the prescription code points to the purged prescription
+	if objectType == prescriptionObjectType {
+		var prescription Prescription
+		err = unmarshalStoredObject(current, &prescription)
+		if err != nil {
+			return fmt.Errorf("Cannot unmarshal prescription: %v", err)
+		}
+
+		codeKey, err := ctx.GetStub().CreateCompositeKey(prescriptionCodeObjectType, []string{prescription.CodeHash})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+
+		err = ctx.GetStub().PurgePrivateData(collection, codeKey)
+		if err != nil {
+			return fmt.Errorf("failed to purge prescription code: %v", err)
+		}
+	}
+
+	log.Printf("Purge: collection %v, Key %v", collection, item.Key)
+	err = ctx.GetStub().PurgePrivateData(collection, item.Key)
+	if err != nil {
+		return fmt.Errorf("failed to purge %v: %v", objectType, err)
+	}
+
+	return nil
+}
+
+/// remove the archived medical records from the patient data
+/// records of a patient are removed together, the patient data is written once
+func (s *SmartContract) purgeMedicalRecords(ctx contractapi.TransactionContextInterface, collection string, items []ArchiveItem, cutoff time.Time) error {
+
+	archivedRecords := map[string]map[string][]byte{}
+	pids := []string{}
+	for _, item := range items {
+		archived, err := archivedValue(medicalRecordObjectType, item, cutoff)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := archivedRecords[item.Key]; !ok {
+			archivedRecords[item.Key] = map[string][]byte{}
+			pids = append(pids, item.Key)
+		}
+		archivedRecords[item.Key][item.RecordID] = archived
+	}
+
+	for _, pid := range pids {
+		assetData, err := s.readAssetPrivateData(ctx, pid)
+		if err != nil {
+			return err
+		}
+
+		if assetData.Meta.CollectionName != collection {
+			return fmt.Errorf("Patient %v is not kept in %v", pid, collection)
+		}
+
+		records := []MedicalInfo{}
+		for _, record := range assetData.MedicalRecords {
+			archived, ok := archivedRecords[pid][record.ID]
+			if !ok {
+				records = append(records, record)
+				continue
+			}
+
+			current, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("Cannot marshal medical record: %v", err)
+			}
+
+			if !bytes.Equal(current, archived) {
+				return fmt.Errorf("Medical record %v changed since it was archived", record.ID)
+			}
+
+			delete(archivedRecords[pid], record.ID)
+		}
+
+		if len(archivedRecords[pid]) != 0 {
+			return fmt.Errorf("Archived medical records of %v not found", pid)
+		}
+
+		assetData.MedicalRecords = records
+
+		err = s.putAssetData(ctx, assetData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func putArchiveReceipt(ctx contractapi.TransactionContextInterface, receipt *ArchiveReceipt) error {
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(archiveReceiptObjectType, []string{receipt.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	receiptJSON, err := marshalStoredObject(receipt)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal archive receipt: %v", err)
+	}
+
+	log.Printf("ArchiveReceipt Put: collection %v, ID %v, Key %v", orgCollectionName, receipt.ID, receiptKey)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, receiptKey, receiptJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put archive receipt: %v", err)
+	}
+
+	return nil
+}
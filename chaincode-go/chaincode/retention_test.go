@@ -0,0 +1,229 @@
+package chaincode
+
+import (
+	"time"
+	"strings"
+	"testing"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) setRetentionPolicy(admin *fabrictest.Identity, objectType string, retentionDays int) {
+	err := sc.submit(admin, "SetRetentionPolicy", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.SetRetentionPolicy(ctx, objectType, retentionDays)
+	})
+	if err != nil {
+		sc.t.Fatalf("SetRetentionPolicy: %v", err)
+	}
+}
+
+func (sc *scenario) exportArchive(admin *fabrictest.Identity, objectType string) *ArchiveBundle {
+	var bundle *ArchiveBundle
+	err := sc.network.NewTransaction(admin, "ExportArchive", objectType).Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		bundle, err = sc.contract.ExportArchive(ctx, objectType)
+		return err
+	})
+	if err != nil {
+		sc.t.Fatalf("ExportArchive: %v", err)
+	}
+	return bundle
+}
+
+/// purge the bundle with the admin signature on its digest
+func (sc *scenario) purgeArchive(admin *fabrictest.Identity, bundle *ArchiveBundle) (string, error) {
+	adminSign, err := admin.Sign([]byte(bundle.Digest))
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+	archiveJSON, err := json.Marshal(archiveInput{Bundle: *bundle, AdminSign: adminSign})
+	if err != nil {
+		sc.t.Fatal(err)
+	}
+
+	var receiptID string
+	tx := sc.network.NewTransaction(admin, "PurgeArchive").WithTransient("archive", archiveJSON)
+	err = tx.Submit(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		receiptID, err = sc.contract.PurgeArchive(ctx)
+		return err
+	})
+	return receiptID, err
+}
+
+/// bundle of the items with their digest, as an export would return it
+func signedItems(t *testing.T, bundle *ArchiveBundle, items []ArchiveItem) *ArchiveBundle {
+	forged := *bundle
+	forged.Items = items
+	digest, err := archiveDigest(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Digest = digest
+	return &forged
+}
+
+func prescriptionKey(pid, prescriptionID string) string {
+	return "\x00" + prescriptionObjectType + "\x00" + pid + "\x00" + prescriptionID + "\x00"
+}
+
+/// two prescriptions of the patient, the first past retention and the second not
+func newRetentionScenario(t *testing.T) (*scenario, *fabrictest.Identity, string, string) {
+	sc, _, doctor, _ := newAppointedScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+
+	expiredID, err := sc.issuePrescription(doctor, "code-expired", testPrescriptionContent(0))
+	if err != nil {
+		t.Fatalf("IssuePrescription: %v", err)
+	}
+	sc.network.Advance(10 * 24 * time.Hour)
+	currentID, err := sc.issuePrescription(doctor, "code-current", testPrescriptionContent(0))
+	if err != nil {
+		t.Fatalf("IssuePrescription: %v", err)
+	}
+
+	/// the first expired 5 days ago, the second expires in 5 days, retained for a day after expiry
+	sc.network.Advance(time.Duration(prescriptionValidityDays-5) * 24 * time.Hour)
+	sc.setRetentionPolicy(admin, prescriptionObjectType, 1)
+
+	return sc, admin, expiredID, currentID
+}
+
+func TestPurgeArchiveCutoff(t *testing.T) {
+	sc, admin, expiredID, currentID := newRetentionScenario(t)
+	pharmacy := sc.client("Org1MSP", "pharmacy", "0001H")
+
+	bundle := sc.exportArchive(admin, prescriptionObjectType)
+	if len(bundle.Items) != 1 || bundle.Items[0].Key != prescriptionKey("0001P", expiredID) {
+		t.Fatalf("archived items = %+v", bundle.Items)
+	}
+
+	/// an object inside the retention period cannot be added to the bundle
+	current := ArchiveItem{Key: prescriptionKey("0001P", currentID), Value: sc.network.PrivateData(org1CollectionName, prescriptionKey("0001P", currentID))}
+	_, err := sc.purgeArchive(admin, signedItems(t, bundle, append(bundle.Items, current)))
+	if err == nil || !strings.Contains(err.Error(), "not past retention") {
+		t.Errorf("prescription inside the retention period purged: %v", err)
+	}
+
+	receiptID, err := sc.purgeArchive(admin, bundle)
+	if err != nil {
+		t.Fatalf("PurgeArchive: %v", err)
+	}
+
+	if sc.network.PrivateData(org1CollectionName, prescriptionKey("0001P", expiredID)) != nil {
+		t.Errorf("expired prescription not purged")
+	}
+	if _, err := sc.readPrescriptionByCode(pharmacy, "code-expired"); err == nil {
+		t.Errorf("code of the purged prescription still indexed")
+	}
+	if _, err := sc.readPrescriptionByCode(pharmacy, "code-current"); err != nil {
+		t.Errorf("prescription inside the retention period: %v", err)
+	}
+
+	var receipts *ArchiveReceipts
+	err = sc.network.NewTransaction(admin, "GetArchiveReceipts").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		receipts, err = sc.contract.GetArchiveReceipts(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("GetArchiveReceipts: %v", err)
+	}
+	if len(receipts.Data) != 1 || receipts.Data[0].ID != receiptID || receipts.Data[0].Digest != bundle.Digest || receipts.Data[0].ItemCount != 1 {
+		t.Errorf("receipts = %+v", receipts.Data)
+	}
+}
+
+func TestPurgeArchiveMatchesExportedBytes(t *testing.T) {
+	sc, admin, expiredID, _ := newRetentionScenario(t)
+	bundle := sc.exportArchive(admin, prescriptionObjectType)
+
+	/// items changed after the digest was taken
+	tampered := *bundle
+	tampered.Items = []ArchiveItem{{Key: bundle.Items[0].Key, Value: json.RawMessage(strings.Replace(string(bundle.Items[0].Value), "amoxicillin", "ibuprofen", 1))}}
+	_, err := sc.purgeArchive(admin, &tampered)
+	if err == nil || !strings.Contains(err.Error(), "digest does not match") {
+		t.Errorf("archive with a wrong digest purged: %v", err)
+	}
+
+	/// archived value other than the stored one, with its own digest
+	_, err = sc.purgeArchive(admin, signedItems(t, bundle, tampered.Items))
+	if err == nil || !strings.Contains(err.Error(), "changed since it was archived") {
+		t.Errorf("archive with another value purged: %v", err)
+	}
+
+	/// digest signed by another client
+	other := sc.client("Org1MSP", "admin", "0002A")
+	adminSign, err := other.Sign([]byte(bundle.Digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiveJSON, err := json.Marshal(archiveInput{Bundle: *bundle, AdminSign: adminSign})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sc.network.NewTransaction(admin, "PurgeArchive").WithTransient("archive", archiveJSON).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.PurgeArchive(ctx)
+		return err
+	})
+	if err == nil {
+		t.Errorf("archive signed by another admin purged")
+	}
+
+	/// the stored object changed after the export
+	stored := sc.network.PrivateData(org1CollectionName, prescriptionKey("0001P", expiredID))
+	err = sc.submit(admin, "Put", func(ctx contractapi.TransactionContextInterface) error {
+		changed := strings.Replace(string(stored), `"dispensings":[]`, `"dispensings":[{"pharmacyId":"0001H","txId":"tx","dispensedAt":"2024-01-01T00:00:00Z"}]`, 1)
+		return ctx.GetStub().PutPrivateData(org1CollectionName, prescriptionKey("0001P", expiredID), []byte(changed))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sc.purgeArchive(admin, bundle)
+	if err == nil || !strings.Contains(err.Error(), "changed since it was archived") {
+		t.Errorf("prescription changed after the export purged: %v", err)
+	}
+	if sc.network.PrivateData(org1CollectionName, prescriptionKey("0001P", expiredID)) == nil {
+		t.Errorf("prescription purged by a rejected archive")
+	}
+}
+
+func TestPurgeArchiveRequiresExport(t *testing.T) {
+	sc, admin, _, _ := newRetentionScenario(t)
+	bundle := sc.exportArchive(admin, prescriptionObjectType)
+
+	/// nothing exported
+	if _, err := sc.purgeArchive(admin, signedItems(t, bundle, []ArchiveItem{})); err == nil {
+		t.Errorf("empty archive purged")
+	}
+
+	/// object that was never stored
+	missing := ArchiveItem{Key: prescriptionKey("0001P", "missing"), Value: bundle.Items[0].Value}
+	_, err := sc.purgeArchive(admin, signedItems(t, bundle, []ArchiveItem{missing}))
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("archive of a missing prescription purged: %v", err)
+	}
+
+	/// object of another type under the exported value
+	patientItem := ArchiveItem{Key: "0001P", Value: bundle.Items[0].Value}
+	_, err = sc.purgeArchive(admin, signedItems(t, bundle, []ArchiveItem{patientItem}))
+	if err == nil || !strings.Contains(err.Error(), "is not a prescription key") {
+		t.Errorf("patient data purged as a prescription: %v", err)
+	}
+
+	/// only the admin purges
+	doctor := sc.client("Org1MSP", "doctor", "0001D")
+	if _, err := sc.purgeArchive(doctor, bundle); err == nil {
+		t.Errorf("archive purged by a doctor")
+	}
+
+	/// the export is purged once
+	if _, err := sc.purgeArchive(admin, bundle); err != nil {
+		t.Fatalf("PurgeArchive: %v", err)
+	}
+	_, err = sc.purgeArchive(admin, bundle)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("archive purged twice: %v", err)
+	}
+}
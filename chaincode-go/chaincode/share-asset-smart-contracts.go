@@ -5,7 +5,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
-	"encoding/json"
+	"time"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -37,11 +37,15 @@ type metaData struct {
 
 /// request agreement 
 type requestAgreement struct {
+	SchemaVersion int `json:"schemaVersion"`
 	MetaData metaData `json:"metaData"`
 	PID  string `json:"pid"`
 	HID  string `json:"hid"`
 	DigitalSignatures signatures `json:"digitalSignatures"`
 	Valid bool `json:"valid"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Payload string `json:"payload"`
 }
 
 func (ra *requestAgreement)assignData(pid, hid, clientSign, orgSign string) error {
@@ -81,6 +85,14 @@ func (ra *requestAgreement) verifyMetaData() error {
 	return nil
 }
 
+func (ra *requestAgreement) objectType() string {
+	return requestAgreementObjectType
+}
+
+func (ra *requestAgreement) setSchemaVersion(version int) {
+	ra.SchemaVersion = version
+}
+
 func (ra *requestAgreement) getClientID() (string, error) {
 	if len(ra.MetaData.ClientID) == 0 {
 		return "", fmt.Errorf("Client Id not found in meta data")
@@ -96,9 +108,10 @@ func (ra *requestAgreement) getClientID() (string, error) {
   * PID patient ID
   * HID hospital ID
   * Digital Signatures 
+  * payload signed by the doctor and the hospital, with the days until the agreement expires
 */
 
-func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContextInterface, pid string, docSign string, hospSign string, user string, org string) error {
+func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContextInterface, payload string, docSign string, hospSign string) error {
 
 	/// check if the client is doctor 
 	client, err := s.GetIdentityAttribute(ctx, "role")
@@ -116,10 +129,30 @@ func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContex
 		return fmt.Errorf("Error getting client id: %v", err)
 	}
 
+	request, err := parseRequestPayload(payload, requestAgreementObjectType)
+	if err != nil {
+		return fmt.Errorf("Cannot create request agreement: %v", err)
+	}
+
+	if request.MetaData.ClientID != id {
+		return fmt.Errorf("Signed payload is not issued by %v", id)
+	}
+
+	err = verifyClientJSONSignature(ctx, []byte(payload), docSign)
+	if err != nil {
+		return fmt.Errorf("Cannot create request agreement: %v", err)
+	}
+
+	pid := request.PID
+
+	createdAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
 
-	/// check if there is already a request 
-	_, err = s.ReadRequestAgreement(ctx, pid);
-	if err == nil {
+	/// check if there is already a request, an expired one is replaced
+	existing, err := s.ReadRequestAgreement(ctx, pid);
+	if err == nil && !isRequestExpired(existing.ExpiresAt, createdAt) {
 		return fmt.Errorf("Share Request Agreement for %v patient ID already exits", pid);
 	}
 
@@ -130,7 +163,7 @@ func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContex
 	}
 
 	/// check asset data already exists 
-	assetData, err := s.ReadAssetPrivateData(ctx, pid)
+	assetData, err := s.readAssetPrivateData(ctx, pid)
 	if assetData != nil {
 		return fmt.Errorf("Data Already exists")
 	}
@@ -149,6 +182,15 @@ func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContex
 		return fmt.Errorf("Create Request Agreement cannot be performed: Error %v", err)
 	}
 
+	if request.HID != clientID {
+		return fmt.Errorf("Signed payload is for hospital %v", request.HID)
+	}
+
+	err = checkRequestPayload(ctx, request, createdAt)
+	if err != nil {
+		return fmt.Errorf("Cannot create request agreement: %v", err)
+	}
+
 	// Create agreeement that indicates which identity that is requesting data
 	requestAgreeKey, err := ctx.GetStub().CreateCompositeKey(requestAgreementObjectType, []string{pid})
 	if err != nil {
@@ -156,7 +198,7 @@ func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContex
 	}
 
 	var requestAgreementData requestAgreement
-	err = requestAgreementData.assignMetaData(org, user, id)
+	err = requestAgreementData.assignMetaData(request.MetaData.Org, request.MetaData.User, id)
 	if err != nil {
 		return fmt.Errorf("Cannot create request agreement: %v", err)
 	}
@@ -166,7 +208,15 @@ func (s *SmartContract) CreateRequestAgreement(ctx contractapi.TransactionContex
 		return fmt.Errorf("Cannot create request agreement: %v", err)
 	}
 
-	requestAgreementJSON, err := json.Marshal(requestAgreementData)
+	requestAgreementData.CreatedAt = createdAt
+	requestAgreementData.ExpiresAt, err = requestExpiry(createdAt, request.ExpiryDays)
+	if err != nil {
+		return fmt.Errorf("Cannot create request agreement: %v", err)
+	}
+
+	requestAgreementData.Payload = payload
+
+	requestAgreementJSON, err := marshalStoredObject(&requestAgreementData)
 	if err != nil {
 		return fmt.Errorf("Cannot marshal request agreement: %v", err)
 	}
@@ -211,7 +261,7 @@ func (s *SmartContract) ReadRequestAgreement(ctx contractapi.TransactionContextI
 
 	/// request agreement structure 
 	var agreement requestAgreement
-	err = json.Unmarshal(requestAgreementJSON, &agreement)
+	err = unmarshalStoredObject(requestAgreementJSON, &agreement)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot unmarshal request agreement: %v", err)
 	}
@@ -266,7 +316,7 @@ func (s *SmartContract) NotifyRequestAgreement(ctx contractapi.TransactionContex
 
 	/// request agreement structure 
 	var agreement requestAgreement
-	err = json.Unmarshal(requestAgreementJSON, &agreement)
+	err = unmarshalStoredObject(requestAgreementJSON, &agreement)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot unmarshal request agreement: %v", err)
 	}
@@ -306,6 +356,16 @@ func (s *SmartContract) ValidateRequestAgreement(ctx contractapi.TransactionCont
 		return fmt.Errorf("Cannot read request agreement: %v", err)
 	}
 
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = checkRequestNotExpired(agreement.ExpiresAt, now)
+	if err != nil {
+		return fmt.Errorf("Cannot validate request agreement: %v", err)
+	}
+
 	/// after verifying the digital signatures on the request agreement, assign the valid 
 	/// field in the request agreement 
 	agreement.Valid = check
@@ -316,7 +376,7 @@ func (s *SmartContract) ValidateRequestAgreement(ctx contractapi.TransactionCont
 		return fmt.Errorf("failed to create composite key: %v", err)
 	}
 
-	requestAgreementJSON, err := json.Marshal(agreement)
+	requestAgreementJSON, err := marshalStoredObject(agreement)
 	if err != nil {
 		return fmt.Errorf("Cannot marshal request agreement: %v", err)
 	}
@@ -389,7 +449,7 @@ func (s *SmartContract) ShareAssetData(ctx contractapi.TransactionContextInterfa
 		return fmt.Errorf("Error while verfiy request agreement: %v", err)
 	}
 
-	assetData, err := s.ReadAssetPrivateData(ctx, assetID)
+	assetData, err := s.readAssetPrivateData(ctx, assetID)
 	if err != nil {
 		return fmt.Errorf("Error reading asset data from the collection: %v", err)
 	}
@@ -409,7 +469,7 @@ func (s *SmartContract) ShareAssetData(ctx contractapi.TransactionContextInterfa
 	assetData.addMetaData(org1AndOrg2PrivateCollection)
 
 	/// write data into the common collection 
-	assetJSONData, err := json.Marshal(assetData)
+	assetJSONData, err := marshalStoredObject(assetData)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal the asset data: %v", err)
 	}
@@ -420,6 +480,11 @@ func (s *SmartContract) ShareAssetData(ctx contractapi.TransactionContextInterfa
 		return err
 	}
 
+	err = s.recordAccess(ctx, assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+
 	/// delete the data from the private collection of the organization 
 	orgCollectionName, err := getOrgCollectionName(ctx)
 	if err != nil {
@@ -474,7 +539,7 @@ func (s *SmartContract) verifyRequestAgreement(ctx contractapi.TransactionContex
 		return err
 	}
 
-	assetData, err := s.ReadAssetPrivateData(ctx, agreement.PID)
+	assetData, err := s.readAssetPrivateData(ctx, agreement.PID)
 	if err != nil {
 		return err
 	}
@@ -492,6 +557,16 @@ func (s *SmartContract) verifyRequestAgreement(ctx contractapi.TransactionContex
     if !agreement.Valid {
 		return fmt.Errorf("Request Agreement is not valid request, digital signature falied to verify")
 	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = checkRequestNotExpired(agreement.ExpiresAt, now)
+	if err != nil {
+		return fmt.Errorf("Request Agreement is not valid request: %v", err)
+	}
 	
 	return nil
 }
@@ -0,0 +1,123 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// aggregate counts of the org, no individual patient data is returned
+type OrgStatistics struct {
+	TotalPatients int `json:"totalPatients"`
+	TotalDoctors int `json:"totalDoctors"`
+	TotalRecords int `json:"totalRecords"`
+	RecordsByType map[string]int `json:"recordsByType"`
+	RecordsByMonth map[string]int `json:"recordsByMonth"`
+	PatientsByGender map[string]int `json:"patientsByGender"`
+	DoctorsBySpecialization map[string]int `json:"doctorsBySpecialization"`
+	PatientsBySpecialization map[string]int `json:"patientsBySpecialization"`
+	/// patients of each specialization, a patient of two doctors of the same specialization counts once
+	specializationPatients map[string]map[string]bool
+}
+
+func newOrgStatistics() *OrgStatistics {
+	return &OrgStatistics{
+		RecordsByType: map[string]int{},
+		RecordsByMonth: map[string]int{},
+		PatientsByGender: map[string]int{},
+		DoctorsBySpecialization: map[string]int{},
+		PatientsBySpecialization: map[string]int{},
+		specializationPatients: map[string]map[string]bool{},
+	}
+}
+
+func (st *OrgStatistics) addPatient(patientData PatientInfo) {
+	st.TotalPatients++
+	st.PatientsByGender[strings.ToUpper(patientData.PersonalInfo.Gender)]++
+
+	for _, record := range patientData.MedicalRecords {
+		st.TotalRecords++
+		st.RecordsByType[strings.ToUpper(record.Type)]++
+		st.RecordsByMonth[record.issueTime().Format("2006-01")]++
+	}
+}
+
+func (st *OrgStatistics) addDoctor(doctorData DoctorInfo) {
+	specialization := strings.ToUpper(doctorData.Specialization)
+
+	st.TotalDoctors++
+	st.DoctorsBySpecialization[specialization]++
+	patients, ok := st.specializationPatients[specialization]
+	if !ok {
+		patients = map[string]bool{}
+		st.specializationPatients[specialization] = patients
+	}
+	for _, pid := range doctorData.PIDS {
+		patients[pid] = true
+	}
+	st.PatientsBySpecialization[specialization] = len(patients)
+}
+
+/// get statistics of the org, counts of records by type and month of issue,
+/// patients by gender and doctors and their patients by specialization
+func (s *SmartContract) GetOrgStatistics(ctx contractapi.TransactionContextInterface) (*OrgStatistics, error) {
+
+	/// access control
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "admin" {
+		return nil, fmt.Errorf("Cannot execute the smart contract, only admin can")
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot execute the smart contract: Error %v", err)
+	}
+
+	statistics := newOrgStatistics()
+
+	patients, err := getAllPatients(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read patient data: %v", err)
+	}
+
+	for _, patientData := range patients {
+		statistics.addPatient(patientData)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(orgCollectionName, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		/// check the data is Doctor data
+		if !checkID(response.Key, "D") {
+			continue
+		}
+
+		var doctorData DoctorInfo
+		err = unmarshalStoredObject(response.Value, &doctorData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+
+		statistics.addDoctor(doctorData)
+	}
+
+	return statistics, nil
+}
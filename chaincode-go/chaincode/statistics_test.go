@@ -0,0 +1,22 @@
+package chaincode
+
+import (
+	"testing"
+)
+
+func TestPatientsBySpecializationCountsUniquePatients(t *testing.T) {
+	statistics := newOrgStatistics()
+	statistics.addDoctor(DoctorInfo{ID: "0001D", Specialization: "Cardiology", PIDS: []string{"0001P", "0002P"}})
+	statistics.addDoctor(DoctorInfo{ID: "0002D", Specialization: "cardiology", PIDS: []string{"0002P", "0003P"}})
+	statistics.addDoctor(DoctorInfo{ID: "0003D", Specialization: "Nephrology", PIDS: []string{"0001P"}})
+
+	if count := statistics.PatientsBySpecialization["CARDIOLOGY"]; count != 3 {
+		t.Errorf("cardiology patients = %v", count)
+	}
+	if count := statistics.PatientsBySpecialization["NEPHROLOGY"]; count != 1 {
+		t.Errorf("nephrology patients = %v", count)
+	}
+	if count := statistics.DoctorsBySpecialization["CARDIOLOGY"]; count != 2 {
+		t.Errorf("cardiology doctors = %v", count)
+	}
+}
@@ -0,0 +1,357 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+	"strconv"
+	"strings"
+	"math/rand"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// configuration of the chaincode, set when the chaincode is initialized
+const chaincodeConfigKey = "chaincodeConfig"
+
+/// clients and records generated in a single transaction
+const maxSyntheticClients = 500
+const maxSyntheticRecordsPerPatient = 12
+
+type ChaincodeConfig struct {
+	SyntheticData bool `json:"syntheticData"`
+	InitializedBy string `json:"initializedBy"`
+	InitializedAt time.Time `json:"initializedAt"`
+}
+
+type SyntheticDataResult struct {
+	Seed int64 `json:"seed"`
+	PIDs []string `json:"pids"`
+	DIDs []string `json:"dids"`
+	Records int `json:"records"`
+}
+
+/// initialize the chaincode, invoked with --isInit when the chaincode definition requires init
+/// synthetic data can only be generated on channels initialized with syntheticData true
+/// the chaincode is initialized once, synthetic data cannot be enabled later on a live channel
+func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface, syntheticData string) error {
+
+	adminID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing, err := ctx.GetStub().GetState(chaincodeConfigKey)
+	if err != nil {
+		return fmt.Errorf("failed to read chaincode config: %v", err)
+	}
+
+	if existing != nil {
+		return fmt.Errorf("Chaincode is already initialized")
+	}
+
+	enabled, err := strconv.ParseBool(syntheticData)
+	if err != nil {
+		return fmt.Errorf("Cannot convert to bool: %v", err)
+	}
+
+	initializedAt, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	config := ChaincodeConfig{SyntheticData: enabled, InitializedBy: adminID, InitializedAt: initializedAt}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal chaincode config: %v", err)
+	}
+
+	log.Printf("InitLedger Put: Key %v, synthetic data %v", chaincodeConfigKey, enabled)
+	err = ctx.GetStub().PutState(chaincodeConfigKey, configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put chaincode config: %v", err)
+	}
+
+	return nil
+}
+
+/// configuration of the chaincode, the zero config when the chaincode was not initialized
+func readChaincodeConfig(ctx contractapi.TransactionContextInterface) (*ChaincodeConfig, error) {
+
+	configJSON, err := ctx.GetStub().GetState(chaincodeConfigKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chaincode config: %v", err)
+	}
+
+	config := &ChaincodeConfig{}
+	if configJSON == nil {
+		return config, nil
+	}
+
+	err = json.Unmarshal(configJSON, config)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal chaincode config: %v", err)
+	}
+
+	return config, nil
+}
+
+/// demographics the synthetic clients are drawn from
+type syntheticCity struct {
+	City string
+	State string
+	Country string
+}
+
+var syntheticCities = []syntheticCity{
+	{"Vijayawada", "AP", "India"},
+	{"Visakhapatnam", "AP", "India"},
+	{"Hyderabad", "TG", "India"},
+	{"Chennai", "TN", "India"},
+	{"Bengaluru", "KA", "India"},
+	{"Mumbai", "MH", "India"},
+	{"Pune", "MH", "India"},
+	{"Kolkata", "WB", "India"},
+	{"New Delhi", "DL", "India"},
+	{"Jaipur", "RJ", "India"},
+	{"Kochi", "KL", "India"},
+	{"Lucknow", "UP", "India"},
+}
+
+var syntheticFirstNames = map[string][]string{
+	"M": {"Aarav", "Arjun", "Rahul", "Vikram", "Karthik", "Suresh", "Imran", "Rohan", "Sanjay", "Ravi", "Aditya", "Farhan"},
+	"F": {"Ananya", "Priya", "Lakshmi", "Divya", "Sneha", "Fatima", "Kavya", "Meera", "Pooja", "Neha", "Aisha", "Swathi"},
+}
+
+var syntheticLastNames = []string{"Reddy", "Sharma", "Iyer", "Nair", "Khan", "Patel", "Rao", "Gupta", "Das", "Singh", "Menon", "Chowdary", "Joshi", "Kumar"}
+
+var syntheticSpecializations = []string{"Cardiology", "Nephrology", "Hematology", "General Medicine", "Endocrinology", "Pediatrics", "Oncology", "Neurology"}
+
+/// analyte of a record type with its reference range, values are drawn around the range
+/// so that some of the generated results are abnormal
+type syntheticAnalyte struct {
+	Name string
+	Low float64
+	High float64
+	Decimals int
+}
+
+/// record types of the medical record forms of the application
+var syntheticRecordTypes = map[string][]syntheticAnalyte{
+	"CBC": {
+		{"hb", 12.0, 17.5, 1},
+		{"wbc", 4.0, 11.0, 1},
+		{"rbc", 4.2, 5.9, 2},
+		{"platelets", 150, 450, 0},
+		{"mcv", 80, 100, 0},
+		{"mch", 27, 33, 1},
+		{"mchc", 32, 36, 1},
+		{"mpv", 7.5, 11.5, 1},
+		{"neutrophils", 40, 75, 0},
+		{"lymphocyte", 20, 45, 0},
+		{"eosinophils", 1, 6, 0},
+		{"basophils", 0, 1, 1},
+	},
+	"RFT": {
+		{"creatinine", 0.6, 1.3, 2},
+		{"egfr", 60, 120, 0},
+		{"bun", 7, 20, 0},
+		{"na", 135, 145, 0},
+		{"k", 3.5, 5.1, 1},
+		{"cl", 98, 107, 0},
+		{"bicarb", 22, 29, 0},
+	},
+}
+
+/// record types in a fixed order, map order is random and the endorsers must agree
+var syntheticRecordTypeNames = []string{"CBC", "RFT"}
+
+/// generator of synthetic clients, the same seed generates the same data on every endorser
+type syntheticGenerator struct {
+	rng *rand.Rand
+	seed int64
+	now time.Time
+}
+
+func pick(rng *rand.Rand, values []string) string {
+	return values[rng.Intn(len(values))]
+}
+
+func (g *syntheticGenerator) id(i int, clientType string) string {
+	return fmt.Sprintf("syn%v-%v%v", g.seed, i, clientType)
+}
+
+func (g *syntheticGenerator) personalInfo(i int, minAge int, maxAge int, clientType string) ClientPersonalInfo {
+
+	gender := pick(g.rng, []string{"M", "F"})
+	firstName := pick(g.rng, syntheticFirstNames[gender])
+	lastName := pick(g.rng, syntheticLastNames)
+	city := syntheticCities[g.rng.Intn(len(syntheticCities))]
+
+	var info ClientPersonalInfo
+	info.SetInfo(
+		minAge + g.rng.Intn(maxAge - minAge + 1),
+		firstName,
+		lastName,
+		gender,
+		fmt.Sprintf("%v.%v%v@example.com", strings.ToLower(firstName), strings.ToLower(lastName), i),
+		fmt.Sprintf("%v%09d", 6 + g.rng.Intn(4), g.rng.Intn(1000000000)),
+		city.City,
+		city.State,
+		city.Country,
+		clientType)
+
+	return info
+}
+
+func (g *syntheticGenerator) analyteValue(analyte syntheticAnalyte) string {
+
+	/// normal around the middle of the range, about one in eight values falls outside
+	mean := (analyte.Low + analyte.High) / 2
+	deviation := (analyte.High - analyte.Low) / 3
+	value := math.Max(0, mean + g.rng.NormFloat64() * deviation)
+
+	return strconv.FormatFloat(value, 'f', analyte.Decimals, 64)
+}
+
+/// record of a random type issued by the doctor within the last three years
+func (g *syntheticGenerator) medicalRecord(id string, pid string, did string) MedicalInfo {
+
+	recordType := pick(g.rng, syntheticRecordTypeNames)
+
+	report := map[string]string{}
+	for _, analyte := range syntheticRecordTypes[recordType] {
+		report[analyte.Name] = g.analyteValue(analyte)
+	}
+
+	issuedAt := g.now.AddDate(0, 0, -g.rng.Intn(3 * 365)).Add(-time.Duration(g.rng.Intn(24 * 60)) * time.Minute)
+
+	var record MedicalInfo
+	record.SetInfo(recordType, report, issuedAt, pid, did)
+	record.ID = id
+
+	return record
+}
+
+/// generate synthetic doctors and patients with medical records into the org collection
+/// every patient is appointed to a random generated doctor who issued its records,
+/// the generated ids are derived from the seed
+func (s *SmartContract) GenerateSyntheticData(ctx contractapi.TransactionContextInterface, seed int64, patients int, doctors int, recordsPerPatient int) (*SyntheticDataResult, error) {
+
+	adminID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := readChaincodeConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !config.SyntheticData {
+		return nil, fmt.Errorf("Synthetic data is disabled, the chaincode was not initialized with synthetic data enabled")
+	}
+
+	if patients < 0 || patients > maxSyntheticClients || doctors < 0 || doctors > maxSyntheticClients {
+		return nil, fmt.Errorf("Patients and doctors must be between 0 and %v", maxSyntheticClients)
+	}
+
+	if recordsPerPatient < 0 || recordsPerPatient > maxSyntheticRecordsPerPatient {
+		return nil, fmt.Errorf("Records per patient must be between 0 and %v", maxSyntheticRecordsPerPatient)
+	}
+
+	if patients > 0 && doctors == 0 {
+		return nil, fmt.Errorf("Patients are appointed to the generated doctors, at least one doctor is needed")
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	generator := &syntheticGenerator{rng: rand.New(rand.NewSource(seed)), seed: seed, now: now}
+	result := &SyntheticDataResult{Seed: seed, PIDs: []string{}, DIDs: []string{}}
+
+	doctorData := make([]*DoctorInfo, doctors)
+	for i := range doctorData {
+		did := generator.id(i + 1, "D")
+
+		err = checkAssetAlreadyExists(ctx, orgCollectionName, did)
+		if err != nil {
+			return nil, fmt.Errorf("Synthetic data of seed %v already generated: %v", seed, err)
+		}
+
+		doctorData[i] = &DoctorInfo{}
+		doctorData[i].SetInfo(did, generator.personalInfo(i + 1, 28, 70, "Doctor"), pick(generator.rng, syntheticSpecializations), adminID, []string{})
+		doctorData[i].addMetaData(orgCollectionName)
+
+		result.DIDs = append(result.DIDs, did)
+	}
+
+	for i := 0; i < patients; i++ {
+		pid := generator.id(i + 1, "P")
+
+		err = checkAssetAlreadyExists(ctx, orgCollectionName, pid)
+		if err != nil {
+			return nil, fmt.Errorf("Synthetic data of seed %v already generated: %v", seed, err)
+		}
+
+		doctor := doctorData[generator.rng.Intn(doctors)]
+
+		var assetData PatientInfo
+		assetData.SetInfo(pid, generator.personalInfo(i + 1, 1, 90, "Patient"), []MedicalInfo{}, []string{doctor.ID}, []string{adminID})
+		assetData.addMetaData(orgCollectionName)
+
+		/// a series of results over time, several records can have the same type
+		for j := 0; j < recordsPerPatient; j++ {
+			recordID := fmt.Sprintf("%v-%v-%v", ctx.GetStub().GetTxID(), i + 1, j + 1)
+			assetData.MedicalRecords = append(assetData.MedicalRecords, generator.medicalRecord(recordID, pid, doctor.ID))
+		}
+
+		err = doctor.AddPID(pid)
+		if err != nil {
+			return nil, err
+		}
+
+		assetDataJSON, err := marshalStoredObject(&assetData)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal asset data: %v", err)
+		}
+
+		log.Printf("GenerateSyntheticData Put: collection %v, ID %v", orgCollectionName, pid)
+		err = ctx.GetStub().PutPrivateData(orgCollectionName, pid, assetDataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put asset private details: %v", err)
+		}
+
+		err = s.recordAccess(ctx, &assetData, accessWrite)
+		if err != nil {
+			return nil, err
+		}
+
+		result.PIDs = append(result.PIDs, pid)
+		result.Records += len(assetData.MedicalRecords)
+	}
+
+	for _, doctor := range doctorData {
+		doctorDataJSON, err := marshalStoredObject(doctor)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal asset data: %v", err)
+		}
+
+		log.Printf("GenerateSyntheticData Put: collection %v, ID %v", orgCollectionName, doctor.ID)
+		err = ctx.GetStub().PutPrivateData(orgCollectionName, doctor.ID, doctorDataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put asset private details: %v", err)
+		}
+	}
+
+	return result, nil
+}
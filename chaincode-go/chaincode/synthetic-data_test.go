@@ -0,0 +1,125 @@
+package chaincode
+
+import (
+	"reflect"
+	"testing"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) generateSyntheticData(admin *fabrictest.Identity, seed int64, patients, doctors, records int) (*SyntheticDataResult, error) {
+	var result *SyntheticDataResult
+	err := sc.submit(admin, "GenerateSyntheticData", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = sc.contract.GenerateSyntheticData(ctx, seed, patients, doctors, records)
+		return err
+	})
+	return result, err
+}
+
+func (sc *scenario) initLedger(admin *fabrictest.Identity, syntheticData string) {
+	err := sc.submit(admin, "InitLedger", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.InitLedger(ctx, syntheticData)
+	})
+	if err != nil {
+		sc.t.Fatalf("InitLedger: %v", err)
+	}
+}
+
+func TestSyntheticDataDisabledByDefault(t *testing.T) {
+	sc := newScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	patient := sc.client("Org1MSP", "patient", "0001P")
+
+	if _, err := sc.generateSyntheticData(admin, 1, 2, 1, 1); err == nil {
+		t.Fatalf("synthetic data generated on an uninitialized chaincode")
+	}
+
+	err := sc.submit(patient, "InitLedger", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.InitLedger(ctx, "true")
+	})
+	if err == nil {
+		t.Fatalf("chaincode initialized by a patient")
+	}
+
+	sc.initLedger(admin, "false")
+	if _, err := sc.generateSyntheticData(admin, 1, 2, 1, 1); err == nil {
+		t.Fatalf("synthetic data generated with synthetic data disabled")
+	}
+
+	/// synthetic data cannot be enabled on a live channel
+	err = sc.submit(admin, "InitLedger", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.InitLedger(ctx, "true")
+	})
+	if err == nil {
+		t.Fatalf("chaincode initialized twice")
+	}
+	if _, err := sc.generateSyntheticData(admin, 1, 2, 1, 1); err == nil {
+		t.Fatalf("synthetic data generated after a second InitLedger")
+	}
+}
+
+func TestSyntheticDataGeneration(t *testing.T) {
+	sc := newScenario(t)
+	admin := sc.client("Org1MSP", "admin", "0001A")
+	sc.initLedger(admin, "true")
+
+	patient := sc.client("Org1MSP", "patient", "0001P")
+	if _, err := sc.generateSyntheticData(patient, 1, 2, 1, 1); err == nil {
+		t.Fatalf("synthetic data generated by a patient")
+	}
+
+	result, err := sc.generateSyntheticData(admin, 42, 5, 2, 3)
+	if err != nil {
+		t.Fatalf("GenerateSyntheticData: %v", err)
+	}
+	if len(result.PIDs) != 5 || len(result.DIDs) != 2 || result.Records != 15 {
+		t.Fatalf("result = %+v", result)
+	}
+
+	treated := 0
+	for _, did := range result.DIDs {
+		doctorData := sc.readDoctor(admin, did)
+		if err := doctorData.PersonalInfo.validate(); err != nil {
+			t.Errorf("doctor %v personal info: %v", did, err)
+		}
+		treated += len(doctorData.PIDS)
+	}
+	if treated != 5 {
+		t.Errorf("doctors treat %v patients, want 5", treated)
+	}
+
+	for _, pid := range result.PIDs {
+		assetData := sc.readPatient(admin, pid)
+		if err := assetData.PersonalInfo.validate(); err != nil {
+			t.Errorf("patient %v personal info: %v", pid, err)
+		}
+		if len(assetData.TreatedBy) != 1 || len(assetData.MedicalRecords) != 3 {
+			t.Fatalf("patient %v = %+v", pid, assetData)
+		}
+		for _, record := range assetData.MedicalRecords {
+			if len(record.MReport) != len(syntheticRecordTypes[record.Type]) || record.IssuedBy != assetData.TreatedBy[0] {
+				t.Errorf("record %+v", record)
+			}
+		}
+	}
+
+	/// the ids of a seed are generated once
+	if _, err := sc.generateSyntheticData(admin, 42, 1, 1, 0); err == nil {
+		t.Fatalf("synthetic data of a seed generated twice")
+	}
+
+	/// the same seed generates the same clients on another channel
+	other := newScenario(t)
+	otherAdmin := other.client("Org1MSP", "admin", "0001A")
+	other.initLedger(otherAdmin, "true")
+	if _, err := other.generateSyntheticData(otherAdmin, 42, 5, 2, 3); err != nil {
+		t.Fatalf("GenerateSyntheticData: %v", err)
+	}
+
+	first := sc.readPatient(admin, result.PIDs[0])
+	second := other.readPatient(otherAdmin, result.PIDs[0])
+	if !reflect.DeepEqual(first.PersonalInfo, second.PersonalInfo) {
+		t.Errorf("personal info of the seed differs: %+v, %+v", first.PersonalInfo, second.PersonalInfo)
+	}
+}
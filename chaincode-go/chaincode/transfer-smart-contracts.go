@@ -0,0 +1,709 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"encoding/json"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// transfers are kept in the shared collection, both hospitals read and approve them
+const patientTransferObjectType = "patientTransfer"
+
+/// forwarding pointer left in the source org collection for the transferred patient
+const patientForwardObjectType = "patientForward"
+
+/// transfer states, a transfer can be cancelled until it is released
+const (
+	transferRequested = "requested"
+	transferApproved = "approved"
+	transferReleased = "released"
+	transferCompleted = "completed"
+)
+
+/// approval of a hospital admin
+type TransferApproval struct {
+	ClientID string `json:"clientId"`
+	ApprovedAt time.Time `json:"approvedAt"`
+}
+
+/// transfer of a patient from the collection of the source org to the destination org
+/// requested by the patient and approved by the admins of both orgs
+/// each org collection is written by its own org only, so the source admin releases
+/// the record into the transfer and the destination admin accepts it from there
+type PatientTransfer struct {
+	SchemaVersion int `json:"schemaVersion"`
+	PID string `json:"pid"`
+	SourceOrg string `json:"sourceOrg"`
+	DestinationOrg string `json:"destinationOrg"`
+	DoctorID string `json:"doctorId,omitempty"`
+	Status string `json:"status"`
+	RequestedAt time.Time `json:"requestedAt"`
+	SourceApproval *TransferApproval `json:"sourceApproval,omitempty"`
+	DestinationApproval *TransferApproval `json:"destinationApproval,omitempty"`
+	ReleasedAt time.Time `json:"releasedAt"`
+	CompletedAt time.Time `json:"completedAt"`
+	FormerDoctors []string `json:"formerDoctors,omitempty"`
+	/// data key wrapped for the patient identity and the receiving doctor at the destination
+	WrappedKeys map[string]string `json:"wrappedKeys,omitempty"`
+	/// patient data and access log between release and acceptance
+	Record json.RawMessage `json:"record,omitempty"`
+	AccessLog []AccessLogEntry `json:"accessLog,omitempty"`
+}
+
+func (pt *PatientTransfer) objectType() string {
+	return patientTransferObjectType
+}
+
+func (pt *PatientTransfer) setSchemaVersion(version int) {
+	pt.SchemaVersion = version
+}
+
+/// where the patient data of the source org went
+type PatientForward struct {
+	SchemaVersion int `json:"schemaVersion"`
+	PID string `json:"pid"`
+	DestinationOrg string `json:"destinationOrg"`
+	Collection string `json:"collection"`
+	TransferredAt time.Time `json:"transferredAt"`
+	TxID string `json:"txId"`
+}
+
+func (pf *PatientForward) objectType() string {
+	return patientForwardObjectType
+}
+
+func (pf *PatientForward) setSchemaVersion(version int) {
+	pf.SchemaVersion = version
+}
+
+func transferCollectionName(org string) string {
+	return org + "PrivateCollection"
+}
+
+func readPatientTransfer(ctx contractapi.TransactionContextInterface, pid string) (*PatientTransfer, error) {
+
+	transferKey, err := ctx.GetStub().CreateCompositeKey(patientTransferObjectType, []string{pid})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	transferJSON, err := ctx.GetStub().GetPrivateData(org1AndOrg2PrivateCollection, transferKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient transfer: %v", err)
+	}
+
+	if transferJSON == nil {
+		return nil, fmt.Errorf("Patient transfer of %v does not exist", pid)
+	}
+
+	var transfer PatientTransfer
+	err = unmarshalStoredObject(transferJSON, &transfer)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal patient transfer: %v", err)
+	}
+
+	return &transfer, nil
+}
+
+func putPatientTransfer(ctx contractapi.TransactionContextInterface, transfer *PatientTransfer) error {
+
+	transferKey, err := ctx.GetStub().CreateCompositeKey(patientTransferObjectType, []string{transfer.PID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	transferJSON, err := marshalStoredObject(transfer)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal patient transfer: %v", err)
+	}
+
+	log.Printf("PatientTransfer Put: collection %v, ID %v, Key %v", org1AndOrg2PrivateCollection, transfer.PID, transferKey)
+	err = ctx.GetStub().PutPrivateData(org1AndOrg2PrivateCollection, transferKey, transferJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put patient transfer: %v", err)
+	}
+
+	return nil
+}
+
+/// forwarding pointer of the patient in the collection, nil when the patient was not transferred
+func readPatientForward(ctx contractapi.TransactionContextInterface, collection string, pid string) (*PatientForward, error) {
+
+	forwardKey, err := ctx.GetStub().CreateCompositeKey(patientForwardObjectType, []string{pid})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	forwardJSON, err := ctx.GetStub().GetPrivateData(collection, forwardKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forwarding pointer: %v", err)
+	}
+
+	if forwardJSON == nil {
+		return nil, nil
+	}
+
+	var forward PatientForward
+	err = unmarshalStoredObject(forwardJSON, &forward)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal forwarding pointer: %v", err)
+	}
+
+	return &forward, nil
+}
+
+/// the data key of an encrypted patient must be wrapped for the identities at the destination
+func transferWrappedKeys(ctx contractapi.TransactionContextInterface, assetData *PatientInfo, doctorID string) (map[string]string, error) {
+
+	if assetData.DataKey == nil {
+		return nil, nil
+	}
+
+	if assetData.DataKey.Rotation != nil {
+		return nil, fmt.Errorf("Data key rotation in progress, complete the rotation first")
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting transient: %v", err)
+	}
+
+	wrappedKeys := map[string]string{}
+
+	wrappedKey, ok := transientMap["wrapped_key"]
+	if !ok || len(wrappedKey) == 0 {
+		return nil, fmt.Errorf("Patient data is encrypted: data key wrapped for the destination identity not found in the transient map")
+	}
+	wrappedKeys[assetData.ID] = string(wrappedKey)
+
+	if len(doctorID) != 0 {
+		doctorWrappedKey, ok := transientMap["doctor_wrapped_key"]
+		if !ok || len(doctorWrappedKey) == 0 {
+			return nil, fmt.Errorf("Patient data is encrypted: data key wrapped for %v not found in the transient map", doctorID)
+		}
+		wrappedKeys[doctorID] = string(doctorWrappedKey)
+	}
+
+	return wrappedKeys, nil
+}
+
+/// request the transfer of the invoked patient to the destination org
+/// the receiving doctor of the destination org is optional
+/// an encrypted patient passes the data key wrapped for their destination identity
+/// and for the receiving doctor in the transient map
+func (s *SmartContract) TransferPatient(ctx contractapi.TransactionContextInterface, destinationOrg string, doctorID string) error {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	if strings.ToLower(client) != "patient" {
+		return fmt.Errorf("Only Patient can request a transfer")
+	}
+
+	pid, err := s.GetIdentityAttribute(ctx, "id")
+	if err != nil {
+		return fmt.Errorf("Error getting client id: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return fmt.Errorf("Transfer patient cannot be performed: Error %v", err)
+	}
+
+	sourceOrg, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	if len(destinationOrg) == 0 || destinationOrg == sourceOrg {
+		return fmt.Errorf("Destination org must be another org")
+	}
+
+	if len(doctorID) != 0 && !checkID(doctorID, "D") {
+		return fmt.Errorf("Receiving doctor id must end with D")
+	}
+
+	/// shared patient data has more than one owner and cannot be moved
+	orgCollectionName := transferCollectionName(sourceOrg)
+	err = checkAssetExistsInOwnerOrg(ctx, orgCollectionName, pid)
+	if err != nil {
+		return fmt.Errorf("Cannot transfer patient: %v", err)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing, err := readPatientTransfer(ctx, pid)
+	if err == nil && existing.Status != transferCompleted {
+		return fmt.Errorf("Patient transfer of %v already exists", pid)
+	}
+
+	assetData, err := s.readAssetPrivateData(ctx, pid)
+	if err != nil {
+		return fmt.Errorf("Cannot get Patient Data: %v", err)
+	}
+
+	wrappedKeys, err := transferWrappedKeys(ctx, assetData, doctorID)
+	if err != nil {
+		return fmt.Errorf("Cannot transfer patient: %v", err)
+	}
+
+	transfer := &PatientTransfer{
+		PID: pid,
+		SourceOrg: sourceOrg,
+		DestinationOrg: destinationOrg,
+		DoctorID: doctorID,
+		Status: transferRequested,
+		RequestedAt: now,
+		WrappedKeys: wrappedKeys,
+	}
+
+	return putPatientTransfer(ctx, transfer)
+}
+
+/// approve the transfer as the admin of the source or the destination org
+/// the destination admin approval checks the patient can be received
+func (s *SmartContract) ApprovePatientTransfer(ctx contractapi.TransactionContextInterface, pid string) error {
+
+	adminID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	transfer, err := readPatientTransfer(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	if transfer.Status != transferRequested {
+		return fmt.Errorf("Patient transfer of %v is %v", pid, transfer.Status)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	approval := &TransferApproval{ClientID: adminID, ApprovedAt: now}
+
+	switch org {
+	case transfer.SourceOrg:
+		transfer.SourceApproval = approval
+	case transfer.DestinationOrg:
+		err = checkAssetAlreadyExists(ctx, transferCollectionName(org), pid)
+		if err != nil {
+			return fmt.Errorf("Cannot receive patient: %v", err)
+		}
+		if len(transfer.DoctorID) != 0 {
+			_, err = s.ReadDoctorPrivateData(ctx, transfer.DoctorID)
+			if err != nil {
+				return fmt.Errorf("Receiving doctor not found: %v", err)
+			}
+		}
+		transfer.DestinationApproval = approval
+	default:
+		return fmt.Errorf("Only the admins of %v and %v can approve the transfer", transfer.SourceOrg, transfer.DestinationOrg)
+	}
+
+	if transfer.SourceApproval != nil && transfer.DestinationApproval != nil {
+		transfer.Status = transferApproved
+	}
+
+	return putPatientTransfer(ctx, transfer)
+}
+
+/// move the approved patient out of the source org collection into the transfer
+/// the doctors of the source org lose their access and a forwarding pointer is left behind
+func (s *SmartContract) ReleasePatientTransfer(ctx contractapi.TransactionContextInterface, pid string) error {
+
+	_, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := readPatientTransfer(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	if org != transfer.SourceOrg {
+		return fmt.Errorf("Only the admin of %v can release the patient", transfer.SourceOrg)
+	}
+
+	if transfer.Status != transferApproved {
+		return fmt.Errorf("Patient transfer of %v is %v, it must be approved by both orgs", pid, transfer.Status)
+	}
+
+	orgCollectionName := transferCollectionName(org)
+
+	assetDataJSON, err := ctx.GetStub().GetPrivateData(orgCollectionName, pid)
+	if err != nil {
+		return fmt.Errorf("failed to read asset: %v", err)
+	}
+	if assetDataJSON == nil {
+		return fmt.Errorf("Asset %v does not exists in collection %v", pid, orgCollectionName)
+	}
+
+	assetData := &PatientInfo{}
+	err = unmarshalStoredObject(assetDataJSON, assetData)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	if assetData.DataKey != nil && assetData.DataKey.Rotation != nil {
+		return fmt.Errorf("Data key rotation in progress, complete the rotation first")
+	}
+
+	accessLog, err := readCollectionAccessLog(ctx, orgCollectionName, pid)
+	if err != nil {
+		return err
+	}
+
+	/// the release is logged at the source and moves with the rest of the source history,
+	/// the log read above does not see the entry written in this transaction
+	releaseEntry, err := s.logAccess(ctx, assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+	accessLog = append(accessLog, *releaseEntry)
+
+	/// doctors of the source org cannot reach the destination collection
+	formerDoctors := append([]string{}, assetData.TreatedBy...)
+	for _, doctorID := range formerDoctors {
+		err = assetData.removeAccess(doctorID)
+		if err != nil {
+			return fmt.Errorf("Cannot remove access of %v: %v", doctorID, err)
+		}
+
+		doctorData, err := s.ReadDoctorPrivateData(ctx, doctorID)
+		if err != nil {
+			/// doctor of another org, nothing to update here
+			continue
+		}
+
+		err = doctorData.removePID(pid)
+		if err != nil {
+			continue
+		}
+
+		doctorDataJSON, err := marshalStoredObject(doctorData)
+		if err != nil {
+			return fmt.Errorf("Failed to marshal doctor data: %v", err)
+		}
+
+		log.Printf("ReleasePatientTransfer Put: collection %v, ID %v", orgCollectionName, doctorID)
+		err = ctx.GetStub().PutPrivateData(orgCollectionName, doctorID, doctorDataJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put doctor private details: %v", err)
+		}
+	}
+
+	/// the former doctors keep the current data key, the patient must rotate it at the destination
+	if assetData.DataKey != nil {
+		assetData.DataKey.RotationRequired = true
+	}
+
+	destinationCollection := transferCollectionName(transfer.DestinationOrg)
+	err = assetData.addMetaData(destinationCollection)
+	if err != nil {
+		return err
+	}
+
+	transfer.Record, err = marshalStoredObject(assetData)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal asset data: %v", err)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = transferReleased
+	transfer.ReleasedAt = now
+	transfer.FormerDoctors = formerDoctors
+	transfer.AccessLog = accessLog
+
+	err = putPatientTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("ReleasePatientTransfer Delete: collection %v, ID %v", orgCollectionName, pid)
+	err = ctx.GetStub().DelPrivateData(orgCollectionName, pid)
+	if err != nil {
+		return fmt.Errorf("failed to delete asset: %v", err)
+	}
+
+	/// a pending access request cannot be granted anymore
+	requestKey, err := ctx.GetStub().CreateCompositeKey(dataAccessRequestObjectType, []string{pid})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().DelPrivateData(orgCollectionName, requestKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete data access request: %v", err)
+	}
+
+	/// the patient is indexed again by the destination org
+	err = removePatientIndex(ctx, PatientRef{PID: pid, Org: org})
+	if err != nil {
+		return err
+	}
+
+	forward := &PatientForward{
+		PID: pid,
+		DestinationOrg: transfer.DestinationOrg,
+		Collection: destinationCollection,
+		TransferredAt: now,
+		TxID: ctx.GetStub().GetTxID(),
+	}
+
+	forwardKey, err := ctx.GetStub().CreateCompositeKey(patientForwardObjectType, []string{pid})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	forwardJSON, err := marshalStoredObject(forward)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal forwarding pointer: %v", err)
+	}
+
+	log.Printf("ReleasePatientTransfer Put: collection %v, ID %v, Key %v", orgCollectionName, pid, forwardKey)
+	return ctx.GetStub().PutPrivateData(orgCollectionName, forwardKey, forwardJSON)
+}
+
+/// write the released patient into the destination org collection
+/// the receiving doctor is appointed and the access log continues at the destination
+func (s *SmartContract) AcceptPatientTransfer(ctx contractapi.TransactionContextInterface, pid string) error {
+
+	adminID, err := s.checkAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := readPatientTransfer(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	org, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("Failed getting client MSPID: %v", err)
+	}
+
+	if org != transfer.DestinationOrg {
+		return fmt.Errorf("Only the admin of %v can accept the patient", transfer.DestinationOrg)
+	}
+
+	if transfer.Status != transferReleased {
+		return fmt.Errorf("Patient transfer of %v is %v, it must be released by %v", pid, transfer.Status, transfer.SourceOrg)
+	}
+
+	orgCollectionName := transferCollectionName(org)
+	err = checkAssetAlreadyExists(ctx, orgCollectionName, pid)
+	if err != nil {
+		return fmt.Errorf("Cannot receive patient: %v", err)
+	}
+
+	assetData := &PatientInfo{}
+	err = unmarshalStoredObject(transfer.Record, assetData)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal transferred patient: %v", err)
+	}
+
+	assetData.addOwner(adminID)
+
+	if assetData.DataKey != nil {
+		err = assetData.DataKey.addWrappedKey(pid, transfer.WrappedKeys[pid])
+		if err != nil {
+			return fmt.Errorf("Cannot receive patient: %v", err)
+		}
+	}
+
+	if len(transfer.DoctorID) != 0 {
+		err = assetData.addDoctorInfo(transfer.DoctorID)
+		if err != nil {
+			return err
+		}
+
+		if assetData.DataKey != nil {
+			err = assetData.DataKey.addWrappedKey(transfer.DoctorID, transfer.WrappedKeys[transfer.DoctorID])
+			if err != nil {
+				return fmt.Errorf("Cannot receive patient: %v", err)
+			}
+		}
+
+		err = s.updateDocInfo(ctx, transfer.DoctorID, pid)
+		if err != nil {
+			return err
+		}
+	}
+
+	assetDataJSON, err := marshalStoredObject(assetData)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal asset data: %v", err)
+	}
+
+	log.Printf("AcceptPatientTransfer Put: collection %v, ID %v", orgCollectionName, pid)
+	err = ctx.GetStub().PutPrivateData(orgCollectionName, pid, assetDataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put asset private details: %v", err)
+	}
+
+	for _, entry := range transfer.AccessLog {
+		entryKey, err := ctx.GetStub().CreateCompositeKey(accessLogObjectType, []string{entry.PID, entry.TxID, entry.Action})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+
+		entryJSON, err := marshalStoredObject(&entry)
+		if err != nil {
+			return fmt.Errorf("Cannot marshal access log entry: %v", err)
+		}
+
+		err = ctx.GetStub().PutPrivateData(orgCollectionName, entryKey, entryJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put access log entry: %v", err)
+		}
+	}
+
+	err = s.recordAccess(ctx, assetData, accessWrite)
+	if err != nil {
+		return err
+	}
+
+	_, err = indexPatient(ctx, assetData)
+	if err != nil {
+		return err
+	}
+
+	/// a patient coming back is no longer forwarded
+	forwardKey, err := ctx.GetStub().CreateCompositeKey(patientForwardObjectType, []string{pid})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().DelPrivateData(orgCollectionName, forwardKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete forwarding pointer: %v", err)
+	}
+
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = transferCompleted
+	transfer.CompletedAt = now
+	transfer.WrappedKeys = nil
+	transfer.Record = nil
+	transfer.AccessLog = nil
+
+	return putPatientTransfer(ctx, transfer)
+}
+
+/// cancel the transfer before it is released, by the patient or an admin of either org
+func (s *SmartContract) CancelPatientTransfer(ctx contractapi.TransactionContextInterface, pid string) error {
+
+	transfer, err := s.ReadPatientTransfer(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	if transfer.Status != transferRequested && transfer.Status != transferApproved {
+		return fmt.Errorf("Patient transfer of %v is %v and cannot be cancelled", pid, transfer.Status)
+	}
+
+	transferKey, err := ctx.GetStub().CreateCompositeKey(patientTransferObjectType, []string{pid})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	log.Printf("CancelPatientTransfer Delete: collection %v, ID %v, Key %v", org1AndOrg2PrivateCollection, pid, transferKey)
+	return ctx.GetStub().DelPrivateData(org1AndOrg2PrivateCollection, transferKey)
+}
+
+/// read the transfer of the patient
+/// the patient reads their own transfer, admins the transfers of their org
+func (s *SmartContract) ReadPatientTransfer(ctx contractapi.TransactionContextInterface, pid string) (*PatientTransfer, error) {
+
+	client, err := s.GetIdentityAttribute(ctx, "role")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting client identity: %v", err)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Read patient transfer cannot be performed: Error %v", err)
+	}
+
+	transfer, err := readPatientTransfer(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(client) {
+	case "patient":
+		id, err := s.GetIdentityAttribute(ctx, "id")
+		if err != nil {
+			return nil, fmt.Errorf("Error getting client id: %v", err)
+		}
+		if id != pid {
+			return nil, fmt.Errorf("Patient can only read their own transfer")
+		}
+	case "admin":
+		org, err := ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return nil, fmt.Errorf("Failed getting client MSPID: %v", err)
+		}
+		if org != transfer.SourceOrg && org != transfer.DestinationOrg {
+			return nil, fmt.Errorf("Only the admins of %v and %v can read the transfer", transfer.SourceOrg, transfer.DestinationOrg)
+		}
+	default:
+		return nil, fmt.Errorf("Only patient or admin can read the transfer")
+	}
+
+	return transfer, nil
+}
+
+/// read the forwarding pointer of a patient transferred out of the org of the client
+func (s *SmartContract) ReadPatientForward(ctx contractapi.TransactionContextInterface, pid string) (*PatientForward, error) {
+
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Read forwarding pointer cannot be performed: Error %v", err)
+	}
+
+	orgCollectionName, err := getOrgCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	forward, err := readPatientForward(ctx, orgCollectionName, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if forward == nil {
+		return nil, fmt.Errorf("Patient %v was not transferred out of %v", pid, orgCollectionName)
+	}
+
+	return forward, nil
+}
@@ -0,0 +1,143 @@
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/fabrictest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func (sc *scenario) transferStep(client *fabrictest.Identity, function string, pid string) error {
+	return sc.network.NewTransaction(client, function, pid).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		switch function {
+		case "ApprovePatientTransfer":
+			return sc.contract.ApprovePatientTransfer(ctx, pid)
+		case "ReleasePatientTransfer":
+			return sc.contract.ReleasePatientTransfer(ctx, pid)
+		case "AcceptPatientTransfer":
+			return sc.contract.AcceptPatientTransfer(ctx, pid)
+		}
+		return sc.contract.CancelPatientTransfer(ctx, pid)
+	})
+}
+
+func TestTransferPatient(t *testing.T) {
+	sc, patient, doctor, _ := newAppointedScenario(t)
+	sourceAdmin := sc.client("Org1MSP", "admin", "0001A")
+	destinationAdmin := sc.client("Org2MSP", "admin", "0002A")
+	receivingDoctor := sc.client("Org2MSP", "doctor", "0003D")
+	movedPatient := sc.client("Org2MSP", "patient", "0001P")
+	sc.registerDoctor(receivingDoctor, "Dan")
+
+	err := sc.network.NewTransaction(patient, "TransferPatient", "Org2MSP", "0003D").Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.TransferPatient(ctx, "Org2MSP", "0003D")
+	})
+	if err != nil {
+		t.Fatalf("TransferPatient: %v", err)
+	}
+
+	/// the record moves only once both orgs approved
+	if err = sc.transferStep(sourceAdmin, "ReleasePatientTransfer", "0001P"); err == nil {
+		t.Fatalf("patient released before the approvals")
+	}
+	if err = sc.transferStep(doctor, "ApprovePatientTransfer", "0001P"); err == nil {
+		t.Fatalf("transfer approved by a doctor")
+	}
+	for _, admin := range []*fabrictest.Identity{destinationAdmin, sourceAdmin} {
+		if err = sc.transferStep(admin, "ApprovePatientTransfer", "0001P"); err != nil {
+			t.Fatalf("ApprovePatientTransfer: %v", err)
+		}
+	}
+	if err = sc.transferStep(destinationAdmin, "ReleasePatientTransfer", "0001P"); err == nil {
+		t.Fatalf("patient released by the destination admin")
+	}
+	if err = sc.transferStep(sourceAdmin, "ReleasePatientTransfer", "0001P"); err != nil {
+		t.Fatalf("ReleasePatientTransfer: %v", err)
+	}
+
+	if sc.network.PrivateData(org1CollectionName, "0001P") != nil {
+		t.Errorf("patient left in the source collection")
+	}
+	if containsID(sc.readDoctor(doctor, "0001D").PIDS, "0001P") {
+		t.Errorf("source doctor still treats the patient")
+	}
+
+	/// the source org is pointed to the destination
+	err = sc.network.NewTransaction(doctor, "readAssetPrivateData", "0001P").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.contract.readAssetPrivateData(ctx, "0001P")
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "transferred to Org2MSP") {
+		t.Errorf("read of the transferred patient: %v", err)
+	}
+
+	if err = sc.transferStep(destinationAdmin, "AcceptPatientTransfer", "0001P"); err != nil {
+		t.Fatalf("AcceptPatientTransfer: %v", err)
+	}
+
+	assetData := sc.readPatient(movedPatient, "0001P")
+	if assetData.Meta.CollectionName != "Org2MSPPrivateCollection" {
+		t.Errorf("patient collection = %v", assetData.Meta.CollectionName)
+	}
+	if len(assetData.TreatedBy) != 1 || assetData.TreatedBy[0] != "0003D" {
+		t.Errorf("patient treated by %v", assetData.TreatedBy)
+	}
+	if !containsID(sc.readDoctor(receivingDoctor, "0003D").PIDS, "0001P") {
+		t.Errorf("receiving doctor does not treat the patient")
+	}
+
+	/// the access log of the source org continues at the destination
+	var accessLog *AccessLog
+	err = sc.network.NewTransaction(movedPatient, "GetAccessLog").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		accessLog, err = sc.contract.GetAccessLog(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("GetAccessLog: %v", err)
+	}
+	functions := []string{}
+	for _, entry := range accessLog.Data {
+		functions = append(functions, entry.Function)
+	}
+	if !containsID(functions, "AppointDoctor") || !containsID(functions, "ReleasePatientTransfer") || !containsID(functions, "AcceptPatientTransfer") {
+		t.Errorf("access log functions %v", functions)
+	}
+
+	if err = sc.transferStep(movedPatient, "CancelPatientTransfer", "0001P"); err == nil {
+		t.Errorf("completed transfer cancelled")
+	}
+}
+
+func TestTransferRequiresKeyRotation(t *testing.T) {
+	sc, patient, _, _ := newAppointedScenario(t)
+	sourceAdmin := sc.client("Org1MSP", "admin", "0001A")
+	destinationAdmin := sc.client("Org2MSP", "admin", "0002A")
+	movedPatient := sc.client("Org2MSP", "patient", "0001P")
+	sc.setDataKey(patient)
+
+	err := sc.network.NewTransaction(patient, "TransferPatient", "Org2MSP", "").WithTransient("wrapped_key", []byte("destination-wrapped-key")).Submit(func(ctx contractapi.TransactionContextInterface) error {
+		return sc.contract.TransferPatient(ctx, "Org2MSP", "")
+	})
+	if err != nil {
+		t.Fatalf("TransferPatient: %v", err)
+	}
+	for _, step := range []struct {
+		admin *fabrictest.Identity
+		function string
+	}{
+		{destinationAdmin, "ApprovePatientTransfer"},
+		{sourceAdmin, "ApprovePatientTransfer"},
+		{sourceAdmin, "ReleasePatientTransfer"},
+		{destinationAdmin, "AcceptPatientTransfer"},
+	} {
+		if err = sc.transferStep(step.admin, step.function, "0001P"); err != nil {
+			t.Fatalf("%v: %v", step.function, err)
+		}
+	}
+
+	/// the doctor of the source org still holds the data key
+	dataKey := sc.readPatient(movedPatient, "0001P").DataKey
+	if dataKey == nil || !dataKey.RotationRequired {
+		t.Errorf("data key of the transferred patient %+v", dataKey)
+	}
+}
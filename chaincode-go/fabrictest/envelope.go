@@ -0,0 +1,172 @@
+package fabrictest
+
+import (
+	"fmt"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+)
+
+/// medical report encrypted as the envelope package of the application encrypts it
+/// each field sealed with the record key, the record key sealed with the data key
+type SealedReport struct {
+	MReport map[string]string
+	RecordKey string
+	ReportDigest string
+	ReportSalt string
+}
+
+var recordKeyLabel = []byte("recordKey")
+var reportSaltLabel = []byte("reportSalt")
+
+/// random AES-256 key, for data keys, record keys and digest salts
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate key: %v", err)
+	}
+	return key, nil
+}
+
+/// encrypt the report with a new record key wrapped with the data key
+func EncryptReport(dataKey []byte, report map[string]string) (*SealedReport, error) {
+	salt, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	return sealReport(dataKey, report, salt)
+}
+
+/// re-encrypt the report with a new record key wrapped with the new data key, keeping its salt
+func ReencryptReport(oldDataKey, newDataKey []byte, wrappedRecordKey string, report map[string]string, reportSalt string) (*SealedReport, error) {
+	recordKey, err := UnwrapRecordKey(oldDataKey, wrappedRecordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := DecryptReportWithRecordKey(recordKey, report)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := openBase64(recordKey, reportSalt, reportSaltLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealReport(newDataKey, plain, salt)
+}
+
+/// decrypt the report with the record key wrapped with the data key
+func DecryptReport(dataKey []byte, wrappedRecordKey string, report map[string]string) (map[string]string, error) {
+	recordKey, err := UnwrapRecordKey(dataKey, wrappedRecordKey)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptReportWithRecordKey(recordKey, report)
+}
+
+func DecryptReportWithRecordKey(recordKey []byte, report map[string]string) (map[string]string, error) {
+	decrypted := map[string]string{}
+	for field, value := range report {
+		plain, err := openBase64(recordKey, value, []byte(field))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decrypt %v: %v", field, err)
+		}
+		decrypted[field] = string(plain)
+	}
+	return decrypted, nil
+}
+
+func UnwrapRecordKey(dataKey []byte, wrappedRecordKey string) ([]byte, error) {
+	return openBase64(dataKey, wrappedRecordKey, recordKeyLabel)
+}
+
+/// base64 sha256 of the salt and the canonical JSON of the plaintext report
+func reportDigest(salt []byte, report map[string]string) (string, error) {
+	reportJSON, err := canonical.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(append(append([]byte{}, salt...), reportJSON...))
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
+}
+
+func sealReport(dataKey []byte, report map[string]string, salt []byte) (*SealedReport, error) {
+	digest, err := reportDigest(salt, report)
+	if err != nil {
+		return nil, err
+	}
+
+	recordKey, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := &SealedReport{MReport: map[string]string{}, ReportDigest: digest}
+	for field, value := range report {
+		sealed.MReport[field], err = sealBase64(recordKey, []byte(value), []byte(field))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sealed.ReportSalt, err = sealBase64(recordKey, salt, reportSaltLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.RecordKey, err = sealBase64(dataKey, recordKey, recordKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+/// AES-GCM, base64(nonce | ciphertext)
+func sealBase64(key, plaintext, additionalData []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("Cannot generate nonce: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+func openBase64(key []byte, value string, additionalData []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode: %v", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("Ciphertext is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
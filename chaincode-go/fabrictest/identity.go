@@ -0,0 +1,235 @@
+package fabrictest
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"math/big"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/afrozahmed441/Capstone-Project/chaincode-go/canonical"
+)
+
+/// extension the Fabric CA stores the enrollment attributes in
+var attributeExtensionOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+type attributes struct {
+	Attrs map[string]string `json:"attrs"`
+}
+
+/// issuing CA of an org
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key *ecdsa.PrivateKey
+}
+
+/// domain of the org in the test network, Org1MSP is org1.example.com
+func orgDomain(mspID string) string {
+	return strings.ToLower(strings.TrimSuffix(mspID, "MSP")) + ".example.com"
+}
+
+func (n *Network) newSerialNumber() *big.Int {
+	n.serial++
+	return big.NewInt(n.serial)
+}
+
+func (n *Network) certificateAuthority(mspID string) (*certificateAuthority, error) {
+
+	if ca, ok := n.authorities[mspID]; ok {
+		return ca, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: n.newSerialNumber(),
+		Subject: pkix.Name{CommonName: "ca." + orgDomain(mspID), Organization: []string{orgDomain(mspID)}},
+		NotBefore: n.clock.Add(-time.Hour),
+		NotAfter: n.clock.AddDate(10, 0, 0),
+		IsCA: true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse CA certificate: %v", err)
+	}
+
+	ca := &certificateAuthority{cert: cert, key: key}
+	n.authorities[mspID] = ca
+
+	return ca, nil
+}
+
+/// enrolled client of an org, the attributes are carried in the certificate
+/// like the attributes the Fabric CA adds on enrollment (role, id)
+type Identity struct {
+	MSPID string
+	Name string
+	cert *x509.Certificate
+	key *ecdsa.PrivateKey
+}
+
+/// enroll a client of the org with the attributes
+func (n *Network) NewIdentity(mspID string, name string, attrs map[string]string) (*Identity, error) {
+
+	ca, err := n.certificateAuthority(mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate client key: %v", err)
+	}
+
+	attrsJSON, err := json.Marshal(attributes{Attrs: attrs})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal attributes: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: n.newSerialNumber(),
+		Subject: pkix.Name{CommonName: name, OrganizationalUnit: []string{"client"}},
+		NotBefore: n.clock.Add(-time.Hour),
+		NotAfter: n.clock.AddDate(1, 0, 0),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: attributeExtensionOID, Value: attrsJSON}},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create client certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse client certificate: %v", err)
+	}
+
+	return &Identity{MSPID: mspID, Name: name, cert: cert, key: key}, nil
+}
+
+func (id *Identity) Certificate() *x509.Certificate {
+	return id.cert
+}
+
+func (id *Identity) PrivateKey() *ecdsa.PrivateKey {
+	return id.key
+}
+
+/// id of the client as the client identity library reports it, base64 encoded
+func (id *Identity) ID() string {
+	clientID := fmt.Sprintf("x509::%v::%v", id.cert.Subject.String(), id.cert.Issuer.String())
+	return base64.StdEncoding.EncodeToString([]byte(clientID))
+}
+
+/// attribute of the enrollment certificate
+func (id *Identity) Attribute(name string) (string, bool, error) {
+
+	for _, extension := range id.cert.Extensions {
+		if !extension.Id.Equal(attributeExtensionOID) {
+			continue
+		}
+
+		var attrs attributes
+		err := json.Unmarshal(extension.Value, &attrs)
+		if err != nil {
+			return "", false, fmt.Errorf("Cannot unmarshal attributes: %v", err)
+		}
+
+		value, ok := attrs.Attrs[name]
+		return value, ok, nil
+	}
+
+	return "", false, nil
+}
+
+/// digital signature of the data, as the sign package of the application creates it
+/// the base64 encoded ASN.1 ECDSA signature of the sha256 hash of the data
+func (id *Identity) Sign(data []byte) (string, error) {
+
+	signature, err := id.signRaw(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func (id *Identity) signRaw(data []byte) ([]byte, error) {
+
+	hash := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, id.key, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("Cannot sign data: %v", err)
+	}
+
+	return signature, nil
+}
+
+/// digital signature of the canonical form of the JSON data
+func (id *Identity) SignJSON(data []byte) (string, error) {
+
+	canonicalJSON, err := canonical.Transform(data)
+	if err != nil {
+		return "", fmt.Errorf("Cannot canonicalize data: %v", err)
+	}
+
+	return id.Sign(canonicalJSON)
+}
+
+/// client identity of the transaction context
+type clientIdentity struct {
+	identity *Identity
+}
+
+func (ci *clientIdentity) GetID() (string, error) {
+	return ci.identity.ID(), nil
+}
+
+func (ci *clientIdentity) GetMSPID() (string, error) {
+	return ci.identity.MSPID, nil
+}
+
+func (ci *clientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	return ci.identity.Attribute(attrName)
+}
+
+func (ci *clientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+
+	value, ok, err := ci.identity.Attribute(attrName)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("Attribute '%s' was not found", attrName)
+	}
+
+	if value != attrValue {
+		return fmt.Errorf("Attribute '%s' equals '%s', not '%s'", attrName, value, attrValue)
+	}
+
+	return nil
+}
+
+func (ci *clientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return ci.identity.cert, nil
+}
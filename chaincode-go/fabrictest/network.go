@@ -0,0 +1,169 @@
+/// in-memory Fabric network for exercising the chaincode without peers
+/// the network keeps the world state and the private data collections of a single channel,
+/// contracts are called directly with the transaction context of a harness transaction
+package fabrictest
+
+import (
+	"fmt"
+	"time"
+	"regexp"
+	"io/ioutil"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+/// private data collection and its membership rules
+/// members are the MSP ids of the orgs whose peers keep the data
+type Collection struct {
+	Name string
+	Members []string
+	MemberOnlyRead bool
+	MemberOnlyWrite bool
+}
+
+func (c *Collection) isMember(mspID string) bool {
+	for _, member := range c.Members {
+		if member == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+/// collection definition of the collections config file used to deploy the chaincode
+type collectionConfig struct {
+	Name string `json:"name"`
+	Policy string `json:"policy"`
+	MemberOnlyRead bool `json:"memberOnlyRead"`
+	MemberOnlyWrite bool `json:"memberOnlyWrite"`
+}
+
+/// members of the collection policy, OR('Org1MSP.member', 'Org2MSP.member')
+var policyMemberPattern = regexp.MustCompile(`'([^'.]+)\.(member|peer|client|admin)'`)
+
+/// ledger of a channel with the chaincode deployed
+type Network struct {
+	channel string
+	chaincode string
+	state map[string][]byte
+	collections map[string]*Collection
+	privateData map[string]map[string][]byte
+	authorities map[string]*certificateAuthority
+	clock time.Time
+	txCount int
+	serial int64
+}
+
+/// network with the chaincode deployed on the channel, without collections
+func NewNetwork(channel string, chaincode string) *Network {
+	return &Network{
+		channel: channel,
+		chaincode: chaincode,
+		state: map[string][]byte{},
+		collections: map[string]*Collection{},
+		privateData: map[string]map[string][]byte{},
+		authorities: map[string]*certificateAuthority{},
+		clock: time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+/// define the private data collection, replacing the collection of the same name
+func (n *Network) AddCollection(collection Collection) {
+	n.collections[collection.Name] = &collection
+	if _, ok := n.privateData[collection.Name]; !ok {
+		n.privateData[collection.Name] = map[string][]byte{}
+	}
+}
+
+/// define the collections of the collections config file
+func (n *Network) LoadCollectionsConfig(path string) error {
+
+	configJSON, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("Cannot read collections config: %v", err)
+	}
+
+	var configs []collectionConfig
+	err = json.Unmarshal(configJSON, &configs)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal collections config: %v", err)
+	}
+
+	for _, config := range configs {
+		matches := policyMemberPattern.FindAllStringSubmatch(config.Policy, -1)
+		if len(matches) == 0 {
+			return fmt.Errorf("No members found in the policy of collection %v", config.Name)
+		}
+
+		collection := Collection{
+			Name: config.Name,
+			MemberOnlyRead: config.MemberOnlyRead,
+			MemberOnlyWrite: config.MemberOnlyWrite,
+		}
+		for _, match := range matches {
+			collection.Members = append(collection.Members, match[1])
+		}
+
+		n.AddCollection(collection)
+	}
+
+	return nil
+}
+
+func (n *Network) Channel() string {
+	return n.channel
+}
+
+func (n *Network) Chaincode() string {
+	return n.chaincode
+}
+
+/// time of the next transaction, every transaction moves the clock by a second
+func (n *Network) Now() time.Time {
+	return n.clock
+}
+
+/// move the clock, to let requests and retention periods expire
+func (n *Network) Advance(d time.Duration) {
+	n.clock = n.clock.Add(d)
+}
+
+/// committed world state value of the key, nil when there is none
+func (n *Network) State(key string) []byte {
+	return n.state[key]
+}
+
+/// committed private data of the key, without the membership rules of the collection
+func (n *Network) PrivateData(collection string, key string) []byte {
+	return n.privateData[collection][key]
+}
+
+func (n *Network) nextTxID() string {
+	n.txCount++
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%v:%v:%v", n.channel, n.chaincode, n.txCount)))
+	return hex.EncodeToString(hash[:])
+}
+
+/// apply the write set of a successful transaction
+func (n *Network) commit(writes *writeSet) {
+
+	for key, value := range writes.state {
+		if value == nil {
+			delete(n.state, key)
+		} else {
+			n.state[key] = value
+		}
+	}
+
+	for collection, collectionWrites := range writes.privateData {
+		for key, value := range collectionWrites {
+			if value == nil {
+				delete(n.privateData[collection], key)
+			} else {
+				n.privateData[collection][key] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package fabrictest
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+	"crypto/sha256"
+	"encoding/pem"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+/// composite keys as the shim builds them
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue = 0
+	maxUnicodeRuneValue = utf8.MaxRune
+	emptyKeySubstitute = "\x01"
+)
+
+/// writes of a transaction, a nil value deletes the key
+/// the writes are applied when the transaction commits, reads in the same
+/// transaction see the committed ledger like on a peer
+type writeSet struct {
+	state map[string][]byte
+	privateData map[string]map[string][]byte
+}
+
+func newWriteSet() *writeSet {
+	return &writeSet{
+		state: map[string][]byte{},
+		privateData: map[string]map[string][]byte{},
+	}
+}
+
+func (ws *writeSet) putPrivateData(collection string, key string, value []byte) {
+	if _, ok := ws.privateData[collection]; !ok {
+		ws.privateData[collection] = map[string][]byte{}
+	}
+	ws.privateData[collection][key] = value
+}
+
+/// chaincode stub of a harness transaction
+/// the stub interface is embedded for the APIs the harness does not simulate
+/// (chaincode to chaincode calls, key level endorsement, history, pagination), calling them panics
+type stub struct {
+	shim.ChaincodeStubInterface
+	tx *Transaction
+	writes *writeSet
+	event *peer.ChaincodeEvent
+}
+
+func newStub(tx *Transaction) *stub {
+	return &stub{tx: tx, writes: newWriteSet()}
+}
+
+func (s *stub) GetArgs() [][]byte {
+	args := [][]byte{[]byte(s.tx.function)}
+	for _, arg := range s.tx.args {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
+func (s *stub) GetStringArgs() []string {
+	return append([]string{s.tx.function}, s.tx.args...)
+}
+
+func (s *stub) GetFunctionAndParameters() (string, []string) {
+	return s.tx.function, append([]string{}, s.tx.args...)
+}
+
+func (s *stub) GetArgsSlice() ([]byte, error) {
+	args := []byte{}
+	for _, arg := range s.GetArgs() {
+		args = append(args, arg...)
+	}
+	return args, nil
+}
+
+func (s *stub) GetTxID() string {
+	return s.tx.txID
+}
+
+func (s *stub) GetChannelID() string {
+	return s.tx.network.channel
+}
+
+func (s *stub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.tx.timestamp), nil
+}
+
+func (s *stub) GetTransient() (map[string][]byte, error) {
+	transient := map[string][]byte{}
+	for key, value := range s.tx.transient {
+		transient[key] = value
+	}
+	return transient, nil
+}
+
+/// serialized identity of the client
+func (s *stub) GetCreator() ([]byte, error) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.tx.client.cert.Raw})
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: s.tx.client.MSPID, IdBytes: certPEM})
+}
+
+/// proposal of the transaction signed by the client, without the header
+func (s *stub) GetSignedProposal() (*peer.SignedProposal, error) {
+
+	invocation := &peer.ChaincodeInvocationSpec{
+		ChaincodeSpec: &peer.ChaincodeSpec{
+			Type: peer.ChaincodeSpec_GOLANG,
+			ChaincodeId: &peer.ChaincodeID{Name: s.tx.network.chaincode},
+			Input: &peer.ChaincodeInput{Args: s.GetArgs()},
+		},
+	}
+
+	invocationBytes, err := proto.Marshal(invocation)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal invocation spec: %v", err)
+	}
+
+	proposalPayload, err := proto.Marshal(&peer.ChaincodeProposalPayload{Input: invocationBytes, TransientMap: s.tx.transient})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal proposal payload: %v", err)
+	}
+
+	proposalBytes, err := proto.Marshal(&peer.Proposal{Payload: proposalPayload})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal proposal: %v", err)
+	}
+
+	signature, err := s.tx.client.signRaw(proposalBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &peer.SignedProposal{ProposalBytes: proposalBytes, Signature: signature}, nil
+}
+
+func (s *stub) SetEvent(name string, payload []byte) error {
+	if len(name) == 0 {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	s.event = &peer.ChaincodeEvent{EventName: name, Payload: payload}
+	return nil
+}
+
+/// composite keys
+
+func validateCompositeKeyAttribute(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", str)
+	}
+	for index, runeValue := range str {
+		if runeValue == minUnicodeRuneValue || runeValue == maxUnicodeRuneValue {
+			return fmt.Errorf("input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key", runeValue, index, minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+func (s *stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+
+	compositeKey := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
+	for _, attribute := range attributes {
+		if err := validateCompositeKeyAttribute(attribute); err != nil {
+			return "", err
+		}
+		compositeKey += attribute + string(rune(minUnicodeRuneValue))
+	}
+
+	return compositeKey, nil
+}
+
+func (s *stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+
+	componentIndex := 1
+	components := []string{}
+	for i := 1; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+
+	return components[0], components[1:], nil
+}
+
+/// range of the keys starting with the partial composite key
+func (s *stub) partialCompositeKeyRange(objectType string, attributes []string) (string, string, error) {
+	startKey, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+	return startKey, startKey + string(rune(maxUnicodeRuneValue)), nil
+}
+
+/// range queries are for simple keys, the empty start key skips the composite keys
+func simpleKeyRange(startKey, endKey string) (string, string, error) {
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	for _, key := range []string{startKey, endKey} {
+		if len(key) > 0 && key[0] == compositeKeyNamespace[0] {
+			return "", "", fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return startKey, endKey, nil
+}
+
+/// world state
+
+func (s *stub) GetState(key string) ([]byte, error) {
+	return s.tx.network.state[key], nil
+}
+
+func (s *stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		value = nil
+	}
+	s.writes.state[key] = value
+	return nil
+}
+
+func (s *stub) DelState(key string) error {
+	s.writes.state[key] = nil
+	return nil
+}
+
+func (s *stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := simpleKeyRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return newIterator(s.tx.network.chaincode, s.tx.network.state, startKey, endKey), nil
+}
+
+func (s *stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := s.partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newIterator(s.tx.network.chaincode, s.tx.network.state, startKey, endKey), nil
+}
+
+func (s *stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("rich queries are not supported by the harness ledger")
+}
+
+/// private data
+
+func (s *stub) collection(name string) (*Collection, error) {
+	collection, ok := s.tx.network.collections[name]
+	if !ok {
+		return nil, fmt.Errorf("collection [%s] not defined in the collection config for chaincode [%s]", name, s.tx.network.chaincode)
+	}
+	return collection, nil
+}
+
+/// collection the client can read, member only read collections are readable by the members
+func (s *stub) readableCollection(name string) (*Collection, error) {
+
+	collection, err := s.collection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if collection.MemberOnlyRead && !collection.isMember(s.tx.client.MSPID) {
+		return nil, fmt.Errorf("tx creator does not have read access permission on privatedata in chaincodeName:%s collectionName: %s", s.tx.network.chaincode, name)
+	}
+
+	return collection, nil
+}
+
+func (s *stub) writableCollection(name string) (*Collection, error) {
+
+	collection, err := s.collection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if collection.MemberOnlyWrite && !collection.isMember(s.tx.client.MSPID) {
+		return nil, fmt.Errorf("tx creator does not have write access permission on privatedata in chaincodeName:%s collectionName: %s", s.tx.network.chaincode, name)
+	}
+
+	return collection, nil
+}
+
+/// the peer of an org that is not a member only has the hashes of the private data
+func (s *stub) GetPrivateData(collection, key string) ([]byte, error) {
+
+	definition, err := s.readableCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	value := s.tx.network.privateData[collection][key]
+	if value != nil && !definition.isMember(s.tx.peerMSPID) {
+		return nil, fmt.Errorf("private data matching public hash version is not available on the peer of %v", s.tx.peerMSPID)
+	}
+
+	return value, nil
+}
+
+func (s *stub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+
+	_, err := s.collection(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	value := s.tx.network.privateData[collection][key]
+	if value == nil {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (s *stub) PutPrivateData(collection string, key string, value []byte) error {
+
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+
+	_, err := s.writableCollection(collection)
+	if err != nil {
+		return err
+	}
+
+	if len(value) == 0 {
+		value = nil
+	}
+	s.writes.putPrivateData(collection, key, value)
+
+	return nil
+}
+
+func (s *stub) DelPrivateData(collection, key string) error {
+
+	_, err := s.writableCollection(collection)
+	if err != nil {
+		return err
+	}
+
+	s.writes.putPrivateData(collection, key, nil)
+	return nil
+}
+
+/// the harness keeps no history of the private data, purge is a delete
+func (s *stub) PurgePrivateData(collection, key string) error {
+	return s.DelPrivateData(collection, key)
+}
+
+/// private data of the range, empty on the peer of an org that is not a member
+func (s *stub) privateDataIterator(collection string, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+
+	definition, err := s.readableCollection(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	data := s.tx.network.privateData[collection]
+	if !definition.isMember(s.tx.peerMSPID) {
+		data = nil
+	}
+
+	return newIterator(s.tx.network.chaincode, data, startKey, endKey), nil
+}
+
+func (s *stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := simpleKeyRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.privateDataIterator(collection, startKey, endKey)
+}
+
+func (s *stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, endKey, err := s.partialCompositeKeyRange(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.privateDataIterator(collection, startKey, endKey)
+}
+
+func (s *stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("rich queries are not supported by the harness ledger")
+}
+
+/// iterator over a snapshot of the committed keys in [startKey, endKey), an empty end key is unbounded
+type iterator struct {
+	results []*queryresult.KV
+	next int
+	closed bool
+}
+
+func newIterator(namespace string, data map[string][]byte, startKey, endKey string) *iterator {
+
+	keys := []string{}
+	for key := range data {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Namespace: namespace, Key: key, Value: data[key]})
+	}
+
+	return &iterator{results: results}
+}
+
+func (it *iterator) HasNext() bool {
+	return !it.closed && it.next < len(it.results)
+}
+
+func (it *iterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	result := it.results[it.next]
+	it.next++
+	return result, nil
+}
+
+func (it *iterator) Close() error {
+	it.closed = true
+	return nil
+}
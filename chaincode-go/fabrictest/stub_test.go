@@ -0,0 +1,193 @@
+package fabrictest
+
+import (
+	"bytes"
+	"testing"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const sharedCollection = "org1MSPorg2MSPPrivateCollection"
+
+func newTestNetwork(t *testing.T) *Network {
+	network := NewNetwork("mychannel", "basic")
+	err := network.LoadCollectionsConfig("../collections_config.json")
+	if err != nil {
+		t.Fatalf("LoadCollectionsConfig: %v", err)
+	}
+	return network
+}
+
+func newTestIdentity(t *testing.T, network *Network, mspID string, name string) *Identity {
+	identity, err := network.NewIdentity(mspID, name, map[string]string{"role": "patient", "id": name})
+	if err != nil {
+		t.Fatalf("NewIdentity: %v", err)
+	}
+	return identity
+}
+
+func putPrivateData(collection, key string, value []byte) func(ctx contractapi.TransactionContextInterface) error {
+	return func(ctx contractapi.TransactionContextInterface) error {
+		return ctx.GetStub().PutPrivateData(collection, key, value)
+	}
+}
+
+func TestIdentityAttributes(t *testing.T) {
+	network := newTestNetwork(t)
+	client := newTestIdentity(t, network, "Org1MSP", "0001P")
+
+	tx := network.NewTransaction(client, "GetPatientInfo")
+	role, found, err := tx.GetClientIdentity().GetAttributeValue("role")
+	if err != nil || !found || role != "patient" {
+		t.Fatalf("role attribute = %q, %v, %v", role, found, err)
+	}
+
+	_, found, err = tx.GetClientIdentity().GetAttributeValue("hf.Type")
+	if err != nil || found {
+		t.Fatalf("missing attribute found = %v, %v", found, err)
+	}
+
+	mspID, err := tx.GetClientIdentity().GetMSPID()
+	if err != nil || mspID != "Org1MSP" {
+		t.Fatalf("MSP id = %q, %v", mspID, err)
+	}
+}
+
+func TestWritesCommitOnSubmit(t *testing.T) {
+	network := newTestNetwork(t)
+	client := newTestIdentity(t, network, "Org1MSP", "0001P")
+
+	err := network.NewTransaction(client, "Put").Submit(func(ctx contractapi.TransactionContextInterface) error {
+		err := ctx.GetStub().PutPrivateData("Org1MSPPrivateCollection", "key", []byte("value"))
+		if err != nil {
+			return err
+		}
+
+		/// reads do not see the writes of the same transaction
+		value, err := ctx.GetStub().GetPrivateData("Org1MSPPrivateCollection", "key")
+		if err != nil || value != nil {
+			t.Errorf("read of own write = %q, %v", value, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	if value := network.PrivateData("Org1MSPPrivateCollection", "key"); !bytes.Equal(value, []byte("value")) {
+		t.Fatalf("committed value = %q", value)
+	}
+
+	err = network.NewTransaction(client, "Put").Evaluate(putPrivateData("Org1MSPPrivateCollection", "other", []byte("value")))
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	if value := network.PrivateData("Org1MSPPrivateCollection", "other"); value != nil {
+		t.Fatalf("evaluated write committed: %q", value)
+	}
+}
+
+func TestCollectionMembership(t *testing.T) {
+	network := newTestNetwork(t)
+	org1Client := newTestIdentity(t, network, "Org1MSP", "0001P")
+	org3Client := newTestIdentity(t, network, "Org3MSP", "0003P")
+
+	err := network.NewTransaction(org1Client, "Put").Submit(putPrivateData(sharedCollection, "key", []byte("value")))
+	if err != nil {
+		t.Fatalf("member write: %v", err)
+	}
+
+	/// member only write
+	err = network.NewTransaction(org3Client, "Put").Submit(putPrivateData(sharedCollection, "key", []byte("other")))
+	if err == nil {
+		t.Fatalf("write by a client of a non member org succeeded")
+	}
+
+	/// member only read
+	err = network.NewTransaction(org3Client, "Get").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		_, err := ctx.GetStub().GetPrivateData(sharedCollection, "key")
+		return err
+	})
+	if err == nil {
+		t.Fatalf("read by a client of a non member org succeeded")
+	}
+
+	/// the org collection is not member only write, but its data is only on the peers of the org
+	err = network.NewTransaction(org3Client, "Put").OnPeer("Org1MSP").Submit(putPrivateData("Org1MSPPrivateCollection", "key", []byte("value")))
+	if err != nil {
+		t.Fatalf("write to the collection of another org: %v", err)
+	}
+
+	org1OnOrg2Peer := network.NewTransaction(org1Client, "Get").OnPeer("Org2MSP")
+	err = org1OnOrg2Peer.Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		_, err := ctx.GetStub().GetPrivateData("Org1MSPPrivateCollection", "key")
+		return err
+	})
+	if err == nil {
+		t.Fatalf("read of private data on the peer of a non member org succeeded")
+	}
+}
+
+func TestRangeSkipsCompositeKeys(t *testing.T) {
+	network := newTestNetwork(t)
+	client := newTestIdentity(t, network, "Org1MSP", "0001P")
+
+	err := network.NewTransaction(client, "Put").Submit(func(ctx contractapi.TransactionContextInterface) error {
+		compositeKey, err := ctx.GetStub().CreateCompositeKey("request", []string{"0001P"})
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{"a", "b", compositeKey} {
+			err = ctx.GetStub().PutPrivateData("Org1MSPPrivateCollection", key, []byte(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	err = network.NewTransaction(client, "Query").Evaluate(func(ctx contractapi.TransactionContextInterface) error {
+		simple, err := ctx.GetStub().GetPrivateDataByRange("Org1MSPPrivateCollection", "", "")
+		if err != nil {
+			return err
+		}
+		defer simple.Close()
+
+		keys := []string{}
+		for simple.HasNext() {
+			result, err := simple.Next()
+			if err != nil {
+				return err
+			}
+			keys = append(keys, result.Key)
+		}
+		if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+			t.Errorf("range keys = %q", keys)
+		}
+
+		composite, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey("Org1MSPPrivateCollection", "request", []string{})
+		if err != nil {
+			return err
+		}
+		defer composite.Close()
+
+		result, err := composite.Next()
+		if err != nil {
+			return err
+		}
+		objectType, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+		if err != nil || objectType != "request" || len(attributes) != 1 || attributes[0] != "0001P" {
+			t.Errorf("composite key = %q %q, %v", objectType, attributes, err)
+		}
+		if composite.HasNext() {
+			t.Errorf("more than one composite key")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package fabrictest
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/// environment variable the shim reads the MSP id of the peer from
+const peerMSPIDEnv = "CORE_PEER_LOCALMSPID"
+
+/// transaction proposed by a client to a peer, it is the transaction context of the contract call
+/// by default the transaction is sent to the peer of the client org
+type Transaction struct {
+	network *Network
+	client *Identity
+	peerMSPID string
+	function string
+	args []string
+	transient map[string][]byte
+	txID string
+	timestamp time.Time
+	stub *stub
+	done bool
+}
+
+/// transaction of the client invoking the function with the arguments
+/// the function and arguments are what the stub reports, the contract is called by Submit or Evaluate
+func (n *Network) NewTransaction(client *Identity, function string, args ...string) *Transaction {
+
+	tx := &Transaction{
+		network: n,
+		client: client,
+		peerMSPID: client.MSPID,
+		function: function,
+		args: args,
+		transient: map[string][]byte{},
+		txID: n.nextTxID(),
+		timestamp: n.clock,
+	}
+	tx.stub = newStub(tx)
+
+	n.clock = n.clock.Add(time.Second)
+
+	return tx
+}
+
+/// add the value to the transient map of the transaction
+func (tx *Transaction) WithTransient(key string, value []byte) *Transaction {
+	tx.transient[key] = value
+	return tx
+}
+
+/// send the transaction to the peer of the org
+func (tx *Transaction) OnPeer(mspID string) *Transaction {
+	tx.peerMSPID = mspID
+	return tx
+}
+
+func (tx *Transaction) GetStub() shim.ChaincodeStubInterface {
+	return tx.stub
+}
+
+func (tx *Transaction) GetClientIdentity() cid.ClientIdentity {
+	return &clientIdentity{identity: tx.client}
+}
+
+func (tx *Transaction) TxID() string {
+	return tx.txID
+}
+
+func (tx *Transaction) Timestamp() time.Time {
+	return tx.timestamp
+}
+
+/// name and payload of the event set by the transaction, empty when none was set
+func (tx *Transaction) Event() (string, []byte) {
+	if tx.stub.event == nil {
+		return "", nil
+	}
+	return tx.stub.event.EventName, tx.stub.event.Payload
+}
+
+/// endorse and commit the transaction, the writes are discarded when the contract fails
+func (tx *Transaction) Submit(invoke func(ctx contractapi.TransactionContextInterface) error) error {
+
+	err := tx.run(invoke)
+	if err != nil {
+		return err
+	}
+
+	tx.network.commit(tx.stub.writes)
+
+	return nil
+}
+
+/// run the contract without committing, like a query to a single peer
+func (tx *Transaction) Evaluate(invoke func(ctx contractapi.TransactionContextInterface) error) error {
+	return tx.run(invoke)
+}
+
+func (tx *Transaction) run(invoke func(ctx contractapi.TransactionContextInterface) error) error {
+
+	if tx.done {
+		return fmt.Errorf("Transaction %v already ran", tx.txID)
+	}
+	tx.done = true
+
+	/// the chaincode reads the MSP id of its peer from the environment
+	previous, set := os.LookupEnv(peerMSPIDEnv)
+	os.Setenv(peerMSPIDEnv, tx.peerMSPID)
+	defer func() {
+		if set {
+			os.Setenv(peerMSPIDEnv, previous)
+		} else {
+			os.Unsetenv(peerMSPIDEnv)
+		}
+	}()
+
+	return invoke(tx)
+}
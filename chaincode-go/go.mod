@@ -0,0 +1,12 @@
+module github.com/afrozahmed441/Capstone-Project/chaincode-go
+
+go 1.20
+
+require (
+	github.com/golang/protobuf v1.5.3
+	github.com/google/uuid v1.3.0
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20230731094759-d626e9ab09b9
+	github.com/hyperledger/fabric-contract-api-go v1.2.2
+	github.com/hyperledger/fabric-protos-go v0.3.0
+	google.golang.org/protobuf v1.31.0
+)